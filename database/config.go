@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	"gorm.io/driver/mysql"
@@ -74,15 +75,41 @@ func NewDatabase(config Config) (*gorm.DB, error) {
 
 // SetupDatabase initializes the database with migrations and indexes
 func SetupDatabase(db *gorm.DB) error {
+	// Organizations created before personal organizations were tracked are matched to their users once
+	backfillPersonal := db.Migrator().HasTable(&Org{}) && !db.Migrator().HasColumn(&Org{}, "PersonalUserID")
+	// Users created before email verification was tracked are treated as verified, so requiring
+	// verification does not lock them out
+	backfillEmailVerified := db.Migrator().HasTable(&User{}) && !db.Migrator().HasColumn(&User{}, "EmailVerified")
+
 	err := db.AutoMigrate(
 		&User{},
 		&Org{},
 		&OrgMember{},
+		&OrgInvitation{},
+		&OrgDomain{},
+		&OrgJoinRequest{},
+		&OrgRole{},
+		&Team{},
+		&TeamMember{},
 		&OAuthApplication{},
 		&OAuthAuthorizationCode{},
 		&OAuthAccessToken{},
 		&OAuthRefreshToken{},
 		&OAuthScope{},
+		&PasswordHistory{},
+		&PasswordResetToken{},
+		&AuditEvent{},
+		&AuditSinkCursor{},
+		&WebhookSubscription{},
+		&WebhookDelivery{},
+		&WebhookDeliveryAttempt{},
+		&IdentityProvider{},
+		&LinkedIdentity{},
+		&SAMLServiceProvider{},
+		&PersonalAccessToken{},
+		&ServiceAccount{},
+		&ServiceAccountCredential{},
+		&APIKey{},
 	)
 	if err != nil {
 		return fmt.Errorf("failed to auto migrate models: %w", err)
@@ -92,6 +119,18 @@ func SetupDatabase(db *gorm.DB) error {
 		return fmt.Errorf("failed to setup join table: %w", err)
 	}
 
+	if backfillEmailVerified {
+		if err := db.Unscoped().Model(&User{}).Where("1 = 1").Update("email_verified", true).Error; err != nil {
+			return fmt.Errorf("failed to backfill verified email addresses: %w", err)
+		}
+	}
+
+	if backfillPersonal {
+		if err := backfillPersonalOrgs(db); err != nil {
+			return fmt.Errorf("failed to backfill personal organizations: %w", err)
+		}
+	}
+
 	// Initialize default OAuth scopes
 	err = initializeDefaultOAuthScopes(db)
 	if err != nil {
@@ -101,6 +140,32 @@ func SetupDatabase(db *gorm.DB) error {
 	return nil
 }
 
+// backfillPersonalOrgs marks the organizations created at signup, named after their owner, as personal organizations
+func backfillPersonalOrgs(db *gorm.DB) error {
+	var pairs []struct {
+		OrgID  uint
+		UserID uint
+	}
+	err := db.Table("orgs").
+		Select("orgs.id AS org_id, users.id AS user_id").
+		Joins("JOIN users ON users.username = orgs.slug").
+		Joins("JOIN org_members ON org_members.org_id = orgs.id AND org_members.user_id = users.id").
+		Where("org_members.role = ?", OrgMemberRoleOwner).
+		Scan(&pairs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, pair := range pairs {
+		if err := db.Unscoped().Model(&Org{}).
+			Where("id = ?", pair.OrgID).
+			Update("personal_user_id", pair.UserID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // InitializeDefaultAdmin creates a default admin user if none exists
 func InitializeDefaultAdmin(db *gorm.DB) error {
 	// Check if default admin creation is disabled
@@ -121,11 +186,14 @@ func InitializeDefaultAdmin(db *gorm.DB) error {
 		return nil
 	}
 
-	// Create default admin user
+	// Create default admin user (treated as verified so the email verification policy cannot lock it out)
+	now := time.Now()
 	defaultAdmin := &User{
-		Username: getEnv("DEFAULT_ADMIN_USERNAME", "admin"),
-		Email:    getEnv("DEFAULT_ADMIN_EMAIL", "admin@example.com"),
-		Role:     UserRoleAdmin,
+		Username:        getEnv("DEFAULT_ADMIN_USERNAME", "admin"),
+		Email:           getEnv("DEFAULT_ADMIN_EMAIL", "admin@example.com"),
+		EmailVerified:   true,
+		EmailVerifiedAt: &now,
+		Role:            UserRoleAdmin,
 	}
 
 	// Set default password
@@ -192,6 +260,15 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
 // initializeDefaultOAuthScopes creates default OAuth scopes if they don't exist
 func initializeDefaultOAuthScopes(db *gorm.DB) error {
 	// Check if any scopes already exist
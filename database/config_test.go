@@ -0,0 +1,57 @@
+package database
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestSetupDatabaseBackfillsEmailVerified(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	defer sqlDB.Close()
+
+	// A users table from before email verification was tracked
+	if err := db.Exec(`CREATE TABLE users (id integer PRIMARY KEY, created_at datetime, updated_at datetime, deleted_at datetime,
+		username text, email text NOT NULL, password_hash text NOT NULL, role text NOT NULL DEFAULT 'user')`).Error; err != nil {
+		t.Fatalf("create users: %v", err)
+	}
+	if err := db.Exec(`INSERT INTO users (id, username, email, password_hash) VALUES (1, 'admin', 'admin@example.com', 'x')`).Error; err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+
+	if err := SetupDatabase(db); err != nil {
+		t.Fatalf("SetupDatabase: %v", err)
+	}
+	var existing User
+	if err := db.First(&existing, 1).Error; err != nil {
+		t.Fatalf("load user: %v", err)
+	}
+	if !existing.EmailVerified {
+		t.Error("existing user was not marked verified")
+	}
+
+	// Later users start unverified, and migrating again leaves them so
+	created := &User{Username: "new", Email: "new@example.com", PasswordHash: "x"}
+	if err := db.Create(created).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := SetupDatabase(db); err != nil {
+		t.Fatalf("SetupDatabase: %v", err)
+	}
+	if err := db.First(created, created.ID).Error; err != nil {
+		t.Fatalf("load user: %v", err)
+	}
+	if created.EmailVerified {
+		t.Error("user created after the migration was marked verified")
+	}
+}
@@ -3,7 +3,6 @@ package database
 import (
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -16,34 +15,72 @@ const (
 
 type User struct {
 	gorm.Model
-	Username     string
-	Email        string   `gorm:"uniqueIndex;not null"`
-	PasswordHash string   `gorm:"not null"`
-	Orgs         []Org    `gorm:"many2many:user_orgs;"`
-	Role         UserRole `gorm:"not null;default:'user'"`
+	Username           string
+	Email              string `gorm:"uniqueIndex;not null"`
+	EmailVerified      bool   `gorm:"not null;default:false"`
+	EmailVerifiedAt    *time.Time
+	VerificationSentAt *time.Time // Last verification email requested through resend, for its cooldown
+	PasswordHash       string     `gorm:"not null"`
+	SessionVersion     uint       `gorm:"not null;default:0"` // Incremented to invalidate all existing sessions
+	Orgs               []Org      `gorm:"many2many:user_orgs;"`
+	Role               UserRole   `gorm:"not null;default:'user'"`
+	Disabled           bool       `gorm:"not null;default:false"`   // Disabled users cannot sign in
+	ExternalID         string     `gorm:"index"`                    // Identifier assigned by a provisioning client such as a SCIM directory
+	AuthSource         string     `gorm:"not null;default:'local'"` // Authenticator that verifies the user's password, e.g. local or ldap
+	AuthSubject        string     `gorm:"index"`                    // Identifier of the user at a non-local source, e.g. the LDAP entry DN
 }
 
-// SetPassword hashes and sets the user's password
+// SetPassword hashes and sets the user's password using the configured algorithm
 func (u *User) SetPassword(password string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hash, err := HashPassword(password)
 	if err != nil {
 		return err
 	}
-	u.PasswordHash = string(hash)
+	u.PasswordHash = hash
+	return nil
+}
+
+// SetPasswordHash sets a pre-computed password hash, e.g. one imported from another system
+func (u *User) SetPasswordHash(hash string) error {
+	if err := ValidatePasswordHash(hash); err != nil {
+		return err
+	}
+	u.PasswordHash = hash
 	return nil
 }
 
 // CheckPassword verifies if the provided password matches the user's password
 func (u *User) CheckPassword(password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password))
-	return err == nil
+	ok, err := VerifyPassword(u.PasswordHash, password)
+	return err == nil && ok
+}
+
+// PasswordHistory stores previous password hashes of a user to prevent reuse
+type PasswordHistory struct {
+	ID           uint   `gorm:"primaryKey"`
+	UserID       uint   `gorm:"index;not null"`
+	PasswordHash string `gorm:"not null"`
+	CreatedAt    time.Time
+}
+
+// PasswordResetToken is a single-use token for the self-service password reset flow.
+// Only the SHA-256 hash of the token is stored.
+type PasswordResetToken struct {
+	gorm.Model
+	UserID    uint      `gorm:"index;not null"`
+	User      User      `gorm:"foreignKey:UserID"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	RequestIP string
 }
 
 type Org struct {
 	gorm.Model
-	Name    string `gorm:"not null"`
-	Slug    string `gorm:"uniqueIndex;not null"`
-	Members []OrgMember
+	Name           string `gorm:"not null"`
+	Slug           string `gorm:"uniqueIndex;not null"`
+	PersonalUserID *uint  `gorm:"uniqueIndex"` // User whose personal organization this is; nil for regular organizations
+	Members        []OrgMember
 }
 
 type OrgMemberRole string
@@ -61,9 +98,112 @@ type OrgMember struct {
 	CreatedAt time.Time
 	DeletedAt gorm.DeletedAt
 	Role      OrgMemberRole `gorm:"not null;default:'member'"`
+	OrgRoleID *uint         // Optional custom role granting permissions on top of the built-in role
+}
+
+// OrgRole is a custom role defined by an organization as a named set of permissions
+type OrgRole struct {
+	ID          uint   `gorm:"primaryKey"`
+	OrgID       uint   `gorm:"uniqueIndex:idx_org_roles_org_name;not null"`
+	Name        string `gorm:"uniqueIndex:idx_org_roles_org_name;not null"`
+	Description string
+	Permissions string `gorm:"type:text"` // Space-separated permissions
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
 }
 
-// OAuth Application represents a registered OAuth client application (system-level)
+// Team groups members of an organization. Teams can be nested: members of a child team
+// are also treated as members of its parent teams.
+type Team struct {
+	ID          uint   `gorm:"primaryKey"`
+	OrgID       uint   `gorm:"uniqueIndex:idx_teams_org_slug;not null"`
+	ParentID    *uint  `gorm:"index"`
+	Name        string `gorm:"not null"`
+	Slug        string `gorm:"uniqueIndex:idx_teams_org_slug;not null"`
+	Description string
+	Permissions string `gorm:"type:text"` // Space-separated organization permissions granted to members
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// TeamMember is the direct membership of a user in a team
+type TeamMember struct {
+	TeamID    uint `gorm:"primaryKey"`
+	UserID    uint `gorm:"primaryKey;index"`
+	CreatedAt time.Time
+}
+
+type OrgInvitationStatus string
+
+const (
+	OrgInvitationStatusPending  OrgInvitationStatus = "pending"
+	OrgInvitationStatusAccepted OrgInvitationStatus = "accepted"
+	OrgInvitationStatusDeclined OrgInvitationStatus = "declined"
+	OrgInvitationStatusRevoked  OrgInvitationStatus = "revoked"
+)
+
+// OrgInvitation invites an email address to join an organization with a role.
+// Only the SHA-256 hash of the invitation token is stored.
+type OrgInvitation struct {
+	gorm.Model
+	OrgID       uint                `gorm:"index;not null"`
+	Org         Org                 `gorm:"foreignKey:OrgID"`
+	Email       string              `gorm:"index;not null"`
+	Role        OrgMemberRole       `gorm:"not null;default:'member'"`
+	TokenHash   string              `gorm:"uniqueIndex;not null"`
+	InvitedByID uint                `gorm:"not null"`
+	Status      OrgInvitationStatus `gorm:"index;not null;default:'pending'"`
+	ExpiresAt   time.Time           `gorm:"not null"`
+	RespondedAt *time.Time
+}
+
+type OrgDomainJoinPolicy string
+
+const (
+	OrgDomainJoinPolicyNone    OrgDomainJoinPolicy = "none"    // Users of the domain are not offered membership
+	OrgDomainJoinPolicyAuto    OrgDomainJoinPolicy = "auto"    // Users of the domain join automatically
+	OrgDomainJoinPolicyRequest OrgDomainJoinPolicy = "request" // Users of the domain get a join request for approval
+)
+
+// OrgDomain is an email domain claimed by an organization. The claim takes effect once
+// ownership has been proven with a DNS TXT record containing the verification token.
+type OrgDomain struct {
+	ID                uint                `gorm:"primaryKey"`
+	OrgID             uint                `gorm:"uniqueIndex:idx_org_domains_org_domain;not null"`
+	Domain            string              `gorm:"uniqueIndex:idx_org_domains_org_domain;index;not null"` // Lowercase domain name
+	VerificationToken string              `gorm:"not null"`
+	VerifiedAt        *time.Time          `gorm:"index"`
+	JoinPolicy        OrgDomainJoinPolicy `gorm:"not null;default:'none'"`
+	DefaultRole       OrgMemberRole       `gorm:"not null;default:'member'"` // Role given to users joining through the domain
+	CreatedByID       uint                `gorm:"not null"`
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+type OrgJoinRequestStatus string
+
+const (
+	OrgJoinRequestStatusPending  OrgJoinRequestStatus = "pending"
+	OrgJoinRequestStatusApproved OrgJoinRequestStatus = "approved"
+	OrgJoinRequestStatusRejected OrgJoinRequestStatus = "rejected"
+)
+
+// OrgJoinRequest asks the organization to admit a user whose email address belongs to one of its verified domains
+type OrgJoinRequest struct {
+	ID            uint                 `gorm:"primaryKey"`
+	OrgID         uint                 `gorm:"index;not null"`
+	Org           Org                  `gorm:"foreignKey:OrgID"`
+	UserID        uint                 `gorm:"index;not null"`
+	User          User                 `gorm:"foreignKey:UserID"`
+	DomainID      uint                 `gorm:"not null"`
+	Role          OrgMemberRole        `gorm:"not null;default:'member'"`
+	Status        OrgJoinRequestStatus `gorm:"index;not null;default:'pending'"`
+	RespondedByID *uint
+	RespondedAt   *time.Time
+	CreatedAt     time.Time
+}
+
+// OAuth Application represents a registered OAuth client application, either system-level or owned by an organization
 type OAuthApplication struct {
 	gorm.Model
 	Name         string `gorm:"not null"`
@@ -73,10 +213,17 @@ type OAuthApplication struct {
 	Scopes       string `gorm:"default:'read'"` // Space-separated scopes
 	Description  string `gorm:"type:text"`      // Application description
 	Website      string // Application website URL
-	CreatedByID  uint   `gorm:"not null"` // Admin who created the application
-	CreatedBy    User   `gorm:"foreignKey:CreatedByID"`
+	CreatedByID  *uint  // Admin who created the application; nil when a service account created it
+	CreatedBy    *User  `gorm:"foreignKey:CreatedByID"`
 	Trusted      bool   `gorm:"default:false"` // Whether this app can skip user consent
 	Active       bool   `gorm:"default:true"`  // Whether this app is active
+	OrgID        *uint  `gorm:"index"`         // Owning organization; nil for system-level apps
+	Org          *Org   `gorm:"foreignKey:OrgID"`
+	MembersOnly  bool   `gorm:"default:false"` // Whether only members of the owning organization can authorize this app
+
+	// Service account that created the application through the internal API, if any
+	CreatedByServiceAccountID *uint
+	CreatedByServiceAccount   *ServiceAccount `gorm:"foreignKey:CreatedByServiceAccountID"`
 }
 
 // OAuth Authorization Code
@@ -92,6 +239,7 @@ type OAuthAuthorizationCode struct {
 	Used                bool      `gorm:"default:false"`
 	CodeChallenge       string    // For PKCE
 	CodeChallengeMethod string    // For PKCE (plain or S256)
+	OrgID               *uint     // Active organization chosen during authorization
 }
 
 // OAuth Access Token
@@ -102,6 +250,7 @@ type OAuthAccessToken struct {
 	UserID    uint      `gorm:"not null"`
 	User      User      `gorm:"foreignKey:UserID"`
 	Scopes    string    // Space-separated scopes
+	OrgID     *uint     // Active organization chosen during authorization
 	ExpiresAt time.Time `gorm:"not null"`
 	Revoked   bool      `gorm:"default:false"`
 }
@@ -125,3 +274,218 @@ type OAuthScope struct {
 	Description string
 	Default     bool `gorm:"default:false"` // Whether this scope is granted by default
 }
+
+// AuditEvent records a security-relevant action. Events are append-only and only removed
+// once they are older than the configured retention period.
+type AuditEvent struct {
+	ID               uint      `gorm:"primaryKey"`
+	CreatedAt        time.Time `gorm:"index"`
+	ActorType        string    `gorm:"not null"` // user, client, service_account or anonymous
+	ActorID          *uint     `gorm:"index"`    // User who performed the action
+	ClientID         string    `gorm:"index"`    // OAuth client that performed the action
+	ServiceAccountID *uint     `gorm:"index"`    // Service account that performed the action
+	Action           string    `gorm:"index;not null"`
+	TargetType       string    `gorm:"index:idx_audit_events_target"`
+	TargetID         string    `gorm:"index:idx_audit_events_target"`
+	OrgID            *uint     `gorm:"index"`
+	IP               string
+	UserAgent        string
+	Outcome          string `gorm:"index;not null"` // success or failure
+	Metadata         string `gorm:"type:text"`      // JSON object with action-specific details
+}
+
+// AuditSinkCursor remembers the last audit event delivered to an audit sink, so that
+// streaming resumes where it stopped after a restart or a delivery failure
+type AuditSinkCursor struct {
+	Sink        string `gorm:"primaryKey"`
+	LastEventID uint   `gorm:"not null;default:0"`
+	UpdatedAt   time.Time
+}
+
+// WebhookSubscription delivers lifecycle events to an external endpoint. Deliveries are signed
+// with an HMAC-SHA256 of the payload keyed by Secret.
+type WebhookSubscription struct {
+	ID          uint   `gorm:"primaryKey"`
+	Name        string `gorm:"not null"`
+	URL         string `gorm:"not null"`
+	Secret      string `gorm:"not null"`
+	Events      string `gorm:"type:text;not null"` // Space-separated event types, or * for all events
+	Active      bool   `gorm:"not null;default:true"`
+	CreatedByID uint   `gorm:"not null"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryStatusPending   WebhookDeliveryStatus = "pending"   // Waiting for its next attempt
+	WebhookDeliveryStatusSucceeded WebhookDeliveryStatus = "succeeded" // Accepted by the endpoint
+	WebhookDeliveryStatusFailed    WebhookDeliveryStatus = "failed"    // Gave up after the maximum number of attempts
+)
+
+// WebhookDelivery is an event queued for delivery to a webhook subscription
+type WebhookDelivery struct {
+	ID             uint                  `gorm:"primaryKey"`
+	SubscriptionID uint                  `gorm:"index;not null"`
+	EventID        string                `gorm:"index;not null"` // Shared by the deliveries of the same event
+	EventType      string                `gorm:"not null"`
+	Payload        string                `gorm:"type:text;not null"`
+	Status         WebhookDeliveryStatus `gorm:"index;not null;default:'pending'"`
+	Attempts       int                   `gorm:"not null;default:0"`
+	NextAttemptAt  *time.Time            `gorm:"index"`
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// WebhookDeliveryAttempt records one HTTP request made for a webhook delivery
+type WebhookDeliveryAttempt struct {
+	ID           uint `gorm:"primaryKey"`
+	DeliveryID   uint `gorm:"index;not null"`
+	StatusCode   int  // Zero when no response was received
+	Error        string
+	ResponseBody string `gorm:"type:text"` // Beginning of the response body
+	DurationMs   int64
+	CreatedAt    time.Time
+}
+
+type IdentityProviderType string
+
+const (
+	IdentityProviderTypeOIDC   IdentityProviderType = "oidc"   // OpenID Connect provider with discovery and ID tokens
+	IdentityProviderTypeOAuth2 IdentityProviderType = "oauth2" // Plain OAuth 2.0 provider with a user info API, e.g. GitHub
+)
+
+// IdentityProvider is an upstream provider users can sign in with, miniauth acting as the client.
+// Endpoints of OIDC providers are discovered from Issuer unless set explicitly.
+type IdentityProvider struct {
+	ID               uint                 `gorm:"primaryKey"`
+	Slug             string               `gorm:"uniqueIndex;not null"`
+	Name             string               `gorm:"not null"`
+	Type             IdentityProviderType `gorm:"not null"`
+	ClientID         string               `gorm:"not null"`
+	ClientSecret     string               `gorm:"not null"`
+	Issuer           string
+	AuthorizationURL string
+	TokenURL         string
+	UserInfoURL      string
+	EmailsURL        string // Lists the addresses of the user with their verification status, e.g. GitHub's /user/emails
+	Scopes           string // Space-separated
+	SubjectClaim     string `gorm:"not null"` // Claim or user info field identifying the user, e.g. sub or id
+	EmailClaim       string `gorm:"not null"`
+	UsernameClaim    string `gorm:"not null"`
+	TrustEmail       bool   `gorm:"not null;default:false"` // Treat addresses as verified even without an email_verified claim
+	AllowSignup      bool   `gorm:"not null;default:true"`  // Create users on their first sign in
+	Enabled          bool   `gorm:"not null;default:true"`
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// LinkedIdentity links an account at an upstream identity provider to a user
+type LinkedIdentity struct {
+	ID          uint   `gorm:"primaryKey"`
+	ProviderID  uint   `gorm:"uniqueIndex:idx_linked_identities_provider_subject;not null"`
+	Subject     string `gorm:"uniqueIndex:idx_linked_identities_provider_subject;not null"`
+	UserID      uint   `gorm:"index;not null"`
+	Email       string // Address reported by the provider when the identity was last used
+	CreatedAt   time.Time
+	LastLoginAt *time.Time
+}
+
+// SAMLServiceProvider is an application users sign in to through SAML 2.0, miniauth acting as the identity provider
+type SAMLServiceProvider struct {
+	ID                uint   `gorm:"primaryKey"`
+	Name              string `gorm:"not null"`
+	EntityID          string `gorm:"uniqueIndex;not null"`
+	ACSURLs           string `gorm:"type:text"` // JSON array of allowed assertion consumer service URLs; the first is the default
+	NameIDFormat      string `gorm:"not null"`
+	AttributeMappings string `gorm:"type:text"` // JSON object of SAML attribute names to user fields
+	Description       string `gorm:"type:text"`
+	Website           string
+	CreatedByID       uint  `gorm:"not null"` // Admin who registered the service provider
+	CreatedBy         User  `gorm:"foreignKey:CreatedByID"`
+	Active            bool  `gorm:"not null;default:true"`
+	OrgID             *uint `gorm:"index"` // Owning organization; nil for system-level service providers
+	Org               *Org  `gorm:"foreignKey:OrgID"`
+	MembersOnly       bool  `gorm:"not null;default:false"` // Whether only members of the owning organization can sign in
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+// Scopes of personal access tokens
+const (
+	TokenScopeRead  = "read"  // Safe requests (GET, HEAD, OPTIONS)
+	TokenScopeWrite = "write" // Requests of any method
+	TokenScopeAdmin = "admin" // Admin endpoints, for tokens of admin users
+)
+
+// PersonalAccessToken lets a user call the API from scripts with a bearer token. Only a hash of
+// the token is stored; it is shown to the user once, when created.
+type PersonalAccessToken struct {
+	ID         uint       `gorm:"primaryKey"`
+	UserID     uint       `gorm:"index;not null"`
+	Name       string     `gorm:"not null"`
+	TokenHash  string     `gorm:"uniqueIndex;not null"`
+	Prefix     string     `gorm:"not null"`           // Start of the token, shown to tell tokens apart
+	Scopes     string     `gorm:"type:text;not null"` // Space-separated scopes
+	ExpiresAt  *time.Time // Nil for tokens that do not expire
+	LastUsedAt *time.Time
+	LastUsedIP string
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+// ServiceAccount is a non-human principal that calls internal endpoints, such as authorization
+// checks, with its own credentials. Scopes limit it to specific endpoints.
+type ServiceAccount struct {
+	ID          uint   `gorm:"primaryKey"`
+	Name        string `gorm:"uniqueIndex;not null"`
+	Description string `gorm:"type:text"`
+	Scopes      string `gorm:"type:text;not null"` // Space-separated scopes
+	Active      bool   `gorm:"not null;default:true"`
+	CreatedByID uint   `gorm:"not null"` // Admin who created the service account
+	Credentials []ServiceAccountCredential
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// ServiceAccountCredential is a secret a service account authenticates with. An account can hold
+// several at once, so secrets are rotated by adding a new one before revoking the old. Only a hash
+// of the secret is stored; it is shown once, when issued.
+type ServiceAccountCredential struct {
+	ID               uint   `gorm:"primaryKey"`
+	ServiceAccountID uint   `gorm:"index;not null"`
+	Name             string // Label telling credentials apart, e.g. where the secret is deployed
+	SecretHash       string `gorm:"uniqueIndex;not null"`
+	Prefix           string `gorm:"not null"` // Start of the secret, shown to tell credentials apart
+	ExpiresAt        *time.Time
+	LastUsedAt       *time.Time
+	LastUsedIP       string
+	RevokedAt        *time.Time
+	CreatedAt        time.Time
+}
+
+// APIKey lets a resource server call the token introspection and user lookup APIs on behalf of its
+// owner, either an organization or an OAuth application. Only a hash of the key is stored; it is
+// shown once, when issued.
+type APIKey struct {
+	ID                 uint              `gorm:"primaryKey"`
+	Name               string            `gorm:"not null"`
+	KeyHash            string            `gorm:"uniqueIndex;not null"`
+	Prefix             string            `gorm:"not null"` // Start of the key, shown to tell keys apart
+	OrgID              *uint             `gorm:"index"`    // Owning organization; nil when an application owns the key
+	Org                *Org              `gorm:"foreignKey:OrgID"`
+	ApplicationID      *uint             `gorm:"index"` // Owning OAuth application; nil when an organization owns the key
+	Application        *OAuthApplication `gorm:"foreignKey:ApplicationID"`
+	Scopes             string            `gorm:"type:text;not null"` // Space-separated scopes
+	AllowedIPs         string            `gorm:"type:text"`          // Space-separated addresses and CIDR ranges; empty allows any address
+	RateLimitPerMinute int               `gorm:"not null"`
+	ExpiresAt          *time.Time
+	LastUsedAt         *time.Time
+	LastUsedIP         string
+	RevokedAt          *time.Time
+	RotatedFromID      *uint // Key this one replaced when it was rotated
+	CreatedByID        uint  `gorm:"not null"` // Admin who issued the key
+	CreatedAt          time.Time
+}
@@ -0,0 +1,376 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Supported password hashing algorithms
+const (
+	PasswordAlgorithmBcrypt   = "bcrypt"
+	PasswordAlgorithmArgon2id = "argon2id"
+	PasswordAlgorithmPBKDF2   = "pbkdf2"
+	PasswordAlgorithmScrypt   = "scrypt"
+)
+
+// ErrUnsupportedPasswordHash is returned when a stored hash cannot be recognized
+var ErrUnsupportedPasswordHash = errors.New("unsupported password hash format")
+
+// Bounds on the parameters of stored hashes. Imported hashes are checked against them so a bad
+// import cannot match every password or make each login attempt allocate gigabytes.
+const (
+	minPasswordHashKeyBytes = 16
+	maxPasswordHashKeyBytes = 128
+	maxArgon2Memory         = 1 << 20 // KiB, 1 GiB
+	maxScryptLogN           = 20
+	maxScryptMemory         = 1 << 30 // Bytes, 128 * r * N
+	maxScryptParallelism    = 16
+)
+
+// PasswordHashConfig controls how new password hashes are generated
+type PasswordHashConfig struct {
+	Algorithm         string // bcrypt or argon2id
+	BcryptCost        int
+	Argon2Memory      uint32 // KiB
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	Argon2SaltLength  int
+	Argon2KeyLength   uint32
+}
+
+// passwordHashConfig is the active configuration used by SetPassword and CheckPassword
+var passwordHashConfig = LoadPasswordHashConfig()
+
+// LoadPasswordHashConfig builds the password hashing configuration from environment variables
+func LoadPasswordHashConfig() PasswordHashConfig {
+	return PasswordHashConfig{
+		Algorithm:         getEnv("PASSWORD_HASH_ALGORITHM", PasswordAlgorithmBcrypt),
+		BcryptCost:        getEnvInt("PASSWORD_BCRYPT_COST", bcrypt.DefaultCost),
+		Argon2Memory:      uint32(getEnvInt("PASSWORD_ARGON2_MEMORY", 64*1024)),
+		Argon2Iterations:  uint32(getEnvInt("PASSWORD_ARGON2_ITERATIONS", 3)),
+		Argon2Parallelism: uint8(getEnvInt("PASSWORD_ARGON2_PARALLELISM", 2)),
+		Argon2SaltLength:  16,
+		Argon2KeyLength:   32,
+	}
+}
+
+// SetPasswordHashConfig replaces the active password hashing configuration
+func SetPasswordHashConfig(config PasswordHashConfig) {
+	passwordHashConfig = config
+}
+
+// bcryptMaxPasswordBytes is the longest password bcrypt accepts
+const bcryptMaxPasswordBytes = 72
+
+// MaxPasswordBytes returns the longest password in bytes the configured algorithm can hash,
+// or 0 when it has no limit
+func MaxPasswordBytes() int {
+	switch passwordHashConfig.Algorithm {
+	case PasswordAlgorithmBcrypt, "":
+		return bcryptMaxPasswordBytes
+	default:
+		return 0
+	}
+}
+
+// HashPassword hashes a password with the configured algorithm.
+// The algorithm and its parameters are encoded in the returned string.
+func HashPassword(password string) (string, error) {
+	switch passwordHashConfig.Algorithm {
+	case PasswordAlgorithmArgon2id:
+		return hashArgon2id(password, passwordHashConfig)
+	case PasswordAlgorithmBcrypt, "":
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashConfig.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	default:
+		return "", fmt.Errorf("unsupported password hash algorithm: %s", passwordHashConfig.Algorithm)
+	}
+}
+
+// VerifyPassword checks a password against an encoded hash of any supported algorithm
+func VerifyPassword(encoded, password string) (bool, error) {
+	switch PasswordHashAlgorithm(encoded) {
+	case PasswordAlgorithmBcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	case PasswordAlgorithmArgon2id:
+		return verifyArgon2id(encoded, password)
+	case PasswordAlgorithmPBKDF2:
+		return verifyPBKDF2(encoded, password)
+	case PasswordAlgorithmScrypt:
+		return verifyScrypt(encoded, password)
+	default:
+		return false, ErrUnsupportedPasswordHash
+	}
+}
+
+// PasswordHashAlgorithm detects the algorithm of an encoded password hash
+func PasswordHashAlgorithm(encoded string) string {
+	switch {
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return PasswordAlgorithmBcrypt
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return PasswordAlgorithmArgon2id
+	case strings.HasPrefix(encoded, "$pbkdf2-"), strings.HasPrefix(encoded, "pbkdf2_"):
+		return PasswordAlgorithmPBKDF2
+	case strings.HasPrefix(encoded, "$scrypt$"):
+		return PasswordAlgorithmScrypt
+	default:
+		return ""
+	}
+}
+
+// ValidatePasswordHash checks that an imported hash is in a supported format with parameters within bounds
+func ValidatePasswordHash(encoded string) error {
+	var err error
+	switch PasswordHashAlgorithm(encoded) {
+	case PasswordAlgorithmBcrypt:
+		_, err = bcrypt.Cost([]byte(encoded))
+	case PasswordAlgorithmArgon2id:
+		_, _, _, err = decodeArgon2id(encoded)
+	case PasswordAlgorithmPBKDF2:
+		_, _, _, _, err = decodePBKDF2(encoded)
+	case PasswordAlgorithmScrypt:
+		_, _, _, _, _, err = decodeScrypt(encoded)
+	default:
+		err = ErrUnsupportedPasswordHash
+	}
+	return err
+}
+
+// PasswordNeedsRehash reports whether an encoded hash is weaker than the active configuration
+func PasswordNeedsRehash(encoded string) bool {
+	algorithm := PasswordHashAlgorithm(encoded)
+	target := passwordHashConfig.Algorithm
+	if target == "" {
+		target = PasswordAlgorithmBcrypt
+	}
+	if algorithm != target {
+		return true
+	}
+
+	switch algorithm {
+	case PasswordAlgorithmBcrypt:
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost < passwordHashConfig.BcryptCost
+	case PasswordAlgorithmArgon2id:
+		params, _, _, err := decodeArgon2id(encoded)
+		return err != nil ||
+			params.memory < passwordHashConfig.Argon2Memory ||
+			params.iterations < passwordHashConfig.Argon2Iterations ||
+			params.parallelism < passwordHashConfig.Argon2Parallelism
+	}
+	return true
+}
+
+// checkPasswordHashKey rejects a missing salt and a short key: an empty key matches every password
+func checkPasswordHashKey(algorithm string, salt, key []byte) error {
+	if len(salt) == 0 {
+		return fmt.Errorf("invalid %s salt: empty", algorithm)
+	}
+	if len(key) < minPasswordHashKeyBytes || len(key) > maxPasswordHashKeyBytes {
+		return fmt.Errorf("invalid %s hash: must be %d to %d bytes long", algorithm, minPasswordHashKeyBytes, maxPasswordHashKeyBytes)
+	}
+	return nil
+}
+
+// argon2id
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+func hashArgon2id(password string, config PasswordHashConfig) (string, error) {
+	salt := make([]byte, config.Argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, config.Argon2Iterations, config.Argon2Memory, config.Argon2Parallelism, config.Argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		config.Argon2Memory, config.Argon2Iterations, config.Argon2Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// decodeArgon2id parses the PHC string format: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func decodeArgon2id(encoded string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrUnsupportedPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version")
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 parameters: %w", err)
+	}
+	if params.iterations < 1 || params.parallelism < 1 || params.memory > maxArgon2Memory {
+		return params, nil, nil, fmt.Errorf("invalid argon2 parameters: t must be at least 1, p from 1 to 255 and m at most %d", maxArgon2Memory)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 hash: %w", err)
+	}
+	if err := checkPasswordHashKey("argon2", salt, key); err != nil {
+		return params, nil, nil, err
+	}
+
+	return params, salt, key, nil
+}
+
+func verifyArgon2id(encoded, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, computed) == 1, nil
+}
+
+// PBKDF2 (import only)
+
+// decodePBKDF2 parses either the PHC-style format $pbkdf2-sha256$i=<iterations>$<salt>$<hash>
+// (raw base64 salt and hash) or the Django format pbkdf2_sha256$<iterations>$<salt>$<hash>
+// (plain text salt, standard base64 hash)
+func decodePBKDF2(encoded string) (func() hash.Hash, int, []byte, []byte, error) {
+	var digest, iterationPart, saltPart, hashPart string
+	var salt, key []byte
+	var err error
+
+	if strings.HasPrefix(encoded, "pbkdf2_") {
+		parts := strings.Split(encoded, "$")
+		if len(parts) != 4 {
+			return nil, 0, nil, nil, ErrUnsupportedPasswordHash
+		}
+		digest = strings.TrimPrefix(parts[0], "pbkdf2_")
+		iterationPart, saltPart, hashPart = parts[1], parts[2], parts[3]
+		salt = []byte(saltPart)
+		key, err = base64.StdEncoding.DecodeString(hashPart)
+	} else {
+		parts := strings.Split(encoded, "$")
+		if len(parts) != 5 {
+			return nil, 0, nil, nil, ErrUnsupportedPasswordHash
+		}
+		digest = strings.TrimPrefix(parts[1], "pbkdf2-")
+		iterationPart, saltPart, hashPart = strings.TrimPrefix(parts[2], "i="), parts[3], parts[4]
+		salt, err = base64.RawStdEncoding.DecodeString(saltPart)
+		if err == nil {
+			key, err = base64.RawStdEncoding.DecodeString(hashPart)
+		}
+	}
+	if err != nil {
+		return nil, 0, nil, nil, fmt.Errorf("invalid pbkdf2 encoding: %w", err)
+	}
+
+	iterations, err := strconv.Atoi(iterationPart)
+	if err != nil || iterations <= 0 {
+		return nil, 0, nil, nil, fmt.Errorf("invalid pbkdf2 iterations")
+	}
+
+	var hashFunc func() hash.Hash
+	switch digest {
+	case "sha1":
+		hashFunc = sha1.New
+	case "sha256":
+		hashFunc = sha256.New
+	case "sha512":
+		hashFunc = sha512.New
+	default:
+		return nil, 0, nil, nil, fmt.Errorf("unsupported pbkdf2 digest: %s", digest)
+	}
+	if err := checkPasswordHashKey("pbkdf2", salt, key); err != nil {
+		return nil, 0, nil, nil, err
+	}
+
+	return hashFunc, iterations, salt, key, nil
+}
+
+func verifyPBKDF2(encoded, password string) (bool, error) {
+	hashFunc, iterations, salt, key, err := decodePBKDF2(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	computed := pbkdf2.Key([]byte(password), salt, iterations, len(key), hashFunc)
+	return subtle.ConstantTimeCompare(key, computed) == 1, nil
+}
+
+// scrypt (import only)
+
+// decodeScrypt parses the format $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash> with raw base64 salt and hash
+func decodeScrypt(encoded string) (n, r, p int, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return 0, 0, 0, nil, nil, ErrUnsupportedPasswordHash
+	}
+
+	var logN int
+	if _, err = fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &logN, &r, &p); err != nil {
+		return 0, 0, 0, nil, nil, fmt.Errorf("invalid scrypt parameters: %w", err)
+	}
+	if logN <= 0 || logN > maxScryptLogN || r < 1 || r > maxScryptMemory/(128<<logN) || p < 1 || p > maxScryptParallelism {
+		return 0, 0, 0, nil, nil, fmt.Errorf("invalid scrypt parameters: ln must be from 1 to %d, p from 1 to %d and 128*r*N at most %d bytes",
+			maxScryptLogN, maxScryptParallelism, maxScryptMemory)
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil {
+		return 0, 0, 0, nil, nil, fmt.Errorf("invalid scrypt salt: %w", err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return 0, 0, 0, nil, nil, fmt.Errorf("invalid scrypt hash: %w", err)
+	}
+	if err = checkPasswordHashKey("scrypt", salt, key); err != nil {
+		return 0, 0, 0, nil, nil, err
+	}
+
+	return 1 << logN, r, p, salt, key, nil
+}
+
+func verifyScrypt(encoded, password string) (bool, error) {
+	n, r, p, salt, key, err := decodeScrypt(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	computed, err := scrypt.Key([]byte(password), salt, n, r, p, len(key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, computed) == 1, nil
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+func TestImportedPasswordHashes(t *testing.T) {
+	const password = "correct horse"
+	salt := []byte("saltsaltsaltsalt")
+	b64 := base64.RawStdEncoding.EncodeToString
+
+	pbkdf2Key := pbkdf2.Key([]byte(password), salt, 1000, 32, sha256.New)
+	scryptKey, err := scrypt.Key([]byte(password), salt, 16, 8, 1, 32)
+	if err != nil {
+		t.Fatalf("scrypt: %v", err)
+	}
+	argon2Key := argon2.IDKey([]byte(password), salt, 1, 64, 1, 32)
+	argon2Hash := func(params string, key []byte) string {
+		return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, params, b64(salt), b64(key))
+	}
+
+	tests := []struct {
+		name    string
+		hash    string
+		wantErr bool
+	}{
+		{name: "pbkdf2", hash: "$pbkdf2-sha256$i=1000$" + b64(salt) + "$" + b64(pbkdf2Key)},
+		{name: "django pbkdf2", hash: "pbkdf2_sha256$1000$" + string(salt) + "$" + base64.StdEncoding.EncodeToString(pbkdf2Key)},
+		{name: "scrypt", hash: "$scrypt$ln=4,r=8,p=1$" + b64(salt) + "$" + b64(scryptKey)},
+		{name: "argon2id", hash: argon2Hash("m=64,t=1,p=1", argon2Key)},
+
+		{name: "pbkdf2 without key", hash: "$pbkdf2-sha256$i=1000$c2FsdHNhbHQ$", wantErr: true},
+		{name: "pbkdf2 with short key", hash: "$pbkdf2-sha256$i=1000$" + b64(salt) + "$" + b64(pbkdf2Key[:8]), wantErr: true},
+		{name: "pbkdf2 without salt", hash: "$pbkdf2-sha256$i=1000$$" + b64(pbkdf2Key), wantErr: true},
+		{name: "django pbkdf2 without key", hash: "pbkdf2_sha256$1000$saltsalt$", wantErr: true},
+		{name: "scrypt without key", hash: "$scrypt$ln=4,r=8,p=1$c2FsdHNhbHQ$", wantErr: true},
+		{name: "scrypt with too much memory", hash: "$scrypt$ln=20,r=16,p=1$" + b64(salt) + "$" + b64(scryptKey), wantErr: true},
+		{name: "scrypt with too high cost", hash: "$scrypt$ln=30,r=1,p=1$" + b64(salt) + "$" + b64(scryptKey), wantErr: true},
+		{name: "scrypt with r=0", hash: "$scrypt$ln=4,r=0,p=1$" + b64(salt) + "$" + b64(scryptKey), wantErr: true},
+		{name: "scrypt with p=0", hash: "$scrypt$ln=4,r=8,p=0$" + b64(salt) + "$" + b64(scryptKey), wantErr: true},
+		{name: "argon2id without key", hash: argon2Hash("m=64,t=1,p=1", nil), wantErr: true},
+		{name: "argon2id without salt", hash: fmt.Sprintf("$argon2id$v=%d$m=64,t=1,p=1$$%s", argon2.Version, b64(argon2Key)), wantErr: true},
+		{name: "argon2id with t=0", hash: argon2Hash("m=64,t=0,p=1", argon2Key), wantErr: true},
+		{name: "argon2id with p=0", hash: argon2Hash("m=64,t=1,p=0", argon2Key), wantErr: true},
+		{name: "argon2id with p=256", hash: argon2Hash("m=64,t=1,p=256", argon2Key), wantErr: true},
+		{name: "argon2id with too much memory", hash: argon2Hash("m=4194304,t=1,p=1", argon2Key), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var user User
+			err := user.SetPasswordHash(tt.hash)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetPasswordHash(%q) error = %v, want error %v", tt.hash, err, tt.wantErr)
+			}
+
+			// Hashes already stored must fail verification rather than match or panic
+			for _, candidate := range []string{password, "wrong password", ""} {
+				ok, err := VerifyPassword(tt.hash, candidate)
+				if want := !tt.wantErr && candidate == password; ok != want {
+					t.Errorf("VerifyPassword(%q) = %v, %v, want %v", candidate, ok, err, want)
+				}
+				if tt.wantErr && err == nil {
+					t.Errorf("VerifyPassword(%q) returned no error", candidate)
+				}
+			}
+		})
+	}
+}
@@ -1,10 +1,14 @@
 #!/bin/bash
 
-# Internal OAuth API Test Script with Internal Token Support
-# This script demonstrates how to use the internal OAuth API with internal token authentication
+# Internal OAuth API Test Script with Service Account Authentication
+# This script demonstrates how to use the internal OAuth API with a service account credential
+#
+# An admin creates the service account with the oauth_apps:create scope and issues its secret:
+#   POST /api/admin/service-accounts {"name": "provisioner", "scopes": ["oauth_apps:create"]}
+#   POST /api/admin/service-accounts/<id>/credentials {"name": "test script"}
 
 BASE_URL="http://localhost:8080/api"
-INTERNAL_TOKEN="${MINIAUTH_INTERNAL_TOKEN:-miniauth-internal-default-token-change-in-production}"
+SERVICE_ACCOUNT_SECRET="${MINIAUTH_SERVICE_ACCOUNT_SECRET:?Set MINIAUTH_SERVICE_ACCOUNT_SECRET to a service account credential secret}"
 ADMIN_SESSION=""  # Optional: admin session cookie as fallback
 
 # Colors for output
@@ -14,9 +18,7 @@ YELLOW='\033[1;33m'
 BLUE='\033[0;34m'
 NC='\033[0m' # No Color
 
-echo -e "${YELLOW}=== Internal OAuth API Test Script with Internal Token ===${NC}"
-echo ""
-echo -e "${BLUE}Using internal token: ${INTERNAL_TOKEN}${NC}"
+echo -e "${YELLOW}=== Internal OAuth API Test Script with Service Account ===${NC}"
 echo ""
 
 echo -e "${YELLOW}Testing single application creation with different authentication methods...${NC}"
@@ -25,10 +27,10 @@ echo -e "${YELLOW}Testing single application creation with different authenticat
 echo -e "${GREEN}1. Creating application with Authorization: Bearer header...${NC}"
 RESPONSE=$(curl -s -X POST "$BASE_URL/admin/oauth/internal/applications" \
     -H "Content-Type: application/json" \
-    -H "Authorization: Bearer $INTERNAL_TOKEN" \
+    -H "Authorization: Bearer $SERVICE_ACCOUNT_SECRET" \
     -d '{
         "name": "Test App (Bearer Auth)",
-        "description": "Test application created via Bearer token",
+        "description": "Test application created via Bearer credential",
         "website": "https://test-bearer.example.com",
         "redirect_uris": ["https://test-bearer.example.com/oauth/callback"],
         "scopes": ["read", "profile"],
@@ -44,10 +46,10 @@ echo ""
 echo -e "${GREEN}2. Creating application with Authorization: Internal header...${NC}"
 RESPONSE=$(curl -s -X POST "$BASE_URL/admin/oauth/internal/applications" \
     -H "Content-Type: application/json" \
-    -H "Authorization: Internal $INTERNAL_TOKEN" \
+    -H "Authorization: Internal $SERVICE_ACCOUNT_SECRET" \
     -d '{
         "name": "Test App (Internal Auth)",
-        "description": "Test application created via Internal token",
+        "description": "Test application created via Internal credential",
         "website": "https://test-internal.example.com",
         "redirect_uris": ["https://test-internal.example.com/oauth/callback"],
         "scopes": ["read", "profile"],
@@ -63,7 +65,7 @@ echo ""
 echo -e "${GREEN}3. Creating application with X-Internal-Token header...${NC}"
 RESPONSE=$(curl -s -X POST "$BASE_URL/admin/oauth/internal/applications" \
     -H "Content-Type: application/json" \
-    -H "X-Internal-Token: $INTERNAL_TOKEN" \
+    -H "X-Internal-Token: $SERVICE_ACCOUNT_SECRET" \
     -d '{
         "name": "Test App (X-Internal-Token)",
         "description": "Test application created via X-Internal-Token header",
@@ -78,29 +80,11 @@ RESPONSE=$(curl -s -X POST "$BASE_URL/admin/oauth/internal/applications" \
 echo "Response: $RESPONSE"
 echo ""
 
-# Test 4: Create with query parameter
-echo -e "${GREEN}4. Creating application with internal_token query parameter...${NC}"
-RESPONSE=$(curl -s -X POST "$BASE_URL/admin/oauth/internal/applications?internal_token=$INTERNAL_TOKEN" \
-    -H "Content-Type: application/json" \
-    -d '{
-        "name": "Test App (Query Param)",
-        "description": "Test application created via query parameter",
-        "website": "https://test-query.example.com",
-        "redirect_uris": ["https://test-query.example.com/oauth/callback"],
-        "scopes": ["read"],
-        "trusted": false,
-        "client_id": "test-query-app-123",
-        "client_secret": "test-query-secret-456"
-    }')
-
-echo "Response: $RESPONSE"
-echo ""
-
-# Test 5: Try to create with duplicate client_id (should fail)
-echo -e "${YELLOW}5. Testing duplicate client_id (should fail)...${NC}"
+# Test 4: Try to create with duplicate client_id (should fail)
+echo -e "${YELLOW}4. Testing duplicate client_id (should fail)...${NC}"
 RESPONSE=$(curl -s -X POST "$BASE_URL/admin/oauth/internal/applications" \
     -H "Content-Type: application/json" \
-    -H "Authorization: Bearer $INTERNAL_TOKEN" \
+    -H "Authorization: Bearer $SERVICE_ACCOUNT_SECRET" \
     -d '{
         "name": "Duplicate App",
         "description": "This should fail due to duplicate client_id",
@@ -112,22 +96,22 @@ RESPONSE=$(curl -s -X POST "$BASE_URL/admin/oauth/internal/applications" \
 echo "Response: $RESPONSE"
 echo ""
 
-# Test 6: Batch creation with internal token
-echo -e "${YELLOW}6. Testing batch application creation with internal token...${NC}"
+# Test 5: Batch creation with service account
+echo -e "${YELLOW}5. Testing batch application creation with service account...${NC}"
 RESPONSE=$(curl -s -X POST "$BASE_URL/admin/oauth/internal/applications/batch" \
     -H "Content-Type: application/json" \
-    -H "Authorization: Bearer $INTERNAL_TOKEN" \
+    -H "Authorization: Bearer $SERVICE_ACCOUNT_SECRET" \
     -d '[
         {
-            "name": "Batch App 1 (Token)",
-            "description": "First batch application via token",
+            "name": "Batch App 1 (Service Account)",
+            "description": "First batch application via service account",
             "redirect_uris": ["https://batch1-token.example.com/callback"],
             "client_id": "batch-token-app-1",
             "client_secret": "batch-token-secret-1"
         },
         {
-            "name": "Batch App 2 (Token)", 
-            "description": "Second batch application via token",
+            "name": "Batch App 2 (Service Account)", 
+            "description": "Second batch application via service account",
             "redirect_uris": ["https://batch2-token.example.com/callback"],
             "scopes": ["read"],
             "trusted": true,
@@ -146,11 +130,11 @@ RESPONSE=$(curl -s -X POST "$BASE_URL/admin/oauth/internal/applications/batch" \
 echo "Response: $RESPONSE"
 echo ""
 
-# Test 7: Test unauthorized access (invalid token)
-echo -e "${YELLOW}7. Testing unauthorized access with invalid token...${NC}"
+# Test 6: Test unauthorized access (invalid secret)
+echo -e "${YELLOW}6. Testing unauthorized access with invalid secret...${NC}"
 RESPONSE=$(curl -s -X POST "$BASE_URL/admin/oauth/internal/applications" \
     -H "Content-Type: application/json" \
-    -H "Authorization: Bearer invalid-token" \
+    -H "Authorization: Bearer invalid-secret" \
     -d '{
         "name": "Unauthorized App",
         "description": "This should fail",
@@ -162,9 +146,9 @@ RESPONSE=$(curl -s -X POST "$BASE_URL/admin/oauth/internal/applications" \
 echo "Response: $RESPONSE"
 echo ""
 
-# Test 8: Test session fallback (if admin session is provided)
+# Test 7: Test session fallback (if admin session is provided)
 if [ -n "$ADMIN_SESSION" ]; then
-    echo -e "${YELLOW}8. Testing admin session fallback...${NC}"
+    echo -e "${YELLOW}7. Testing admin session fallback...${NC}"
     RESPONSE=$(curl -s -X POST "$BASE_URL/admin/oauth/internal/applications" \
         -H "Content-Type: application/json" \
         -H "Cookie: $ADMIN_SESSION" \
@@ -183,17 +167,15 @@ fi
 echo -e "${GREEN}Test completed!${NC}"
 echo ""
 echo -e "${YELLOW}Authentication methods tested:${NC}"
-echo "✓ Authorization: Bearer <token>"
-echo "✓ Authorization: Internal <token>"
-echo "✓ X-Internal-Token: <token>"
-echo "✓ ?internal_token=<token>"
+echo "✓ Authorization: Bearer <secret>"
+echo "✓ Authorization: Internal <secret>"
+echo "✓ X-Internal-Token: <secret>"
 if [ -n "$ADMIN_SESSION" ]; then
     echo "✓ Admin session fallback"
 fi
 echo ""
 echo -e "${YELLOW}To run this script:${NC}"
 echo "1. Start the miniauth server: make run"
-echo "2. Set internal token (optional): export MINIAUTH_INTERNAL_TOKEN='your-secret-token'"
-echo "3. Run this script: bash examples/test_internal_oauth_api.sh"
-echo ""
-echo -e "${BLUE}Default internal token: miniauth-internal-default-token-change-in-production${NC}"
+echo "2. Create a service account with the oauth_apps:create scope and issue a credential"
+echo "3. Set its secret: export MINIAUTH_SERVICE_ACCOUNT_SECRET='your-service-account-secret'"
+echo "4. Run this script: bash examples/test_internal_oauth_api.sh"
@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"miniauth/database"
 	"miniauth/service"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -29,15 +31,17 @@ type AdminUserInfo struct {
 }
 
 type AdminUpdateUserRequest struct {
-	Username string             `json:"username" validate:"omitempty,min=3,max=50"`
-	Email    string             `json:"email" validate:"omitempty,email"`
-	Role     *database.UserRole `json:"role" validate:"omitempty,oneof=admin user"`
+	Username      string             `json:"username" validate:"omitempty,min=3,max=50"`
+	Email         string             `json:"email" validate:"omitempty,email"`
+	EmailVerified *bool              `json:"email_verified"`
+	Role          *database.UserRole `json:"role" validate:"omitempty,oneof=admin user"`
 }
 
 type AdminCreateUserRequest struct {
-	Username string `json:"username" validate:"required,min=3,max=50"`
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=6,max=100"`
+	Username     string `json:"username" validate:"required,min=3,max=50"`
+	Email        string `json:"email" validate:"required,email"`
+	Password     string `json:"password" validate:"required_without=PasswordHash"`
+	PasswordHash string `json:"password_hash"` // Pre-computed hash imported from another system (bcrypt, argon2id, pbkdf2 or scrypt)
 }
 
 type AdminUserResponse struct {
@@ -145,15 +149,16 @@ func AdminGetUser(ctx echo.Context) error {
 // AdminCreateUser creates a new user (admin operation)
 //
 //	@Summary		Create user (Admin)
-//	@Description	Create a new user account (admin operation)
+//	@Description	Create a new user account (admin operation). Either a password or a password hash imported from another system must be provided.
 //	@Tags			admin
 //	@Security		BasicAuth
 //	@Accept			json
 //	@Produce		json
 //	@Param			user	body		AdminCreateUserRequest	true	"User creation request"
 //	@Success		201		{object}	AdminUserResponse
-//	@Failure		400		{object}	map[string]string
+//	@Failure		400		{object}	PasswordPolicyErrorResponse
 //	@Failure		401		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
 //	@Failure		500		{object}	map[string]string
 //	@Router			/admin/users [post]
 func AdminCreateUser(ctx echo.Context) error {
@@ -176,13 +181,29 @@ func AdminCreateUser(ctx echo.Context) error {
 		Email:    req.Email,
 	}
 
-	if err := user.SetPassword(req.Password); err != nil {
+	if req.PasswordHash != "" {
+		// Imported hashes are verified on login and upgraded to the current algorithm
+		if err := user.SetPasswordHash(req.PasswordHash); err != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Unsupported password hash: " + err.Error(),
+			})
+		}
+	} else if err := serviceManager.User.SetUserPassword(user, req.Password); err != nil {
+		var policyErr *service.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return passwordPolicyErrorResponse(ctx, policyErr)
+		}
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to set password",
 		})
 	}
 
 	if err := serviceManager.User.CreateUser(user); err != nil {
+		if errors.Is(err, service.ErrEmailInUse) {
+			return ctx.JSON(http.StatusConflict, map[string]string{
+				"error": "This email address is already used by another account",
+			})
+		}
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{
 			"error": err.Error(),
 		})
@@ -210,6 +231,7 @@ func AdminCreateUser(ctx echo.Context) error {
 //	@Failure		400		{object}	map[string]string
 //	@Failure		401		{object}	map[string]string
 //	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
 //	@Failure		500		{object}	map[string]string
 //	@Router			/admin/users/{id} [put]
 func AdminUpdateUser(ctx echo.Context) error {
@@ -254,11 +276,29 @@ func AdminUpdateUser(ctx echo.Context) error {
 	if req.Username != "" {
 		user.Username = req.Username
 	}
-	if req.Email != "" {
+	if req.Email != "" && req.Email != user.Email {
+		if err := serviceManager.User.EnsureEmailAvailable(req.Email, user.ID); err != nil {
+			if errors.Is(err, service.ErrEmailInUse) {
+				return ctx.JSON(http.StatusConflict, map[string]string{
+					"error": "This email address is already used by another account",
+				})
+			}
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		// A new address has not been verified by the user
 		user.Email = req.Email
-	}
-	if req.Role != nil {
-		user.Role = *req.Role
+		user.EmailVerified = false
+		user.EmailVerifiedAt = nil
+	}
+	if req.EmailVerified != nil {
+		user.EmailVerified = *req.EmailVerified
+		user.EmailVerifiedAt = nil
+		if user.EmailVerified {
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+		}
 	}
 
 	if err := serviceManager.User.UpdateUser(user); err != nil {
@@ -267,6 +307,15 @@ func AdminUpdateUser(ctx echo.Context) error {
 		})
 	}
 
+	// Role changes are audited and announced to webhooks
+	if req.Role != nil && *req.Role != user.Role {
+		if err := serviceManager.User.UpdateUserRole(auditActor(ctx), user.ID, *req.Role); err != nil {
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{
+				"error": err.Error(),
+			})
+		}
+	}
+
 	return ctx.JSON(http.StatusOK, AdminUserResponse{
 		ID:       user.ID,
 		Username: user.Username,
@@ -288,6 +337,7 @@ func AdminUpdateUser(ctx echo.Context) error {
 //	@Failure		400	{object}	map[string]string
 //	@Failure		401	{object}	map[string]string
 //	@Failure		404	{object}	map[string]string
+//	@Failure		409	{object}	map[string]interface{}
 //	@Failure		500	{object}	map[string]string
 //	@Router			/admin/users/{id} [delete]
 func AdminDeleteUser(ctx echo.Context) error {
@@ -317,7 +367,18 @@ func AdminDeleteUser(ctx echo.Context) error {
 	}
 
 	// Delete user
-	if err := serviceManager.User.DeleteUser(id); err != nil {
+	if err := serviceManager.User.DeleteUser(auditActor(ctx), id); err != nil {
+		var soleOwnerErr *service.SoleOwnerError
+		if errors.As(err, &soleOwnerErr) {
+			slugs := make([]string, 0, len(soleOwnerErr.Orgs))
+			for _, org := range soleOwnerErr.Orgs {
+				slugs = append(slugs, org.Slug)
+			}
+			return ctx.JSON(http.StatusConflict, map[string]interface{}{
+				"error":         "User is the only owner of organizations with other members; transfer ownership first",
+				"organizations": slugs,
+			})
+		}
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{
 			"error": err.Error(),
 		})
@@ -339,7 +400,7 @@ func AdminDeleteUser(ctx echo.Context) error {
 //	@Param			id			path		int					true	"User ID"
 //	@Param			password	body		map[string]string	true	"New password"
 //	@Success		200			{object}	map[string]string
-//	@Failure		400			{object}	map[string]string
+//	@Failure		400			{object}	PasswordPolicyErrorResponse
 //	@Failure		401			{object}	map[string]string
 //	@Failure		404			{object}	map[string]string
 //	@Failure		500			{object}	map[string]string
@@ -364,7 +425,7 @@ func AdminResetUserPassword(ctx echo.Context) error {
 
 	// Parse request
 	var req struct {
-		Password string `json:"password" validate:"required,min=6,max=100"`
+		Password string `json:"password" validate:"required"`
 	}
 	if err := ctx.Bind(&req); err != nil {
 		return ctx.JSON(http.StatusBadRequest, map[string]string{
@@ -377,7 +438,11 @@ func AdminResetUserPassword(ctx echo.Context) error {
 	}
 
 	// Check if user exists and reset password
-	if err := serviceManager.User.UpdateUserPassword(id, req.Password); err != nil {
+	if err := serviceManager.User.UpdateUserPassword(auditActor(ctx), id, req.Password); err != nil {
+		var policyErr *service.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return passwordPolicyErrorResponse(ctx, policyErr)
+		}
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{
 			"error": err.Error(),
 		})
@@ -440,7 +505,7 @@ func AdminUpdateUserRole(ctx echo.Context) error {
 	}
 
 	// Update user role using service
-	if err := serviceManager.User.UpdateUserRole(id, req.Role); err != nil {
+	if err := serviceManager.User.UpdateUserRole(auditActor(ctx), id, req.Role); err != nil {
 		if err.Error() == "user not found" {
 			return ctx.JSON(http.StatusNotFound, map[string]string{
 				"error": "User not found",
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"errors"
+	"miniauth/database"
+	"miniauth/service"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type APIKeyResponse struct {
+	ID                 uint     `json:"id"`
+	Name               string   `json:"name"`
+	Prefix             string   `json:"prefix"`
+	OrgID              *uint    `json:"org_id,omitempty"`
+	OrgSlug            string   `json:"org_slug,omitempty"`
+	ApplicationID      *uint    `json:"application_id,omitempty"`
+	ApplicationName    string   `json:"application_name,omitempty"`
+	Scopes             []string `json:"scopes"`
+	AllowedIPs         []string `json:"allowed_ips"`
+	RateLimitPerMinute int      `json:"rate_limit_per_minute"`
+	ExpiresAt          string   `json:"expires_at,omitempty"`
+	Expired            bool     `json:"expired"`
+	LastUsedAt         string   `json:"last_used_at,omitempty"`
+	LastUsedIP         string   `json:"last_used_ip,omitempty"`
+	RotatedFromID      *uint    `json:"rotated_from_id,omitempty"`
+	CreatedAt          string   `json:"created_at"`
+}
+
+type IssueAPIKeyResponse struct {
+	APIKeyResponse
+	Key string `json:"key"` // Shown only once
+}
+
+type ListAPIKeysResponse struct {
+	APIKeys []APIKeyResponse `json:"api_keys"`
+	Scopes  []string         `json:"scopes"` // Scopes API keys can be granted
+}
+
+func newAPIKeyResponse(key *database.APIKey) APIKeyResponse {
+	response := APIKeyResponse{
+		ID:                 key.ID,
+		Name:               key.Name,
+		Prefix:             key.Prefix,
+		OrgID:              key.OrgID,
+		ApplicationID:      key.ApplicationID,
+		Scopes:             strings.Fields(key.Scopes),
+		AllowedIPs:         strings.Fields(key.AllowedIPs),
+		RateLimitPerMinute: key.RateLimitPerMinute,
+		LastUsedIP:         key.LastUsedIP,
+		RotatedFromID:      key.RotatedFromID,
+		CreatedAt:          key.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+	if key.Org != nil {
+		response.OrgSlug = key.Org.Slug
+	}
+	if key.Application != nil {
+		response.ApplicationName = key.Application.Name
+	}
+	if key.ExpiresAt != nil {
+		response.ExpiresAt = key.ExpiresAt.Format("2006-01-02 15:04:05")
+		response.Expired = !time.Now().Before(*key.ExpiresAt)
+	}
+	if key.LastUsedAt != nil {
+		response.LastUsedAt = key.LastUsedAt.Format("2006-01-02 15:04:05")
+	}
+	return response
+}
+
+// AdminListAPIKeys lists API keys
+//
+//	@Summary		List API keys (Admin)
+//	@Description	List the API keys of resource servers that have not been revoked, newest first
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			org_id			query		int	false	"Only keys owned by this organization"
+//	@Param			application_id	query		int	false	"Only keys owned by this OAuth application"
+//	@Success		200				{object}	ListAPIKeysResponse
+//	@Failure		400				{object}	map[string]string
+//	@Failure		401				{object}	map[string]string
+//	@Failure		403				{object}	map[string]string
+//	@Failure		500				{object}	map[string]string
+//	@Router			/admin/api-keys [get]
+func AdminListAPIKeys(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	orgID, err := parseOptionalUintParam(ctx, "org_id")
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid org_id",
+		})
+	}
+	applicationID, err := parseOptionalUintParam(ctx, "application_id")
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid application_id",
+		})
+	}
+
+	keys, err := serviceManager.APIKeys.ListAPIKeys(orgID, applicationID)
+	if err != nil {
+		return apiKeyErrorResponse(ctx, err)
+	}
+
+	response := ListAPIKeysResponse{
+		APIKeys: make([]APIKeyResponse, 0, len(keys)),
+		Scopes:  service.APIKeyScopes,
+	}
+	for i := range keys {
+		response.APIKeys = append(response.APIKeys, newAPIKeyResponse(&keys[i]))
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// AdminIssueAPIKey issues an API key
+//
+//	@Summary		Issue API key (Admin)
+//	@Description	Issue an API key a resource server sends in the X-API-Key header. The key is owned by an organization or an OAuth application and limited to its scopes: tokens:introspect for token introspection and users:read for user lookup. Requests from addresses outside allowed_ips are rejected, and requests over the rate limit are answered with 429. The key is returned only once.
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		service.APIKeyRequest	true	"API key"
+//	@Success		201		{object}	IssueAPIKeyResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/admin/api-keys [post]
+func AdminIssueAPIKey(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req service.APIKeyRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	key, secret, err := serviceManager.APIKeys.IssueAPIKey(auditActor(ctx), req)
+	if err != nil {
+		return apiKeyErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusCreated, IssueAPIKeyResponse{
+		APIKeyResponse: newAPIKeyResponse(key),
+		Key:            secret,
+	})
+}
+
+// AdminGetAPIKey returns an API key
+//
+//	@Summary		Get API key (Admin)
+//	@Description	Get an API key with its owner
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id	path		int	true	"API key ID"
+//	@Success		200	{object}	APIKeyResponse
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Router			/admin/api-keys/{id} [get]
+func AdminGetAPIKey(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid API key ID",
+		})
+	}
+
+	key, err := serviceManager.APIKeys.GetAPIKey(uint(id))
+	if err != nil {
+		return apiKeyErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, newAPIKeyResponse(key))
+}
+
+// AdminRotateAPIKey rotates an API key
+//
+//	@Summary		Rotate API key (Admin)
+//	@Description	Replace an API key with a new one that has the same owner, scopes, allowed IPs, rate limit and lifetime. The old key keeps working for the grace period so the resource server can switch over, and is revoked at once without one. The new key is returned only once.
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int							true	"API key ID"
+//	@Param			request	body		service.APIKeyRotateRequest	true	"Rotation"
+//	@Success		201		{object}	IssueAPIKeyResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/admin/api-keys/{id}/rotate [post]
+func AdminRotateAPIKey(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid API key ID",
+		})
+	}
+
+	var req service.APIKeyRotateRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	key, secret, err := serviceManager.APIKeys.RotateAPIKey(auditActor(ctx), uint(id), req)
+	if err != nil {
+		return apiKeyErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusCreated, IssueAPIKeyResponse{
+		APIKeyResponse: newAPIKeyResponse(key),
+		Key:            secret,
+	})
+}
+
+// AdminRevokeAPIKey revokes an API key
+//
+//	@Summary		Revoke API key (Admin)
+//	@Description	Revoke an API key. It stops working at once.
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id	path		int	true	"API key ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Router			/admin/api-keys/{id} [delete]
+func AdminRevokeAPIKey(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid API key ID",
+		})
+	}
+
+	if err := serviceManager.APIKeys.RevokeAPIKey(auditActor(ctx), uint(id)); err != nil {
+		return apiKeyErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "API key revoked",
+	})
+}
+
+func apiKeyErrorResponse(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ctx.JSON(http.StatusNotFound, map[string]string{
+			"error": "API key, organization or application not found",
+		})
+	case errors.Is(err, service.ErrInvalidAPIKeyScope),
+		errors.Is(err, service.ErrInvalidAPIKeyOwner),
+		errors.Is(err, service.ErrInvalidAPIKeyAllowedIP):
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	default:
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to process API key request",
+		})
+	}
+}
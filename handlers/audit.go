@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"miniauth/middleware"
+	"miniauth/service"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type ListAuditEventsResponse struct {
+	Events     []service.AuditEventRecord `json:"events"`
+	NextCursor string                     `json:"next_cursor,omitempty"`
+}
+
+// auditActor describes the client of the current request for the audit log
+func auditActor(ctx echo.Context) service.AuditActor {
+	actor := service.AuditActor{
+		IP:        ctx.RealIP(),
+		UserAgent: ctx.Request().UserAgent(),
+	}
+	if currentUser, ok := ctx.Get("currentUser").(*middleware.SessionData); ok && currentUser != nil {
+		userID := currentUser.UserID
+		actor.UserID = &userID
+	}
+	if serviceAccount, ok := ctx.Get("serviceAccount").(*middleware.ServiceAccountData); ok && serviceAccount != nil {
+		serviceAccountID := serviceAccount.ID
+		actor.ServiceAccountID = &serviceAccountID
+	}
+	return actor
+}
+
+// AdminListAuditEvents lists audit events, newest first
+//
+//	@Summary		List audit events (Admin)
+//	@Description	Query the audit log of security-relevant events. Results are paginated with an opaque cursor.
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			actor_id			query		int		false	"User who performed the action"
+//	@Param			client_id			query		string	false	"OAuth client that performed the action"
+//	@Param			service_account_id	query		int		false	"Service account that performed the action"
+//	@Param			action				query		string	false	"Action, or action prefix ending with * (e.g. user.*)"
+//	@Param			target_type			query		string	false	"Target type"
+//	@Param			target_id			query		string	false	"Target ID"
+//	@Param			org_id				query		int		false	"Organization ID"
+//	@Param			outcome				query		string	false	"Outcome (success or failure)"
+//	@Param			since				query		string	false	"Earliest event time (RFC3339)"
+//	@Param			until				query		string	false	"Latest event time, exclusive (RFC3339)"
+//	@Param			cursor				query		string	false	"Cursor returned with the previous page"
+//	@Param			limit				query		int		false	"Page size (default: 50, max: 200)"
+//	@Success		200					{object}	ListAuditEventsResponse
+//	@Failure		400					{object}	map[string]string
+//	@Failure		401					{object}	map[string]string
+//	@Failure		403					{object}	map[string]string
+//	@Failure		500					{object}	map[string]string
+//	@Router			/admin/audit-events [get]
+func AdminListAuditEvents(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	query, err := parseAuditQuery(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	query.Cursor = ctx.QueryParam("cursor")
+	if limit := ctx.QueryParam("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 1 {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid limit"})
+		}
+	}
+
+	events, next, err := serviceManager.Audit.ListEvents(query)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAuditCursor) {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid cursor"})
+		}
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	response := ListAuditEventsResponse{
+		Events:     make([]service.AuditEventRecord, 0, len(events)),
+		NextCursor: next,
+	}
+	for _, event := range events {
+		response.Events = append(response.Events, service.NewAuditEventRecord(event))
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// AdminExportAuditEvents streams the audit events of a time range for compliance reviews
+//
+//	@Summary		Export audit events (Admin)
+//	@Description	Stream the audit events of a time range, oldest first, as NDJSON or CSV. The other filters of the list endpoint apply as well.
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		application/x-ndjson
+//	@Produce		text/csv
+//	@Param			since				query		string	true	"Earliest event time (RFC3339)"
+//	@Param			until				query		string	true	"Latest event time, exclusive (RFC3339)"
+//	@Param			format				query		string	false	"ndjson (default) or csv"
+//	@Param			actor_id			query		int		false	"User who performed the action"
+//	@Param			client_id			query		string	false	"OAuth client that performed the action"
+//	@Param			service_account_id	query		int		false	"Service account that performed the action"
+//	@Param			action				query		string	false	"Action, or action prefix ending with * (e.g. user.*)"
+//	@Param			target_type			query		string	false	"Target type"
+//	@Param			target_id			query		string	false	"Target ID"
+//	@Param			org_id				query		int		false	"Organization ID"
+//	@Param			outcome				query		string	false	"Outcome (success or failure)"
+//	@Success		200					{file}		file
+//	@Failure		400					{object}	map[string]string
+//	@Failure		401					{object}	map[string]string
+//	@Failure		403					{object}	map[string]string
+//	@Router			/admin/audit-events/export [get]
+func AdminExportAuditEvents(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	query, err := parseAuditQuery(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if query.Since == nil || query.Until == nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "since and until are required"})
+	}
+
+	format := ctx.QueryParam("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	var contentType string
+	switch format {
+	case "ndjson":
+		contentType = "application/x-ndjson"
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+	default:
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid format, expected ndjson or csv"})
+	}
+
+	filename := fmt.Sprintf("audit-events-%s-%s.%s", query.Since.UTC().Format("20060102T150405Z"), query.Until.UTC().Format("20060102T150405Z"), format)
+	response := ctx.Response()
+	response.Header().Set(echo.HeaderContentType, contentType)
+	response.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	response.WriteHeader(http.StatusOK)
+
+	// Headers are sent, so failures past this point can only cut the stream short
+	var write func(service.AuditEventRecord) error
+	if format == "csv" {
+		writer := csv.NewWriter(response)
+		if err := writer.Write(auditCSVHeader); err != nil {
+			return err
+		}
+		write = func(event service.AuditEventRecord) error {
+			writer.Write(auditCSVRow(event))
+			writer.Flush()
+			return writer.Error()
+		}
+	} else {
+		encoder := json.NewEncoder(response)
+		write = func(event service.AuditEventRecord) error {
+			return encoder.Encode(event)
+		}
+	}
+
+	count := 0
+	err = serviceManager.Audit.ExportEvents(query, func(event service.AuditEventRecord) error {
+		if err := write(event); err != nil {
+			return err
+		}
+		if count++; count%100 == 0 {
+			response.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("audit export stopped after %d events: %v", count, err)
+	}
+	response.Flush()
+	return nil
+}
+
+var auditCSVHeader = []string{
+	"id", "created_at", "actor_type", "actor_id", "client_id", "service_account_id", "action",
+	"target_type", "target_id", "org_id", "ip", "user_agent", "outcome", "metadata",
+}
+
+// auditCSVRow renders an audit event as a row under auditCSVHeader
+func auditCSVRow(event service.AuditEventRecord) []string {
+	optionalID := func(id *uint) string {
+		if id == nil {
+			return ""
+		}
+		return strconv.FormatUint(uint64(*id), 10)
+	}
+	return []string{
+		strconv.FormatUint(uint64(event.ID), 10),
+		event.CreatedAt,
+		event.ActorType,
+		optionalID(event.ActorID),
+		event.ClientID,
+		optionalID(event.ServiceAccountID),
+		event.Action,
+		event.TargetType,
+		event.TargetID,
+		optionalID(event.OrgID),
+		event.IP,
+		event.UserAgent,
+		event.Outcome,
+		string(event.Metadata),
+	}
+}
+
+// parseAuditQuery reads the audit event filters shared by the list and export endpoints
+func parseAuditQuery(ctx echo.Context) (service.AuditQuery, error) {
+	query := service.AuditQuery{
+		ClientID:   ctx.QueryParam("client_id"),
+		Action:     ctx.QueryParam("action"),
+		TargetType: ctx.QueryParam("target_type"),
+		TargetID:   ctx.QueryParam("target_id"),
+		Outcome:    ctx.QueryParam("outcome"),
+	}
+
+	var err error
+	if query.ActorID, err = parseOptionalUintParam(ctx, "actor_id"); err != nil {
+		return query, errors.New("Invalid actor_id")
+	}
+	if query.ServiceAccountID, err = parseOptionalUintParam(ctx, "service_account_id"); err != nil {
+		return query, errors.New("Invalid service_account_id")
+	}
+	if query.OrgID, err = parseOptionalUintParam(ctx, "org_id"); err != nil {
+		return query, errors.New("Invalid org_id")
+	}
+	if query.Since, err = parseOptionalTimeParam(ctx, "since"); err != nil {
+		return query, errors.New("Invalid since, expected RFC3339 time")
+	}
+	if query.Until, err = parseOptionalTimeParam(ctx, "until"); err != nil {
+		return query, errors.New("Invalid until, expected RFC3339 time")
+	}
+	return query, nil
+}
+
+// parseOptionalUintParam parses an optional unsigned integer query parameter
+func parseOptionalUintParam(ctx echo.Context, name string) (*uint, error) {
+	raw := ctx.QueryParam(name)
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	id := uint(value)
+	return &id, nil
+}
+
+// parseOptionalTimeParam parses an optional RFC3339 time query parameter
+func parseOptionalTimeParam(ctx echo.Context, name string) (*time.Time, error) {
+	raw := ctx.QueryParam(name)
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
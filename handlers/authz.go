@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"miniauth/service"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxAuthzBatchSize limits the number of checks in a single batch request
+const maxAuthzBatchSize = 100
+
+// AuthzCheckRequest is either a single check or a batch of checks
+type AuthzCheckRequest struct {
+	Subject  string                 `json:"subject"`
+	Action   string                 `json:"action"`
+	Resource string                 `json:"resource"`
+	Checks   []service.AuthzRequest `json:"checks" validate:"omitempty,dive"`
+}
+
+type AuthzBatchResponse struct {
+	Results []service.AuthzDecision `json:"results"`
+}
+
+// AuthzCheck decides whether subjects may perform actions on resources
+//
+//	@Summary		Check authorization
+//	@Description	Policy decision endpoint for downstream services. Send a single check (subject, action, resource) or a batch in "checks". Subjects are "user:<id>"; resources are "org:<slug>" or "system"; actions are permission names or "role:<role>". Authenticated with a service account with the authz:check scope or OAuth client credentials (HTTP Basic). Applications owned by an organization only get answers about that organization.
+//	@Tags			authz
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		AuthzCheckRequest	true	"Authorization check"
+//	@Success		200		{object}	service.AuthzDecision
+//	@Success		200		{object}	AuthzBatchResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/authz/check [post]
+func AuthzCheck(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req AuthzCheckRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if len(req.Checks) > maxAuthzBatchSize {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Too many checks in a single request",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	var caller service.AuthzCaller
+	caller.ClientID, _ = ctx.Get("oauth_client_id").(string)
+
+	if len(req.Checks) > 0 {
+		results, err := serviceManager.Authz.CheckBatch(caller, req.Checks)
+		if err != nil {
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to evaluate authorization checks",
+			})
+		}
+		return ctx.JSON(http.StatusOK, AuthzBatchResponse{Results: results})
+	}
+
+	check := service.AuthzRequest{Subject: req.Subject, Action: req.Action, Resource: req.Resource}
+	if err := ctx.Validate(&check); err != nil {
+		return err
+	}
+
+	decision, err := serviceManager.Authz.Check(caller, check)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to evaluate authorization check",
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, decision)
+}
@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"errors"
+	"miniauth/middleware"
+	"miniauth/service"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ChangeEmailRequest struct {
+	NewEmail        string `json:"newEmail" validate:"required,email"`
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+}
+
+type EmailChangeTokenRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// VerifyEmail verifies a user's email address with a signed token
+//
+//	@Summary		Verify email address
+//	@Description	Mark the user's email address as verified using the token from the verification email
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		VerifyEmailRequest	true	"Verification token"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Router			/email/verify [post]
+func VerifyEmail(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req VerifyEmailRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	if _, err := serviceManager.User.VerifyEmail(req.Token); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid or expired verification token",
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Email verified successfully",
+	})
+}
+
+// VerifyEmailLink verifies a user's email address from the link in the verification email
+//
+//	@Summary		Verify email address (link)
+//	@Description	Verify the email address from the emailed link and redirect to the login page
+//	@Tags			auth
+//	@Param			token	query		string	true	"Verification token"
+//	@Success		302		{string}	string	"Redirect to the login page"
+//	@Router			/email/verify [get]
+func VerifyEmailLink(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	if _, err := serviceManager.User.VerifyEmail(ctx.QueryParam("token")); err != nil {
+		return ctx.Redirect(http.StatusFound, "/login?email_verified=false")
+	}
+
+	return ctx.Redirect(http.StatusFound, "/login?email_verified=true")
+}
+
+// ResendVerificationEmail sends a new verification email
+//
+//	@Summary		Resend verification email
+//	@Description	Send a new verification email, at most once per cooldown for each account. The response does not reveal whether the address is registered.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		ResendVerificationRequest	true	"Email address"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		429		{object}	map[string]string
+//	@Router			/email/resend-verification [post]
+func ResendVerificationEmail(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req ResendVerificationRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	// The request is handled in the background, so neither the response nor its timing reveals
+	// which addresses exist
+	serviceManager.User.QueueVerificationEmail(req.Email)
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "If the address belongs to an unverified account, a verification email has been sent",
+	})
+}
+
+// RequestEmailChange starts an email address change for the current user
+//
+//	@Summary		Request email change
+//	@Description	Send a confirmation link to the new email address. The address is changed once the link is confirmed, and the previous address receives a notification with a revert link.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		ChangeEmailRequest	true	"Change email request"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/me/email [post]
+func RequestEmailChange(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req ChangeEmailRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	user, err := serviceManager.User.GetUserByID(currentUser.UserID)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get user",
+		})
+	}
+
+	if !user.CheckPassword(req.CurrentPassword) {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Current password is incorrect",
+		})
+	}
+
+	if err := serviceManager.User.RequestEmailChange(user, req.NewEmail); err != nil {
+		switch {
+		case errors.Is(err, service.ErrEmailInUse):
+			return ctx.JSON(http.StatusConflict, map[string]string{
+				"error": "This email address is already used by another account",
+			})
+		case errors.Is(err, service.ErrEmailUnchanged):
+			return ctx.JSON(http.StatusBadRequest, map[string]string{
+				"error": "The new email address is the same as the current one",
+			})
+		}
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to send confirmation email",
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "A confirmation link has been sent to the new email address",
+	})
+}
+
+// ConfirmEmailChange confirms a pending email address change
+//
+//	@Summary		Confirm email change
+//	@Description	Apply a pending email change using the token sent to the new address
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		EmailChangeTokenRequest	true	"Confirmation token"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Router			/email/change/confirm [post]
+func ConfirmEmailChange(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req EmailChangeTokenRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	if _, err := serviceManager.User.ConfirmEmailChange(req.Token); err != nil {
+		return emailChangeErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Email address changed successfully",
+	})
+}
+
+// ConfirmEmailChangeLink confirms a pending email address change from the emailed link
+//
+//	@Summary		Confirm email change (link)
+//	@Description	Apply a pending email change from the emailed link and redirect to the profile page
+//	@Tags			auth
+//	@Param			token	query		string	true	"Confirmation token"
+//	@Success		302		{string}	string	"Redirect to the profile page"
+//	@Router			/email/change/confirm [get]
+func ConfirmEmailChangeLink(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	if _, err := serviceManager.User.ConfirmEmailChange(ctx.QueryParam("token")); err != nil {
+		return ctx.Redirect(http.StatusFound, "/profile?email_changed=false")
+	}
+
+	return ctx.Redirect(http.StatusFound, "/profile?email_changed=true")
+}
+
+// RevertEmailChange restores the previous email address
+//
+//	@Summary		Revert email change
+//	@Description	Restore the previous email address using the token sent to it, and revoke all sessions and OAuth tokens
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		EmailChangeTokenRequest	true	"Revert token"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Router			/email/change/revert [post]
+func RevertEmailChange(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req EmailChangeTokenRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	if _, err := serviceManager.User.RevertEmailChange(req.Token); err != nil {
+		return emailChangeErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Email address restored and all sessions signed out",
+	})
+}
+
+// RevertEmailChangeLink restores the previous email address from the emailed link
+//
+//	@Summary		Revert email change (link)
+//	@Description	Restore the previous email address from the emailed link and redirect to the login page
+//	@Tags			auth
+//	@Param			token	query		string	true	"Revert token"
+//	@Success		302		{string}	string	"Redirect to the login page"
+//	@Router			/email/change/revert [get]
+func RevertEmailChangeLink(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	if _, err := serviceManager.User.RevertEmailChange(ctx.QueryParam("token")); err != nil {
+		return ctx.Redirect(http.StatusFound, "/login?email_reverted=false")
+	}
+
+	return ctx.Redirect(http.StatusFound, "/login?email_reverted=true")
+}
+
+// emailChangeErrorResponse maps email change errors to HTTP responses
+func emailChangeErrorResponse(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrEmailInUse):
+		return ctx.JSON(http.StatusConflict, map[string]string{
+			"error": "This email address is already used by another account",
+		})
+	case errors.Is(err, service.ErrInvalidSignedToken):
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid or expired token",
+		})
+	}
+	return ctx.JSON(http.StatusInternalServerError, map[string]string{
+		"error": "Failed to update email address",
+	})
+}
@@ -0,0 +1,531 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"miniauth/database"
+	"miniauth/middleware"
+	"miniauth/service"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	// federatedLoginCookie keeps the state of a sign in with an identity provider until it redirects back
+	federatedLoginCookie     = "federated_login"
+	federatedLoginCookiePath = "/api/auth/providers"
+)
+
+type IdentityProviderResponse struct {
+	ID               uint   `json:"id"`
+	Slug             string `json:"slug"`
+	Name             string `json:"name"`
+	Type             string `json:"type"`
+	ClientID         string `json:"client_id"`
+	ClientSecretSet  bool   `json:"client_secret_set"`
+	Issuer           string `json:"issuer,omitempty"`
+	AuthorizationURL string `json:"authorization_url,omitempty"`
+	TokenURL         string `json:"token_url,omitempty"`
+	UserInfoURL      string `json:"userinfo_url,omitempty"`
+	EmailsURL        string `json:"emails_url,omitempty"`
+	Scopes           string `json:"scopes"`
+	SubjectClaim     string `json:"subject_claim"`
+	EmailClaim       string `json:"email_claim"`
+	UsernameClaim    string `json:"username_claim"`
+	TrustEmail       bool   `json:"trust_email"`
+	AllowSignup      bool   `json:"allow_signup"`
+	Enabled          bool   `json:"enabled"`
+	CallbackURL      string `json:"callback_url"`
+	CreatedAt        string `json:"created_at"`
+	UpdatedAt        string `json:"updated_at"`
+}
+
+type ListIdentityProvidersResponse struct {
+	Providers []IdentityProviderResponse `json:"providers"`
+}
+
+type LoginProviderResponse struct {
+	Slug     string `json:"slug"`
+	Name     string `json:"name"`
+	LoginURL string `json:"login_url"`
+}
+
+type ListLoginProvidersResponse struct {
+	Providers []LoginProviderResponse `json:"providers"`
+}
+
+type LinkedIdentityResponse struct {
+	ID           uint   `json:"id"`
+	ProviderSlug string `json:"provider_slug"`
+	ProviderName string `json:"provider_name"`
+	Email        string `json:"email,omitempty"`
+	CreatedAt    string `json:"created_at"`
+	LastLoginAt  string `json:"last_login_at,omitempty"`
+}
+
+type ListLinkedIdentitiesResponse struct {
+	Identities []LinkedIdentityResponse `json:"identities"`
+}
+
+// ListLoginProviders lists the identity providers users can sign in with
+//
+//	@Summary		List sign in providers
+//	@Description	List the enabled upstream identity providers shown as "Sign in with" buttons
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	ListLoginProvidersResponse
+//	@Failure		500	{object}	map[string]string
+//	@Router			/auth/providers [get]
+func ListLoginProviders(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	providers, err := serviceManager.Federation.ListEnabledProviders()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to list identity providers",
+		})
+	}
+
+	response := ListLoginProvidersResponse{Providers: make([]LoginProviderResponse, 0, len(providers))}
+	for _, provider := range providers {
+		response.Providers = append(response.Providers, LoginProviderResponse{
+			Slug:     provider.Slug,
+			Name:     provider.Name,
+			LoginURL: "/api/auth/providers/" + provider.Slug + "/login",
+		})
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// FederatedLogin starts signing in with an identity provider
+//
+//	@Summary		Sign in with provider
+//	@Description	Redirect to the identity provider to sign in. After signing in the user is redirected to the given path.
+//	@Tags			auth
+//	@Param			slug		path		string	true	"Provider slug"
+//	@Param			redirect	query		string	false	"Path to return to after signing in"
+//	@Success		302			{string}	string	"Redirect to the identity provider"
+//	@Router			/auth/providers/{slug}/login [get]
+func FederatedLogin(ctx echo.Context) error {
+	return startFederatedLogin(ctx, 0, "/login")
+}
+
+// LinkIdentity starts linking an identity provider account to the current user
+//
+//	@Summary		Link identity
+//	@Description	Redirect to the identity provider to link its account to the current user. Afterwards the user is redirected to the given path.
+//	@Tags			user
+//	@Security		BasicAuth
+//	@Param			slug		path		string	true	"Provider slug"
+//	@Param			redirect	query		string	false	"Path to return to after linking"
+//	@Success		302			{string}	string	"Redirect to the identity provider"
+//	@Failure		401			{object}	map[string]string
+//	@Router			/me/identities/{slug}/link [get]
+func LinkIdentity(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	return startFederatedLogin(ctx, currentUser.UserID, "/profile")
+}
+
+func startFederatedLogin(ctx echo.Context, linkUserID uint, failurePath string) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	slug := ctx.Param("slug")
+
+	login, err := serviceManager.Federation.StartLogin(slug, ctx.QueryParam("redirect"), linkUserID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("federated sign in with %s failed: %v", slug, err)
+		}
+		return ctx.Redirect(http.StatusFound, withQueryParam(failurePath, "federated_error", service.FederationErrorProvider))
+	}
+
+	ctx.SetCookie(&http.Cookie{
+		Name:     federatedLoginCookie,
+		Value:    login.State,
+		Path:     federatedLoginCookiePath,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   serviceManager.Federation.SecureCookies(),
+		SameSite: http.SameSiteLaxMode, // Sent on the top-level redirect back from the provider
+	})
+	return ctx.Redirect(http.StatusFound, login.AuthorizationURL)
+}
+
+// FederatedCallback completes signing in with, or linking, an identity provider
+//
+//	@Summary		Identity provider callback
+//	@Description	Redirect target of identity providers. Signs the user in, or links the account, and redirects to the path given when starting. Failures redirect with a federated_error query parameter.
+//	@Tags			auth
+//	@Param			slug	path		string	true	"Provider slug"
+//	@Param			code	query		string	false	"Authorization code"
+//	@Param			state	query		string	true	"State"
+//	@Success		302		{string}	string	"Redirect to the application"
+//	@Router			/auth/providers/{slug}/callback [get]
+func FederatedCallback(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	sessionManager := ctx.Get("sessionManager").(*middleware.SessionManager)
+	slug := ctx.Param("slug")
+
+	var stateCookie string
+	if cookie, err := ctx.Cookie(federatedLoginCookie); err == nil {
+		stateCookie = cookie.Value
+	}
+	ctx.SetCookie(&http.Cookie{
+		Name:     federatedLoginCookie,
+		Path:     federatedLoginCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   serviceManager.Federation.SecureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	var sessionUserID uint
+	if session, err := sessionManager.GetSession(ctx); err == nil {
+		sessionUserID = session.UserID
+	}
+
+	// Providers redirect with an error instead of a code when the user cancels
+	code := ctx.QueryParam("code")
+	if ctx.QueryParam("error") != "" {
+		code = ""
+	}
+	result, err := serviceManager.Federation.CompleteLogin(auditActor(ctx), slug, code, ctx.QueryParam("state"), stateCookie, sessionUserID)
+	if err != nil {
+		errorCode := service.FederationErrorCode(err)
+		if errorCode == service.FederationErrorProvider {
+			log.Printf("federated sign in with %s failed: %v", slug, err)
+		}
+		failurePath := "/login"
+		if serviceManager.Federation.IsLinkState(stateCookie) {
+			failurePath = serviceManager.Federation.LoginRedirect(stateCookie)
+		}
+		return ctx.Redirect(http.StatusFound, withQueryParam(failurePath, "federated_error", errorCode))
+	}
+
+	if result.Linked {
+		return ctx.Redirect(http.StatusFound, withQueryParam(result.Redirect, "identity_linked", slug))
+	}
+	if err := sessionManager.CreateSession(ctx, result.User); err != nil {
+		return ctx.Redirect(http.StatusFound, withQueryParam("/login", "federated_error", service.FederationErrorProvider))
+	}
+	return ctx.Redirect(http.StatusFound, result.Redirect)
+}
+
+// withQueryParam adds a query parameter to a relative URL
+func withQueryParam(path, key, value string) string {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + url.QueryEscape(key) + "=" + url.QueryEscape(value)
+}
+
+// ListMyIdentities lists the identity provider accounts linked to the current user
+//
+//	@Summary		List linked identities
+//	@Description	List the identity provider accounts linked to the current user
+//	@Tags			user
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Success		200	{object}	ListLinkedIdentitiesResponse
+//	@Failure		401	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/me/identities [get]
+func ListMyIdentities(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	identities, providers, err := serviceManager.Federation.ListUserIdentities(currentUser.UserID)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to list linked identities",
+		})
+	}
+
+	response := ListLinkedIdentitiesResponse{Identities: make([]LinkedIdentityResponse, 0, len(identities))}
+	for _, identity := range identities {
+		provider := providers[identity.ProviderID]
+		item := LinkedIdentityResponse{
+			ID:           identity.ID,
+			ProviderSlug: provider.Slug,
+			ProviderName: provider.Name,
+			Email:        identity.Email,
+			CreatedAt:    identity.CreatedAt.Format("2006-01-02 15:04:05"),
+		}
+		if identity.LastLoginAt != nil {
+			item.LastLoginAt = identity.LastLoginAt.Format("2006-01-02 15:04:05")
+		}
+		response.Identities = append(response.Identities, item)
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// UnlinkMyIdentity unlinks an identity provider account from the current user
+//
+//	@Summary		Unlink identity
+//	@Description	Unlink an identity provider account from the current user. Users created through a provider must set a password, with a password reset, before unlinking their last identity.
+//	@Tags			user
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id	path		int	true	"Linked identity ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		409	{object}	map[string]string
+//	@Router			/me/identities/{id} [delete]
+func UnlinkMyIdentity(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid identity ID",
+		})
+	}
+
+	switch err := serviceManager.Federation.UnlinkIdentity(currentUser.UserID, uint(id)); {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ctx.JSON(http.StatusNotFound, map[string]string{
+			"error": "Linked identity not found",
+		})
+	case errors.Is(err, service.ErrLastIdentity):
+		return ctx.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	case err != nil:
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to unlink identity",
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Identity unlinked",
+	})
+}
+
+// AdminListIdentityProviders lists identity providers
+//
+//	@Summary		List identity providers (Admin)
+//	@Description	List the upstream identity providers users can sign in with
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Success		200	{object}	ListIdentityProvidersResponse
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/admin/identity-providers [get]
+func AdminListIdentityProviders(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	providers, err := serviceManager.Federation.ListProviders()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to list identity providers",
+		})
+	}
+
+	response := ListIdentityProvidersResponse{Providers: make([]IdentityProviderResponse, 0, len(providers))}
+	for i := range providers {
+		response.Providers = append(response.Providers, newIdentityProviderResponse(ctx, &providers[i]))
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// AdminCreateIdentityProvider creates an identity provider
+//
+//	@Summary		Create identity provider (Admin)
+//	@Description	Add an OpenID Connect or OAuth 2.0 provider users can sign in with. Register the returned callback_url as redirect URI at the provider.
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		service.IdentityProviderRequest	true	"Identity provider"
+//	@Success		201		{object}	IdentityProviderResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/admin/identity-providers [post]
+func AdminCreateIdentityProvider(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req service.IdentityProviderRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	provider, err := serviceManager.Federation.CreateProvider(req)
+	if err != nil {
+		return identityProviderErrorResponse(ctx, err)
+	}
+	return ctx.JSON(http.StatusCreated, newIdentityProviderResponse(ctx, provider))
+}
+
+// AdminGetIdentityProvider returns an identity provider
+//
+//	@Summary		Get identity provider (Admin)
+//	@Description	Get an identity provider
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id	path		int	true	"Identity provider ID"
+//	@Success		200	{object}	IdentityProviderResponse
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Router			/admin/identity-providers/{id} [get]
+func AdminGetIdentityProvider(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid identity provider ID",
+		})
+	}
+
+	provider, err := serviceManager.Federation.GetProvider(uint(id))
+	if err != nil {
+		return identityProviderErrorResponse(ctx, err)
+	}
+	return ctx.JSON(http.StatusOK, newIdentityProviderResponse(ctx, provider))
+}
+
+// AdminUpdateIdentityProvider updates an identity provider
+//
+//	@Summary		Update identity provider (Admin)
+//	@Description	Update an identity provider. The client secret is kept when omitted.
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int								true	"Identity provider ID"
+//	@Param			request	body		service.IdentityProviderRequest	true	"Identity provider"
+//	@Success		200		{object}	IdentityProviderResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Router			/admin/identity-providers/{id} [put]
+func AdminUpdateIdentityProvider(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid identity provider ID",
+		})
+	}
+
+	var req service.IdentityProviderRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	provider, err := serviceManager.Federation.UpdateProvider(uint(id), req)
+	if err != nil {
+		return identityProviderErrorResponse(ctx, err)
+	}
+	return ctx.JSON(http.StatusOK, newIdentityProviderResponse(ctx, provider))
+}
+
+// AdminDeleteIdentityProvider deletes an identity provider
+//
+//	@Summary		Delete identity provider (Admin)
+//	@Description	Delete an identity provider and unlink all accounts linked through it
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id	path		int	true	"Identity provider ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Router			/admin/identity-providers/{id} [delete]
+func AdminDeleteIdentityProvider(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid identity provider ID",
+		})
+	}
+
+	if err := serviceManager.Federation.DeleteProvider(uint(id)); err != nil {
+		return identityProviderErrorResponse(ctx, err)
+	}
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Identity provider deleted",
+	})
+}
+
+func newIdentityProviderResponse(ctx echo.Context, provider *database.IdentityProvider) IdentityProviderResponse {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	return IdentityProviderResponse{
+		ID:               provider.ID,
+		Slug:             provider.Slug,
+		Name:             provider.Name,
+		Type:             string(provider.Type),
+		ClientID:         provider.ClientID,
+		ClientSecretSet:  provider.ClientSecret != "",
+		Issuer:           provider.Issuer,
+		AuthorizationURL: provider.AuthorizationURL,
+		TokenURL:         provider.TokenURL,
+		UserInfoURL:      provider.UserInfoURL,
+		EmailsURL:        provider.EmailsURL,
+		Scopes:           provider.Scopes,
+		SubjectClaim:     provider.SubjectClaim,
+		EmailClaim:       provider.EmailClaim,
+		UsernameClaim:    provider.UsernameClaim,
+		TrustEmail:       provider.TrustEmail,
+		AllowSignup:      provider.AllowSignup,
+		Enabled:          provider.Enabled,
+		CallbackURL:      serviceManager.Federation.CallbackURL(provider.Slug),
+		CreatedAt:        provider.CreatedAt.Format("2006-01-02 15:04:05"),
+		UpdatedAt:        provider.UpdatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+func identityProviderErrorResponse(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ctx.JSON(http.StatusNotFound, map[string]string{
+			"error": "Identity provider not found",
+		})
+	case errors.Is(err, service.ErrInvalidIdentityProvider):
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrIdentityProviderExists):
+		return ctx.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	default:
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to process identity provider request",
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"errors"
+	"miniauth/database"
+	"miniauth/middleware"
+	"miniauth/service"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type CreateOrgInvitationRequest struct {
+	Email string                 `json:"email" validate:"required,email"`
+	Role  database.OrgMemberRole `json:"role" validate:"required,oneof=owner admin member guest"`
+}
+
+type InvitationTokenRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type OrgInvitationResponse struct {
+	ID        uint   `json:"id"`
+	OrgName   string `json:"org_name"`
+	OrgSlug   string `json:"org_slug"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	Status    string `json:"status"`
+	ExpiresAt string `json:"expires_at"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ListOrgInvitationsResponse struct {
+	Invitations []OrgInvitationResponse `json:"invitations"`
+}
+
+// CreateOrgInvitation invites an email address to join an organization
+//
+//	@Summary		Invite to organization
+//	@Description	Send an invitation to join the organization with a role. Requires the members:invite permission; the invited role cannot exceed the inviter's role.
+//	@Tags			orgs
+//	@Accept			json
+//	@Produce		json
+//	@Param			slug	path		string						true	"Organization slug"
+//	@Param			request	body		CreateOrgInvitationRequest	true	"Invitation details"
+//	@Success		201		{object}	OrgInvitationResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/invitations [post]
+func CreateOrgInvitation(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req CreateOrgInvitationRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	org, member, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	inviter, err := serviceManager.User.GetUserByID(currentUser.UserID)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get user",
+		})
+	}
+
+	invitation, err := serviceManager.Org.CreateOrgInvitation(org, inviter, member.Role, req.Email, req.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrOrgPermissionDenied):
+			return orgErrorResponse(ctx, err)
+		case errors.Is(err, service.ErrAlreadyOrgMember):
+			return orgMemberErrorResponse(ctx, err)
+		}
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to send invitation",
+		})
+	}
+
+	return ctx.JSON(http.StatusCreated, newOrgInvitationResponse(invitation))
+}
+
+// ListOrgInvitations lists the pending invitations of an organization
+//
+//	@Summary		List organization invitations
+//	@Description	List the pending invitations of an organization. Requires the members:invite permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Success		200		{object}	ListOrgInvitationsResponse
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/invitations [get]
+func ListOrgInvitations(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	invitations, err := serviceManager.Org.ListOrgInvitations(org.ID)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get invitations",
+		})
+	}
+
+	response := ListOrgInvitationsResponse{Invitations: make([]OrgInvitationResponse, 0, len(invitations))}
+	for i := range invitations {
+		invitations[i].Org = *org
+		response.Invitations = append(response.Invitations, newOrgInvitationResponse(&invitations[i]))
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// RevokeOrgInvitation revokes a pending invitation
+//
+//	@Summary		Revoke organization invitation
+//	@Description	Revoke a pending invitation so it can no longer be accepted. Requires the members:invite permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Param			id		path		int		true	"Invitation ID"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/invitations/{id} [delete]
+func RevokeOrgInvitation(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	invitationID, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid invitation ID",
+		})
+	}
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	if err := serviceManager.Org.RevokeOrgInvitation(org.ID, uint(invitationID)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ctx.JSON(http.StatusNotFound, map[string]string{
+				"error": "Invitation not found",
+			})
+		}
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to revoke invitation",
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Invitation revoked successfully",
+	})
+}
+
+// GetInvitation retrieves a pending invitation by token
+//
+//	@Summary		Get invitation
+//	@Description	Get the organization and role of a pending invitation, for display before accepting or declining
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			token	query		string	true	"Invitation token"
+//	@Success		200		{object}	OrgInvitationResponse
+//	@Failure		404		{object}	map[string]string
+//	@Router			/invitations [get]
+func GetInvitation(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	invitation, err := serviceManager.Org.GetOrgInvitationByToken(ctx.QueryParam("token"))
+	if err != nil {
+		return invitationErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, newOrgInvitationResponse(invitation))
+}
+
+// AcceptInvitation accepts an invitation for the current user
+//
+//	@Summary		Accept invitation
+//	@Description	Join the organization with the invited role. The current user's email must match the invited address.
+//	@Tags			orgs
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		InvitationTokenRequest	true	"Invitation token"
+//	@Success		200		{object}	OrgInvitationResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/invitations/accept [post]
+func AcceptInvitation(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req InvitationTokenRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	user, err := serviceManager.User.GetUserByID(currentUser.UserID)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get user",
+		})
+	}
+
+	invitation, err := serviceManager.Org.AcceptOrgInvitation(req.Token, user)
+	if err != nil {
+		return invitationErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, newOrgInvitationResponse(invitation))
+}
+
+// DeclineInvitation declines an invitation
+//
+//	@Summary		Decline invitation
+//	@Description	Decline a pending invitation
+//	@Tags			orgs
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		InvitationTokenRequest	true	"Invitation token"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/invitations/decline [post]
+func DeclineInvitation(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req InvitationTokenRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	if err := serviceManager.Org.DeclineOrgInvitation(req.Token); err != nil {
+		return invitationErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Invitation declined",
+	})
+}
+
+// invitationErrorResponse maps invitation errors to HTTP responses
+func invitationErrorResponse(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidInvitation):
+		return ctx.JSON(http.StatusNotFound, map[string]string{
+			"error": "Invalid or expired invitation",
+		})
+	case errors.Is(err, service.ErrInvitationEmailMismatch):
+		return ctx.JSON(http.StatusForbidden, map[string]string{
+			"error": "This invitation was sent to a different email address",
+		})
+	case errors.Is(err, service.ErrAlreadyOrgMember):
+		return orgMemberErrorResponse(ctx, err)
+	}
+	return ctx.JSON(http.StatusInternalServerError, map[string]string{
+		"error": "Failed to process invitation",
+	})
+}
+
+func newOrgInvitationResponse(invitation *database.OrgInvitation) OrgInvitationResponse {
+	return OrgInvitationResponse{
+		ID:        invitation.ID,
+		OrgName:   invitation.Org.Name,
+		OrgSlug:   invitation.Org.Slug,
+		Email:     invitation.Email,
+		Role:      string(invitation.Role),
+		Status:    string(invitation.Status),
+		ExpiresAt: invitation.ExpiresAt.Format("2006-01-02 15:04:05"),
+		CreatedAt: invitation.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"miniauth/database"
 	"miniauth/middleware"
@@ -25,8 +26,10 @@ import (
 //	@Param			state					query		string	false	"State parameter for CSRF protection"
 //	@Param			code_challenge			query		string	false	"PKCE code challenge"
 //	@Param			code_challenge_method	query		string	false	"PKCE code challenge method"
+//	@Param			organization			query		string	false	"Slug of the active organization to bind to the tokens"
 //	@Success		302						{string}	string	"Redirect to authorization page or back to client"
 //	@Failure		400						{object}	map[string]string
+//	@Failure		403						{object}	map[string]string
 //	@Router			/oauth/authorize [get]
 func OAuthAuthorize(c echo.Context) error {
 	// Parse query parameters
@@ -38,6 +41,7 @@ func OAuthAuthorize(c echo.Context) error {
 		State:               c.QueryParam("state"),
 		CodeChallenge:       c.QueryParam("code_challenge"),
 		CodeChallengeMethod: c.QueryParam("code_challenge_method"),
+		Organization:        c.QueryParam("organization"),
 	}
 
 	// Validate request
@@ -65,12 +69,31 @@ func OAuthAuthorize(c echo.Context) error {
 		if req.CodeChallenge != "" {
 			loginURL += fmt.Sprintf("&code_challenge=%s&code_challenge_method=%s", req.CodeChallenge, req.CodeChallengeMethod)
 		}
+		if req.Organization != "" {
+			loginURL += fmt.Sprintf("&organization=%s", req.Organization)
+		}
 		return c.Redirect(http.StatusFound, loginURL)
 	}
 
+	// Enforce the email verification policy
+	if err := ensureEmailVerifiedForOAuth(serviceManager, user.ID); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "access_denied", "error_description": err.Error()})
+	}
+
+	// Enforce the members-only restriction of organization applications
+	if err := oauthService.CheckApplicationAccess(app, user.ID); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "access_denied", "error_description": err.Error()})
+	}
+
+	// Resolve the active organization requested with the organization parameter
+	org, err := oauthService.ResolveAuthorizationOrg(app, user.ID, req.Organization)
+	if err != nil {
+		return authorizationOrgErrorResponse(c, err)
+	}
+
 	// For trusted applications, automatically grant authorization
 	if app.Trusted {
-		code, err := oauthService.CreateAuthorizationCode(user.ID, app, req)
+		code, err := oauthService.CreateAuthorizationCode(user.ID, app, req, org)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error", "error_description": err.Error()})
 		}
@@ -83,6 +106,17 @@ func OAuthAuthorize(c echo.Context) error {
 		return c.Redirect(http.StatusFound, redirectURL)
 	}
 
+	// Organizations the user can choose as the active organization on the consent screen
+	organizations, err := authorizationOrgChoices(serviceManager, app, user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error", "error_description": err.Error()})
+	}
+
+	selected := ""
+	if org != nil {
+		selected = org.Slug
+	}
+
 	// Return authorization page data (frontend will handle the consent UI)
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"client_name":           app.Name,
@@ -93,6 +127,8 @@ func OAuthAuthorize(c echo.Context) error {
 		"response_type":         req.ResponseType,
 		"code_challenge":        req.CodeChallenge,
 		"code_challenge_method": req.CodeChallengeMethod,
+		"organization":          selected,
+		"organizations":         organizations,
 		"user": map[string]interface{}{
 			"id":       user.ID,
 			"username": user.Username,
@@ -156,19 +192,38 @@ func OAuthAuthorizeDecision(c echo.Context) error {
 	if codeChallengeMethod, ok := requestBody["code_challenge_method"].(string); ok {
 		req.CodeChallengeMethod = codeChallengeMethod
 	}
+	if organization, ok := requestBody["organization"].(string); ok {
+		req.Organization = organization
+	}
 
 	// Get OAuth service
 	serviceManager := c.Get("serviceManager").(*service.ServiceManager)
 	oauthService := serviceManager.OAuth
 
+	// Enforce the email verification policy
+	if err := ensureEmailVerifiedForOAuth(serviceManager, user.ID); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "access_denied", "error_description": err.Error()})
+	}
+
 	// Validate the authorization request again
 	app, err := oauthService.ValidateAuthorizationRequest(req)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": err.Error()})
 	}
 
+	// Enforce the members-only restriction of organization applications
+	if err := oauthService.CheckApplicationAccess(app, user.ID); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "access_denied", "error_description": err.Error()})
+	}
+
+	// Resolve the active organization chosen on the consent screen
+	org, err := oauthService.ResolveAuthorizationOrg(app, user.ID, req.Organization)
+	if err != nil {
+		return authorizationOrgErrorResponse(c, err)
+	}
+
 	// Create authorization code
-	code, err := oauthService.CreateAuthorizationCode(user.ID, app, req)
+	code, err := oauthService.CreateAuthorizationCode(user.ID, app, req, org)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error", "error_description": err.Error()})
 	}
@@ -182,6 +237,50 @@ func OAuthAuthorizeDecision(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"redirect_url": redirectURL})
 }
 
+// authorizationOrgChoices lists the organizations the user can bind to an authorization.
+// Members-only applications can only be bound to their own organization.
+func authorizationOrgChoices(serviceManager *service.ServiceManager, app *database.OAuthApplication, userID uint) ([]map[string]interface{}, error) {
+	orgs, err := serviceManager.Org.GetUserOrgsWithRoles(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	choices := make([]map[string]interface{}, 0, len(orgs))
+	for _, org := range orgs {
+		if app.OrgID != nil && app.MembersOnly && *app.OrgID != org.ID {
+			continue
+		}
+		choices = append(choices, map[string]interface{}{
+			"id":   org.ID,
+			"name": org.Name,
+			"slug": org.Slug,
+			"role": org.Role,
+		})
+	}
+	return choices, nil
+}
+
+// authorizationOrgErrorResponse maps errors resolving the active organization to OAuth error responses
+func authorizationOrgErrorResponse(c echo.Context, err error) error {
+	if errors.Is(err, service.ErrInvalidAuthorizationOrg) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error", "error_description": err.Error()})
+}
+
+// ensureEmailVerifiedForOAuth checks the email verification policy for the given user
+func ensureEmailVerifiedForOAuth(serviceManager *service.ServiceManager, userID uint) error {
+	user, err := serviceManager.User.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := serviceManager.User.EnsureEmailVerifiedForOAuth(user); err != nil {
+		return fmt.Errorf("email address must be verified before authorizing applications")
+	}
+	return nil
+}
+
 // OAuth Token endpoint
 //
 //	@Summary		OAuth Token
@@ -225,9 +324,9 @@ func OAuthToken(c echo.Context) error {
 
 	switch req.GrantType {
 	case "authorization_code":
-		tokenResponse, err = oauthService.ExchangeCodeForToken(req)
+		tokenResponse, err = oauthService.ExchangeCodeForToken(auditActor(c), req)
 	case "refresh_token":
-		tokenResponse, err = oauthService.RefreshAccessToken(req)
+		tokenResponse, err = oauthService.RefreshAccessToken(auditActor(c), req)
 	default:
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
 	}
@@ -242,7 +341,7 @@ func OAuthToken(c echo.Context) error {
 // OAuth User Info endpoint
 //
 //	@Summary		OAuth User Info
-//	@Description	Get user information using access token
+//	@Description	Get user information using access token. Tokens bound to an active organization include org_id, org_slug, org_role and org_teams; the full list of organizations requires the organizations scope.
 //	@Tags			OAuth
 //	@Accept			json
 //	@Produce		json
@@ -268,7 +367,7 @@ func OAuthUserInfo(c echo.Context) error {
 	oauthService := serviceManager.OAuth
 
 	// Validate access token
-	user, scopes, err := oauthService.ValidateAccessToken(token)
+	user, scopes, activeOrg, err := oauthService.ValidateAccessToken(token)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
 	}
@@ -278,27 +377,51 @@ func OAuthUserInfo(c echo.Context) error {
 		"sub": user.ID, // Subject (user ID)
 	}
 
+	// Include the active organization chosen during authorization
+	if activeOrg != nil {
+		userInfo["org_id"] = activeOrg.ID
+		userInfo["org_slug"] = activeOrg.Slug
+		userInfo["org_role"] = activeOrg.Role
+
+		teams, _ := serviceManager.Org.GetUserTeams(activeOrg.ID, user.ID)
+		teamSlugs := make([]string, 0, len(teams))
+		for _, team := range teams {
+			teamSlugs = append(teamSlugs, team.Slug)
+		}
+		userInfo["org_teams"] = teamSlugs
+	}
+
 	// Add information based on granted scopes
 	for _, scope := range scopes {
 		switch scope {
 		case "profile":
 			userInfo["username"] = user.Username
 			userInfo["email"] = user.Email
+			userInfo["email_verified"] = user.EmailVerified
 		case "read":
 			userInfo["id"] = user.ID
 			userInfo["role"] = user.Role
-
-			// Include organization information if user has organizations
+		case "organizations":
+			// Include all organizations of the user
 			orgService := serviceManager.Org
 			orgs, err := orgService.GetUserOrgsWithRoles(user.ID)
 			if err == nil && len(orgs) > 0 {
+				// Team slugs let applications gate features by team; they are left empty if teams cannot be loaded
+				teamsByOrg, _ := orgService.GetUserTeamsByOrg(user.ID)
+
 				orgInfo := make([]map[string]interface{}, len(orgs))
 				for i, org := range orgs {
+					teams := make([]string, 0, len(teamsByOrg[org.ID]))
+					for _, team := range teamsByOrg[org.ID] {
+						teams = append(teams, team.Slug)
+					}
+
 					orgInfo[i] = map[string]interface{}{
-						"id":   org.ID,
-						"name": org.Name,
-						"slug": org.Slug,
-						"role": org.Role,
+						"id":    org.ID,
+						"name":  org.Name,
+						"slug":  org.Slug,
+						"role":  org.Role,
+						"teams": teams,
 					}
 				}
 				userInfo["organizations"] = orgInfo
@@ -309,6 +432,47 @@ func OAuthUserInfo(c echo.Context) error {
 	return c.JSON(http.StatusOK, userInfo)
 }
 
+// OAuth Token Introspection endpoint
+//
+//	@Summary		OAuth Token Introspection
+//	@Description	Report whether an access token is active, and the user, client, scopes and active organization it grants (RFC 7662). Resource servers authenticate with an API key with the tokens:introspect scope, or with OAuth client credentials over HTTP Basic authentication. Clients and keys of an application only see tokens issued to that application, and keys of an organization only tokens issued to its applications; other tokens are reported as inactive.
+//	@Tags			OAuth
+//	@Accept			application/x-www-form-urlencoded
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BasicAuth
+//	@Param			token			formData	string	true	"Access token"
+//	@Param			token_type_hint	formData	string	false	"Ignored; only access tokens can be introspected"
+//	@Success		200				{object}	service.TokenIntrospection
+//	@Failure		400				{object}	map[string]string
+//	@Failure		401				{object}	map[string]string
+//	@Failure		403				{object}	map[string]string
+//	@Failure		429				{object}	map[string]string
+//	@Failure		500				{object}	map[string]string
+//	@Router			/oauth/introspect [post]
+func OAuthIntrospect(c echo.Context) error {
+	token := c.FormValue("token")
+	if token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "token is required"})
+	}
+
+	var caller service.IntrospectionCaller
+	if apiKey, ok := c.Get("apiKey").(*middleware.APIKeyData); ok {
+		caller.ClientID = apiKey.ClientID
+		caller.OrgID = apiKey.OrgID
+	} else {
+		caller.ClientID, _ = c.Get("oauth_client_id").(string)
+	}
+
+	serviceManager := c.Get("serviceManager").(*service.ServiceManager)
+	introspection, err := serviceManager.OAuth.IntrospectToken(caller, token)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+
+	return c.JSON(http.StatusOK, introspection)
+}
+
 // Create OAuth Application endpoint (Admin only)
 //
 //	@Summary		Create OAuth Application
@@ -361,7 +525,7 @@ func AdminCreateOAuthApplication(c echo.Context) error {
 // List OAuth Applications endpoint (Admin only)
 //
 //	@Summary		List OAuth Applications
-//	@Description	Get all OAuth applications (admin only)
+//	@Description	Get all OAuth applications, including those owned by organizations (admin only)
 //	@Tags			OAuth
 //	@Accept			json
 //	@Produce		json
@@ -390,6 +554,7 @@ func AdminListOAuthApplications(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error", "error_description": err.Error()})
 	}
+	oauthService.AuditClientSecretReads(auditActor(c), apps...)
 
 	return c.JSON(http.StatusOK, apps)
 }
@@ -584,16 +749,15 @@ func AdminToggleOAuthApplicationTrustedStatus(c echo.Context) error {
 	return c.NoContent(http.StatusNoContent)
 }
 
-// Internal Create OAuth Application endpoint (Internal Token or Admin)
+// Internal Create OAuth Application endpoint (Service Account or Admin)
 //
 //	@Summary		Internal Create OAuth Application
-//	@Description	Create an OAuth application with custom client_id and secret (internal API, requires internal token or admin session)
+//	@Description	Create an OAuth application with custom client_id and secret (internal API, requires a service account with the oauth_apps:create scope or an admin session)
 //	@Tags			OAuth
 //	@Accept			json
 //	@Produce		json
-//	@Param			Authorization		header		string										false	"Internal token (Bearer <token> or Internal <token>)"
-//	@Param			X-Internal-Token	header		string										false	"Internal token"
-//	@Param			internal_token		query		string										false	"Internal token"
+//	@Param			Authorization		header		string										false	"Service account secret (Bearer <secret> or Internal <secret>)"
+//	@Param			X-Internal-Token	header		string										false	"Service account secret"
 //	@Param			request				body		service.InternalApplicationCreateRequest	true	"Application data with custom credentials"
 //	@Success		201					{object}	service.ApplicationResponse
 //	@Failure		400					{object}	map[string]string
@@ -602,19 +766,6 @@ func AdminToggleOAuthApplicationTrustedStatus(c echo.Context) error {
 //	@Failure		409					{object}	map[string]string
 //	@Router			/admin/oauth/internal/applications [post]
 func AdminInternalCreateOAuthApplication(c echo.Context) error {
-	// Get admin user ID for creation
-	var adminUserID uint
-
-	// Check if this is internal token authentication
-	if isInternal, ok := c.Get("internal_auth").(bool); ok && isInternal {
-		// Use virtual admin ID for internal token requests
-		adminUserID = c.Get("virtual_admin_id").(uint)
-	} else {
-		// Use actual admin user from session
-		user := c.Get("admin_user").(*database.User)
-		adminUserID = user.ID
-	}
-
 	// Parse request body
 	var req service.InternalApplicationCreateRequest
 	if err := c.Bind(&req); err != nil {
@@ -631,7 +782,7 @@ func AdminInternalCreateOAuthApplication(c echo.Context) error {
 	oauthService := serviceManager.OAuth
 
 	// Create application with custom credentials
-	app, err := oauthService.CreateApplicationWithCustomCredentials(adminUserID, req)
+	app, err := oauthService.CreateApplicationWithCustomCredentials(auditActor(c), req)
 	if err != nil {
 		if err.Error() == "client_id already exists" {
 			return c.JSON(http.StatusConflict, map[string]string{"error": "client_id_exists", "error_description": "The specified client_id already exists"})
@@ -642,16 +793,15 @@ func AdminInternalCreateOAuthApplication(c echo.Context) error {
 	return c.JSON(http.StatusCreated, app)
 }
 
-// Batch Internal Create OAuth Applications endpoint (Internal Token or Admin)
+// Batch Internal Create OAuth Applications endpoint (Service Account or Admin)
 //
 //	@Summary		Batch Internal Create OAuth Applications
-//	@Description	Create multiple OAuth applications with custom client_id and secret (internal API, requires internal token or admin session)
+//	@Description	Create multiple OAuth applications with custom client_id and secret (internal API, requires a service account with the oauth_apps:create scope or an admin session)
 //	@Tags			OAuth
 //	@Accept			json
 //	@Produce		json
-//	@Param			Authorization		header		string										false	"Internal token (Bearer <token> or Internal <token>)"
-//	@Param			X-Internal-Token	header		string										false	"Internal token"
-//	@Param			internal_token		query		string										false	"Internal token"
+//	@Param			Authorization		header		string										false	"Service account secret (Bearer <secret> or Internal <secret>)"
+//	@Param			X-Internal-Token	header		string										false	"Service account secret"
 //	@Param			request				body		[]service.InternalApplicationCreateRequest	true	"Array of application data with custom credentials"
 //	@Success		200					{object}	map[string]interface{}
 //	@Failure		400					{object}	map[string]string
@@ -659,19 +809,6 @@ func AdminInternalCreateOAuthApplication(c echo.Context) error {
 //	@Failure		403					{object}	map[string]string
 //	@Router			/admin/oauth/internal/applications/batch [post]
 func AdminInternalBatchCreateOAuthApplications(c echo.Context) error {
-	// Get admin user ID for creation
-	var adminUserID uint
-
-	// Check if this is internal token authentication
-	if isInternal, ok := c.Get("internal_auth").(bool); ok && isInternal {
-		// Use virtual admin ID for internal token requests
-		adminUserID = c.Get("virtual_admin_id").(uint)
-	} else {
-		// Use actual admin user from session
-		user := c.Get("admin_user").(*database.User)
-		adminUserID = user.ID
-	}
-
 	// Parse request body
 	var requests []service.InternalApplicationCreateRequest
 	if err := c.Bind(&requests); err != nil {
@@ -703,7 +840,7 @@ func AdminInternalBatchCreateOAuthApplications(c echo.Context) error {
 		}
 
 		// Create application
-		app, err := oauthService.CreateApplicationWithCustomCredentials(adminUserID, req)
+		app, err := oauthService.CreateApplicationWithCustomCredentials(auditActor(c), req)
 		if err != nil {
 			errorType := "server_error"
 			if err.Error() == "client_id already exists" {
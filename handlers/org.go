@@ -0,0 +1,697 @@
+package handlers
+
+import (
+	"errors"
+	"miniauth/database"
+	"miniauth/middleware"
+	"miniauth/service"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type CreateOrgRequest struct {
+	Name string `json:"name" validate:"required,min=1,max=100"`
+	Slug string `json:"slug" validate:"required,min=2,max=64"`
+}
+
+type UpdateOrgRequest struct {
+	Name string `json:"name" validate:"required,min=1,max=100"`
+}
+
+type OrgResponse struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	Role        string `json:"role"`
+	Personal    bool   `json:"personal"` // Whether this is the personal organization of its owner
+	MemberCount int64  `json:"member_count"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+	// RestoreUntil is set for organizations pending deletion
+	RestoreUntil string `json:"restore_until,omitempty"`
+	// Permissions are the current user's effective permissions, set when reading a single organization
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+type ListOrgsResponse struct {
+	Organizations []OrgResponse `json:"organizations"`
+}
+
+type UpdateOrgMemberRoleRequest struct {
+	Role database.OrgMemberRole `json:"role" validate:"required,oneof=owner admin member guest"`
+}
+
+type TransferOrgOwnershipRequest struct {
+	UserID uint `json:"user_id" validate:"required"`
+}
+
+type OrgMemberResponse struct {
+	UserID       uint   `json:"user_id"`
+	Username     string `json:"username"`
+	Email        string `json:"email"`
+	Role         string `json:"role"`
+	CustomRoleID *uint  `json:"custom_role_id,omitempty"`
+	JoinedAt     string `json:"joined_at"`
+}
+
+type ListOrgMembersResponse struct {
+	Members []OrgMemberResponse `json:"members"`
+}
+
+// CreateOrg creates a new organization owned by the current user
+//
+//	@Summary		Create organization
+//	@Description	Create a new organization. The current user becomes its owner.
+//	@Tags			orgs
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		CreateOrgRequest	true	"Organization details"
+//	@Success		201		{object}	OrgResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs [post]
+func CreateOrg(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req CreateOrgRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	org := &database.Org{
+		Name: req.Name,
+		Slug: req.Slug,
+	}
+	if err := serviceManager.Org.CreateOrgWithOwner(org, currentUser.UserID); err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidOrgSlug):
+			return ctx.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		case errors.Is(err, service.ErrOrgSlugTaken):
+			return ctx.JSON(http.StatusConflict, map[string]string{
+				"error": "Organization slug is already taken",
+			})
+		}
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create organization",
+		})
+	}
+
+	return ctx.JSON(http.StatusCreated, newOrgResponse(org, database.OrgMemberRoleOwner, 1))
+}
+
+// CreatePersonalOrg creates a personal organization for the current user
+//
+//	@Summary		Create personal organization
+//	@Description	Create a personal organization named after the current user, with a unique slug derived from the username. Not available when the personal organization policy is never.
+//	@Tags			orgs
+//	@Produce		json
+//	@Success		201	{object}	OrgResponse
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		409	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/me/personal-org [post]
+func CreatePersonalOrg(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	org, err := serviceManager.User.CreatePersonalOrg(currentUser.UserID)
+	if err != nil {
+		return personalOrgErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusCreated, newOrgResponse(org, database.OrgMemberRoleOwner, 1))
+}
+
+// DetachPersonalOrg turns the current user's personal organization into a regular organization
+//
+//	@Summary		Detach personal organization
+//	@Description	Turn the current user's personal organization into a regular organization. The user stays its owner.
+//	@Tags			orgs
+//	@Produce		json
+//	@Success		200	{object}	OrgResponse
+//	@Failure		401	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/me/personal-org/detach [post]
+func DetachPersonalOrg(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	org, err := serviceManager.User.DetachPersonalOrg(currentUser.UserID)
+	if err != nil {
+		return personalOrgErrorResponse(ctx, err)
+	}
+
+	member, err := serviceManager.Org.GetOrgMemberByUserID(org.ID, currentUser.UserID)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	memberCount, err := serviceManager.Org.CountOrgMembers(org.ID)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, newOrgResponse(org, member.Role, memberCount))
+}
+
+// ListMyOrgs lists the organizations the current user belongs to
+//
+//	@Summary		List my organizations
+//	@Description	List all organizations the current user is a member of, with the user's role in each. With deleted=true, list the deleted organizations the user owned that can still be restored.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			deleted	query		bool	false	"List organizations pending deletion"
+//	@Success		200		{object}	ListOrgsResponse
+//	@Failure		401		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs [get]
+func ListMyOrgs(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	if ctx.QueryParam("deleted") == "true" {
+		deletedOrgs, err := serviceManager.Org.GetUserDeletedOrgs(currentUser.UserID)
+		if err != nil {
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to get organizations",
+			})
+		}
+
+		response := ListOrgsResponse{Organizations: make([]OrgResponse, 0, len(deletedOrgs))}
+		for _, org := range deletedOrgs {
+			orgResponse := newOrgResponse(&org.Org, database.OrgMemberRoleOwner, 0)
+			orgResponse.RestoreUntil = org.RestoreUntil.Format("2006-01-02 15:04:05")
+			response.Organizations = append(response.Organizations, orgResponse)
+		}
+
+		return ctx.JSON(http.StatusOK, response)
+	}
+
+	orgs, err := serviceManager.Org.GetUserOrgsWithRoles(currentUser.UserID)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get organizations",
+		})
+	}
+
+	response := ListOrgsResponse{Organizations: make([]OrgResponse, 0, len(orgs))}
+	for _, org := range orgs {
+		memberCount, _ := serviceManager.Org.CountOrgMembers(org.ID)
+		response.Organizations = append(response.Organizations, newOrgResponse(&org.Org, org.Role, memberCount))
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// GetOrg retrieves an organization by slug
+//
+//	@Summary		Get organization
+//	@Description	Get an organization by slug with the current user's effective permissions. Requires the org:read permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Success		200		{object}	OrgResponse
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Router			/orgs/{slug} [get]
+func GetOrg(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	org, member, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	permissions, err := serviceManager.Permission.GetOrgPermissions(org.ID, member.UserID)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	memberCount, _ := serviceManager.Org.CountOrgMembers(org.ID)
+	response := newOrgResponse(org, member.Role, memberCount)
+	response.Permissions = permissions
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// UpdateOrg renames an organization
+//
+//	@Summary		Rename organization
+//	@Description	Change the display name of an organization. Requires the org:update permission.
+//	@Tags			orgs
+//	@Accept			json
+//	@Produce		json
+//	@Param			slug	path		string				true	"Organization slug"
+//	@Param			request	body		UpdateOrgRequest	true	"New organization name"
+//	@Success		200		{object}	OrgResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug} [put]
+func UpdateOrg(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req UpdateOrgRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	org, member, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	org.Name = req.Name
+	if err := serviceManager.Org.UpdateOrg(org); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to update organization",
+		})
+	}
+
+	memberCount, _ := serviceManager.Org.CountOrgMembers(org.ID)
+	return ctx.JSON(http.StatusOK, newOrgResponse(org, member.Role, memberCount))
+}
+
+// DeleteOrg deletes an organization
+//
+//	@Summary		Delete organization
+//	@Description	Schedule an organization for deletion. Requires the org:delete permission. Owners can restore it until the grace period ends, after which it is permanently deleted.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Success		200		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug} [delete]
+func DeleteOrg(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	if err := serviceManager.Org.DeleteOrg(org.ID); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to delete organization",
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message":       "Organization deleted successfully",
+		"restore_until": time.Now().Add(serviceManager.Org.DeletionGracePeriod()).Format("2006-01-02 15:04:05"),
+	})
+}
+
+// RestoreOrg restores a deleted organization
+//
+//	@Summary		Restore organization
+//	@Description	Restore an organization pending deletion together with its members. Requires having been an owner when it was deleted, and must happen within the grace period.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Success		200		{object}	OrgResponse
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		410		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/restore [post]
+func RestoreOrg(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	org, err := serviceManager.Org.RestoreOrg(ctx.Param("slug"), currentUser.UserID)
+	if err != nil {
+		if errors.Is(err, service.ErrOrgRestoreExpired) {
+			return ctx.JSON(http.StatusGone, map[string]string{
+				"error": "The restore grace period for this organization has ended",
+			})
+		}
+		return orgErrorResponse(ctx, err)
+	}
+
+	memberCount, _ := serviceManager.Org.CountOrgMembers(org.ID)
+	return ctx.JSON(http.StatusOK, newOrgResponse(org, database.OrgMemberRoleOwner, memberCount))
+}
+
+// TransferOrgOwnership transfers ownership of an organization to another member
+//
+//	@Summary		Transfer organization ownership
+//	@Description	Make another member an owner of the organization. The current owner becomes an admin. Requires the org:transfer permission.
+//	@Tags			orgs
+//	@Accept			json
+//	@Produce		json
+//	@Param			slug	path		string						true	"Organization slug"
+//	@Param			request	body		TransferOrgOwnershipRequest	true	"New owner"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/transfer-ownership [post]
+func TransferOrgOwnership(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req TransferOrgOwnershipRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	org, member, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	if req.UserID == member.UserID {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Cannot transfer ownership to yourself",
+		})
+	}
+
+	if err := serviceManager.Org.TransferOrgOwnership(org.ID, member.UserID, req.UserID); err != nil {
+		if errors.Is(err, service.ErrOrgPermissionDenied) {
+			return orgErrorResponse(ctx, err)
+		}
+		return orgMemberErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Ownership transferred successfully",
+	})
+}
+
+// ListOrgMembers lists the members of an organization
+//
+//	@Summary		List organization members
+//	@Description	List the members of an organization with their roles. Requires the members:read permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Success		200		{object}	ListOrgMembersResponse
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/members [get]
+func ListOrgMembers(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	members, err := serviceManager.Org.GetOrgMembers(org.ID)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get organization members",
+		})
+	}
+
+	response := ListOrgMembersResponse{Members: make([]OrgMemberResponse, 0, len(members))}
+	for _, member := range members {
+		user, err := serviceManager.User.GetUserByID(member.UserID)
+		if err != nil {
+			continue // Skip members whose user cannot be loaded
+		}
+
+		response.Members = append(response.Members, OrgMemberResponse{
+			UserID:       user.ID,
+			Username:     user.Username,
+			Email:        user.Email,
+			Role:         string(member.Role),
+			CustomRoleID: member.OrgRoleID,
+			JoinedAt:     member.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// UpdateOrgMemberRole changes the role of an organization member
+//
+//	@Summary		Change member role
+//	@Description	Change the role of an organization member. Requires the members:manage permission; members cannot manage higher roles or grant a role above their own, so only owners can manage owners or grant the owner role. The last owner cannot be demoted.
+//	@Tags			orgs
+//	@Accept			json
+//	@Produce		json
+//	@Param			slug	path		string						true	"Organization slug"
+//	@Param			user_id	path		int							true	"User ID"
+//	@Param			request	body		UpdateOrgMemberRoleRequest	true	"New role"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/members/{user_id}/role [put]
+func UpdateOrgMemberRole(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	userID, err := strconv.ParseUint(ctx.Param("user_id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var req UpdateOrgMemberRoleRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	org, actor, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	target, err := serviceManager.Org.GetOrgMemberByUserID(org.ID, uint(userID))
+	if err != nil {
+		return orgMemberErrorResponse(ctx, err)
+	}
+
+	if !service.CanManageOrgMember(actor.Role, target.Role) || !service.OrgRoleAtLeast(actor.Role, req.Role) {
+		return orgErrorResponse(ctx, service.ErrOrgPermissionDenied)
+	}
+
+	if err := serviceManager.User.UpdateUserOrgRole(target.UserID, org.ID, req.Role); err != nil {
+		return orgMemberErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Member role updated successfully",
+	})
+}
+
+// RemoveOrgMember removes a member from an organization
+//
+//	@Summary		Remove member
+//	@Description	Remove a member from an organization. Requires the members:manage permission; only owners can remove owners. The last owner cannot be removed.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Param			user_id	path		int		true	"User ID"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/members/{user_id} [delete]
+func RemoveOrgMember(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	userID, err := strconv.ParseUint(ctx.Param("user_id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user ID",
+		})
+	}
+
+	org, actor, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	target, err := serviceManager.Org.GetOrgMemberByUserID(org.ID, uint(userID))
+	if err != nil {
+		return orgMemberErrorResponse(ctx, err)
+	}
+
+	if !service.CanManageOrgMember(actor.Role, target.Role) {
+		return orgErrorResponse(ctx, service.ErrOrgPermissionDenied)
+	}
+
+	if err := serviceManager.User.RemoveUserFromOrg(target.UserID, org.ID); err != nil {
+		return orgMemberErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Member removed successfully",
+	})
+}
+
+// LeaveOrg removes the current user from an organization
+//
+//	@Summary		Leave organization
+//	@Description	Leave an organization. The last owner cannot leave; transfer ownership first.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Success		200		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/leave [post]
+func LeaveOrg(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	org, member, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	if err := serviceManager.User.RemoveUserFromOrg(member.UserID, org.ID); err != nil {
+		return orgMemberErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "You have left the organization",
+	})
+}
+
+// loadOrgMembership loads the organization from the slug path parameter and the current user's
+// membership in it. Permissions are enforced by the RequirePermission middleware on the route.
+func loadOrgMembership(ctx echo.Context) (*database.Org, *database.OrgMember, error) {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	org, err := serviceManager.Org.GetOrgBySlug(ctx.Param("slug"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	member, err := serviceManager.Permission.RequireOrgPermission(org.ID, currentUser.UserID, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return org, member, nil
+}
+
+// orgErrorResponse maps organization access errors to HTTP responses.
+// Non-members get the same response as for a missing organization.
+func orgErrorResponse(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, service.ErrNotOrgMember):
+		return ctx.JSON(http.StatusNotFound, map[string]string{
+			"error": "Organization not found",
+		})
+	case errors.Is(err, service.ErrOrgPermissionDenied):
+		return ctx.JSON(http.StatusForbidden, map[string]string{
+			"error": "Insufficient organization role",
+		})
+	}
+	return ctx.JSON(http.StatusInternalServerError, map[string]string{
+		"error": "Failed to get organization",
+	})
+}
+
+// personalOrgErrorResponse maps personal organization errors to HTTP responses
+func personalOrgErrorResponse(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrPersonalOrgDisabled):
+		return ctx.JSON(http.StatusForbidden, map[string]string{
+			"error": "Personal organizations are disabled",
+		})
+	case errors.Is(err, service.ErrPersonalOrgExists):
+		return ctx.JSON(http.StatusConflict, map[string]string{
+			"error": "You already have a personal organization",
+		})
+	case errors.Is(err, service.ErrNoPersonalOrg):
+		return ctx.JSON(http.StatusNotFound, map[string]string{
+			"error": "You have no personal organization",
+		})
+	}
+	return ctx.JSON(http.StatusInternalServerError, map[string]string{
+		"error": "Failed to update personal organization",
+	})
+}
+
+// orgMemberErrorResponse maps membership management errors to HTTP responses
+func orgMemberErrorResponse(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, service.ErrNotOrgMember):
+		return ctx.JSON(http.StatusNotFound, map[string]string{
+			"error": "Member not found",
+		})
+	case errors.Is(err, service.ErrLastOrgOwner):
+		return ctx.JSON(http.StatusConflict, map[string]string{
+			"error": "The organization must keep at least one owner",
+		})
+	case errors.Is(err, service.ErrAlreadyOrgMember):
+		return ctx.JSON(http.StatusConflict, map[string]string{
+			"error": "User is already a member of the organization",
+		})
+	}
+	return ctx.JSON(http.StatusInternalServerError, map[string]string{
+		"error": "Failed to update organization membership",
+	})
+}
+
+func newOrgResponse(org *database.Org, role database.OrgMemberRole, memberCount int64) OrgResponse {
+	return OrgResponse{
+		ID:          org.ID,
+		Name:        org.Name,
+		Slug:        org.Slug,
+		Role:        string(role),
+		Personal:    org.PersonalUserID != nil,
+		MemberCount: memberCount,
+		CreatedAt:   org.CreatedAt.Format("2006-01-02 15:04:05"),
+		UpdatedAt:   org.UpdatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"errors"
+	"miniauth/middleware"
+	"miniauth/service"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type ListOrgApplicationsResponse struct {
+	Applications []*service.ApplicationResponse `json:"applications"`
+}
+
+// ListOrgApplications lists the OAuth applications owned by an organization
+//
+//	@Summary		List organization applications
+//	@Description	List the OAuth applications owned by an organization. Requires the apps:read permission; client secrets are only included for members with the apps:manage permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Success		200		{object}	ListOrgApplicationsResponse
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/apps [get]
+func ListOrgApplications(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	apps, err := serviceManager.OAuth.GetOrgApplications(org.ID)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get applications",
+		})
+	}
+
+	if err := hideClientSecrets(ctx, org.ID, apps...); err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, ListOrgApplicationsResponse{Applications: apps})
+}
+
+// CreateOrgApplication registers an OAuth application owned by an organization
+//
+//	@Summary		Create organization application
+//	@Description	Register an OAuth application owned by the organization. Scopes must be registered OAuth scopes other than admin. With members_only, only members of the organization can authorize it. Requires the apps:manage permission.
+//	@Tags			orgs
+//	@Accept			json
+//	@Produce		json
+//	@Param			slug	path		string							true	"Organization slug"
+//	@Param			request	body		service.OrgApplicationRequest	true	"Application details"
+//	@Success		201		{object}	service.ApplicationResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/apps [post]
+func CreateOrgApplication(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req service.OrgApplicationRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	app, err := serviceManager.OAuth.CreateOrgApplication(auditActor(ctx), org.ID, req)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidApplicationScope) || errors.Is(err, service.ErrOrgApplicationAdminScope) {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create application",
+		})
+	}
+
+	return ctx.JSON(http.StatusCreated, app)
+}
+
+// GetOrgApplication retrieves an OAuth application owned by an organization
+//
+//	@Summary		Get organization application
+//	@Description	Get an OAuth application owned by the organization. Requires the apps:read permission; the client secret is only included for members with the apps:manage permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Param			app_id	path		int		true	"Application ID"
+//	@Success		200		{object}	service.ApplicationResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/apps/{app_id} [get]
+func GetOrgApplication(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	appID, err := strconv.ParseUint(ctx.Param("app_id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid application ID",
+		})
+	}
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	app, err := serviceManager.OAuth.GetOrgApplication(org.ID, uint(appID))
+	if err != nil {
+		return orgApplicationErrorResponse(ctx, err)
+	}
+
+	if err := hideClientSecrets(ctx, org.ID, app); err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, app)
+}
+
+// UpdateOrgApplication updates an OAuth application owned by an organization
+//
+//	@Summary		Update organization application
+//	@Description	Update an OAuth application owned by the organization. Scopes must be registered OAuth scopes other than admin. Requires the apps:manage permission.
+//	@Tags			orgs
+//	@Accept			json
+//	@Produce		json
+//	@Param			slug	path		string							true	"Organization slug"
+//	@Param			app_id	path		int								true	"Application ID"
+//	@Param			request	body		service.OrgApplicationRequest	true	"Application details"
+//	@Success		200		{object}	service.ApplicationResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/apps/{app_id} [put]
+func UpdateOrgApplication(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	appID, err := strconv.ParseUint(ctx.Param("app_id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid application ID",
+		})
+	}
+
+	var req service.OrgApplicationRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	app, err := serviceManager.OAuth.UpdateOrgApplication(org.ID, uint(appID), req)
+	if err != nil {
+		return orgApplicationErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, app)
+}
+
+// DeleteOrgApplication deletes an OAuth application owned by an organization
+//
+//	@Summary		Delete organization application
+//	@Description	Delete an OAuth application owned by the organization and revoke its tokens. Requires the apps:manage permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Param			app_id	path		int		true	"Application ID"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/apps/{app_id} [delete]
+func DeleteOrgApplication(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	appID, err := strconv.ParseUint(ctx.Param("app_id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid application ID",
+		})
+	}
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	if err := serviceManager.OAuth.DeleteOrgApplication(org.ID, uint(appID)); err != nil {
+		return orgApplicationErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Application deleted successfully",
+	})
+}
+
+// hideClientSecrets removes client secrets from applications unless the current user can manage them
+func hideClientSecrets(ctx echo.Context, orgID uint, apps ...*service.ApplicationResponse) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	canManage, err := serviceManager.Permission.HasOrgPermission(orgID, currentUser.UserID, service.PermissionAppsManage)
+	if err != nil {
+		return err
+	}
+	if !canManage {
+		for _, app := range apps {
+			app.ClientSecret = ""
+		}
+		return nil
+	}
+	serviceManager.OAuth.AuditClientSecretReads(auditActor(ctx), apps...)
+	return nil
+}
+
+// orgApplicationErrorResponse maps organization application errors to HTTP responses
+func orgApplicationErrorResponse(ctx echo.Context, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ctx.JSON(http.StatusNotFound, map[string]string{
+			"error": "Application not found",
+		})
+	}
+	if errors.Is(err, service.ErrInvalidApplicationScope) || errors.Is(err, service.ErrOrgApplicationAdminScope) {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	return ctx.JSON(http.StatusInternalServerError, map[string]string{
+		"error": "Failed to update application",
+	})
+}
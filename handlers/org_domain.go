@@ -0,0 +1,485 @@
+package handlers
+
+import (
+	"errors"
+	"miniauth/database"
+	"miniauth/middleware"
+	"miniauth/service"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type CreateOrgDomainRequest struct {
+	Domain      string                       `json:"domain" validate:"required,max=253"`
+	JoinPolicy  database.OrgDomainJoinPolicy `json:"join_policy" validate:"omitempty,oneof=none auto request"`
+	DefaultRole database.OrgMemberRole       `json:"default_role" validate:"omitempty,oneof=admin member guest"`
+}
+
+type UpdateOrgDomainRequest struct {
+	JoinPolicy  database.OrgDomainJoinPolicy `json:"join_policy" validate:"required,oneof=none auto request"`
+	DefaultRole database.OrgMemberRole       `json:"default_role" validate:"required,oneof=admin member guest"`
+}
+
+type OrgDomainResponse struct {
+	ID          uint   `json:"id"`
+	Domain      string `json:"domain"`
+	Verified    bool   `json:"verified"`
+	VerifiedAt  string `json:"verified_at,omitempty"`
+	JoinPolicy  string `json:"join_policy"`
+	DefaultRole string `json:"default_role"`
+	// RecordName and RecordValue describe the TXT record that proves ownership of the domain
+	RecordName  string `json:"record_name"`
+	RecordValue string `json:"record_value"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type ListOrgDomainsResponse struct {
+	Domains []OrgDomainResponse `json:"domains"`
+}
+
+type OrgJoinRequestResponse struct {
+	ID        uint   `json:"id"`
+	OrgName   string `json:"org_name,omitempty"`
+	OrgSlug   string `json:"org_slug,omitempty"`
+	UserID    uint   `json:"user_id"`
+	Username  string `json:"username,omitempty"`
+	Email     string `json:"email,omitempty"`
+	Role      string `json:"role"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ListOrgJoinRequestsResponse struct {
+	JoinRequests []OrgJoinRequestResponse `json:"join_requests"`
+}
+
+// ListOrgDomains lists the email domains claimed by an organization
+//
+//	@Summary		List organization domains
+//	@Description	List the email domains claimed by an organization with their verification record and join policy. Requires the domains:manage permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Success		200		{object}	ListOrgDomainsResponse
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/domains [get]
+func ListOrgDomains(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	domains, err := serviceManager.Org.ListOrgDomains(org.ID)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get domains",
+		})
+	}
+
+	response := ListOrgDomainsResponse{Domains: make([]OrgDomainResponse, 0, len(domains))}
+	for i := range domains {
+		response.Domains = append(response.Domains, newOrgDomainResponse(&domains[i]))
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// CreateOrgDomain claims an email domain for an organization
+//
+//	@Summary		Claim organization domain
+//	@Description	Claim an email domain for the organization. The claim takes effect once the returned TXT record is published and verified. With the auto join policy, new users of the domain join with the default role; with request, they get a join request for approval. Requires the domains:manage permission; the default role cannot be owner or exceed the caller's role.
+//	@Tags			orgs
+//	@Accept			json
+//	@Produce		json
+//	@Param			slug	path		string					true	"Organization slug"
+//	@Param			request	body		CreateOrgDomainRequest	true	"Domain details"
+//	@Success		201		{object}	OrgDomainResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/domains [post]
+func CreateOrgDomain(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req CreateOrgDomainRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	org, member, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	domain := &database.OrgDomain{
+		OrgID:       org.ID,
+		Domain:      req.Domain,
+		JoinPolicy:  req.JoinPolicy,
+		DefaultRole: req.DefaultRole,
+		CreatedByID: currentUser.UserID,
+	}
+	if err := serviceManager.Org.AddOrgDomain(domain, member.Role); err != nil {
+		return orgDomainErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusCreated, newOrgDomainResponse(domain))
+}
+
+// UpdateOrgDomain updates the join policy of a claimed domain
+//
+//	@Summary		Update organization domain
+//	@Description	Change the join policy and default role of a claimed domain. Requires the domains:manage permission; the default role cannot be owner or exceed the caller's role.
+//	@Tags			orgs
+//	@Accept			json
+//	@Produce		json
+//	@Param			slug		path		string					true	"Organization slug"
+//	@Param			domain_id	path		int						true	"Domain ID"
+//	@Param			request		body		UpdateOrgDomainRequest	true	"Join policy"
+//	@Success		200			{object}	OrgDomainResponse
+//	@Failure		400			{object}	map[string]string
+//	@Failure		401			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Router			/orgs/{slug}/domains/{domain_id} [put]
+func UpdateOrgDomain(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	domainID, err := strconv.ParseUint(ctx.Param("domain_id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid domain ID",
+		})
+	}
+
+	var req UpdateOrgDomainRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	org, member, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	domain, err := serviceManager.Org.GetOrgDomain(org.ID, uint(domainID))
+	if err != nil {
+		return orgDomainErrorResponse(ctx, err)
+	}
+
+	domain.JoinPolicy = req.JoinPolicy
+	domain.DefaultRole = req.DefaultRole
+	if err := serviceManager.Org.UpdateOrgDomain(domain, member.Role); err != nil {
+		return orgDomainErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, newOrgDomainResponse(domain))
+}
+
+// DeleteOrgDomain releases a claimed domain
+//
+//	@Summary		Delete organization domain
+//	@Description	Release a claimed domain. Pending join requests made through it are rejected. Requires the domains:manage permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug		path		string	true	"Organization slug"
+//	@Param			domain_id	path		int		true	"Domain ID"
+//	@Success		200			{object}	map[string]string
+//	@Failure		400			{object}	map[string]string
+//	@Failure		401			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Router			/orgs/{slug}/domains/{domain_id} [delete]
+func DeleteOrgDomain(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	domainID, err := strconv.ParseUint(ctx.Param("domain_id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid domain ID",
+		})
+	}
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	if err := serviceManager.Org.DeleteOrgDomain(org.ID, uint(domainID)); err != nil {
+		return orgDomainErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Domain deleted successfully",
+	})
+}
+
+// VerifyOrgDomain checks the verification TXT record of a claimed domain
+//
+//	@Summary		Verify organization domain
+//	@Description	Look up the TXT record of a claimed domain and mark the claim verified when it contains the verification value. A domain can only be verified by one organization. Requires the domains:manage permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug		path		string	true	"Organization slug"
+//	@Param			domain_id	path		int		true	"Domain ID"
+//	@Success		200			{object}	OrgDomainResponse
+//	@Failure		400			{object}	map[string]string
+//	@Failure		401			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		409			{object}	map[string]string
+//	@Failure		422			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Failure		502			{object}	map[string]string
+//	@Router			/orgs/{slug}/domains/{domain_id}/verify [post]
+func VerifyOrgDomain(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	domainID, err := strconv.ParseUint(ctx.Param("domain_id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid domain ID",
+		})
+	}
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	domain, err := serviceManager.Org.VerifyOrgDomain(ctx.Request().Context(), org.ID, uint(domainID))
+	if err != nil {
+		return orgDomainErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, newOrgDomainResponse(domain))
+}
+
+// ListOrgJoinRequests lists the pending join requests of an organization
+//
+//	@Summary		List organization join requests
+//	@Description	List the pending requests to join the organization made by users of its verified domains. Requires the members:invite permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Success		200		{object}	ListOrgJoinRequestsResponse
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/join-requests [get]
+func ListOrgJoinRequests(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	requests, err := serviceManager.Org.ListOrgJoinRequests(org.ID)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get join requests",
+		})
+	}
+
+	response := ListOrgJoinRequestsResponse{JoinRequests: make([]OrgJoinRequestResponse, 0, len(requests))}
+	for i := range requests {
+		response.JoinRequests = append(response.JoinRequests, newOrgJoinRequestResponse(&requests[i]))
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// ApproveOrgJoinRequest approves a pending join request
+//
+//	@Summary		Approve join request
+//	@Description	Add the requesting user to the organization with the role of the join request. Requires the members:invite permission; the role cannot exceed the caller's role.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Param			id		path		int		true	"Join request ID"
+//	@Success		200		{object}	OrgJoinRequestResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/join-requests/{id}/approve [post]
+func ApproveOrgJoinRequest(ctx echo.Context) error {
+	return respondToOrgJoinRequest(ctx, true)
+}
+
+// RejectOrgJoinRequest rejects a pending join request
+//
+//	@Summary		Reject join request
+//	@Description	Reject a pending request to join the organization. Requires the members:invite permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Param			id		path		int		true	"Join request ID"
+//	@Success		200		{object}	OrgJoinRequestResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/join-requests/{id}/reject [post]
+func RejectOrgJoinRequest(ctx echo.Context) error {
+	return respondToOrgJoinRequest(ctx, false)
+}
+
+// ListMyJoinRequests lists the pending join requests of the current user
+//
+//	@Summary		List my join requests
+//	@Description	List the organizations the current user has asked to join through their email domain and that have not answered yet
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{object}	ListOrgJoinRequestsResponse
+//	@Failure		401	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/me/join-requests [get]
+func ListMyJoinRequests(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	requests, err := serviceManager.Org.ListUserJoinRequests(currentUser.UserID)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get join requests",
+		})
+	}
+
+	response := ListOrgJoinRequestsResponse{JoinRequests: make([]OrgJoinRequestResponse, 0, len(requests))}
+	for i := range requests {
+		response.JoinRequests = append(response.JoinRequests, newOrgJoinRequestResponse(&requests[i]))
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+func respondToOrgJoinRequest(ctx echo.Context, approve bool) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	requestID, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid join request ID",
+		})
+	}
+
+	org, member, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	request, err := serviceManager.Org.RespondToJoinRequest(org.ID, uint(requestID), member, approve)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidJoinRequest):
+			return ctx.JSON(http.StatusNotFound, map[string]string{
+				"error": "Join request not found",
+			})
+		case errors.Is(err, service.ErrOrgPermissionDenied):
+			return orgErrorResponse(ctx, err)
+		case errors.Is(err, service.ErrAlreadyOrgMember):
+			return orgMemberErrorResponse(ctx, err)
+		}
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to process join request",
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, newOrgJoinRequestResponse(request))
+}
+
+// orgDomainErrorResponse maps domain claim errors to HTTP responses
+func orgDomainErrorResponse(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ctx.JSON(http.StatusNotFound, map[string]string{
+			"error": "Domain not found",
+		})
+	case errors.Is(err, service.ErrInvalidDomain), errors.Is(err, service.ErrInvalidJoinPolicy):
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrOrgPermissionDenied):
+		return ctx.JSON(http.StatusForbidden, map[string]string{
+			"error": "The default role cannot be owner or exceed your own role",
+		})
+	case errors.Is(err, service.ErrDomainTaken):
+		return ctx.JSON(http.StatusConflict, map[string]string{
+			"error": "Domain is already claimed",
+		})
+	case errors.Is(err, service.ErrDomainNotVerified):
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]string{
+			"error": "Verification TXT record not found",
+		})
+	case errors.Is(err, service.ErrDomainLookupFailed):
+		return ctx.JSON(http.StatusBadGateway, map[string]string{
+			"error": "Failed to look up the verification TXT record",
+		})
+	}
+	return ctx.JSON(http.StatusInternalServerError, map[string]string{
+		"error": "Failed to update domain",
+	})
+}
+
+func newOrgDomainResponse(domain *database.OrgDomain) OrgDomainResponse {
+	recordName, recordValue := service.OrgDomainChallenge(domain)
+	response := OrgDomainResponse{
+		ID:          domain.ID,
+		Domain:      domain.Domain,
+		Verified:    domain.VerifiedAt != nil,
+		JoinPolicy:  string(domain.JoinPolicy),
+		DefaultRole: string(domain.DefaultRole),
+		RecordName:  recordName,
+		RecordValue: recordValue,
+		CreatedAt:   domain.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+	if domain.VerifiedAt != nil {
+		response.VerifiedAt = domain.VerifiedAt.Format("2006-01-02 15:04:05")
+	}
+	return response
+}
+
+func newOrgJoinRequestResponse(request *database.OrgJoinRequest) OrgJoinRequestResponse {
+	return OrgJoinRequestResponse{
+		ID:        request.ID,
+		OrgName:   request.Org.Name,
+		OrgSlug:   request.Org.Slug,
+		UserID:    request.UserID,
+		Username:  request.User.Username,
+		Email:     request.User.Email,
+		Role:      string(request.Role),
+		Status:    string(request.Status),
+		CreatedAt: request.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
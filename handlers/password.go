@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+	"miniauth/service"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"newPassword" validate:"required"`
+}
+
+// ForgotPassword starts the self-service password reset flow
+//
+//	@Summary		Request password reset
+//	@Description	Send a password reset link to the given email address. The response does not reveal whether the address is registered.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		ForgotPasswordRequest	true	"Email address"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		429		{object}	map[string]string
+//	@Router			/password/forgot [post]
+func ForgotPassword(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req ForgotPasswordRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	// The request is handled in the background, so neither the response nor its timing reveals
+	// which addresses exist
+	serviceManager.User.QueuePasswordReset(req.Email, ctx.RealIP())
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "If an account exists for this address, a password reset link has been sent",
+	})
+}
+
+// ResetPassword completes the self-service password reset flow
+//
+//	@Summary		Reset password
+//	@Description	Set a new password using a reset token. All existing sessions and OAuth tokens of the user are revoked.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		ResetPasswordRequest	true	"Reset token and new password"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	PasswordPolicyErrorResponse
+//	@Failure		429		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/password/reset [post]
+func ResetPassword(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req ResetPasswordRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	if err := serviceManager.User.ResetPassword(auditActor(ctx), req.Token, req.NewPassword); err != nil {
+		var policyErr *service.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return passwordPolicyErrorResponse(ctx, policyErr)
+		}
+		if errors.Is(err, service.ErrInvalidResetToken) {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid or expired password reset token",
+			})
+		}
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to reset password",
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Password reset successfully",
+	})
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"errors"
+	"miniauth/database"
+	"miniauth/middleware"
+	"miniauth/service"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type PersonalAccessTokenResponse struct {
+	ID         uint     `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`
+	Expired    bool     `json:"expired"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	LastUsedIP string   `json:"last_used_ip,omitempty"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+type CreatePersonalAccessTokenResponse struct {
+	PersonalAccessTokenResponse
+	Token string `json:"token"` // Shown only once
+}
+
+type ListPersonalAccessTokensResponse struct {
+	Tokens []PersonalAccessTokenResponse `json:"tokens"`
+	Scopes []string                      `json:"scopes"` // Scopes tokens can be granted
+}
+
+func newPersonalAccessTokenResponse(token *database.PersonalAccessToken) PersonalAccessTokenResponse {
+	response := PersonalAccessTokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     strings.Fields(token.Scopes),
+		LastUsedIP: token.LastUsedIP,
+		CreatedAt:  token.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+	if token.ExpiresAt != nil {
+		response.ExpiresAt = token.ExpiresAt.Format("2006-01-02 15:04:05")
+		response.Expired = !time.Now().Before(*token.ExpiresAt)
+	}
+	if token.LastUsedAt != nil {
+		response.LastUsedAt = token.LastUsedAt.Format("2006-01-02 15:04:05")
+	}
+	return response
+}
+
+// ListMyTokens lists the personal access tokens of the current user
+//
+//	@Summary		List personal access tokens
+//	@Description	List the personal access tokens of the current user that have not been revoked
+//	@Tags			user
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Success		200	{object}	ListPersonalAccessTokensResponse
+//	@Failure		401	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/me/tokens [get]
+func ListMyTokens(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	tokens, err := serviceManager.Tokens.ListTokens(currentUser.UserID)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to list personal access tokens",
+		})
+	}
+
+	response := ListPersonalAccessTokensResponse{
+		Tokens: make([]PersonalAccessTokenResponse, 0, len(tokens)),
+		Scopes: service.PersonalAccessTokenScopes,
+	}
+	for i := range tokens {
+		response.Tokens = append(response.Tokens, newPersonalAccessTokenResponse(&tokens[i]))
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// CreateMyToken creates a personal access token for the current user
+//
+//	@Summary		Create personal access token
+//	@Description	Create a personal access token to call the API with as "Authorization: Bearer <token>". The read scope allows GET requests, write allows requests of any method and admin allows admin endpoints. The token is returned only once. Tokens cannot be created with another token.
+//	@Tags			user
+//	@Security		BasicAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		service.PersonalAccessTokenRequest	true	"Token"
+//	@Success		201		{object}	CreatePersonalAccessTokenResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/me/tokens [post]
+func CreateMyToken(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	// A token could otherwise mint tokens that outlive it or hold more scopes
+	if currentUser.AccessTokenID != 0 {
+		return ctx.JSON(http.StatusForbidden, map[string]string{
+			"error": "Personal access tokens cannot create tokens",
+		})
+	}
+
+	var req service.PersonalAccessTokenRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	accessToken, token, err := serviceManager.Tokens.CreateToken(auditActor(ctx), currentUser.UserID, req)
+	if err != nil {
+		return personalAccessTokenErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusCreated, CreatePersonalAccessTokenResponse{
+		PersonalAccessTokenResponse: newPersonalAccessTokenResponse(accessToken),
+		Token:                       token,
+	})
+}
+
+// RevokeMyToken revokes a personal access token of the current user
+//
+//	@Summary		Revoke personal access token
+//	@Description	Revoke a personal access token of the current user
+//	@Tags			user
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id	path		int	true	"Token ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/me/tokens/{id} [delete]
+func RevokeMyToken(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid token ID",
+		})
+	}
+
+	if err := serviceManager.Tokens.RevokeToken(auditActor(ctx), currentUser.UserID, uint(id)); err != nil {
+		return personalAccessTokenErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Token revoked",
+	})
+}
+
+func personalAccessTokenErrorResponse(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ctx.JSON(http.StatusNotFound, map[string]string{
+			"error": "Token not found",
+		})
+	case errors.Is(err, service.ErrInvalidTokenScope):
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrAdminScopeForbidden):
+		return ctx.JSON(http.StatusForbidden, map[string]string{
+			"error": err.Error(),
+		})
+	default:
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to process token request",
+		})
+	}
+}
@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"errors"
+	"miniauth/database"
+	"miniauth/service"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type OrgRoleRequest struct {
+	Name        string   `json:"name" validate:"required,min=1,max=50"`
+	Description string   `json:"description" validate:"max=255"`
+	Permissions []string `json:"permissions" validate:"required,min=1"`
+}
+
+type AssignOrgRoleRequest struct {
+	RoleID *uint `json:"role_id"` // null removes the custom role
+}
+
+type OrgRoleResponse struct {
+	ID          uint     `json:"id,omitempty"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+	Builtin     bool     `json:"builtin"`
+}
+
+type ListOrgRolesResponse struct {
+	Roles []OrgRoleResponse `json:"roles"`
+}
+
+type ListPermissionsResponse struct {
+	Permissions []service.PermissionInfo `json:"permissions"`
+}
+
+// ListPermissions lists the permissions that can be granted in organizations
+//
+//	@Summary		List permissions
+//	@Description	List all organization permissions that can be granted through roles
+//	@Tags			orgs
+//	@Produce		json
+//	@Success		200	{object}	ListPermissionsResponse
+//	@Failure		401	{object}	map[string]string
+//	@Router			/permissions [get]
+func ListPermissions(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, ListPermissionsResponse{
+		Permissions: service.OrgPermissionCatalog,
+	})
+}
+
+// ListOrgRoles lists the built-in and custom roles of an organization
+//
+//	@Summary		List organization roles
+//	@Description	List the built-in roles and the organization's custom roles with their permissions. Requires the members:read permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Success		200		{object}	ListOrgRolesResponse
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/roles [get]
+func ListOrgRoles(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	roles, err := serviceManager.Permission.ListOrgRoles(org.ID)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get roles",
+		})
+	}
+
+	response := ListOrgRolesResponse{Roles: make([]OrgRoleResponse, 0, len(roles)+4)}
+	for _, role := range []database.OrgMemberRole{
+		database.OrgMemberRoleOwner,
+		database.OrgMemberRoleAdmin,
+		database.OrgMemberRoleMember,
+		database.OrgMemberRoleGuest,
+	} {
+		response.Roles = append(response.Roles, OrgRoleResponse{
+			Name:        string(role),
+			Permissions: service.BuiltinOrgRolePermissions(role),
+			Builtin:     true,
+		})
+	}
+	for i := range roles {
+		response.Roles = append(response.Roles, newOrgRoleResponse(&roles[i]))
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// CreateOrgRole creates a custom role in an organization
+//
+//	@Summary		Create custom role
+//	@Description	Create a named set of permissions that can be assigned to members. Requires the roles:manage permission; only permissions held by the current user can be granted.
+//	@Tags			orgs
+//	@Accept			json
+//	@Produce		json
+//	@Param			slug	path		string			true	"Organization slug"
+//	@Param			request	body		OrgRoleRequest	true	"Role details"
+//	@Success		201		{object}	OrgRoleResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/roles [post]
+func CreateOrgRole(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req OrgRoleRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	org, member, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	if err := service.ValidateOrgRolePermissions(req.Permissions); err != nil {
+		return orgRoleErrorResponse(ctx, err)
+	}
+	if err := ensureCanGrantPermissions(serviceManager, member, req.Permissions); err != nil {
+		return orgRoleErrorResponse(ctx, err)
+	}
+
+	role := &database.OrgRole{
+		OrgID:       org.ID,
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	if err := serviceManager.Permission.CreateOrgRole(role, req.Permissions); err != nil {
+		return orgRoleErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusCreated, newOrgRoleResponse(role))
+}
+
+// UpdateOrgRole updates a custom role
+//
+//	@Summary		Update custom role
+//	@Description	Update the name, description and permissions of a custom role. Requires the roles:manage permission; only permissions held by the current user can be granted.
+//	@Tags			orgs
+//	@Accept			json
+//	@Produce		json
+//	@Param			slug	path		string			true	"Organization slug"
+//	@Param			role_id	path		int				true	"Role ID"
+//	@Param			request	body		OrgRoleRequest	true	"Role details"
+//	@Success		200		{object}	OrgRoleResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/roles/{role_id} [put]
+func UpdateOrgRole(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	roleID, err := strconv.ParseUint(ctx.Param("role_id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid role ID",
+		})
+	}
+
+	var req OrgRoleRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	org, member, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	role, err := serviceManager.Permission.GetOrgRole(org.ID, uint(roleID))
+	if err != nil {
+		return orgRoleErrorResponse(ctx, err)
+	}
+
+	if err := service.ValidateOrgRolePermissions(req.Permissions); err != nil {
+		return orgRoleErrorResponse(ctx, err)
+	}
+
+	// Both the current and the new permissions must be within the caller's own
+	if err := ensureCanGrantPermissions(serviceManager, member, append(service.SplitPermissions(role.Permissions), req.Permissions...)); err != nil {
+		return orgRoleErrorResponse(ctx, err)
+	}
+
+	role.Name = req.Name
+	role.Description = req.Description
+	if err := serviceManager.Permission.UpdateOrgRole(role, req.Permissions); err != nil {
+		return orgRoleErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, newOrgRoleResponse(role))
+}
+
+// DeleteOrgRole deletes a custom role
+//
+//	@Summary		Delete custom role
+//	@Description	Delete a custom role and unassign it from all members. Requires the roles:manage permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Param			role_id	path		int		true	"Role ID"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/roles/{role_id} [delete]
+func DeleteOrgRole(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	roleID, err := strconv.ParseUint(ctx.Param("role_id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid role ID",
+		})
+	}
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	if err := serviceManager.Permission.DeleteOrgRole(org.ID, uint(roleID)); err != nil {
+		return orgRoleErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Role deleted successfully",
+	})
+}
+
+// AssignOrgMemberRole assigns a custom role to an organization member
+//
+//	@Summary		Assign custom role
+//	@Description	Assign a custom role to a member, in addition to their built-in role, or remove it with a null role_id. Requires the members:manage permission; only roles whose permissions the current user holds can be assigned.
+//	@Tags			orgs
+//	@Accept			json
+//	@Produce		json
+//	@Param			slug	path		string					true	"Organization slug"
+//	@Param			user_id	path		int						true	"User ID"
+//	@Param			request	body		AssignOrgRoleRequest	true	"Custom role"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/members/{user_id}/custom-role [put]
+func AssignOrgMemberRole(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	userID, err := strconv.ParseUint(ctx.Param("user_id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var req AssignOrgRoleRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	org, actor, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	target, err := serviceManager.Org.GetOrgMemberByUserID(org.ID, uint(userID))
+	if err != nil {
+		return orgMemberErrorResponse(ctx, err)
+	}
+
+	if !service.CanManageOrgMember(actor.Role, target.Role) {
+		return orgErrorResponse(ctx, service.ErrOrgPermissionDenied)
+	}
+
+	if req.RoleID != nil {
+		role, err := serviceManager.Permission.GetOrgRole(org.ID, *req.RoleID)
+		if err != nil {
+			return orgRoleErrorResponse(ctx, err)
+		}
+		if err := ensureCanGrantPermissions(serviceManager, actor, service.SplitPermissions(role.Permissions)); err != nil {
+			return orgRoleErrorResponse(ctx, err)
+		}
+	}
+
+	if err := serviceManager.Permission.AssignOrgRole(org.ID, target.UserID, req.RoleID); err != nil {
+		return orgRoleErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Member role updated successfully",
+	})
+}
+
+// ensureCanGrantPermissions prevents members from granting permissions they do not hold themselves
+func ensureCanGrantPermissions(serviceManager *service.ServiceManager, member *database.OrgMember, permissions []string) error {
+	held, err := serviceManager.Permission.GetOrgPermissions(member.OrgID, member.UserID)
+	if err != nil {
+		return err
+	}
+
+	heldSet := make(map[string]bool, len(held))
+	for _, permission := range held {
+		heldSet[permission] = true
+	}
+	for _, permission := range permissions {
+		if !heldSet[permission] {
+			return service.ErrOrgPermissionDenied
+		}
+	}
+	return nil
+}
+
+// orgRoleErrorResponse maps custom role errors to HTTP responses
+func orgRoleErrorResponse(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ctx.JSON(http.StatusNotFound, map[string]string{
+			"error": "Role not found",
+		})
+	case errors.Is(err, service.ErrNotOrgMember):
+		return orgMemberErrorResponse(ctx, err)
+	case errors.Is(err, service.ErrOrgPermissionDenied):
+		return ctx.JSON(http.StatusForbidden, map[string]string{
+			"error": "You can only grant permissions you hold yourself",
+		})
+	case errors.Is(err, service.ErrUnknownPermission), errors.Is(err, service.ErrReservedPermission):
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrOrgRoleNameTaken):
+		return ctx.JSON(http.StatusConflict, map[string]string{
+			"error": "Role name is already taken",
+		})
+	}
+	return ctx.JSON(http.StatusInternalServerError, map[string]string{
+		"error": "Failed to update role",
+	})
+}
+
+func newOrgRoleResponse(role *database.OrgRole) OrgRoleResponse {
+	return OrgRoleResponse{
+		ID:          role.ID,
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: service.SplitPermissions(role.Permissions),
+	}
+}
@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"miniauth/middleware"
+	"miniauth/service"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// samlPostTemplate posts a response to the service provider with the HTTP-POST binding
+var samlPostTemplate = template.Must(template.New("saml_post").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Signing in…</title></head>
+<body onload="document.forms[0].submit()">
+<form method="post" action="{{.ACSURL}}">
+<input type="hidden" name="SAMLResponse" value="{{.SAMLResponse}}">
+{{if .RelayState}}<input type="hidden" name="RelayState" value="{{.RelayState}}">{{end}}
+<noscript><button type="submit">Continue</button></noscript>
+</form>
+</body>
+</html>
+`))
+
+// SAMLMetadata returns the identity provider metadata
+//
+//	@Summary		SAML IdP metadata
+//	@Description	SAML 2.0 metadata of the identity provider, with its entity ID, signing certificate and single sign-on endpoints
+//	@Tags			saml
+//	@Produce		xml
+//	@Success		200	{string}	string	"EntityDescriptor document"
+//	@Router			/saml/metadata [get]
+func SAMLMetadata(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	return ctx.Blob(http.StatusOK, "application/samlmetadata+xml", []byte(serviceManager.SAML.Metadata()))
+}
+
+// SAMLSingleSignOn answers an authentication request of a service provider
+//
+//	@Summary		SAML single sign-on
+//	@Description	Receives AuthnRequests with the HTTP-Redirect (GET) or HTTP-POST (POST) binding and posts a signed response to the service provider. Users without a session are sent to the login page first. A GET with entity_id instead of SAMLRequest starts an IdP-initiated sign in.
+//	@Tags			saml
+//	@Accept			x-www-form-urlencoded
+//	@Produce		html
+//	@Param			SAMLRequest	query		string	false	"AuthnRequest"
+//	@Param			RelayState	query		string	false	"Relay state"
+//	@Param			entity_id	query		string	false	"Service provider of an IdP-initiated sign in"
+//	@Success		200			{string}	string	"Form posting the response to the service provider"
+//	@Success		302			{string}	string	"Redirect to the login page"
+//	@Failure		400			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Router			/saml/sso [get]
+//	@Router			/saml/sso [post]
+func SAMLSingleSignOn(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var (
+		request *service.SAMLSSORequest
+		err     error
+	)
+	switch {
+	case ctx.Request().Method == http.MethodPost:
+		request, err = serviceManager.SAML.ParseAuthnRequest(ctx.FormValue("SAMLRequest"), false, ctx.FormValue("RelayState"))
+	case ctx.QueryParam("SAMLRequest") != "":
+		request, err = serviceManager.SAML.ParseAuthnRequest(ctx.QueryParam("SAMLRequest"), true, ctx.QueryParam("RelayState"))
+	default:
+		request, err = serviceManager.SAML.IdPInitiatedRequest(ctx.QueryParam("entity_id"), ctx.QueryParam("RelayState"))
+	}
+	if err != nil {
+		return samlErrorResponse(ctx, err)
+	}
+
+	return completeSAMLSingleSignOn(ctx, request)
+}
+
+// SAMLResumeSingleSignOn answers an authentication request after the user signed in
+//
+//	@Summary		Resume SAML single sign-on
+//	@Description	Continues a single sign-on interrupted by the login page
+//	@Tags			saml
+//	@Produce		html
+//	@Param			request	query		string	true	"Pending request"
+//	@Success		200		{string}	string	"Form posting the response to the service provider"
+//	@Failure		400		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Router			/saml/sso/resume [get]
+func SAMLResumeSingleSignOn(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	request, err := serviceManager.SAML.VerifySSORequest(ctx.QueryParam("request"))
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Sign in request expired, please start again from the application",
+		})
+	}
+
+	return completeSAMLSingleSignOn(ctx, request)
+}
+
+func completeSAMLSingleSignOn(ctx echo.Context, request *service.SAMLSSORequest) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	sessionManager := ctx.Get("sessionManager").(*middleware.SessionManager)
+
+	// The existing session signs the user in; without one, return here after the login page
+	user, err := sessionManager.GetCurrentUser(ctx)
+	if err != nil {
+		token, err := serviceManager.SAML.SignSSORequest(request)
+		if err != nil {
+			return samlErrorResponse(ctx, err)
+		}
+		resume := "/api/saml/sso/resume?request=" + url.QueryEscape(token)
+		return ctx.Redirect(http.StatusFound, "/login?redirect="+url.QueryEscape(resume))
+	}
+
+	// Enforce the email verification policy as for OAuth authorization
+	if err := ensureEmailVerifiedForOAuth(serviceManager, user.ID); err != nil {
+		return ctx.JSON(http.StatusForbidden, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	response, err := serviceManager.SAML.IssueResponse(auditActor(ctx), request, user)
+	if err != nil {
+		return samlErrorResponse(ctx, err)
+	}
+
+	var page bytes.Buffer
+	if err := samlPostTemplate.Execute(&page, response); err != nil {
+		return samlErrorResponse(ctx, err)
+	}
+	ctx.Response().Header().Set("Cache-Control", "no-store")
+	return ctx.HTML(http.StatusOK, page.String())
+}
+
+func samlErrorResponse(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidSAMLRequest):
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrSAMLServiceProviderMembersOnly):
+		return ctx.JSON(http.StatusForbidden, map[string]string{
+			"error": err.Error(),
+		})
+	default:
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to process SAML request",
+		})
+	}
+}
+
+// AdminListSAMLServiceProviders lists SAML service providers
+//
+//	@Summary		List SAML service providers (Admin)
+//	@Description	List all service providers users can sign in to through SAML
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Success		200	{object}	map[string]interface{}
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/admin/saml/service-providers [get]
+func AdminListSAMLServiceProviders(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	providers, err := serviceManager.SAML.ListServiceProviders()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to list SAML service providers",
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"service_providers": providers,
+		"idp_entity_id":     serviceManager.SAML.EntityID(),
+		"idp_sso_url":       serviceManager.SAML.SSOURL(),
+	})
+}
+
+// AdminCreateSAMLServiceProvider registers a SAML service provider
+//
+//	@Summary		Create SAML service provider (Admin)
+//	@Description	Register a service provider by entity ID with its assertion consumer service URLs, NameID format and attribute mappings
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		service.SAMLServiceProviderRequest	true	"Service provider"
+//	@Success		201		{object}	service.SAMLServiceProviderResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/admin/saml/service-providers [post]
+func AdminCreateSAMLServiceProvider(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req service.SAMLServiceProviderRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	provider, err := serviceManager.SAML.CreateServiceProvider(currentUser.UserID, req)
+	if err != nil {
+		return samlServiceProviderErrorResponse(ctx, err)
+	}
+	return ctx.JSON(http.StatusCreated, provider)
+}
+
+// AdminGetSAMLServiceProvider returns a SAML service provider
+//
+//	@Summary		Get SAML service provider (Admin)
+//	@Description	Get a SAML service provider
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id	path		int	true	"Service provider ID"
+//	@Success		200	{object}	service.SAMLServiceProviderResponse
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Router			/admin/saml/service-providers/{id} [get]
+func AdminGetSAMLServiceProvider(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid service provider ID",
+		})
+	}
+
+	provider, err := serviceManager.SAML.GetServiceProvider(uint(id))
+	if err != nil {
+		return samlServiceProviderErrorResponse(ctx, err)
+	}
+	return ctx.JSON(http.StatusOK, provider)
+}
+
+// AdminUpdateSAMLServiceProvider updates a SAML service provider
+//
+//	@Summary		Update SAML service provider (Admin)
+//	@Description	Update a SAML service provider
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int									true	"Service provider ID"
+//	@Param			request	body		service.SAMLServiceProviderRequest	true	"Service provider"
+//	@Success		200		{object}	service.SAMLServiceProviderResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Router			/admin/saml/service-providers/{id} [put]
+func AdminUpdateSAMLServiceProvider(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid service provider ID",
+		})
+	}
+
+	var req service.SAMLServiceProviderRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	provider, err := serviceManager.SAML.UpdateServiceProvider(uint(id), req)
+	if err != nil {
+		return samlServiceProviderErrorResponse(ctx, err)
+	}
+	return ctx.JSON(http.StatusOK, provider)
+}
+
+// AdminToggleSAMLServiceProviderStatus enables or disables a SAML service provider
+//
+//	@Summary		Toggle SAML service provider (Admin)
+//	@Description	Enable or disable sign in to a SAML service provider
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id	path		int	true	"Service provider ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Router			/admin/saml/service-providers/{id}/toggle [post]
+func AdminToggleSAMLServiceProviderStatus(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid service provider ID",
+		})
+	}
+
+	if err := serviceManager.SAML.ToggleServiceProviderStatus(uint(id)); err != nil {
+		return samlServiceProviderErrorResponse(ctx, err)
+	}
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Service provider status updated",
+	})
+}
+
+// AdminDeleteSAMLServiceProvider deletes a SAML service provider
+//
+//	@Summary		Delete SAML service provider (Admin)
+//	@Description	Delete a SAML service provider
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id	path		int	true	"Service provider ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Router			/admin/saml/service-providers/{id} [delete]
+func AdminDeleteSAMLServiceProvider(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid service provider ID",
+		})
+	}
+
+	if err := serviceManager.SAML.DeleteServiceProvider(uint(id)); err != nil {
+		return samlServiceProviderErrorResponse(ctx, err)
+	}
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Service provider deleted",
+	})
+}
+
+func samlServiceProviderErrorResponse(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ctx.JSON(http.StatusNotFound, map[string]string{
+			"error": "Service provider not found",
+		})
+	case errors.Is(err, service.ErrInvalidSAMLServiceProvider):
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrSAMLServiceProviderExists):
+		return ctx.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	default:
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to process service provider request",
+		})
+	}
+}
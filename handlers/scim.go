@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"miniauth/service"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// The SCIM 2.0 endpoints (RFC 7644) live under /scim/v2, outside the /api base path of the
+// Swagger documentation, and follow the SCIM protocol for requests, responses and errors.
+
+const scimContentType = "application/scim+json"
+
+// ScimListUsers lists users, optionally filtered, e.g. filter=userName eq "alice"
+func ScimListUsers(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	response, err := serviceManager.Scim.ListUsers(scimListQuery(ctx))
+	if err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+	return scimJSON(ctx, http.StatusOK, response)
+}
+
+// ScimGetUser returns a user
+func ScimGetUser(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	user, err := serviceManager.Scim.GetUser(ctx.Param("id"))
+	if err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+	return scimJSON(ctx, http.StatusOK, user)
+}
+
+// ScimCreateUser provisions a user
+func ScimCreateUser(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	var input service.ScimUser
+	if err := decodeScimBody(ctx, &input); err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+
+	user, err := serviceManager.Scim.CreateUser(input)
+	if err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+	ctx.Response().Header().Set(echo.HeaderLocation, user.Meta.Location)
+	return scimJSON(ctx, http.StatusCreated, user)
+}
+
+// ScimReplaceUser replaces a user
+func ScimReplaceUser(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	var input service.ScimUser
+	if err := decodeScimBody(ctx, &input); err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+
+	user, err := serviceManager.Scim.ReplaceUser(ctx.Param("id"), input)
+	if err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+	return scimJSON(ctx, http.StatusOK, user)
+}
+
+// ScimPatchUser modifies a user with PATCH operations
+func ScimPatchUser(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	var patch service.ScimPatchRequest
+	if err := decodeScimBody(ctx, &patch); err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+
+	user, err := serviceManager.Scim.PatchUser(ctx.Param("id"), patch)
+	if err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+	return scimJSON(ctx, http.StatusOK, user)
+}
+
+// ScimDeleteUser deprovisions a user
+func ScimDeleteUser(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	if err := serviceManager.Scim.DeleteUser(scimActor(ctx), ctx.Param("id")); err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// ScimListGroups lists organizations as groups, optionally filtered, e.g. filter=displayName eq "Engineering"
+func ScimListGroups(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	response, err := serviceManager.Scim.ListGroups(scimListQuery(ctx))
+	if err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+	return scimJSON(ctx, http.StatusOK, response)
+}
+
+// ScimGetGroup returns an organization as a group
+func ScimGetGroup(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	group, err := serviceManager.Scim.GetGroup(ctx.Param("id"), !scimListQuery(ctx).ExcludeMembers)
+	if err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+	return scimJSON(ctx, http.StatusOK, group)
+}
+
+// ScimCreateGroup creates an organization from a group
+func ScimCreateGroup(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	var input service.ScimGroup
+	if err := decodeScimBody(ctx, &input); err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+
+	group, err := serviceManager.Scim.CreateGroup(input)
+	if err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+	ctx.Response().Header().Set(echo.HeaderLocation, group.Meta.Location)
+	return scimJSON(ctx, http.StatusCreated, group)
+}
+
+// ScimReplaceGroup replaces the name and members of an organization
+func ScimReplaceGroup(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	var input service.ScimGroup
+	if err := decodeScimBody(ctx, &input); err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+
+	group, err := serviceManager.Scim.ReplaceGroup(ctx.Param("id"), input)
+	if err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+	return scimJSON(ctx, http.StatusOK, group)
+}
+
+// ScimPatchGroup modifies an organization with PATCH operations
+func ScimPatchGroup(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	var patch service.ScimPatchRequest
+	if err := decodeScimBody(ctx, &patch); err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+
+	group, err := serviceManager.Scim.PatchGroup(ctx.Param("id"), patch)
+	if err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+	return scimJSON(ctx, http.StatusOK, group)
+}
+
+// ScimDeleteGroup deletes an organization
+func ScimDeleteGroup(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	if err := serviceManager.Scim.DeleteGroup(ctx.Param("id")); err != nil {
+		return scimErrorResponse(ctx, err)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// ScimServiceProviderConfig describes the supported SCIM features
+func ScimServiceProviderConfig(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	return scimJSON(ctx, http.StatusOK, serviceManager.Scim.ServiceProviderConfig())
+}
+
+// ScimSchemas lists the User and Group schemas
+func ScimSchemas(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	return scimJSON(ctx, http.StatusOK, scimResourceList(serviceManager.Scim.Schemas()))
+}
+
+// ScimResourceTypes lists the User and Group resource types
+func ScimResourceTypes(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+	return scimJSON(ctx, http.StatusOK, scimResourceList(serviceManager.Scim.ResourceTypes()))
+}
+
+func scimResourceList(resources []map[string]interface{}) service.ScimListResponse {
+	response := service.ScimListResponse{
+		Schemas:      []string{service.ScimSchemaListResponse},
+		TotalResults: int64(len(resources)),
+		StartIndex:   1,
+		ItemsPerPage: len(resources),
+	}
+	for _, resource := range resources {
+		response.Resources = append(response.Resources, resource)
+	}
+	return response
+}
+
+// scimListQuery reads the list parameters. Invalid startIndex and count values fall back to the defaults as RFC 7644 asks.
+func scimListQuery(ctx echo.Context) service.ScimListQuery {
+	query := service.DefaultScimListQuery()
+	query.Filter = ctx.QueryParam("filter")
+	if startIndex, err := strconv.Atoi(ctx.QueryParam("startIndex")); err == nil && startIndex > 0 {
+		query.StartIndex = startIndex
+	}
+	if count, err := strconv.Atoi(ctx.QueryParam("count")); err == nil && count >= 0 {
+		query.Count = count
+	}
+	for _, attribute := range strings.Split(ctx.QueryParam("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(attribute), "members") {
+			query.ExcludeMembers = true
+		}
+	}
+	return query
+}
+
+// scimActor describes the provisioning client for the audit log
+func scimActor(ctx echo.Context) service.AuditActor {
+	return service.AuditActor{
+		ClientID:  "scim",
+		IP:        ctx.RealIP(),
+		UserAgent: ctx.Request().UserAgent(),
+	}
+}
+
+// decodeScimBody decodes a JSON body regardless of its content type, as clients send application/scim+json
+func decodeScimBody(ctx echo.Context, dest interface{}) error {
+	if err := json.NewDecoder(ctx.Request().Body).Decode(dest); err != nil {
+		return &service.ScimError{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "Invalid request body"}
+	}
+	return nil
+}
+
+func scimJSON(ctx echo.Context, status int, body interface{}) error {
+	ctx.Response().Header().Set(echo.HeaderContentType, scimContentType)
+	return ctx.JSON(status, body)
+}
+
+// scimErrorResponse renders an error as a SCIM error message
+func scimErrorResponse(ctx echo.Context, err error) error {
+	var scimErr *service.ScimError
+	if !errors.As(err, &scimErr) {
+		log.Printf("scim: %v", err)
+		scimErr = &service.ScimError{Status: http.StatusInternalServerError, Detail: "Internal server error"}
+	}
+
+	body := map[string]interface{}{
+		"schemas": []string{service.ScimSchemaError},
+		"status":  strconv.Itoa(scimErr.Status),
+		"detail":  scimErr.Detail,
+	}
+	if scimErr.ScimType != "" {
+		body["scimType"] = scimErr.ScimType
+	}
+	return scimJSON(ctx, scimErr.Status, body)
+}
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"errors"
+	"miniauth/database"
+	"miniauth/service"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type ServiceAccountCredentialResponse struct {
+	ID         uint   `json:"id"`
+	Name       string `json:"name,omitempty"`
+	Prefix     string `json:"prefix"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
+	Expired    bool   `json:"expired"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+	LastUsedIP string `json:"last_used_ip,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type IssueServiceAccountCredentialResponse struct {
+	ServiceAccountCredentialResponse
+	Secret string `json:"secret"` // Shown only once
+}
+
+type ServiceAccountResponse struct {
+	ID          uint                               `json:"id"`
+	Name        string                             `json:"name"`
+	Description string                             `json:"description"`
+	Scopes      []string                           `json:"scopes"`
+	Active      bool                               `json:"active"`
+	Credentials []ServiceAccountCredentialResponse `json:"credentials"`
+	CreatedAt   string                             `json:"created_at"`
+	UpdatedAt   string                             `json:"updated_at"`
+}
+
+type ListServiceAccountsResponse struct {
+	ServiceAccounts []ServiceAccountResponse `json:"service_accounts"`
+	Scopes          []string                 `json:"scopes"` // Scopes service accounts can be granted
+}
+
+func newServiceAccountCredentialResponse(credential *database.ServiceAccountCredential) ServiceAccountCredentialResponse {
+	response := ServiceAccountCredentialResponse{
+		ID:         credential.ID,
+		Name:       credential.Name,
+		Prefix:     credential.Prefix,
+		LastUsedIP: credential.LastUsedIP,
+		CreatedAt:  credential.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+	if credential.ExpiresAt != nil {
+		response.ExpiresAt = credential.ExpiresAt.Format("2006-01-02 15:04:05")
+		response.Expired = !time.Now().Before(*credential.ExpiresAt)
+	}
+	if credential.LastUsedAt != nil {
+		response.LastUsedAt = credential.LastUsedAt.Format("2006-01-02 15:04:05")
+	}
+	return response
+}
+
+func newServiceAccountResponse(account *database.ServiceAccount) ServiceAccountResponse {
+	response := ServiceAccountResponse{
+		ID:          account.ID,
+		Name:        account.Name,
+		Description: account.Description,
+		Scopes:      strings.Fields(account.Scopes),
+		Active:      account.Active,
+		Credentials: make([]ServiceAccountCredentialResponse, 0, len(account.Credentials)),
+		CreatedAt:   account.CreatedAt.Format("2006-01-02 15:04:05"),
+		UpdatedAt:   account.UpdatedAt.Format("2006-01-02 15:04:05"),
+	}
+	for i := range account.Credentials {
+		response.Credentials = append(response.Credentials, newServiceAccountCredentialResponse(&account.Credentials[i]))
+	}
+	return response
+}
+
+// AdminListServiceAccounts lists service accounts
+//
+//	@Summary		List service accounts (Admin)
+//	@Description	List the service accounts internal API clients authenticate as, with their credentials that have not been revoked
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Success		200	{object}	ListServiceAccountsResponse
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/admin/service-accounts [get]
+func AdminListServiceAccounts(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	accounts, err := serviceManager.ServiceAccounts.ListServiceAccounts()
+	if err != nil {
+		return serviceAccountErrorResponse(ctx, err)
+	}
+
+	response := ListServiceAccountsResponse{
+		ServiceAccounts: make([]ServiceAccountResponse, 0, len(accounts)),
+		Scopes:          service.ServiceAccountScopes,
+	}
+	for i := range accounts {
+		response.ServiceAccounts = append(response.ServiceAccounts, newServiceAccountResponse(&accounts[i]))
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// AdminCreateServiceAccount creates a service account
+//
+//	@Summary		Create service account (Admin)
+//	@Description	Create a service account limited to the internal endpoints of its scopes: authz:check for authorization checks and oauth_apps:create for creating OAuth applications with custom credentials. Issue a credential for it to authenticate.
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		service.ServiceAccountRequest	true	"Service account"
+//	@Success		201		{object}	ServiceAccountResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/admin/service-accounts [post]
+func AdminCreateServiceAccount(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req service.ServiceAccountRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	account, err := serviceManager.ServiceAccounts.CreateServiceAccount(auditActor(ctx), req)
+	if err != nil {
+		return serviceAccountErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusCreated, newServiceAccountResponse(account))
+}
+
+// AdminGetServiceAccount returns a service account
+//
+//	@Summary		Get service account (Admin)
+//	@Description	Get a service account with its credentials that have not been revoked
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id	path		int	true	"Service account ID"
+//	@Success		200	{object}	ServiceAccountResponse
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Router			/admin/service-accounts/{id} [get]
+func AdminGetServiceAccount(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid service account ID",
+		})
+	}
+
+	account, err := serviceManager.ServiceAccounts.GetServiceAccount(uint(id))
+	if err != nil {
+		return serviceAccountErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, newServiceAccountResponse(account))
+}
+
+// AdminUpdateServiceAccount updates a service account
+//
+//	@Summary		Update service account (Admin)
+//	@Description	Update the name, description, scopes and status of a service account. Inactive service accounts cannot authenticate.
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int								true	"Service account ID"
+//	@Param			request	body		service.ServiceAccountRequest	true	"Service account"
+//	@Success		200		{object}	ServiceAccountResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Router			/admin/service-accounts/{id} [put]
+func AdminUpdateServiceAccount(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid service account ID",
+		})
+	}
+
+	var req service.ServiceAccountRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	account, err := serviceManager.ServiceAccounts.UpdateServiceAccount(auditActor(ctx), uint(id), req)
+	if err != nil {
+		return serviceAccountErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, newServiceAccountResponse(account))
+}
+
+// AdminDeleteServiceAccount deletes a service account
+//
+//	@Summary		Delete service account (Admin)
+//	@Description	Delete a service account together with its credentials
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id	path		int	true	"Service account ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Router			/admin/service-accounts/{id} [delete]
+func AdminDeleteServiceAccount(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid service account ID",
+		})
+	}
+
+	if err := serviceManager.ServiceAccounts.DeleteServiceAccount(auditActor(ctx), uint(id)); err != nil {
+		return serviceAccountErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Service account deleted successfully",
+	})
+}
+
+// AdminIssueServiceAccountCredential issues a credential to a service account
+//
+//	@Summary		Issue service account credential (Admin)
+//	@Description	Issue a secret a service account authenticates with as "Authorization: Bearer <secret>". The secret is returned only once. Existing credentials stay valid, so rotate by issuing a new credential, deploying it, then revoking the old one.
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int										true	"Service account ID"
+//	@Param			request	body		service.ServiceAccountCredentialRequest	true	"Credential"
+//	@Success		201		{object}	IssueServiceAccountCredentialResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/admin/service-accounts/{id}/credentials [post]
+func AdminIssueServiceAccountCredential(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid service account ID",
+		})
+	}
+
+	var req service.ServiceAccountCredentialRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	credential, secret, err := serviceManager.ServiceAccounts.IssueCredential(auditActor(ctx), uint(id), req)
+	if err != nil {
+		return serviceAccountErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusCreated, IssueServiceAccountCredentialResponse{
+		ServiceAccountCredentialResponse: newServiceAccountCredentialResponse(credential),
+		Secret:                           secret,
+	})
+}
+
+// AdminRevokeServiceAccountCredential revokes a credential of a service account
+//
+//	@Summary		Revoke service account credential (Admin)
+//	@Description	Revoke a credential of a service account. Its other credentials stay valid.
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id				path		int	true	"Service account ID"
+//	@Param			credential_id	path		int	true	"Credential ID"
+//	@Success		200				{object}	map[string]string
+//	@Failure		400				{object}	map[string]string
+//	@Failure		401				{object}	map[string]string
+//	@Failure		403				{object}	map[string]string
+//	@Failure		404				{object}	map[string]string
+//	@Router			/admin/service-accounts/{id}/credentials/{credential_id} [delete]
+func AdminRevokeServiceAccountCredential(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid service account ID",
+		})
+	}
+	credentialID, err := strconv.ParseUint(ctx.Param("credential_id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid credential ID",
+		})
+	}
+
+	if err := serviceManager.ServiceAccounts.RevokeCredential(auditActor(ctx), uint(id), uint(credentialID)); err != nil {
+		return serviceAccountErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Credential revoked",
+	})
+}
+
+func serviceAccountErrorResponse(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ctx.JSON(http.StatusNotFound, map[string]string{
+			"error": "Service account or credential not found",
+		})
+	case errors.Is(err, service.ErrInvalidServiceAccountScope):
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrServiceAccountExists):
+		return ctx.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	default:
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to process service account request",
+		})
+	}
+}
@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"errors"
+	"miniauth/database"
+	"miniauth/service"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type TeamRequest struct {
+	Name        string   `json:"name" validate:"required,min=1,max=100"`
+	Slug        string   `json:"slug" validate:"required,min=2,max=64"`
+	Description string   `json:"description" validate:"max=255"`
+	ParentID    *uint    `json:"parent_id"` // null makes the team a top-level team
+	Permissions []string `json:"permissions"`
+}
+
+type TeamMemberResponse struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	JoinedAt string `json:"joined_at"`
+}
+
+type TeamResponse struct {
+	ID          uint     `json:"id"`
+	Name        string   `json:"name"`
+	Slug        string   `json:"slug"`
+	Description string   `json:"description"`
+	ParentID    *uint    `json:"parent_id"`
+	Permissions []string `json:"permissions"`
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at"`
+	// Members are the direct members of the team, set when reading a single team
+	Members []TeamMemberResponse `json:"members,omitempty"`
+}
+
+type ListTeamsResponse struct {
+	Teams []TeamResponse `json:"teams"`
+}
+
+// ListTeams lists the teams of an organization
+//
+//	@Summary		List teams
+//	@Description	List the teams of an organization. Nested teams reference their parent with parent_id. Requires the teams:read permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug	path		string	true	"Organization slug"
+//	@Success		200		{object}	ListTeamsResponse
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/teams [get]
+func ListTeams(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	teams, err := serviceManager.Org.ListTeams(org.ID)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get teams",
+		})
+	}
+
+	response := ListTeamsResponse{Teams: make([]TeamResponse, 0, len(teams))}
+	for i := range teams {
+		response.Teams = append(response.Teams, newTeamResponse(&teams[i]))
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// CreateTeam creates a team in an organization
+//
+//	@Summary		Create team
+//	@Description	Create a team, optionally nested under a parent team. Members of a team receive its permissions and those of its parent teams. Requires the teams:manage permission; only permissions held by the current user can be granted.
+//	@Tags			orgs
+//	@Accept			json
+//	@Produce		json
+//	@Param			slug	path		string		true	"Organization slug"
+//	@Param			request	body		TeamRequest	true	"Team details"
+//	@Success		201		{object}	TeamResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/orgs/{slug}/teams [post]
+func CreateTeam(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req TeamRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	org, member, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	if err := service.ValidateOrgRolePermissions(req.Permissions); err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+
+	// Members of the new team also receive the permissions of its parent teams
+	inherited, err := serviceManager.Org.InheritedTeamPermissions(org.ID, req.ParentID)
+	if err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+	if err := ensureCanGrantPermissions(serviceManager, member, append(inherited, req.Permissions...)); err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+
+	team := &database.Team{
+		OrgID:       org.ID,
+		ParentID:    req.ParentID,
+		Name:        req.Name,
+		Slug:        req.Slug,
+		Description: req.Description,
+	}
+	if err := serviceManager.Org.CreateTeam(team, req.Permissions); err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusCreated, newTeamResponse(team))
+}
+
+// GetTeam retrieves a team with its direct members
+//
+//	@Summary		Get team
+//	@Description	Get a team with its direct members. Requires the teams:read permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug		path		string	true	"Organization slug"
+//	@Param			team_slug	path		string	true	"Team slug"
+//	@Success		200			{object}	TeamResponse
+//	@Failure		401			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Router			/orgs/{slug}/teams/{team_slug} [get]
+func GetTeam(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	team, err := serviceManager.Org.GetTeamBySlug(org.ID, ctx.Param("team_slug"))
+	if err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+
+	members, err := serviceManager.Org.ListTeamMembers(team.ID)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get team members",
+		})
+	}
+
+	response := newTeamResponse(team)
+	response.Members = make([]TeamMemberResponse, 0, len(members))
+	for _, member := range members {
+		user, err := serviceManager.User.GetUserByID(member.UserID)
+		if err != nil {
+			continue // Skip members whose user cannot be loaded
+		}
+
+		response.Members = append(response.Members, TeamMemberResponse{
+			UserID:   user.ID,
+			Username: user.Username,
+			Email:    user.Email,
+			JoinedAt: member.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// UpdateTeam updates a team
+//
+//	@Summary		Update team
+//	@Description	Update the name, slug, description, parent and permissions of a team. A team cannot be moved under itself or one of its child teams. Requires the teams:manage permission; only permissions held by the current user can be granted.
+//	@Tags			orgs
+//	@Accept			json
+//	@Produce		json
+//	@Param			slug		path		string		true	"Organization slug"
+//	@Param			team_slug	path		string		true	"Team slug"
+//	@Param			request		body		TeamRequest	true	"Team details"
+//	@Success		200			{object}	TeamResponse
+//	@Failure		400			{object}	map[string]string
+//	@Failure		401			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		409			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Router			/orgs/{slug}/teams/{team_slug} [put]
+func UpdateTeam(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req TeamRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	org, member, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	team, err := serviceManager.Org.GetTeamBySlug(org.ID, ctx.Param("team_slug"))
+	if err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+
+	if err := service.ValidateOrgRolePermissions(req.Permissions); err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+
+	// Both the current and the new permissions, including inherited ones, must be within the caller's own
+	current, err := serviceManager.Org.InheritedTeamPermissions(org.ID, &team.ID)
+	if err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+	inherited, err := serviceManager.Org.InheritedTeamPermissions(org.ID, req.ParentID)
+	if err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+	granted := append(append(current, inherited...), req.Permissions...)
+	if err := ensureCanGrantPermissions(serviceManager, member, granted); err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+
+	team.Name = req.Name
+	team.Slug = req.Slug
+	team.Description = req.Description
+	team.ParentID = req.ParentID
+	if err := serviceManager.Org.UpdateTeam(team, req.Permissions); err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, newTeamResponse(team))
+}
+
+// DeleteTeam deletes a team
+//
+//	@Summary		Delete team
+//	@Description	Delete a team and its memberships. Child teams are moved to the deleted team's parent. Requires the teams:manage permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug		path		string	true	"Organization slug"
+//	@Param			team_slug	path		string	true	"Team slug"
+//	@Success		200			{object}	map[string]string
+//	@Failure		401			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Router			/orgs/{slug}/teams/{team_slug} [delete]
+func DeleteTeam(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	team, err := serviceManager.Org.GetTeamBySlug(org.ID, ctx.Param("team_slug"))
+	if err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+
+	if err := serviceManager.Org.DeleteTeam(org.ID, team.ID); err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Team deleted successfully",
+	})
+}
+
+// AddTeamMember adds an organization member to a team
+//
+//	@Summary		Add team member
+//	@Description	Add an organization member to a team. Requires the teams:manage permission; the current user must hold every permission the team grants, including those of its parent teams.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug		path		string	true	"Organization slug"
+//	@Param			team_slug	path		string	true	"Team slug"
+//	@Param			user_id		path		int		true	"User ID"
+//	@Success		200			{object}	map[string]string
+//	@Failure		400			{object}	map[string]string
+//	@Failure		401			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		409			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Router			/orgs/{slug}/teams/{team_slug}/members/{user_id} [put]
+func AddTeamMember(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	userID, err := strconv.ParseUint(ctx.Param("user_id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user ID",
+		})
+	}
+
+	org, member, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	team, err := serviceManager.Org.GetTeamBySlug(org.ID, ctx.Param("team_slug"))
+	if err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+
+	granted, err := serviceManager.Org.InheritedTeamPermissions(org.ID, &team.ID)
+	if err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+	if err := ensureCanGrantPermissions(serviceManager, member, granted); err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+
+	if err := serviceManager.Org.AddTeamMember(team, uint(userID)); err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Team member added successfully",
+	})
+}
+
+// RemoveTeamMember removes a member from a team
+//
+//	@Summary		Remove team member
+//	@Description	Remove a direct member from a team. Requires the teams:manage permission.
+//	@Tags			orgs
+//	@Produce		json
+//	@Param			slug		path		string	true	"Organization slug"
+//	@Param			team_slug	path		string	true	"Team slug"
+//	@Param			user_id		path		int		true	"User ID"
+//	@Success		200			{object}	map[string]string
+//	@Failure		400			{object}	map[string]string
+//	@Failure		401			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Router			/orgs/{slug}/teams/{team_slug}/members/{user_id} [delete]
+func RemoveTeamMember(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	userID, err := strconv.ParseUint(ctx.Param("user_id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user ID",
+		})
+	}
+
+	org, _, err := loadOrgMembership(ctx)
+	if err != nil {
+		return orgErrorResponse(ctx, err)
+	}
+
+	team, err := serviceManager.Org.GetTeamBySlug(org.ID, ctx.Param("team_slug"))
+	if err != nil {
+		return teamErrorResponse(ctx, err)
+	}
+
+	if err := serviceManager.Org.RemoveTeamMember(team.ID, uint(userID)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ctx.JSON(http.StatusNotFound, map[string]string{
+				"error": "Team member not found",
+			})
+		}
+		return teamErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Team member removed successfully",
+	})
+}
+
+// teamErrorResponse maps team errors to HTTP responses
+func teamErrorResponse(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ctx.JSON(http.StatusNotFound, map[string]string{
+			"error": "Team not found",
+		})
+	case errors.Is(err, service.ErrNotOrgMember):
+		return orgMemberErrorResponse(ctx, err)
+	case errors.Is(err, service.ErrOrgPermissionDenied):
+		return ctx.JSON(http.StatusForbidden, map[string]string{
+			"error": "You can only grant permissions you hold yourself",
+		})
+	case errors.Is(err, service.ErrUnknownPermission), errors.Is(err, service.ErrReservedPermission),
+		errors.Is(err, service.ErrInvalidTeamSlug), errors.Is(err, service.ErrInvalidTeamParent):
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrTeamSlugTaken):
+		return ctx.JSON(http.StatusConflict, map[string]string{
+			"error": "Team slug is already taken",
+		})
+	case errors.Is(err, service.ErrAlreadyTeamMember):
+		return ctx.JSON(http.StatusConflict, map[string]string{
+			"error": "User is already a member of the team",
+		})
+	}
+	return ctx.JSON(http.StatusInternalServerError, map[string]string{
+		"error": "Failed to update team",
+	})
+}
+
+func newTeamResponse(team *database.Team) TeamResponse {
+	return TeamResponse{
+		ID:          team.ID,
+		Name:        team.Name,
+		Slug:        team.Slug,
+		Description: team.Description,
+		ParentID:    team.ParentID,
+		Permissions: service.SplitPermissions(team.Permissions),
+		CreatedAt:   team.CreatedAt.Format("2006-01-02 15:04:05"),
+		UpdatedAt:   team.UpdatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
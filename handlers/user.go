@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"miniauth/database"
 	"miniauth/middleware"
@@ -13,7 +14,7 @@ import (
 type CreateUserRequest struct {
 	Username string `json:"username" validate:"required,min=3,max=50"`
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=6,max=100"`
+	Password string `json:"password" validate:"required"`
 }
 
 type CreateUserResponse struct {
@@ -23,16 +24,17 @@ type CreateUserResponse struct {
 	Message  string `json:"message"`
 }
 
-// CreateUser creates a new user with a corresponding organization
+// CreateUser creates a new user and, depending on the personal organization policy, their personal organization
 //
 //	@Summary		Create a new user
-//	@Description	Create a new user account with username, email, and password. Also creates a same-name organization with the user as owner.
+//	@Description	Create a new user account with username, email, and password. With the always personal organization policy (PERSONAL_ORG_POLICY), also creates a personal organization named after the user, with a unique slug derived from the username.
 //	@Tags			users
 //	@Accept			json
 //	@Produce		json
 //	@Param			user	body		CreateUserRequest	true	"User creation request"
 //	@Success		201		{object}	CreateUserResponse
-//	@Failure		400		{object}	map[string]string
+//	@Failure		400		{object}	PasswordPolicyErrorResponse
+//	@Failure		409		{object}	map[string]string
 //	@Failure		500		{object}	map[string]string
 //	@Router			/users [post]
 func CreateUser(ctx echo.Context) error {
@@ -59,24 +61,41 @@ func CreateUser(ctx echo.Context) error {
 	}
 
 	// Set password
-	if err := user.SetPassword(req.Password); err != nil {
+	if err := serviceManager.User.SetUserPassword(user, req.Password); err != nil {
+		var policyErr *service.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return passwordPolicyErrorResponse(ctx, policyErr)
+		}
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to set password",
 		})
 	}
 
 	if err := serviceManager.User.CreateUser(user); err != nil {
+		if errors.Is(err, service.ErrEmailInUse) {
+			return ctx.JSON(http.StatusConflict, map[string]string{
+				"error": "This email address is already used by another account",
+			})
+		}
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{
 			"error": err.Error(),
 		})
 	}
 
+	message := "User created successfully"
+	if serviceManager.User.PersonalOrgPolicy() == service.PersonalOrgPolicyAlways {
+		message = "User and organization created successfully"
+	}
+	if err := serviceManager.User.SendVerificationEmail(user); err != nil {
+		message += ", but the verification email could not be sent"
+	}
+
 	// Return success response
 	response := CreateUserResponse{
 		ID:       user.ID,
 		Username: user.Username,
 		Email:    user.Email,
-		Message:  "User and organization created successfully",
+		Message:  message,
 	}
 
 	return ctx.JSON(http.StatusCreated, response)
@@ -86,28 +105,34 @@ type GetUserResponse struct {
 	ID            uint               `json:"id"`
 	Username      string             `json:"username"`
 	Email         string             `json:"email"`
+	EmailVerified bool               `json:"email_verified"`
 	Role          database.UserRole  `json:"role"`
 	Organizations []OrganizationInfo `json:"organizations"`
 }
 
 type OrganizationInfo struct {
-	ID   uint   `json:"id"`
-	Name string `json:"name"`
-	Slug string `json:"slug"`
-	Role string `json:"role"`
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	Role     string `json:"role"`
+	Personal bool   `json:"personal"` // Whether this is the user's personal organization
 }
 
 // GetUser retrieves user information by ID
 //
 //	@Summary		Get user by ID
-//	@Description	Get user information including associated organizations and roles
+//	@Description	Get user information including associated organizations and roles. No authentication is required. Resource servers may send an API key with the users:read scope, which is then checked against its allowed IPs and rate limit; API keys of an organization, or of one of its applications, only find members of that organization and only see it among their organizations.
 //	@Tags			users
+//	@Security		ApiKeyAuth
 //	@Accept			json
 //	@Produce		json
 //	@Param			id	path		int	true	"User ID"
 //	@Success		200	{object}	GetUserResponse
 //	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
 //	@Failure		404	{object}	map[string]string
+//	@Failure		429	{object}	map[string]string
 //	@Failure		500	{object}	map[string]string
 //	@Router			/users/{id} [get]
 func GetUser(ctx echo.Context) error {
@@ -138,6 +163,16 @@ func GetUser(ctx echo.Context) error {
 		})
 	}
 
+	// API keys of an organization, or of its applications, only see the organization's members
+	apiKey, _ := ctx.Get("apiKey").(*middleware.APIKeyData)
+	if apiKey != nil && apiKey.OrgID != nil {
+		if _, err := serviceManager.Org.GetOrgMemberByUserID(*apiKey.OrgID, user.ID); err != nil {
+			return ctx.JSON(http.StatusNotFound, map[string]string{
+				"error": "User not found",
+			})
+		}
+	}
+
 	// Get user's organizations
 	orgs, err := serviceManager.Org.GetUserOrgs(user.ID)
 	if err != nil {
@@ -149,6 +184,10 @@ func GetUser(ctx echo.Context) error {
 	// Get user's roles in each organization
 	var organizations []OrganizationInfo
 	for _, org := range orgs {
+		if apiKey != nil && apiKey.OrgID != nil && org.ID != *apiKey.OrgID {
+			continue
+		}
+
 		member, err := serviceManager.Org.GetOrgMemberByUserID(org.ID, user.ID)
 		role := "unknown"
 		if err == nil {
@@ -156,10 +195,11 @@ func GetUser(ctx echo.Context) error {
 		}
 
 		organizations = append(organizations, OrganizationInfo{
-			ID:   org.ID,
-			Name: org.Name,
-			Slug: org.Slug,
-			Role: role,
+			ID:       org.ID,
+			Name:     org.Name,
+			Slug:     org.Slug,
+			Role:     role,
+			Personal: isPersonalOrgOf(&org, user.ID),
 		})
 	}
 
@@ -168,6 +208,7 @@ func GetUser(ctx echo.Context) error {
 		ID:            user.ID,
 		Username:      user.Username,
 		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
 		Role:          user.Role,
 		Organizations: organizations,
 	}
@@ -189,7 +230,7 @@ type LoginResponse struct {
 
 type ChangePasswordRequest struct {
 	CurrentPassword string `json:"currentPassword" validate:"required"`
-	NewPassword     string `json:"newPassword" validate:"required,min=6,max=100"`
+	NewPassword     string `json:"newPassword" validate:"required"`
 }
 
 type UpdateProfileRequest struct {
@@ -207,6 +248,7 @@ type UpdateProfileRequest struct {
 //	@Success		200			{object}	LoginResponse
 //	@Failure		400			{object}	map[string]string
 //	@Failure		401			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
 //	@Router			/login [post]
 func LoginUser(ctx echo.Context) error {
 	// Get service manager from context
@@ -226,7 +268,17 @@ func LoginUser(ctx echo.Context) error {
 	}
 
 	// Authenticate user
-	user, err := serviceManager.User.AuthenticateUser(req.Email, req.Password)
+	user, err := serviceManager.User.AuthenticateUser(auditActor(ctx), req.Email, req.Password)
+	if errors.Is(err, service.ErrEmailNotVerified) {
+		return ctx.JSON(http.StatusForbidden, map[string]string{
+			"error": "Email address not verified",
+		})
+	}
+	if errors.Is(err, service.ErrUserDisabled) {
+		return ctx.JSON(http.StatusForbidden, map[string]string{
+			"error": "Account disabled",
+		})
+	}
 	if err != nil {
 		return ctx.JSON(http.StatusUnauthorized, map[string]string{
 			"error": "Invalid credentials",
@@ -295,6 +347,13 @@ func GetCurrentUser(ctx echo.Context) error {
 	// Get service manager to fetch full user details
 	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
 
+	user, err := serviceManager.User.GetUserByID(currentUser.UserID)
+	if err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{
+			"error": "User not found",
+		})
+	}
+
 	// Get user's organizations
 	orgs, err := serviceManager.Org.GetUserOrgs(currentUser.UserID)
 	if err != nil {
@@ -313,19 +372,21 @@ func GetCurrentUser(ctx echo.Context) error {
 		}
 
 		organizations = append(organizations, OrganizationInfo{
-			ID:   org.ID,
-			Name: org.Name,
-			Slug: org.Slug,
-			Role: role,
+			ID:       org.ID,
+			Name:     org.Name,
+			Slug:     org.Slug,
+			Role:     role,
+			Personal: isPersonalOrgOf(&org, currentUser.UserID),
 		})
 	}
 
 	// Return response
 	response := GetUserResponse{
-		ID:            currentUser.UserID,
-		Username:      currentUser.Username,
-		Email:         currentUser.Email,
-		Role:          currentUser.Role,
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Role:          user.Role,
 		Organizations: organizations,
 	}
 
@@ -341,7 +402,7 @@ func GetCurrentUser(ctx echo.Context) error {
 //	@Produce		json
 //	@Param			request	body		ChangePasswordRequest	true	"Change password request"
 //	@Success		200		{object}	map[string]string
-//	@Failure		400		{object}	map[string]string
+//	@Failure		400		{object}	PasswordPolicyErrorResponse
 //	@Failure		401		{object}	map[string]string
 //	@Failure		500		{object}	map[string]string
 //	@Router			/me/change-password [put]
@@ -378,15 +439,12 @@ func ChangePassword(ctx echo.Context) error {
 		})
 	}
 
-	// Set new password
-	if err := user.SetPassword(req.NewPassword); err != nil {
-		return ctx.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to set new password",
-		})
-	}
-
-	// Update user in database
-	if err := serviceManager.User.UpdateUser(user); err != nil {
+	// Validate and store the new password
+	if err := serviceManager.User.UpdateUserPassword(auditActor(ctx), user.ID, req.NewPassword); err != nil {
+		var policyErr *service.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return passwordPolicyErrorResponse(ctx, policyErr)
+		}
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to update password",
 		})
@@ -471,10 +529,11 @@ func UpdateProfile(ctx echo.Context) error {
 		}
 
 		organizations = append(organizations, OrganizationInfo{
-			ID:   org.ID,
-			Name: org.Name,
-			Slug: org.Slug,
-			Role: role,
+			ID:       org.ID,
+			Name:     org.Name,
+			Slug:     org.Slug,
+			Role:     role,
+			Personal: isPersonalOrgOf(&org, user.ID),
 		})
 	}
 
@@ -483,9 +542,44 @@ func UpdateProfile(ctx echo.Context) error {
 		ID:            user.ID,
 		Username:      user.Username,
 		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
 		Role:          user.Role,
 		Organizations: organizations,
 	}
 
 	return ctx.JSON(http.StatusOK, response)
 }
+
+// PasswordPolicyErrorResponse is returned when a password is rejected by the password policy
+type PasswordPolicyErrorResponse struct {
+	Error      string                      `json:"error"`
+	Violations []service.PasswordViolation `json:"violations"`
+}
+
+// passwordPolicyErrorResponse writes the structured password policy error
+func passwordPolicyErrorResponse(ctx echo.Context, policyErr *service.PasswordPolicyError) error {
+	return ctx.JSON(http.StatusBadRequest, PasswordPolicyErrorResponse{
+		Error:      "Password does not meet the password policy",
+		Violations: policyErr.Violations,
+	})
+}
+
+// GetPasswordPolicy returns the active password policy
+//
+//	@Summary		Get password policy
+//	@Description	Get the password rules enforced on registration, password change and admin reset
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	service.PasswordPolicy
+//	@Router			/password-policy [get]
+func GetPasswordPolicy(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	return ctx.JSON(http.StatusOK, serviceManager.User.PasswordPolicy())
+}
+
+// isPersonalOrgOf reports whether the organization is the personal organization of the user
+func isPersonalOrgOf(org *database.Org, userID uint) bool {
+	return org.PersonalUserID != nil && *org.PersonalUserID == userID
+}
@@ -0,0 +1,501 @@
+package handlers
+
+import (
+	"errors"
+	"miniauth/database"
+	"miniauth/middleware"
+	"miniauth/service"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type WebhookSubscriptionResponse struct {
+	ID     uint     `json:"id"`
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active bool     `json:"active"`
+	// Secret signs the deliveries; it is only returned when the subscription is created or its secret rotated
+	Secret    string `json:"secret,omitempty"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type ListWebhookSubscriptionsResponse struct {
+	Webhooks []WebhookSubscriptionResponse `json:"webhooks"`
+}
+
+type WebhookEventTypesResponse struct {
+	Events []string `json:"events"`
+}
+
+type WebhookDeliveryResponse struct {
+	ID            uint                             `json:"id"`
+	EventID       string                           `json:"event_id"`
+	EventType     string                           `json:"event_type"`
+	Status        string                           `json:"status"`
+	Attempts      int                              `json:"attempts"`
+	NextAttemptAt string                           `json:"next_attempt_at,omitempty"`
+	DeliveredAt   string                           `json:"delivered_at,omitempty"`
+	CreatedAt     string                           `json:"created_at"`
+	Payload       string                           `json:"payload,omitempty"`
+	AttemptLog    []WebhookDeliveryAttemptResponse `json:"attempt_log,omitempty"`
+}
+
+type WebhookDeliveryAttemptResponse struct {
+	StatusCode   int    `json:"status_code,omitempty"`
+	Error        string `json:"error,omitempty"`
+	ResponseBody string `json:"response_body,omitempty"`
+	DurationMs   int64  `json:"duration_ms"`
+	CreatedAt    string `json:"created_at"`
+}
+
+type ListWebhookDeliveriesResponse struct {
+	Deliveries []WebhookDeliveryResponse `json:"deliveries"`
+	Total      int64                     `json:"total"`
+	Page       int                       `json:"page"`
+	Size       int                       `json:"size"`
+}
+
+// AdminListWebhookEventTypes lists the events webhooks can subscribe to
+//
+//	@Summary		List webhook event types (Admin)
+//	@Description	List the event types webhook subscriptions can receive. Subscribe to "*" to receive all of them.
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Success		200	{object}	WebhookEventTypesResponse
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Router			/admin/webhooks/events [get]
+func AdminListWebhookEventTypes(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, WebhookEventTypesResponse{Events: service.WebhookEventTypes})
+}
+
+// AdminListWebhooks lists webhook subscriptions
+//
+//	@Summary		List webhooks (Admin)
+//	@Description	List all webhook subscriptions
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Success		200	{object}	ListWebhookSubscriptionsResponse
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/admin/webhooks [get]
+func AdminListWebhooks(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	subscriptions, err := serviceManager.Webhook.ListSubscriptions()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to list webhooks",
+		})
+	}
+
+	response := ListWebhookSubscriptionsResponse{Webhooks: make([]WebhookSubscriptionResponse, 0, len(subscriptions))}
+	for i := range subscriptions {
+		response.Webhooks = append(response.Webhooks, newWebhookSubscriptionResponse(&subscriptions[i], false))
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// AdminCreateWebhook creates a webhook subscription
+//
+//	@Summary		Create webhook (Admin)
+//	@Description	Subscribe an endpoint to events. Deliveries are signed with the returned secret: the X-Miniauth-Signature header is "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">".
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		service.WebhookSubscriptionRequest	true	"Webhook"
+//	@Success		201		{object}	WebhookSubscriptionResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/admin/webhooks [post]
+func AdminCreateWebhook(ctx echo.Context) error {
+	currentUser := ctx.Get("currentUser").(*middleware.SessionData)
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	var req service.WebhookSubscriptionRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	subscription, err := serviceManager.Webhook.CreateSubscription(currentUser.UserID, req)
+	if err != nil {
+		return webhookErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusCreated, newWebhookSubscriptionResponse(subscription, true))
+}
+
+// AdminGetWebhook returns a webhook subscription
+//
+//	@Summary		Get webhook (Admin)
+//	@Description	Get a webhook subscription
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id	path		int	true	"Webhook ID"
+//	@Success		200	{object}	WebhookSubscriptionResponse
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Router			/admin/webhooks/{id} [get]
+func AdminGetWebhook(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid webhook ID",
+		})
+	}
+
+	subscription, err := serviceManager.Webhook.GetSubscription(uint(id))
+	if err != nil {
+		return webhookErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, newWebhookSubscriptionResponse(subscription, false))
+}
+
+// AdminUpdateWebhook updates a webhook subscription
+//
+//	@Summary		Update webhook (Admin)
+//	@Description	Update the name, URL, events and state of a webhook subscription. Deliveries of an inactive webhook are held until it is activated again.
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int									true	"Webhook ID"
+//	@Param			request	body		service.WebhookSubscriptionRequest	true	"Webhook"
+//	@Success		200		{object}	WebhookSubscriptionResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Router			/admin/webhooks/{id} [put]
+func AdminUpdateWebhook(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid webhook ID",
+		})
+	}
+
+	var req service.WebhookSubscriptionRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return err
+	}
+
+	subscription, err := serviceManager.Webhook.UpdateSubscription(uint(id), req)
+	if err != nil {
+		return webhookErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, newWebhookSubscriptionResponse(subscription, false))
+}
+
+// AdminRotateWebhookSecret replaces the signing secret of a webhook subscription
+//
+//	@Summary		Rotate webhook secret (Admin)
+//	@Description	Generate a new signing secret. Deliveries sent from now on are signed with it.
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id	path		int	true	"Webhook ID"
+//	@Success		200	{object}	WebhookSubscriptionResponse
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Router			/admin/webhooks/{id}/rotate-secret [post]
+func AdminRotateWebhookSecret(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid webhook ID",
+		})
+	}
+
+	subscription, err := serviceManager.Webhook.RotateSubscriptionSecret(uint(id))
+	if err != nil {
+		return webhookErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, newWebhookSubscriptionResponse(subscription, true))
+}
+
+// AdminDeleteWebhook deletes a webhook subscription
+//
+//	@Summary		Delete webhook (Admin)
+//	@Description	Delete a webhook subscription together with its deliveries
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id	path		int	true	"Webhook ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Router			/admin/webhooks/{id} [delete]
+func AdminDeleteWebhook(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid webhook ID",
+		})
+	}
+
+	if err := serviceManager.Webhook.DeleteSubscription(uint(id)); err != nil {
+		return webhookErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{
+		"message": "Webhook deleted successfully",
+	})
+}
+
+// AdminListWebhookDeliveries lists the deliveries of a webhook subscription
+//
+//	@Summary		List webhook deliveries (Admin)
+//	@Description	List the deliveries of a webhook subscription, newest first
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id		path		int		true	"Webhook ID"
+//	@Param			status	query		string	false	"Delivery status (pending, succeeded or failed)"
+//	@Param			page	query		int		false	"Page number (default: 1)"
+//	@Param			size	query		int		false	"Page size (default: 20)"
+//	@Success		200		{object}	ListWebhookDeliveriesResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Router			/admin/webhooks/{id}/deliveries [get]
+func AdminListWebhookDeliveries(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid webhook ID",
+		})
+	}
+	if _, err := serviceManager.Webhook.GetSubscription(uint(id)); err != nil {
+		return webhookErrorResponse(ctx, err)
+	}
+
+	status := database.WebhookDeliveryStatus(ctx.QueryParam("status"))
+	switch status {
+	case "", database.WebhookDeliveryStatusPending, database.WebhookDeliveryStatusSucceeded, database.WebhookDeliveryStatusFailed:
+	default:
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid status",
+		})
+	}
+
+	page := 1
+	size := 20
+	if p := ctx.QueryParam("page"); p != "" {
+		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+	if s := ctx.QueryParam("size"); s != "" {
+		if parsed, err := strconv.Atoi(s); err == nil && parsed > 0 && parsed <= 100 {
+			size = parsed
+		}
+	}
+
+	deliveries, total, err := serviceManager.Webhook.ListDeliveries(uint(id), status, (page-1)*size, size)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to list deliveries",
+		})
+	}
+
+	response := ListWebhookDeliveriesResponse{
+		Deliveries: make([]WebhookDeliveryResponse, 0, len(deliveries)),
+		Total:      total,
+		Page:       page,
+		Size:       size,
+	}
+	for i := range deliveries {
+		response.Deliveries = append(response.Deliveries, newWebhookDeliveryResponse(&deliveries[i], nil))
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// AdminGetWebhookDelivery returns a delivery with its payload and attempts
+//
+//	@Summary		Get webhook delivery (Admin)
+//	@Description	Get a delivery of a webhook subscription with its payload and the outcome of every attempt
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id			path		int	true	"Webhook ID"
+//	@Param			delivery_id	path		int	true	"Delivery ID"
+//	@Success		200			{object}	WebhookDeliveryResponse
+//	@Failure		400			{object}	map[string]string
+//	@Failure		401			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Router			/admin/webhooks/{id}/deliveries/{delivery_id} [get]
+func AdminGetWebhookDelivery(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid webhook ID",
+		})
+	}
+	deliveryID, err := strconv.ParseUint(ctx.Param("delivery_id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid delivery ID",
+		})
+	}
+
+	delivery, attempts, err := serviceManager.Webhook.GetDelivery(uint(id), uint(deliveryID))
+	if err != nil {
+		return webhookErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, newWebhookDeliveryResponse(delivery, attempts))
+}
+
+// AdminRedeliverWebhookDelivery queues a delivery again
+//
+//	@Summary		Redeliver webhook delivery (Admin)
+//	@Description	Send a delivery again with a fresh retry budget, whatever its current status
+//	@Tags			admin
+//	@Security		BasicAuth
+//	@Produce		json
+//	@Param			id			path		int	true	"Webhook ID"
+//	@Param			delivery_id	path		int	true	"Delivery ID"
+//	@Success		202			{object}	map[string]string
+//	@Failure		400			{object}	map[string]string
+//	@Failure		401			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Router			/admin/webhooks/{id}/deliveries/{delivery_id}/redeliver [post]
+func AdminRedeliverWebhookDelivery(ctx echo.Context) error {
+	serviceManager := ctx.Get("serviceManager").(*service.ServiceManager)
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid webhook ID",
+		})
+	}
+	deliveryID, err := strconv.ParseUint(ctx.Param("delivery_id"), 10, 32)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid delivery ID",
+		})
+	}
+
+	if _, err := serviceManager.Webhook.Redeliver(uint(id), uint(deliveryID)); err != nil {
+		return webhookErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusAccepted, map[string]string{
+		"message": "Delivery queued",
+	})
+}
+
+func newWebhookSubscriptionResponse(subscription *database.WebhookSubscription, withSecret bool) WebhookSubscriptionResponse {
+	response := WebhookSubscriptionResponse{
+		ID:        subscription.ID,
+		Name:      subscription.Name,
+		URL:       subscription.URL,
+		Events:    strings.Fields(subscription.Events),
+		Active:    subscription.Active,
+		CreatedAt: subscription.CreatedAt.Format("2006-01-02 15:04:05"),
+		UpdatedAt: subscription.UpdatedAt.Format("2006-01-02 15:04:05"),
+	}
+	if withSecret {
+		response.Secret = subscription.Secret
+	}
+	return response
+}
+
+func newWebhookDeliveryResponse(delivery *database.WebhookDelivery, attempts []database.WebhookDeliveryAttempt) WebhookDeliveryResponse {
+	response := WebhookDeliveryResponse{
+		ID:        delivery.ID,
+		EventID:   delivery.EventID,
+		EventType: delivery.EventType,
+		Status:    string(delivery.Status),
+		Attempts:  delivery.Attempts,
+		CreatedAt: delivery.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+	if delivery.NextAttemptAt != nil {
+		response.NextAttemptAt = delivery.NextAttemptAt.Format("2006-01-02 15:04:05")
+	}
+	if delivery.DeliveredAt != nil {
+		response.DeliveredAt = delivery.DeliveredAt.Format("2006-01-02 15:04:05")
+	}
+
+	// The payload and attempt log are only included when a single delivery is requested
+	if attempts != nil {
+		response.Payload = delivery.Payload
+		response.AttemptLog = make([]WebhookDeliveryAttemptResponse, 0, len(attempts))
+		for _, attempt := range attempts {
+			response.AttemptLog = append(response.AttemptLog, WebhookDeliveryAttemptResponse{
+				StatusCode:   attempt.StatusCode,
+				Error:        attempt.Error,
+				ResponseBody: attempt.ResponseBody,
+				DurationMs:   attempt.DurationMs,
+				CreatedAt:    attempt.CreatedAt.Format("2006-01-02 15:04:05"),
+			})
+		}
+	}
+	return response
+}
+
+// webhookErrorResponse maps webhook errors to HTTP responses
+func webhookErrorResponse(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ctx.JSON(http.StatusNotFound, map[string]string{
+			"error": "Webhook or delivery not found",
+		})
+	case errors.Is(err, service.ErrInvalidWebhookURL), errors.Is(err, service.ErrInvalidWebhookEvent):
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	default:
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to process webhook request",
+		})
+	}
+}
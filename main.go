@@ -6,6 +6,7 @@ import (
 	"miniauth/routers"
 	"miniauth/service"
 	"net/http"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
@@ -37,6 +38,9 @@ func (cv *CustomValidator) Validate(i any) error {
 //	@host						localhost:8080
 //	@BasePath					/api
 //	@securityDefinitions.basic	BasicAuth
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						X-API-Key
 func main() {
 	// Initialize database
 	databaseConfig := database.GetDatabaseConfig()
@@ -57,7 +61,25 @@ func main() {
 	}
 
 	// Initialize services
-	serviceManager := service.NewServiceManager(db)
+	serviceManager, err := service.NewServiceManager(db)
+	if err != nil {
+		panic(err)
+	}
+
+	// Permanently delete organizations whose restore grace period has ended
+	serviceManager.Org.StartDeletedOrgPurger(time.Hour)
+
+	// Delete audit events older than the retention period
+	serviceManager.Audit.StartRetentionPurger(time.Hour)
+
+	// Deliver new audit events to the configured audit sinks
+	serviceManager.Audit.StartSinkStreamer(5 * time.Second)
+
+	// Handle password reset and verification email requests in the background
+	serviceManager.User.StartMailWorkers(4)
+
+	// Send queued webhook deliveries that are due
+	serviceManager.Webhook.StartDispatcher(5 * time.Second)
 
 	// Initialize Echo server
 	e := echo.New()
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+)
+
+// APIKeyData identifies the API key a request was authenticated with and what its owner can see
+type APIKeyData struct {
+	ID                 uint
+	Name               string
+	Scopes             []string
+	RateLimitPerMinute int
+	OrgID              *uint  // Organization the key is limited to: its owner, or the organization of its owning application
+	ClientID           string // Client ID of the owning application; empty when an organization owns the key
+}
+
+// APIKeyAuthenticator resolves an API key sent from ip to its key. It returns an *echo.HTTPError
+// with status 403 for a valid key sent from an address the key is not allowed from.
+type APIKeyAuthenticator func(key, ip string) (*APIKeyData, error)
+
+// APIKeyManager authenticates and rate limits the API keys resource servers call miniauth with
+type APIKeyManager struct {
+	authenticate APIKeyAuthenticator
+
+	mu       sync.Mutex
+	limiters map[uint]*rate.Limiter // Per key, allowing its rate limit per minute
+}
+
+// NewAPIKeyManager creates a new API key manager
+func NewAPIKeyManager(authenticate APIKeyAuthenticator) *APIKeyManager {
+	return &APIKeyManager{
+		authenticate: authenticate,
+		limiters:     make(map[uint]*rate.Limiter),
+	}
+}
+
+// apiKey returns the key sent in the X-API-Key header or as "Authorization: ApiKey <key>"
+func apiKey(c echo.Context) (string, bool) {
+	if key := c.Request().Header.Get("X-API-Key"); key != "" {
+		return key, true
+	}
+
+	const scheme = "ApiKey "
+	authHeader := c.Request().Header.Get("Authorization")
+	if len(authHeader) > len(scheme) && strings.EqualFold(authHeader[:len(scheme)], scheme) {
+		return strings.TrimSpace(authHeader[len(scheme):]), true
+	}
+	return "", false
+}
+
+// allow takes a request from the rate limit of a key, returning how long to wait when it is used up
+func (akm *APIKeyManager) allow(key *APIKeyData) (time.Duration, bool) {
+	limit := rate.Limit(float64(key.RateLimitPerMinute) / 60)
+
+	akm.mu.Lock()
+	limiter, ok := akm.limiters[key.ID]
+	// The limit can change while the key is in use; start over with the new one
+	if !ok || limiter.Limit() != limit || limiter.Burst() != key.RateLimitPerMinute {
+		limiter = rate.NewLimiter(limit, key.RateLimitPerMinute)
+		akm.limiters[key.ID] = limiter
+	}
+	akm.mu.Unlock()
+
+	reservation := limiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return delay, false
+	}
+	return 0, true
+}
+
+// requireAPIKey authenticates the API key of the request and checks its rate limit and scope
+// before calling next
+func (akm *APIKeyManager) requireAPIKey(c echo.Context, key, scope string, next echo.HandlerFunc) error {
+	apiKeyData, err := akm.authenticate(key, c.RealIP())
+	if err != nil {
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) && httpErr.Code == http.StatusForbidden {
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"error":             "forbidden",
+				"error_description": fmt.Sprint(httpErr.Message),
+			})
+		}
+		c.Response().Header().Set("WWW-Authenticate", `ApiKey realm="miniauth"`)
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error":             "invalid_api_key",
+			"error_description": "API key is invalid, expired or revoked",
+		})
+	}
+
+	if wait, ok := akm.allow(apiKeyData); !ok {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return c.JSON(http.StatusTooManyRequests, map[string]string{
+			"error":             "rate_limit_exceeded",
+			"error_description": fmt.Sprintf("API key is limited to %d requests per minute", apiKeyData.RateLimitPerMinute),
+		})
+	}
+
+	if !slices.Contains(apiKeyData.Scopes, scope) {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error":             "insufficient_scope",
+			"error_description": "API key requires the " + scope + " scope",
+		})
+	}
+
+	c.Set("apiKey", apiKeyData)
+	return next(c)
+}
+
+// RequireAPIKeyOrClient middleware requires either an API key with the scope or OAuth client
+// credentials sent with HTTP Basic authentication
+func (akm *APIKeyManager) RequireAPIKeyOrClient(scope string, authenticate ClientAuthenticator) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if key, ok := apiKey(c); ok {
+				return akm.requireAPIKey(c, key, scope, next)
+			}
+
+			clientID, clientSecret, ok := c.Request().BasicAuth()
+			if ok && authenticate(clientID, clientSecret) == nil {
+				c.Set("oauth_client_id", clientID)
+				return next(c)
+			}
+
+			c.Response().Header().Set("WWW-Authenticate", `Basic realm="miniauth"`)
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"error":             "unauthorized",
+				"error_description": "Valid API key or client credentials required",
+			})
+		}
+	}
+}
+
+// AllowAPIKey middleware lets requests through without an API key, and requires a key that is
+// sent to be valid, have the scope and be within its rate limit
+func (akm *APIKeyManager) AllowAPIKey(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if key, ok := apiKey(c); ok {
+				return akm.requireAPIKey(c, key, scope, next)
+			}
+			return next(c)
+		}
+	}
+}
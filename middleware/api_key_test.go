@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestAllowAPIKey(t *testing.T) {
+	keys := map[string]*APIKeyData{
+		"mak_reader":  {ID: 1, Scopes: []string{"users:read"}, RateLimitPerMinute: 600},
+		"mak_limited": {ID: 2, Scopes: []string{"users:read"}, RateLimitPerMinute: 1},
+		"mak_other":   {ID: 3, Scopes: []string{"tokens:introspect"}, RateLimitPerMinute: 600},
+	}
+	akm := NewAPIKeyManager(func(key, ip string) (*APIKeyData, error) {
+		if ip != "192.0.2.1" {
+			return nil, echo.NewHTTPError(http.StatusForbidden, "API key is not allowed from this address")
+		}
+		if data, ok := keys[key]; ok {
+			return data, nil
+		}
+		return nil, errors.New("invalid API key")
+	})
+
+	e := echo.New()
+	e.IPExtractor = echo.ExtractIPDirect()
+	e.GET("/", func(c echo.Context) error {
+		if data, ok := c.Get("apiKey").(*APIKeyData); ok {
+			return c.String(http.StatusOK, "key "+data.Name)
+		}
+		return c.String(http.StatusOK, "anonymous")
+	}, akm.AllowAPIKey("users:read"))
+
+	tests := []struct {
+		name       string
+		header     string
+		value      string
+		remoteAddr string
+		wantStatus int
+		wantBody   string
+	}{
+		{"no key", "", "", "192.0.2.1:1234", http.StatusOK, "anonymous"},
+		{"X-API-Key header", "X-API-Key", "mak_reader", "192.0.2.1:1234", http.StatusOK, "key"},
+		{"Authorization header", "Authorization", "apikey mak_reader", "192.0.2.1:1234", http.StatusOK, "key"},
+		{"invalid key", "X-API-Key", "mak_unknown", "192.0.2.1:1234", http.StatusUnauthorized, "invalid_api_key"},
+		{"denied address", "X-API-Key", "mak_reader", "198.51.100.1:1234", http.StatusForbidden, "not allowed from this address"},
+		{"missing scope", "X-API-Key", "mak_other", "192.0.2.1:1234", http.StatusForbidden, "insufficient_scope"},
+		{"within the rate limit", "X-API-Key", "mak_limited", "192.0.2.1:1234", http.StatusOK, "key"},
+		{"over the rate limit", "X-API-Key", "mak_limited", "192.0.2.1:1234", http.StatusTooManyRequests, "rate_limit_exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("got %d %s, want %d containing %q", rec.Code, rec.Body, tt.wantStatus, tt.wantBody)
+			}
+			if tt.wantStatus == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+				t.Error("rate limited response has no Retry-After header")
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SCIMTokenManager authenticates SCIM provisioning clients with a static bearer token
+type SCIMTokenManager struct {
+	token string
+}
+
+// NewSCIMTokenManager creates a SCIM token manager from SCIM_TOKEN. SCIM is disabled when it is empty.
+func NewSCIMTokenManager() *SCIMTokenManager {
+	return &SCIMTokenManager{
+		token: os.Getenv("SCIM_TOKEN"),
+	}
+}
+
+// ValidateSCIMToken validates the bearer token of the request
+func (stm *SCIMTokenManager) ValidateSCIMToken(c echo.Context) bool {
+	if stm.token == "" {
+		return false
+	}
+	authHeader := c.Request().Header.Get("Authorization")
+	if len(authHeader) < 7 || !strings.EqualFold(authHeader[:7], "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(authHeader[7:]), []byte(stm.token)) == 1
+}
+
+// RequireSCIMToken middleware requires the SCIM bearer token and answers with a SCIM error otherwise
+func (stm *SCIMTokenManager) RequireSCIMToken(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if stm.ValidateSCIMToken(c) {
+			return next(c)
+		}
+		c.Response().Header().Set("WWW-Authenticate", `Bearer realm="miniauth-scim"`)
+		c.Response().Header().Set(echo.HeaderContentType, "application/scim+json")
+		return c.JSON(401, map[string]interface{}{
+			"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:Error"},
+			"status":  "401",
+			"detail":  "Valid SCIM bearer token required",
+		})
+	}
+}
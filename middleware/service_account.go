@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"miniauth/database"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ServiceAccountData identifies the service account a request was authenticated as
+type ServiceAccountData struct {
+	ID     uint
+	Name   string
+	Scopes []string
+}
+
+// ServiceAccountAuthenticator resolves a service account secret sent from ip to its account
+type ServiceAccountAuthenticator func(secret, ip string) (*ServiceAccountData, error)
+
+// ServiceAccountManager authenticates service accounts calling internal endpoints
+type ServiceAccountManager struct {
+	authenticate ServiceAccountAuthenticator
+}
+
+// NewServiceAccountManager creates a new service account manager
+func NewServiceAccountManager(authenticate ServiceAccountAuthenticator) *ServiceAccountManager {
+	return &ServiceAccountManager{
+		authenticate: authenticate,
+	}
+}
+
+// serviceAccountSecret returns the secret sent as "Authorization: Bearer <secret>",
+// "Authorization: Internal <secret>" or in the X-Internal-Token header
+func serviceAccountSecret(c echo.Context) (string, bool) {
+	authHeader := c.Request().Header.Get("Authorization")
+	for _, scheme := range []string{"Bearer ", "Internal "} {
+		if len(authHeader) > len(scheme) && strings.EqualFold(authHeader[:len(scheme)], scheme) {
+			return strings.TrimSpace(authHeader[len(scheme):]), true
+		}
+	}
+
+	if secret := c.Request().Header.Get("X-Internal-Token"); secret != "" {
+		return secret, true
+	}
+	return "", false
+}
+
+// authenticateServiceAccount returns the service account of the request, if it sent a valid secret
+func (sam *ServiceAccountManager) authenticateServiceAccount(c echo.Context) (*ServiceAccountData, bool) {
+	secret, ok := serviceAccountSecret(c)
+	if !ok {
+		return nil, false
+	}
+	serviceAccount, err := sam.authenticate(secret, c.RealIP())
+	if err != nil {
+		return nil, false
+	}
+	return serviceAccount, true
+}
+
+// insufficientScope answers a service account that lacks the scope of an endpoint
+func insufficientScope(c echo.Context, scope string) error {
+	return c.JSON(403, map[string]string{
+		"error":             "insufficient_scope",
+		"error_description": "Service account requires the " + scope + " scope",
+	})
+}
+
+// RequireServiceAccountOrAdmin middleware requires either a service account with the scope or an admin session
+func (sam *ServiceAccountManager) RequireServiceAccountOrAdmin(scope string, sessionManager *SessionManager) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if serviceAccount, ok := sam.authenticateServiceAccount(c); ok {
+				if !slices.Contains(serviceAccount.Scopes, scope) {
+					return insufficientScope(c, scope)
+				}
+				c.Set("serviceAccount", serviceAccount)
+				return next(c)
+			}
+
+			// Fall back to admin session authentication
+			sessionData, err := sessionManager.GetSession(c)
+			if err != nil {
+				return c.JSON(401, map[string]string{
+					"error":             "unauthorized",
+					"error_description": "Valid service account credential or admin session required",
+				})
+			}
+
+			if sessionData.Role != database.UserRoleAdmin || !sessionData.HasScope(database.TokenScopeAdmin) {
+				return c.JSON(403, map[string]string{
+					"error":             "forbidden",
+					"error_description": "Admin privileges required",
+				})
+			}
+
+			c.Set("currentUser", sessionData)
+			return next(c)
+		}
+	}
+}
+
+// ClientAuthenticator validates OAuth client credentials
+type ClientAuthenticator func(clientID, clientSecret string) error
+
+// RequireServiceAccountOrClient middleware requires either a service account with the scope or
+// OAuth client credentials sent with HTTP Basic authentication
+func (sam *ServiceAccountManager) RequireServiceAccountOrClient(scope string, authenticate ClientAuthenticator) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if serviceAccount, ok := sam.authenticateServiceAccount(c); ok {
+				if !slices.Contains(serviceAccount.Scopes, scope) {
+					return insufficientScope(c, scope)
+				}
+				c.Set("serviceAccount", serviceAccount)
+				return next(c)
+			}
+
+			clientID, clientSecret, ok := c.Request().BasicAuth()
+			if ok && authenticate(clientID, clientSecret) == nil {
+				c.Set("oauth_client_id", clientID)
+				return next(c)
+			}
+
+			c.Response().Header().Set("WWW-Authenticate", `Basic realm="miniauth"`)
+			return c.JSON(401, map[string]string{
+				"error":             "unauthorized",
+				"error_description": "Valid service account credential or client credentials required",
+			})
+		}
+	}
+}
@@ -2,24 +2,64 @@ package middleware
 
 import (
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"miniauth/database"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/gorilla/sessions"
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
 // SessionManager manages user sessions
 type SessionManager struct {
-	store sessions.Store
+	store              sessions.Store
+	validator          SessionValidator
+	permissionChecker  PermissionChecker
+	tokenAuthenticator TokenAuthenticator
 }
 
+// SessionValidator reports whether a session issued with the given version is still valid for the user
+type SessionValidator func(userID, sessionVersion uint) bool
+
+// PermissionChecker reports whether a user holds a permission on a resource in a scope.
+// It returns gorm.ErrRecordNotFound when the resource does not exist or is hidden from the user.
+type PermissionChecker func(userID uint, scope, resource, permission string) (bool, error)
+
+// TokenAuthenticator resolves a bearer token sent from ip to the session it acts as
+type TokenAuthenticator func(token, ip string) (*SessionData, error)
+
+// tokenSessionKey caches the session of a bearer token for the rest of the request
+const tokenSessionKey = "tokenSession"
+
 // SessionData represents the data stored in a session
 type SessionData struct {
-	UserID   uint              `json:"user_id"`
-	Username string            `json:"username"`
-	Email    string            `json:"email"`
-	Role     database.UserRole `json:"role"`
+	UserID         uint              `json:"user_id"`
+	Username       string            `json:"username"`
+	Email          string            `json:"email"`
+	Role           database.UserRole `json:"role"`
+	SessionVersion uint              `json:"session_version"`
+	AccessTokenID  uint              `json:"access_token_id,omitempty"` // Personal access token the request was authenticated with, if any
+	Scopes         []string          `json:"scopes,omitempty"`          // Scopes of the access token
+}
+
+// HasScope reports whether the session may act with a scope. Browser sessions hold every scope.
+func (s *SessionData) HasScope(scope string) bool {
+	return s.AccessTokenID == 0 || slices.Contains(s.Scopes, scope)
+}
+
+// User returns the signed-in user described by the session
+func (s *SessionData) User() *database.User {
+	user := &database.User{
+		Username: s.Username,
+		Email:    s.Email,
+		Role:     s.Role,
+	}
+	user.ID = s.UserID
+	return user
 }
 
 // NewSessionManager creates a new session manager
@@ -42,6 +82,21 @@ func NewSessionManager(sessionKey string) *SessionManager {
 	}
 }
 
+// SetSessionValidator sets the callback used to check whether a session has been revoked
+func (sm *SessionManager) SetSessionValidator(validator SessionValidator) {
+	sm.validator = validator
+}
+
+// SetPermissionChecker sets the callback used by RequirePermission
+func (sm *SessionManager) SetPermissionChecker(checker PermissionChecker) {
+	sm.permissionChecker = checker
+}
+
+// SetTokenAuthenticator sets the callback used to accept bearer tokens in place of a session
+func (sm *SessionManager) SetTokenAuthenticator(authenticator TokenAuthenticator) {
+	sm.tokenAuthenticator = authenticator
+}
+
 // CreateSession creates a new session for a user
 func (sm *SessionManager) CreateSession(ctx echo.Context, user *database.User) error {
 	session, err := sm.store.Get(ctx.Request(), "user-session")
@@ -50,18 +105,24 @@ func (sm *SessionManager) CreateSession(ctx echo.Context, user *database.User) e
 	}
 
 	sessionData := SessionData{
-		UserID:   user.ID,
-		Username: user.Username,
-		Email:    user.Email,
-		Role:     user.Role,
+		UserID:         user.ID,
+		Username:       user.Username,
+		Email:          user.Email,
+		Role:           user.Role,
+		SessionVersion: user.SessionVersion,
 	}
 
 	session.Values["user"] = sessionData
 	return session.Save(ctx.Request(), ctx.Response())
 }
 
-// GetSession retrieves session data for the current user
+// GetSession retrieves session data for the current user. Requests with a bearer token are
+// authenticated by the token alone, within the scopes it was granted.
 func (sm *SessionManager) GetSession(ctx echo.Context) (*SessionData, error) {
+	if token, ok := bearerToken(ctx); ok && sm.tokenAuthenticator != nil {
+		return sm.getTokenSession(ctx, token)
+	}
+
 	session, err := sm.store.Get(ctx.Request(), "user-session")
 	if err != nil {
 		return nil, err
@@ -77,21 +138,80 @@ func (sm *SessionManager) GetSession(ctx echo.Context) (*SessionData, error) {
 		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid session data")
 	}
 
+	// Reject sessions that were revoked server-side (e.g. after a password reset)
+	if sm.validator != nil && !sm.validator(sessionData.UserID, sessionData.SessionVersion) {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Session has been revoked")
+	}
+
 	return &sessionData, nil
 }
 
-// UpdateSession updates session data for the current user
+// getTokenSession authenticates a bearer token, once per request, and checks that its scopes
+// allow the request method
+func (sm *SessionManager) getTokenSession(ctx echo.Context, token string) (*SessionData, error) {
+	sessionData, ok := ctx.Get(tokenSessionKey).(*SessionData)
+	if !ok {
+		var err error
+		sessionData, err = sm.tokenAuthenticator(token, ctx.RealIP())
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid access token")
+		}
+		ctx.Set(tokenSessionKey, sessionData)
+	}
+
+	switch ctx.Request().Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		if sessionData.HasScope(database.TokenScopeRead) || sessionData.HasScope(database.TokenScopeWrite) {
+			return sessionData, nil
+		}
+	default:
+		if sessionData.HasScope(database.TokenScopeWrite) {
+			return sessionData, nil
+		}
+	}
+	return nil, echo.NewHTTPError(http.StatusForbidden, "Access token does not have the scope for this request")
+}
+
+// bearerToken returns the token of an Authorization: Bearer header
+func bearerToken(ctx echo.Context) (string, bool) {
+	authHeader := ctx.Request().Header.Get("Authorization")
+	if len(authHeader) < 7 || !strings.EqualFold(authHeader[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(authHeader[7:]), true
+}
+
+// authenticationFailed answers a request whose session could not be established
+func authenticationFailed(ctx echo.Context, err error) error {
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) && httpErr.Code == http.StatusForbidden {
+		return ctx.JSON(http.StatusForbidden, map[string]string{
+			"error": fmt.Sprint(httpErr.Message),
+		})
+	}
+	return ctx.JSON(http.StatusUnauthorized, map[string]string{
+		"error": "Authentication required",
+	})
+}
+
+// UpdateSession updates session data for the current user. Requests authenticated with a bearer
+// token have no session to update, and must not be handed a session cookie.
 func (sm *SessionManager) UpdateSession(ctx echo.Context, user *database.User) error {
+	if _, ok := ctx.Get(tokenSessionKey).(*SessionData); ok {
+		return nil
+	}
+
 	session, err := sm.store.Get(ctx.Request(), "user-session")
 	if err != nil {
 		return err
 	}
 
 	sessionData := SessionData{
-		UserID:   user.ID,
-		Username: user.Username,
-		Email:    user.Email,
-		Role:     user.Role,
+		UserID:         user.ID,
+		Username:       user.Username,
+		Email:          user.Email,
+		Role:           user.Role,
+		SessionVersion: user.SessionVersion,
 	}
 
 	session.Values["user"] = sessionData
@@ -115,9 +235,7 @@ func (sm *SessionManager) RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
 		sessionData, err := sm.GetSession(ctx)
 		if err != nil {
-			return ctx.JSON(http.StatusUnauthorized, map[string]string{
-				"error": "Authentication required",
-			})
+			return authenticationFailed(ctx, err)
 		}
 
 		// Store session data in context for use in handlers
@@ -131,9 +249,7 @@ func (sm *SessionManager) RequireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
 		sessionData, err := sm.GetSession(ctx)
 		if err != nil {
-			return ctx.JSON(http.StatusUnauthorized, map[string]string{
-				"error": "Authentication required",
-			})
+			return authenticationFailed(ctx, err)
 		}
 
 		if sessionData.Role != database.UserRoleAdmin {
@@ -141,6 +257,11 @@ func (sm *SessionManager) RequireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
 				"error": "Admin access required",
 			})
 		}
+		if !sessionData.HasScope(database.TokenScopeAdmin) {
+			return ctx.JSON(http.StatusForbidden, map[string]string{
+				"error": "Access token does not have the admin scope",
+			})
+		}
 
 		// Store session data in context for use in handlers
 		ctx.Set("currentUser", sessionData)
@@ -148,6 +269,47 @@ func (sm *SessionManager) RequireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// RequirePermission is a middleware that requires a permission in a scope.
+// For the "org" scope the organization is taken from the :slug path parameter.
+func (sm *SessionManager) RequirePermission(scope, permission string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			sessionData, err := sm.GetSession(ctx)
+			if err != nil {
+				return authenticationFailed(ctx, err)
+			}
+
+			if sm.permissionChecker == nil {
+				return ctx.JSON(http.StatusForbidden, map[string]string{
+					"error": "Permission denied",
+				})
+			}
+
+			allowed, err := sm.permissionChecker(sessionData.UserID, scope, ctx.Param("slug"), permission)
+			if err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return ctx.JSON(http.StatusNotFound, map[string]string{
+						"error": "Resource not found",
+					})
+				}
+				return ctx.JSON(http.StatusInternalServerError, map[string]string{
+					"error": "Failed to check permission",
+				})
+			}
+
+			if !allowed {
+				return ctx.JSON(http.StatusForbidden, map[string]string{
+					"error": "Permission denied: " + permission,
+				})
+			}
+
+			// Store session data in context for use in handlers
+			ctx.Set("currentUser", sessionData)
+			return next(ctx)
+		}
+	}
+}
+
 // GetCurrentUser retrieves the current user from session and returns a User object
 func (sm *SessionManager) GetCurrentUser(ctx echo.Context) (*database.User, error) {
 	sessionData, err := sm.GetSession(ctx)
@@ -155,13 +317,5 @@ func (sm *SessionManager) GetCurrentUser(ctx echo.Context) (*database.User, erro
 		return nil, err
 	}
 
-	// Convert session data to User object
-	user := &database.User{
-		Username: sessionData.Username,
-		Email:    sessionData.Email,
-		Role:     sessionData.Role,
-	}
-	user.ID = sessionData.UserID
-
-	return user, nil
+	return sessionData.User(), nil
 }
@@ -1,22 +1,86 @@
 package routers
 
 import (
+	"errors"
+	"log"
 	"miniauth/handlers"
 	"miniauth/middleware"
 	"miniauth/service"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	echomiddleware "github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
 )
 
 // SetupRoutes sets up all routes for the application
 func SetupRoutes(e *echo.Echo, serviceManager *service.ServiceManager) {
 	// Create session manager with a secret key (should be from environment in production)
 	sessionManager := middleware.NewSessionManager("your-secret-key-change-in-production")
+	sessionManager.SetSessionValidator(serviceManager.User.IsSessionValid)
+	sessionManager.SetPermissionChecker(serviceManager.Permission.Check)
+	sessionManager.SetTokenAuthenticator(func(token, ip string) (*middleware.SessionData, error) {
+		user, accessToken, err := serviceManager.Tokens.Authenticate(token, ip)
+		if err != nil {
+			return nil, err
+		}
+		return &middleware.SessionData{
+			UserID:         user.ID,
+			Username:       user.Username,
+			Email:          user.Email,
+			Role:           user.Role,
+			SessionVersion: user.SessionVersion,
+			AccessTokenID:  accessToken.ID,
+			Scopes:         strings.Fields(accessToken.Scopes),
+		}, nil
+	})
+
+	serviceAccountManager := middleware.NewServiceAccountManager(func(secret, ip string) (*middleware.ServiceAccountData, error) {
+		serviceAccount, err := serviceManager.ServiceAccounts.Authenticate(secret, ip)
+		if err != nil {
+			return nil, err
+		}
+		return &middleware.ServiceAccountData{
+			ID:     serviceAccount.ID,
+			Name:   serviceAccount.Name,
+			Scopes: strings.Fields(serviceAccount.Scopes),
+		}, nil
+	})
+	apiKeyManager := middleware.NewAPIKeyManager(func(key, ip string) (*middleware.APIKeyData, error) {
+		apiKey, err := serviceManager.APIKeys.Authenticate(key, ip)
+		if err != nil {
+			if errors.Is(err, service.ErrAPIKeyIPNotAllowed) {
+				return nil, echo.NewHTTPError(http.StatusForbidden, err.Error())
+			}
+			return nil, err
+		}
+		data := &middleware.APIKeyData{
+			ID:                 apiKey.ID,
+			Name:               apiKey.Name,
+			Scopes:             strings.Fields(apiKey.Scopes),
+			RateLimitPerMinute: apiKey.RateLimitPerMinute,
+			OrgID:              apiKey.OrgID,
+		}
+		if apiKey.Application != nil {
+			data.OrgID = apiKey.Application.OrgID
+			data.ClientID = apiKey.Application.ClientID
+		}
+		return data, nil
+	})
+	authenticateClient := func(clientID, clientSecret string) error {
+		_, err := serviceManager.OAuth.AuthenticateClient(clientID, clientSecret)
+		return err
+	}
+	scimTokenManager := middleware.NewSCIMTokenManager()
 
-	// Create internal token manager
-	internalTokenManager := middleware.NewInternalTokenManager()
+	// Client addresses feed IP allowlists, rate limits and audit records, so forwarding headers
+	// are only honored from trusted proxies
+	e.IPExtractor = clientIPExtractor()
 
 	// Middleware
 	e.Use(echomiddleware.Logger())
@@ -50,11 +114,44 @@ func SetupRoutes(e *echo.Echo, serviceManager *service.ServiceManager) {
 	// Auth routes (no authentication required)
 	api.POST("/login", handlers.LoginUser)
 	api.POST("/logout", handlers.LogoutUser)
+	api.GET("/password-policy", handlers.GetPasswordPolicy)
 
-	// User routes
+	// Sign in with upstream identity providers
+	authProviders := api.Group("/auth/providers")
+	authProviders.GET("", handlers.ListLoginProviders)
+	authProviders.GET("/:slug/login", handlers.FederatedLogin)
+	authProviders.GET("/:slug/callback", handlers.FederatedCallback)
+
+	// SAML 2.0 identity provider (the session signs users in)
+	saml := api.Group("/saml")
+	saml.GET("/metadata", handlers.SAMLMetadata)
+	saml.GET("/sso", handlers.SAMLSingleSignOn)
+	saml.POST("/sso", handlers.SAMLSingleSignOn)
+	saml.GET("/sso/resume", handlers.SAMLResumeSingleSignOn)
+
+	// Requests that send email to an address given by the caller share a per client IP limit
+	mailRateLimiter := echomiddleware.RateLimiter(passwordResetRateLimiterStore())
+
+	// Email verification routes
+	email := api.Group("/email")
+	email.GET("/verify", handlers.VerifyEmailLink)
+	email.POST("/verify", handlers.VerifyEmail)
+	email.POST("/resend-verification", handlers.ResendVerificationEmail, mailRateLimiter)
+	email.GET("/change/confirm", handlers.ConfirmEmailChangeLink)
+	email.POST("/change/confirm", handlers.ConfirmEmailChange)
+	email.GET("/change/revert", handlers.RevertEmailChangeLink)
+	email.POST("/change/revert", handlers.RevertEmailChange)
+
+	// Password reset routes (rate limited per client IP)
+	password := api.Group("/password")
+	password.Use(mailRateLimiter)
+	password.POST("/forgot", handlers.ForgotPassword)
+	password.POST("/reset", handlers.ResetPassword)
+
+	// User routes (resource servers may look users up with an API key)
 	users := api.Group("/users")
 	users.POST("", handlers.CreateUser)
-	users.GET("/:id", handlers.GetUser)
+	users.GET("/:id", handlers.GetUser, apiKeyManager.AllowAPIKey(service.APIKeyScopeUsersRead))
 
 	// Protected routes (authentication required)
 	protected := api.Group("/me")
@@ -62,6 +159,65 @@ func SetupRoutes(e *echo.Echo, serviceManager *service.ServiceManager) {
 	protected.GET("", handlers.GetCurrentUser)
 	protected.PUT("/change-password", handlers.ChangePassword)
 	protected.PUT("/profile", handlers.UpdateProfile)
+	protected.POST("/email", handlers.RequestEmailChange)
+	protected.GET("/join-requests", handlers.ListMyJoinRequests)
+	protected.POST("/personal-org", handlers.CreatePersonalOrg)
+	protected.POST("/personal-org/detach", handlers.DetachPersonalOrg)
+	protected.GET("/identities", handlers.ListMyIdentities)
+	protected.GET("/identities/:slug/link", handlers.LinkIdentity)
+	protected.DELETE("/identities/:id", handlers.UnlinkMyIdentity)
+	protected.GET("/tokens", handlers.ListMyTokens)
+	protected.POST("/tokens", handlers.CreateMyToken)
+	protected.DELETE("/tokens/:id", handlers.RevokeMyToken)
+
+	// Organization routes (authentication required, organization permissions checked per route)
+	orgs := api.Group("/orgs")
+	orgs.GET("", handlers.ListMyOrgs, sessionManager.RequireAuth)
+	orgs.POST("", handlers.CreateOrg, sessionManager.RequireAuth)
+	orgs.GET("/:slug", handlers.GetOrg, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionOrgRead))
+	orgs.PUT("/:slug", handlers.UpdateOrg, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionOrgUpdate))
+	orgs.DELETE("/:slug", handlers.DeleteOrg, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionOrgDelete))
+	orgs.POST("/:slug/restore", handlers.RestoreOrg, sessionManager.RequireAuth)
+	orgs.POST("/:slug/transfer-ownership", handlers.TransferOrgOwnership, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionOrgTransfer))
+	orgs.POST("/:slug/leave", handlers.LeaveOrg, sessionManager.RequireAuth)
+	orgs.GET("/:slug/members", handlers.ListOrgMembers, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionMembersRead))
+	orgs.PUT("/:slug/members/:user_id/role", handlers.UpdateOrgMemberRole, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionMembersManage))
+	orgs.PUT("/:slug/members/:user_id/custom-role", handlers.AssignOrgMemberRole, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionMembersManage))
+	orgs.DELETE("/:slug/members/:user_id", handlers.RemoveOrgMember, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionMembersManage))
+	orgs.GET("/:slug/invitations", handlers.ListOrgInvitations, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionMembersInvite))
+	orgs.POST("/:slug/invitations", handlers.CreateOrgInvitation, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionMembersInvite))
+	orgs.DELETE("/:slug/invitations/:id", handlers.RevokeOrgInvitation, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionMembersInvite))
+	orgs.GET("/:slug/roles", handlers.ListOrgRoles, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionMembersRead))
+	orgs.POST("/:slug/roles", handlers.CreateOrgRole, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionRolesManage))
+	orgs.PUT("/:slug/roles/:role_id", handlers.UpdateOrgRole, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionRolesManage))
+	orgs.DELETE("/:slug/roles/:role_id", handlers.DeleteOrgRole, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionRolesManage))
+	orgs.GET("/:slug/teams", handlers.ListTeams, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionTeamsRead))
+	orgs.POST("/:slug/teams", handlers.CreateTeam, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionTeamsManage))
+	orgs.GET("/:slug/teams/:team_slug", handlers.GetTeam, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionTeamsRead))
+	orgs.PUT("/:slug/teams/:team_slug", handlers.UpdateTeam, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionTeamsManage))
+	orgs.DELETE("/:slug/teams/:team_slug", handlers.DeleteTeam, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionTeamsManage))
+	orgs.PUT("/:slug/teams/:team_slug/members/:user_id", handlers.AddTeamMember, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionTeamsManage))
+	orgs.DELETE("/:slug/teams/:team_slug/members/:user_id", handlers.RemoveTeamMember, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionTeamsManage))
+	orgs.GET("/:slug/apps", handlers.ListOrgApplications, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionAppsRead))
+	orgs.POST("/:slug/apps", handlers.CreateOrgApplication, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionAppsManage))
+	orgs.GET("/:slug/apps/:app_id", handlers.GetOrgApplication, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionAppsRead))
+	orgs.PUT("/:slug/apps/:app_id", handlers.UpdateOrgApplication, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionAppsManage))
+	orgs.DELETE("/:slug/apps/:app_id", handlers.DeleteOrgApplication, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionAppsManage))
+	orgs.GET("/:slug/domains", handlers.ListOrgDomains, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionDomainsManage))
+	orgs.POST("/:slug/domains", handlers.CreateOrgDomain, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionDomainsManage))
+	orgs.PUT("/:slug/domains/:domain_id", handlers.UpdateOrgDomain, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionDomainsManage))
+	orgs.DELETE("/:slug/domains/:domain_id", handlers.DeleteOrgDomain, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionDomainsManage))
+	orgs.POST("/:slug/domains/:domain_id/verify", handlers.VerifyOrgDomain, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionDomainsManage))
+	orgs.GET("/:slug/join-requests", handlers.ListOrgJoinRequests, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionMembersInvite))
+	orgs.POST("/:slug/join-requests/:id/approve", handlers.ApproveOrgJoinRequest, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionMembersInvite))
+	orgs.POST("/:slug/join-requests/:id/reject", handlers.RejectOrgJoinRequest, sessionManager.RequirePermission(service.PermissionScopeOrg, service.PermissionMembersInvite))
+	api.GET("/permissions", handlers.ListPermissions, sessionManager.RequireAuth)
+
+	// Invitation routes (accepting requires authentication)
+	invitations := api.Group("/invitations")
+	invitations.GET("", handlers.GetInvitation)
+	invitations.POST("/accept", handlers.AcceptInvitation, sessionManager.RequireAuth)
+	invitations.POST("/decline", handlers.DeclineInvitation)
 
 	// Admin routes (admin authentication required)
 	admin := api.Group("/admin")
@@ -74,6 +230,75 @@ func SetupRoutes(e *echo.Echo, serviceManager *service.ServiceManager) {
 	adminUsers.DELETE("/:id", handlers.AdminDeleteUser)
 	adminUsers.POST("/:id/reset-password", handlers.AdminResetUserPassword)
 	adminUsers.PUT("/:id/role", handlers.AdminUpdateUserRole)
+	admin.GET("/audit-events", handlers.AdminListAuditEvents)
+	admin.GET("/audit-events/export", handlers.AdminExportAuditEvents)
+
+	// Outbound webhooks (Admin only)
+	adminWebhooks := admin.Group("/webhooks")
+	adminWebhooks.GET("", handlers.AdminListWebhooks)
+	adminWebhooks.POST("", handlers.AdminCreateWebhook)
+	adminWebhooks.GET("/events", handlers.AdminListWebhookEventTypes)
+	adminWebhooks.GET("/:id", handlers.AdminGetWebhook)
+	adminWebhooks.PUT("/:id", handlers.AdminUpdateWebhook)
+	adminWebhooks.DELETE("/:id", handlers.AdminDeleteWebhook)
+	adminWebhooks.POST("/:id/rotate-secret", handlers.AdminRotateWebhookSecret)
+	adminWebhooks.GET("/:id/deliveries", handlers.AdminListWebhookDeliveries)
+	adminWebhooks.GET("/:id/deliveries/:delivery_id", handlers.AdminGetWebhookDelivery)
+	adminWebhooks.POST("/:id/deliveries/:delivery_id/redeliver", handlers.AdminRedeliverWebhookDelivery)
+
+	// Upstream identity providers (Admin only)
+	adminIdentityProviders := admin.Group("/identity-providers")
+	adminIdentityProviders.GET("", handlers.AdminListIdentityProviders)
+	adminIdentityProviders.POST("", handlers.AdminCreateIdentityProvider)
+	adminIdentityProviders.GET("/:id", handlers.AdminGetIdentityProvider)
+	adminIdentityProviders.PUT("/:id", handlers.AdminUpdateIdentityProvider)
+	adminIdentityProviders.DELETE("/:id", handlers.AdminDeleteIdentityProvider)
+
+	// SAML service providers (Admin only)
+	adminSAML := admin.Group("/saml/service-providers")
+	adminSAML.GET("", handlers.AdminListSAMLServiceProviders)
+	adminSAML.POST("", handlers.AdminCreateSAMLServiceProvider)
+	adminSAML.GET("/:id", handlers.AdminGetSAMLServiceProvider)
+	adminSAML.PUT("/:id", handlers.AdminUpdateSAMLServiceProvider)
+	adminSAML.DELETE("/:id", handlers.AdminDeleteSAMLServiceProvider)
+	adminSAML.POST("/:id/toggle", handlers.AdminToggleSAMLServiceProviderStatus)
+
+	// Service accounts for internal API clients (Admin only)
+	adminServiceAccounts := admin.Group("/service-accounts")
+	adminServiceAccounts.GET("", handlers.AdminListServiceAccounts)
+	adminServiceAccounts.POST("", handlers.AdminCreateServiceAccount)
+	adminServiceAccounts.GET("/:id", handlers.AdminGetServiceAccount)
+	adminServiceAccounts.PUT("/:id", handlers.AdminUpdateServiceAccount)
+	adminServiceAccounts.DELETE("/:id", handlers.AdminDeleteServiceAccount)
+	adminServiceAccounts.POST("/:id/credentials", handlers.AdminIssueServiceAccountCredential)
+	adminServiceAccounts.DELETE("/:id/credentials/:credential_id", handlers.AdminRevokeServiceAccountCredential)
+
+	// API keys for resource servers (Admin only)
+	adminAPIKeys := admin.Group("/api-keys")
+	adminAPIKeys.GET("", handlers.AdminListAPIKeys)
+	adminAPIKeys.POST("", handlers.AdminIssueAPIKey)
+	adminAPIKeys.GET("/:id", handlers.AdminGetAPIKey)
+	adminAPIKeys.POST("/:id/rotate", handlers.AdminRotateAPIKey)
+	adminAPIKeys.DELETE("/:id", handlers.AdminRevokeAPIKey)
+
+	// SCIM 2.0 provisioning (SCIM bearer token)
+	scim := e.Group("/scim/v2")
+	scim.Use(scimTokenManager.RequireSCIMToken)
+	scim.GET("/ServiceProviderConfig", handlers.ScimServiceProviderConfig)
+	scim.GET("/Schemas", handlers.ScimSchemas)
+	scim.GET("/ResourceTypes", handlers.ScimResourceTypes)
+	scim.GET("/Users", handlers.ScimListUsers)
+	scim.POST("/Users", handlers.ScimCreateUser)
+	scim.GET("/Users/:id", handlers.ScimGetUser)
+	scim.PUT("/Users/:id", handlers.ScimReplaceUser)
+	scim.PATCH("/Users/:id", handlers.ScimPatchUser)
+	scim.DELETE("/Users/:id", handlers.ScimDeleteUser)
+	scim.GET("/Groups", handlers.ScimListGroups)
+	scim.POST("/Groups", handlers.ScimCreateGroup)
+	scim.GET("/Groups/:id", handlers.ScimGetGroup)
+	scim.PUT("/Groups/:id", handlers.ScimReplaceGroup)
+	scim.PATCH("/Groups/:id", handlers.ScimPatchGroup)
+	scim.DELETE("/Groups/:id", handlers.ScimDeleteGroup)
 
 	// Health check
 	e.GET("/health", func(c echo.Context) error {
@@ -86,6 +311,7 @@ func SetupRoutes(e *echo.Echo, serviceManager *service.ServiceManager) {
 	oauth.POST("/authorize", handlers.OAuthAuthorizeDecision)
 	oauth.POST("/token", handlers.OAuthToken)
 	oauth.GET("/userinfo", handlers.OAuthUserInfo)
+	oauth.POST("/introspect", handlers.OAuthIntrospect, apiKeyManager.RequireAPIKeyOrClient(service.APIKeyScopeTokensIntrospect, authenticateClient))
 
 	// OAuth application management (Admin only)
 	adminOAuth := admin.Group("/oauth")
@@ -97,13 +323,66 @@ func SetupRoutes(e *echo.Echo, serviceManager *service.ServiceManager) {
 	adminOAuthApps.POST("/:id/toggle", handlers.AdminToggleOAuthApplicationStatus)
 	adminOAuthApps.POST("/:id/toggle-trusted", handlers.AdminToggleOAuthApplicationTrustedStatus)
 
-	// Internal OAuth application management (Internal Token or Admin) - allows custom client_id and secret
+	// Authorization decisions for downstream services (service account or OAuth client credentials)
+	authz := api.Group("/authz")
+	authz.Use(serviceAccountManager.RequireServiceAccountOrClient(service.ServiceAccountScopeAuthzCheck, authenticateClient))
+	authz.POST("/check", handlers.AuthzCheck)
+
+	// Internal OAuth application management (Service Account or Admin) - allows custom client_id and secret
 	// Note: Create directly under /api to avoid inheriting admin middleware
 	internalOAuth := api.Group("/admin/oauth/internal")
 	internalOAuthApps := internalOAuth.Group("/applications")
 
-	// Use internal token or admin authentication for internal APIs
-	internalOAuthApps.Use(internalTokenManager.RequireInternalTokenOrAdmin(sessionManager))
+	// Use service account or admin authentication for internal APIs
+	internalOAuthApps.Use(serviceAccountManager.RequireServiceAccountOrAdmin(service.ServiceAccountScopeOAuthApplicationsCreate, sessionManager))
 	internalOAuthApps.POST("", handlers.AdminInternalCreateOAuthApplication)
 	internalOAuthApps.POST("/batch", handlers.AdminInternalBatchCreateOAuthApplications)
 }
+
+// clientIPExtractor returns how the client address of a request is determined. Without
+// TRUSTED_PROXIES it is the address of the connection; with it, X-Forwarded-For is followed
+// through the listed proxy addresses and CIDR ranges only.
+func clientIPExtractor() echo.IPExtractor {
+	var options []echo.TrustOption
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("ignoring invalid TRUSTED_PROXIES entry %q", entry)
+			continue
+		}
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	if len(options) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	// Only the listed proxies are trusted, not every loopback or private address
+	options = append(options, echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false))
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// passwordResetRateLimiterStore creates the per client IP limiter for the password reset and
+// verification email endpoints, configured as requests per minute through PASSWORD_RESET_RATE_PER_MINUTE
+func passwordResetRateLimiterStore() echomiddleware.RateLimiterStore {
+	perMinute := 5
+	if value, err := strconv.Atoi(os.Getenv("PASSWORD_RESET_RATE_PER_MINUTE")); err == nil && value > 0 {
+		perMinute = value
+	}
+
+	return echomiddleware.NewRateLimiterMemoryStoreWithConfig(echomiddleware.RateLimiterMemoryStoreConfig{
+		Rate:      rate.Limit(float64(perMinute) / 60),
+		Burst:     perMinute,
+		ExpiresIn: 10 * time.Minute,
+	})
+}
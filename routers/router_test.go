@@ -0,0 +1,45 @@
+package routers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPExtractor(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies string
+		remoteAddr     string
+		forwardedFor   string
+		want           string
+	}{
+		{"no trusted proxies ignores X-Forwarded-For", "", "10.0.0.1:1234", "203.0.113.7", "10.0.0.1"},
+		{"no trusted proxies without X-Forwarded-For", "", "192.0.2.1:1234", "", "192.0.2.1"},
+		{"trusted proxy address", "10.0.0.1", "10.0.0.1:1234", "203.0.113.7", "203.0.113.7"},
+		{"trusted proxy range", "10.0.0.0/8, 2001:db8::/32", "10.1.2.3:1234", "203.0.113.7", "203.0.113.7"},
+		{"trusted IPv6 proxy", "2001:db8::1", "[2001:db8::1]:1234", "203.0.113.7", "203.0.113.7"},
+		{"chain of trusted proxies", "10.0.0.0/8", "10.0.0.1:1234", "203.0.113.7, 10.0.0.2", "203.0.113.7"},
+		{"spoofed address before the client", "10.0.0.1", "10.0.0.1:1234", "198.51.100.1, 203.0.113.7", "203.0.113.7"},
+		{"untrusted connection", "10.0.0.1", "192.0.2.1:1234", "203.0.113.7", "192.0.2.1"},
+		{"other private addresses are not trusted", "10.0.0.1", "192.168.0.1:1234", "203.0.113.7", "192.168.0.1"},
+		{"loopback is not trusted", "10.0.0.1", "127.0.0.1:1234", "203.0.113.7", "127.0.0.1"},
+		{"invalid entries are ignored", "not-an-ip, 10.0.0.1", "10.0.0.1:1234", "203.0.113.7", "203.0.113.7"},
+		{"only invalid entries trust nobody", "not-an-ip", "10.0.0.1:1234", "203.0.113.7", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.trustedProxies)
+			extract := clientIPExtractor()
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			if got := extract(req); got != tt.want {
+				t.Errorf("client IP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,393 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"miniauth/database"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Scopes of API keys, each allowing one of the APIs resource servers call
+const (
+	APIKeyScopeTokensIntrospect = "tokens:introspect" // POST /api/oauth/introspect
+	APIKeyScopeUsersRead        = "users:read"        // GET /api/users/:id
+)
+
+const (
+	apiKeyPrefix = "mak_"
+	// apiKeyPrefixLength is how much of a key is kept to tell keys apart
+	apiKeyPrefixLength = len(apiKeyPrefix) + 6
+	// apiKeyUsageInterval limits how often the last use of a key is written
+	apiKeyUsageInterval = time.Minute
+)
+
+// APIKeyScopes lists the scopes API keys can be granted
+var APIKeyScopes = []string{
+	APIKeyScopeTokensIntrospect,
+	APIKeyScopeUsersRead,
+}
+
+var (
+	// ErrInvalidAPIKeyScope is returned when an API key request names an unknown scope
+	ErrInvalidAPIKeyScope = errors.New("unknown API key scope")
+	// ErrInvalidAPIKeyOwner is returned when an API key request does not name exactly one organization or application
+	ErrInvalidAPIKeyOwner = errors.New("an API key must be owned by either an organization or an application")
+	// ErrInvalidAPIKeyAllowedIP is returned when an allowlist entry is neither an IP address nor a CIDR range
+	ErrInvalidAPIKeyAllowedIP = errors.New("invalid IP address or CIDR range")
+	// ErrInvalidAPIKey is returned when a key is unknown, revoked or expired, or its owner is gone or inactive
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	// ErrAPIKeyIPNotAllowed is returned when a valid key is used from an address outside its allowlist
+	ErrAPIKeyIPNotAllowed = errors.New("API key is not allowed from this address")
+)
+
+// APIKeyRequest issues an API key
+type APIKeyRequest struct {
+	Name               string   `json:"name" validate:"required,max=100"`
+	OrgID              *uint    `json:"org_id"`         // Owning organization; set either this or application_id
+	ApplicationID      *uint    `json:"application_id"` // Owning OAuth application; set either this or org_id
+	Scopes             []string `json:"scopes" validate:"required,min=1"`
+	AllowedIPs         []string `json:"allowed_ips"`                                                 // IP addresses and CIDR ranges; omit to allow any address
+	RateLimitPerMinute *int     `json:"rate_limit_per_minute" validate:"omitempty,min=1,max=100000"` // Omit for API_KEY_DEFAULT_RATE_PER_MINUTE
+	ExpiresInDays      *int     `json:"expires_in_days" validate:"omitempty,min=1,max=3650"`         // Omit for a key that does not expire
+}
+
+// APIKeyRotateRequest rotates an API key
+type APIKeyRotateRequest struct {
+	GracePeriodMinutes int `json:"grace_period_minutes" validate:"min=0,max=10080"` // How long the old key keeps working; 0 revokes it at once
+}
+
+// APIKeyService manages the API keys resource servers call miniauth with
+type APIKeyService struct {
+	db               *gorm.DB
+	defaultRateLimit int
+}
+
+// NewAPIKeyService creates a new API key service
+func NewAPIKeyService(db *gorm.DB) *APIKeyService {
+	defaultRateLimit := getEnvInt("API_KEY_DEFAULT_RATE_PER_MINUTE", 600)
+	if defaultRateLimit <= 0 {
+		defaultRateLimit = 600
+	}
+	return &APIKeyService{db: db, defaultRateLimit: defaultRateLimit}
+}
+
+// ListAPIKeys lists the API keys that have not been revoked, newest first, optionally only those
+// of an organization or application
+func (s *APIKeyService) ListAPIKeys(orgID, applicationID *uint) ([]database.APIKey, error) {
+	query := s.preloadOwner(s.db).Where("revoked_at IS NULL")
+	if orgID != nil {
+		query = query.Where("org_id = ?", *orgID)
+	}
+	if applicationID != nil {
+		query = query.Where("application_id = ?", *applicationID)
+	}
+
+	var keys []database.APIKey
+	err := query.Order("id DESC").Find(&keys).Error
+	return keys, err
+}
+
+// GetAPIKey returns an API key with its owner
+func (s *APIKeyService) GetAPIKey(id uint) (*database.APIKey, error) {
+	var key database.APIKey
+	if err := s.preloadOwner(s.db).First(&key, id).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (s *APIKeyService) preloadOwner(db *gorm.DB) *gorm.DB {
+	return db.Preload("Org").Preload("Application")
+}
+
+// IssueAPIKey creates an API key. The key is returned only here; just its hash is stored.
+func (s *APIKeyService) IssueAPIKey(actor AuditActor, req APIKeyRequest) (*database.APIKey, string, error) {
+	if (req.OrgID == nil) == (req.ApplicationID == nil) {
+		return nil, "", ErrInvalidAPIKeyOwner
+	}
+	if req.OrgID != nil {
+		if err := s.db.Select("id").First(&database.Org{}, *req.OrgID).Error; err != nil {
+			return nil, "", err
+		}
+	} else if err := s.db.Select("id").First(&database.OAuthApplication{}, *req.ApplicationID).Error; err != nil {
+		return nil, "", err
+	}
+
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		if !slices.Contains(APIKeyScopes, scope) {
+			return nil, "", fmt.Errorf("%w: %q", ErrInvalidAPIKeyScope, scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	allowedIPs, err := normalizeAllowedIPs(req.AllowedIPs)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &database.APIKey{
+		Name:               strings.TrimSpace(req.Name),
+		OrgID:              req.OrgID,
+		ApplicationID:      req.ApplicationID,
+		Scopes:             strings.Join(scopes, " "),
+		AllowedIPs:         strings.Join(allowedIPs, " "),
+		RateLimitPerMinute: s.defaultRateLimit,
+	}
+	if req.RateLimitPerMinute != nil {
+		key.RateLimitPerMinute = *req.RateLimitPerMinute
+	}
+	if req.ExpiresInDays != nil {
+		expiresAt := time.Now().AddDate(0, 0, *req.ExpiresInDays)
+		key.ExpiresAt = &expiresAt
+	}
+	if actor.UserID != nil {
+		key.CreatedByID = *actor.UserID
+	}
+
+	var secret string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if secret, err = createAPIKey(tx, key); err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, auditEntry{
+			Action:     AuditActionAPIKeyCreated,
+			TargetType: AuditTargetAPIKey,
+			TargetID:   auditID(key.ID),
+			OrgID:      key.OrgID,
+			Metadata: map[string]interface{}{
+				"name":           key.Name,
+				"prefix":         key.Prefix,
+				"application_id": key.ApplicationID,
+				"scopes":         scopes,
+			},
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	issued, err := s.GetAPIKey(key.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	return issued, secret, nil
+}
+
+// RotateAPIKey replaces an API key with a new one that has the same owner, scopes, allowlist,
+// rate limit and lifetime. The old key keeps working for the grace period, so resource servers
+// can switch over without downtime; without one it is revoked at once.
+func (s *APIKeyService) RotateAPIKey(actor AuditActor, id uint, req APIKeyRotateRequest) (*database.APIKey, string, error) {
+	var rotated *database.APIKey
+	var secret string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var old database.APIKey
+		if err := tx.Where("id = ? AND revoked_at IS NULL", id).First(&old).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		rotated = &database.APIKey{
+			Name:               old.Name,
+			OrgID:              old.OrgID,
+			ApplicationID:      old.ApplicationID,
+			Scopes:             old.Scopes,
+			AllowedIPs:         old.AllowedIPs,
+			RateLimitPerMinute: old.RateLimitPerMinute,
+			RotatedFromID:      &old.ID,
+			CreatedByID:        old.CreatedByID,
+		}
+		if old.ExpiresAt != nil {
+			expiresAt := now.Add(old.ExpiresAt.Sub(old.CreatedAt))
+			rotated.ExpiresAt = &expiresAt
+		}
+		if actor.UserID != nil {
+			rotated.CreatedByID = *actor.UserID
+		}
+
+		var err error
+		if secret, err = createAPIKey(tx, rotated); err != nil {
+			return err
+		}
+
+		if req.GracePeriodMinutes == 0 {
+			err = tx.Model(&old).Update("revoked_at", now).Error
+		} else if graceEnd := now.Add(time.Duration(req.GracePeriodMinutes) * time.Minute); old.ExpiresAt == nil || graceEnd.Before(*old.ExpiresAt) {
+			err = tx.Model(&old).Update("expires_at", graceEnd).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(tx, actor, auditEntry{
+			Action:     AuditActionAPIKeyRotated,
+			TargetType: AuditTargetAPIKey,
+			TargetID:   auditID(old.ID),
+			OrgID:      old.OrgID,
+			Metadata: map[string]interface{}{
+				"name":                 old.Name,
+				"prefix":               old.Prefix,
+				"new_key_id":           rotated.ID,
+				"new_prefix":           rotated.Prefix,
+				"grace_period_minutes": req.GracePeriodMinutes,
+			},
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	issued, err := s.GetAPIKey(rotated.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	return issued, secret, nil
+}
+
+// RevokeAPIKey revokes an API key
+func (s *APIKeyService) RevokeAPIKey(actor AuditActor, id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var key database.APIKey
+		if err := tx.Where("id = ? AND revoked_at IS NULL", id).First(&key).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&key).Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, actor, auditEntry{
+			Action:     AuditActionAPIKeyRevoked,
+			TargetType: AuditTargetAPIKey,
+			TargetID:   auditID(key.ID),
+			OrgID:      key.OrgID,
+			Metadata: map[string]interface{}{
+				"name":   key.Name,
+				"prefix": key.Prefix,
+			},
+		})
+	})
+}
+
+// Authenticate resolves an API key sent from ip to the key, with its owning application preloaded,
+// recording where the key was last used from
+func (s *APIKeyService) Authenticate(secret, ip string) (*database.APIKey, error) {
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var key database.APIKey
+	if err := s.db.Preload("Application").Where("key_hash = ? AND revoked_at IS NULL", hashToken(secret)).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	// Keys stop working while their organization is deleted or their application is disabled
+	ownerOrgID := key.OrgID
+	if key.ApplicationID != nil {
+		if key.Application == nil || !key.Application.Active {
+			return nil, ErrInvalidAPIKey
+		}
+		ownerOrgID = key.Application.OrgID
+	}
+	if ownerOrgID != nil {
+		if err := s.db.Select("id").First(&database.Org{}, *ownerOrgID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrInvalidAPIKey
+			}
+			return nil, err
+		}
+	}
+
+	if !apiKeyAllowsIP(key.AllowedIPs, ip) {
+		return nil, ErrAPIKeyIPNotAllowed
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyUsageInterval || key.LastUsedIP != ip {
+		if err := s.db.Model(&key).Updates(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": ip,
+		}).Error; err != nil {
+			log.Printf("failed to record use of API key %d: %v", key.ID, err)
+		}
+	}
+	return &key, nil
+}
+
+// createAPIKey generates the secret of key and stores it, returning the secret
+func createAPIKey(tx *gorm.DB, key *database.APIKey) (string, error) {
+	secret := apiKeyPrefix + generateSecureToken()
+	key.KeyHash = hashToken(secret)
+	key.Prefix = secret[:apiKeyPrefixLength]
+	if err := tx.Create(key).Error; err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// normalizeAllowedIPs validates allowlist entries, storing addresses and ranges in canonical form
+func normalizeAllowedIPs(entries []string) ([]string, error) {
+	allowed := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var normalized string
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidAPIKeyAllowedIP, entry)
+			}
+			normalized = prefix.Masked().String()
+		} else {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidAPIKeyAllowedIP, entry)
+			}
+			normalized = addr.Unmap().String()
+		}
+		if !slices.Contains(allowed, normalized) {
+			allowed = append(allowed, normalized)
+		}
+	}
+	return allowed, nil
+}
+
+// apiKeyAllowsIP reports whether ip is in the space-separated allowlist; an empty list allows any address
+func apiKeyAllowsIP(allowedIPs, ip string) bool {
+	entries := strings.Fields(allowedIPs)
+	if len(entries) == 0 {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") {
+			if prefix, err := netip.ParsePrefix(entry); err == nil && prefix.Contains(addr) {
+				return true
+			}
+		} else if entry == addr.String() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"errors"
+	"miniauth/database"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	db := newTestDB(t)
+	apiKeys := NewAPIKeyService(db)
+
+	org := &database.Org{Name: "Org", Slug: "org"}
+	mustCreate(t, db, org)
+	issue := func(t *testing.T, allowedIPs ...string) (*database.APIKey, string) {
+		t.Helper()
+		key, secret, err := apiKeys.IssueAPIKey(AuditActor{}, APIKeyRequest{
+			Name:       "Resource server",
+			OrgID:      &org.ID,
+			Scopes:     []string{APIKeyScopeTokensIntrospect},
+			AllowedIPs: allowedIPs,
+		})
+		if err != nil {
+			t.Fatalf("IssueAPIKey: %v", err)
+		}
+		return key, secret
+	}
+	rotate := func(t *testing.T, id uint, graceMinutes int) string {
+		t.Helper()
+		_, secret, err := apiKeys.RotateAPIKey(AuditActor{}, id, APIKeyRotateRequest{GracePeriodMinutes: graceMinutes})
+		if err != nil {
+			t.Fatalf("RotateAPIKey: %v", err)
+		}
+		return secret
+	}
+
+	_, valid := issue(t)
+	expiredKey, expired := issue(t)
+	if err := db.Model(expiredKey).Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatalf("expire key: %v", err)
+	}
+	revokedKey, revoked := issue(t)
+	if err := apiKeys.RevokeAPIKey(AuditActor{}, revokedKey.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	_, restricted := issue(t, "192.0.2.10", "198.51.100.0/24")
+
+	graceKey, inGrace := issue(t)
+	rotatedFromGrace := rotate(t, graceKey.ID, 60)
+	replacedKey, replaced := issue(t)
+	rotate(t, replacedKey.ID, 0)
+
+	tests := []struct {
+		name    string
+		secret  string
+		ip      string
+		wantErr error
+	}{
+		{"valid key", valid, "203.0.113.1", nil},
+		{"unknown key", apiKeyPrefix + "unknown", "203.0.113.1", ErrInvalidAPIKey},
+		{"not an API key", "secret", "203.0.113.1", ErrInvalidAPIKey},
+		{"expired key", expired, "203.0.113.1", ErrInvalidAPIKey},
+		{"revoked key", revoked, "203.0.113.1", ErrInvalidAPIKey},
+		{"allowed address", restricted, "192.0.2.10", nil},
+		{"address in an allowed range", restricted, "198.51.100.7", nil},
+		{"IPv4-mapped allowed address", restricted, "::ffff:192.0.2.10", nil},
+		{"denied address", restricted, "192.0.2.11", ErrAPIKeyIPNotAllowed},
+		{"unparseable address", restricted, "unknown", ErrAPIKeyIPNotAllowed},
+		{"old key in its grace period", inGrace, "203.0.113.1", nil},
+		{"key rotated from one in its grace period", rotatedFromGrace, "203.0.113.1", nil},
+		{"old key rotated without a grace period", replaced, "203.0.113.1", ErrInvalidAPIKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := apiKeys.Authenticate(tt.secret, tt.ip)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (key.LastUsedIP != tt.ip || key.LastUsedAt == nil) {
+				t.Errorf("last use = %v from %q, want now from %q", key.LastUsedAt, key.LastUsedIP, tt.ip)
+			}
+		})
+	}
+
+	// The grace period ends the old key's lifetime
+	if err := db.Model(graceKey).Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatalf("end grace period: %v", err)
+	}
+	if _, err := apiKeys.Authenticate(inGrace, "203.0.113.1"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Authenticate after the grace period = %v, want ErrInvalidAPIKey", err)
+	}
+	if _, err := apiKeys.Authenticate(rotatedFromGrace, "203.0.113.1"); err != nil {
+		t.Errorf("Authenticate the rotated key after the grace period: %v", err)
+	}
+}
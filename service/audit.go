@@ -0,0 +1,364 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"miniauth/database"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Audit event actions
+const (
+	AuditActionLogin               = "user.login"
+	AuditActionUserDeleted         = "user.deleted"
+	AuditActionUserRoleChanged     = "user.role_changed"
+	AuditActionPasswordChanged     = "user.password_changed"
+	AuditActionPasswordReset       = "user.password_reset"
+	AuditActionClientSecretRead    = "oauth.client_secret_read"
+	AuditActionTokenGranted        = "oauth.token_granted"
+	AuditActionSAMLAssertionIssued = "saml.assertion_issued"
+
+	AuditActionPersonalAccessTokenCreated = "personal_access_token.created"
+	AuditActionPersonalAccessTokenRevoked = "personal_access_token.revoked"
+
+	AuditActionApplicationCreated              = "oauth.application_created"
+	AuditActionServiceAccountCreated           = "service_account.created"
+	AuditActionServiceAccountUpdated           = "service_account.updated"
+	AuditActionServiceAccountDeleted           = "service_account.deleted"
+	AuditActionServiceAccountCredentialCreated = "service_account.credential_created"
+	AuditActionServiceAccountCredentialRevoked = "service_account.credential_revoked"
+
+	AuditActionAPIKeyCreated = "api_key.created"
+	AuditActionAPIKeyRotated = "api_key.rotated"
+	AuditActionAPIKeyRevoked = "api_key.revoked"
+)
+
+// Audit event outcomes
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// Audit actor types
+const (
+	AuditActorUser           = "user"
+	AuditActorClient         = "client"
+	AuditActorServiceAccount = "service_account"
+	AuditActorAnonymous      = "anonymous"
+)
+
+// Audit target types
+const (
+	AuditTargetUser                = "user"
+	AuditTargetApplication         = "oauth_application"
+	AuditTargetSAMLServiceProvider = "saml_service_provider"
+	AuditTargetPersonalAccessToken = "personal_access_token"
+	AuditTargetServiceAccount      = "service_account"
+	AuditTargetAPIKey              = "api_key"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+	// auditExportBatchSize is the number of events loaded at once while exporting
+	auditExportBatchSize = 1000
+)
+
+// ErrInvalidAuditCursor is returned when a pagination cursor cannot be decoded
+var ErrInvalidAuditCursor = errors.New("invalid cursor")
+
+// AuditActor identifies who performed an audited action and from where
+type AuditActor struct {
+	UserID           *uint  // Signed-in user, if any
+	ClientID         string // OAuth client acting on its own behalf, if any
+	ServiceAccountID *uint  // Service account calling an internal endpoint, if any
+	IP               string
+	UserAgent        string
+}
+
+// auditEntry describes an audited action
+type auditEntry struct {
+	Action     string
+	TargetType string
+	TargetID   string
+	OrgID      *uint
+	Outcome    string
+	Metadata   map[string]interface{}
+}
+
+// recordAudit appends an audit event, within the caller's transaction when tx is one
+func recordAudit(tx *gorm.DB, actor AuditActor, entry auditEntry) error {
+	event := &database.AuditEvent{
+		ActorType:        AuditActorAnonymous,
+		ActorID:          actor.UserID,
+		ClientID:         actor.ClientID,
+		ServiceAccountID: actor.ServiceAccountID,
+		Action:           entry.Action,
+		TargetType:       entry.TargetType,
+		TargetID:         entry.TargetID,
+		OrgID:            entry.OrgID,
+		IP:               actor.IP,
+		UserAgent:        actor.UserAgent,
+		Outcome:          entry.Outcome,
+	}
+	switch {
+	case actor.UserID != nil:
+		event.ActorType = AuditActorUser
+	case actor.ClientID != "":
+		event.ActorType = AuditActorClient
+	case actor.ServiceAccountID != nil:
+		event.ActorType = AuditActorServiceAccount
+	}
+	if event.Outcome == "" {
+		event.Outcome = AuditOutcomeSuccess
+	}
+
+	if len(entry.Metadata) > 0 {
+		metadata, err := json.Marshal(entry.Metadata)
+		if err != nil {
+			return err
+		}
+		event.Metadata = string(metadata)
+	}
+
+	return tx.Create(event).Error
+}
+
+// recordAuditOrLog appends an audit event for an action that has no transaction to join, such as
+// a failed attempt. Write errors are logged rather than returned so they do not mask the outcome.
+func recordAuditOrLog(db *gorm.DB, actor AuditActor, entry auditEntry) {
+	if err := recordAudit(db, actor, entry); err != nil {
+		log.Printf("failed to record audit event %s: %v", entry.Action, err)
+	}
+}
+
+// auditID formats a numeric ID as an audit target ID
+func auditID(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// AuditQuery filters audit events. Action matches exactly, or by prefix when it ends with "*".
+type AuditQuery struct {
+	ActorID          *uint
+	ClientID         string
+	ServiceAccountID *uint
+	Action           string
+	TargetType       string
+	TargetID         string
+	OrgID            *uint
+	Outcome          string
+	Since            *time.Time
+	Until            *time.Time
+	Cursor           string // Opaque cursor returned with the previous page
+	Limit            int
+}
+
+// AuditEventRecord is the external representation of an audit event, used by the API,
+// exports and audit sinks
+type AuditEventRecord struct {
+	ID               uint            `json:"id"`
+	CreatedAt        string          `json:"created_at"`
+	ActorType        string          `json:"actor_type"`
+	ActorID          *uint           `json:"actor_id,omitempty"`
+	ClientID         string          `json:"client_id,omitempty"`
+	ServiceAccountID *uint           `json:"service_account_id,omitempty"`
+	Action           string          `json:"action"`
+	TargetType       string          `json:"target_type,omitempty"`
+	TargetID         string          `json:"target_id,omitempty"`
+	OrgID            *uint           `json:"org_id,omitempty"`
+	IP               string          `json:"ip,omitempty"`
+	UserAgent        string          `json:"user_agent,omitempty"`
+	Outcome          string          `json:"outcome"`
+	Metadata         json.RawMessage `json:"metadata,omitempty" swaggertype:"object"`
+}
+
+// NewAuditEventRecord converts a stored audit event to its external representation
+func NewAuditEventRecord(event database.AuditEvent) AuditEventRecord {
+	record := AuditEventRecord{
+		ID:               event.ID,
+		CreatedAt:        event.CreatedAt.UTC().Format(time.RFC3339),
+		ActorType:        event.ActorType,
+		ActorID:          event.ActorID,
+		ClientID:         event.ClientID,
+		ServiceAccountID: event.ServiceAccountID,
+		Action:           event.Action,
+		TargetType:       event.TargetType,
+		TargetID:         event.TargetID,
+		OrgID:            event.OrgID,
+		IP:               event.IP,
+		UserAgent:        event.UserAgent,
+		Outcome:          event.Outcome,
+	}
+	if event.Metadata != "" {
+		record.Metadata = json.RawMessage(event.Metadata)
+	}
+	return record
+}
+
+type AuditService struct {
+	db        *gorm.DB
+	retention time.Duration
+	sinks     []AuditSink
+}
+
+func NewAuditService(db *gorm.DB) *AuditService {
+	return &AuditService{
+		db:        db,
+		retention: getEnvDuration("AUDIT_RETENTION", 365*24*time.Hour),
+		sinks:     NewAuditSinksFromEnv(),
+	}
+}
+
+// Retention returns how long audit events are kept; zero keeps them forever
+func (s *AuditService) Retention() time.Duration {
+	return s.retention
+}
+
+// ListEvents returns audit events matching the query, newest first, and the cursor of the next
+// page. The cursor is empty on the last page.
+func (s *AuditService) ListEvents(query AuditQuery) ([]database.AuditEvent, string, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultAuditPageSize
+	}
+	if limit > maxAuditPageSize {
+		limit = maxAuditPageSize
+	}
+
+	db := s.db.Model(&database.AuditEvent{})
+	if query.Cursor != "" {
+		before, err := decodeAuditCursor(query.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		db = db.Where("id < ?", before)
+	}
+	db = applyAuditFilters(db, query)
+
+	// Fetch one extra event to know whether another page follows
+	var events []database.AuditEvent
+	if err := db.Order("id DESC").Limit(limit + 1).Find(&events).Error; err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(events) > limit {
+		events = events[:limit]
+		next = encodeAuditCursor(events[limit-1].ID)
+	}
+	return events, next, nil
+}
+
+// ExportEvents calls fn with every event matching the query, oldest first. Cursor and Limit are ignored.
+func (s *AuditService) ExportEvents(query AuditQuery, fn func(AuditEventRecord) error) error {
+	var lastID uint
+	for {
+		var events []database.AuditEvent
+		db := applyAuditFilters(s.db.Model(&database.AuditEvent{}), query)
+		if err := db.Where("id > ?", lastID).Order("id ASC").Limit(auditExportBatchSize).Find(&events).Error; err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			if err := fn(NewAuditEventRecord(event)); err != nil {
+				return err
+			}
+		}
+		if len(events) < auditExportBatchSize {
+			return nil
+		}
+		lastID = events[len(events)-1].ID
+	}
+}
+
+// applyAuditFilters restricts db to the events matching the query, except for its cursor
+func applyAuditFilters(db *gorm.DB, query AuditQuery) *gorm.DB {
+	if query.ActorID != nil {
+		db = db.Where("actor_id = ?", *query.ActorID)
+	}
+	if query.ClientID != "" {
+		db = db.Where("client_id = ?", query.ClientID)
+	}
+	if query.ServiceAccountID != nil {
+		db = db.Where("service_account_id = ?", *query.ServiceAccountID)
+	}
+	if prefix, ok := strings.CutSuffix(query.Action, "*"); ok {
+		db = db.Where("SUBSTR(action, 1, ?) = ?", len(prefix), prefix)
+	} else if query.Action != "" {
+		db = db.Where("action = ?", query.Action)
+	}
+	if query.TargetType != "" {
+		db = db.Where("target_type = ?", query.TargetType)
+	}
+	if query.TargetID != "" {
+		db = db.Where("target_id = ?", query.TargetID)
+	}
+	if query.OrgID != nil {
+		db = db.Where("org_id = ?", *query.OrgID)
+	}
+	if query.Outcome != "" {
+		db = db.Where("outcome = ?", query.Outcome)
+	}
+	if query.Since != nil {
+		db = db.Where("created_at >= ?", *query.Since)
+	}
+	if query.Until != nil {
+		db = db.Where("created_at < ?", *query.Until)
+	}
+	return db
+}
+
+// PurgeExpiredEvents deletes the audit events older than the retention period
+func (s *AuditService) PurgeExpiredEvents() (int64, error) {
+	if s.retention <= 0 {
+		return 0, nil
+	}
+
+	result := s.db.Where("created_at < ?", time.Now().Add(-s.retention)).Delete(&database.AuditEvent{})
+	return result.RowsAffected, result.Error
+}
+
+// StartRetentionPurger periodically deletes audit events older than the retention period
+func (s *AuditService) StartRetentionPurger(interval time.Duration) {
+	if s.retention <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			purged, err := s.PurgeExpiredEvents()
+			if err != nil {
+				log.Printf("failed to purge audit events: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("purged %d expired audit events", purged)
+			}
+		}
+	}()
+}
+
+func encodeAuditCursor(id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(auditID(id)))
+}
+
+func decodeAuditCursor(cursor string) (uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidAuditCursor
+	}
+	id, err := strconv.ParseUint(string(raw), 10, 32)
+	if err != nil {
+		return 0, ErrInvalidAuditCursor
+	}
+	return uint(id), nil
+}
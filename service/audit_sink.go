@@ -0,0 +1,402 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"miniauth/database"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// auditSinkBatchSize is the largest number of events handed to a sink at once
+	auditSinkBatchSize = 500
+	// auditStreamSettleDelay holds back recent events so that transactions that allocated a
+	// lower event ID have committed before a sink moves past it
+	auditStreamSettleDelay = 5 * time.Second
+)
+
+// AuditSink receives audit events streamed out of miniauth. Write is called with events in
+// ascending ID order and retried with the same events until it succeeds.
+type AuditSink interface {
+	Name() string
+	Write(events []AuditEventRecord) error
+}
+
+// NewAuditSinksFromEnv creates the audit sinks listed in the comma-separated AUDIT_SINKS
+// environment variable
+//
+// Supported sinks:
+//   - file: appends JSON Lines to AUDIT_FILE_PATH, rotated at AUDIT_FILE_MAX_SIZE_MB
+//   - syslog: sends RFC 5424 messages to the local socket AUDIT_SYSLOG_SOCKET
+//   - webhook: posts batches of events to AUDIT_WEBHOOK_URL
+func NewAuditSinksFromEnv() []AuditSink {
+	var sinks []AuditSink
+	for _, name := range strings.Split(getEnv("AUDIT_SINKS", ""), ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "file":
+			sinks = append(sinks, &FileAuditSink{
+				Path:       getEnv("AUDIT_FILE_PATH", "audit.jsonl"),
+				MaxSize:    int64(getEnvInt("AUDIT_FILE_MAX_SIZE_MB", 100)) << 20,
+				MaxBackups: getEnvInt("AUDIT_FILE_MAX_BACKUPS", 5),
+			})
+		case "syslog":
+			sinks = append(sinks, &SyslogAuditSink{
+				Network: getEnv("AUDIT_SYSLOG_NETWORK", "unixgram"),
+				Address: getEnv("AUDIT_SYSLOG_SOCKET", "/dev/log"),
+				AppName: getEnv("AUDIT_SYSLOG_APP_NAME", "miniauth"),
+			})
+		case "webhook":
+			url := getEnv("AUDIT_WEBHOOK_URL", "")
+			if url == "" {
+				log.Printf("audit sink webhook is enabled but AUDIT_WEBHOOK_URL is not set, skipping it")
+				continue
+			}
+			sinks = append(sinks, &WebhookAuditSink{
+				URL:           url,
+				Authorization: getEnv("AUDIT_WEBHOOK_AUTHORIZATION", ""),
+				BatchSize:     getEnvInt("AUDIT_WEBHOOK_BATCH_SIZE", 100),
+				MaxRetries:    getEnvInt("AUDIT_WEBHOOK_MAX_RETRIES", 5),
+				Client:        &http.Client{Timeout: getEnvDuration("AUDIT_WEBHOOK_TIMEOUT", 10*time.Second)},
+			})
+		default:
+			log.Printf("unknown audit sink %q, skipping it", name)
+		}
+	}
+	return sinks
+}
+
+// StartSinkStreamer periodically delivers new audit events to every configured sink. Each sink
+// keeps its own cursor, so a failing sink neither blocks the others nor loses events.
+func (s *AuditService) StartSinkStreamer(interval time.Duration) {
+	if len(s.sinks) == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			for _, sink := range s.sinks {
+				if err := s.streamToSink(sink); err != nil {
+					log.Printf("failed to stream audit events to sink %s: %v", sink.Name(), err)
+				}
+			}
+		}
+	}()
+}
+
+// streamToSink delivers the events the sink has not received yet, in batches
+func (s *AuditService) streamToSink(sink AuditSink) error {
+	cursor := database.AuditSinkCursor{Sink: sink.Name()}
+	if err := s.db.Where("sink = ?", cursor.Sink).FirstOrCreate(&cursor).Error; err != nil {
+		return err
+	}
+
+	for {
+		var events []database.AuditEvent
+		if err := s.db.Where("id > ? AND created_at <= ?", cursor.LastEventID, time.Now().Add(-auditStreamSettleDelay)).
+			Order("id ASC").Limit(auditSinkBatchSize).Find(&events).Error; err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		records := make([]AuditEventRecord, 0, len(events))
+		for _, event := range events {
+			records = append(records, NewAuditEventRecord(event))
+		}
+		if err := sink.Write(records); err != nil {
+			return err
+		}
+
+		cursor.LastEventID = events[len(events)-1].ID
+		if err := s.db.Save(&cursor).Error; err != nil {
+			return err
+		}
+		if len(events) < auditSinkBatchSize {
+			return nil
+		}
+	}
+}
+
+// FileAuditSink appends events as JSON Lines to a file. The file is renamed to Path.1 once it
+// reaches MaxSize bytes, shifting older files up to Path.<MaxBackups>.
+type FileAuditSink struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func (f *FileAuditSink) Name() string {
+	return "file"
+}
+
+// Write appends the events to the file, rotating it first when they would not fit
+func (f *FileAuditSink) Write(events []AuditEventRecord) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	if f.MaxSize > 0 && f.size > 0 && f.size+int64(buf.Len()) > f.MaxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := f.file.Write(buf.Bytes())
+	f.size += int64(n)
+	return err
+}
+
+func (f *FileAuditSink) open() error {
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *FileAuditSink) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	if f.MaxBackups <= 0 {
+		if err := os.Remove(f.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return f.open()
+	}
+
+	for n := f.MaxBackups - 1; n >= 1; n-- {
+		from := fmt.Sprintf("%s.%d", f.Path, n)
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", f.Path, n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(f.Path, f.Path+".1"); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// SyslogAuditSink sends each event as an RFC 5424 message with facility authpriv to a local
+// syslog socket. The message ID is the action and the message body is the JSON encoded event.
+type SyslogAuditSink struct {
+	Network string // unixgram or unix
+	Address string
+	AppName string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+const (
+	syslogFacilityAuthpriv = 10
+	syslogSeverityWarning  = 4
+	syslogSeverityNotice   = 5
+)
+
+func (s *SyslogAuditSink) Name() string {
+	return "syslog"
+}
+
+// Write sends the events, reconnecting once if the socket was closed by the syslog daemon
+func (s *SyslogAuditSink) Write(events []AuditEventRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hostname, _ := os.Hostname()
+	for _, event := range events {
+		message, err := s.format(hostname, event)
+		if err != nil {
+			return err
+		}
+		if err := s.send(message); err != nil {
+			s.close()
+			if err := s.send(message); err != nil {
+				s.close()
+				return fmt.Errorf("failed to write to syslog: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// format renders an RFC 5424 message; stream sockets use octet counting framing (RFC 6587)
+func (s *SyslogAuditSink) format(hostname string, event AuditEventRecord) ([]byte, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	severity := syslogSeverityNotice
+	if event.Outcome == AuditOutcomeFailure {
+		severity = syslogSeverityWarning
+	}
+	message := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		syslogFacilityAuthpriv*8+severity,
+		event.CreatedAt,
+		syslogField(hostname, 255),
+		syslogField(s.AppName, 48),
+		os.Getpid(),
+		syslogField(event.Action, 32),
+		body,
+	)
+
+	if s.Network == "unix" {
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+	return []byte(message), nil
+}
+
+func (s *SyslogAuditSink) send(message []byte) error {
+	if s.conn == nil {
+		conn, err := net.Dial(s.Network, s.Address)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	_, err := s.conn.Write(message)
+	return err
+}
+
+func (s *SyslogAuditSink) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// syslogField returns a header field of printable ASCII without spaces, or "-" when it is empty
+func syslogField(value string, maxLength int) string {
+	var b strings.Builder
+	for _, r := range value {
+		if r > 32 && r < 127 {
+			b.WriteRune(r)
+		}
+	}
+	field := b.String()
+	if field == "" {
+		return "-"
+	}
+	if len(field) > maxLength {
+		field = field[:maxLength]
+	}
+	return field
+}
+
+// WebhookAuditSink posts events as {"events": [...]} JSON documents of at most BatchSize events.
+// Requests failing with a network error, 429 or a 5xx status are retried with exponential backoff.
+type WebhookAuditSink struct {
+	URL           string
+	Authorization string // Optional Authorization header value
+	BatchSize     int
+	MaxRetries    int
+	Client        *http.Client
+}
+
+func (w *WebhookAuditSink) Name() string {
+	return "webhook"
+}
+
+// Write posts the events in batches
+func (w *WebhookAuditSink) Write(events []AuditEventRecord) error {
+	batchSize := w.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(events)
+	}
+
+	for start := 0; start < len(events); start += batchSize {
+		end := min(start+batchSize, len(events))
+		body, err := json.Marshal(map[string]interface{}{"events": events[start:end]})
+		if err != nil {
+			return err
+		}
+		if err := w.post(body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WebhookAuditSink) post(body []byte) error {
+	backoff := time.Second
+	var lastErr error
+	for attempt := 0; attempt <= w.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff = min(backoff*2, 30*time.Second)
+		}
+
+		retry, err := w.postOnce(body)
+		if err == nil {
+			return nil
+		}
+		if !retry {
+			return err
+		}
+		lastErr = err
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", w.MaxRetries+1, lastErr)
+}
+
+// postOnce sends one request and reports whether a failure is worth retrying
+func (w *WebhookAuditSink) postOnce(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Authorization != "" {
+		req.Header.Set("Authorization", w.Authorization)
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"miniauth/database"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Authentication sources recorded on users
+const (
+	AuthSourceLocal = "local"
+	AuthSourceLDAP  = "ldap"
+)
+
+var (
+	// ErrInvalidCredentials is returned when the password does not match the account
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrAuthenticatorNotApplicable is returned by an authenticator that does not know the account,
+	// letting the next authenticator of the chain try
+	ErrAuthenticatorNotApplicable = errors.New("authenticator does not handle this account")
+)
+
+// Authenticator verifies a login and password against one source of accounts and returns the
+// matching user, provisioning it when the source is external. On ErrInvalidCredentials the user
+// may be returned as well so the failure can be attributed.
+type Authenticator interface {
+	Name() string
+	Authenticate(login, password string) (*database.User, error)
+}
+
+// NewAuthenticatorsFromEnv builds the authenticator chain listed in AUTH_PROVIDERS, e.g. "local,ldap"
+func NewAuthenticatorsFromEnv(users *UserService) []Authenticator {
+	var authenticators []Authenticator
+	for _, name := range strings.Split(getEnv("AUTH_PROVIDERS", AuthSourceLocal), ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case AuthSourceLocal:
+			authenticators = append(authenticators, &LocalAuthenticator{db: users.db})
+		case AuthSourceLDAP:
+			config, err := LoadLDAPConfig()
+			if err != nil {
+				log.Printf("ldap authenticator is misconfigured, skipping it: %v", err)
+				continue
+			}
+			authenticators = append(authenticators, NewLDAPAuthenticator(config, users))
+		default:
+			log.Printf("unknown auth provider %q, skipping it", name)
+		}
+	}
+	return authenticators
+}
+
+// LocalAuthenticator checks passwords against the hashes stored for local users
+type LocalAuthenticator struct {
+	db *gorm.DB
+}
+
+func (a *LocalAuthenticator) Name() string {
+	return AuthSourceLocal
+}
+
+func (a *LocalAuthenticator) Authenticate(login, password string) (*database.User, error) {
+	var user database.User
+	if err := a.db.Where("email = ?", login).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAuthenticatorNotApplicable
+		}
+		return nil, err
+	}
+	if user.AuthSource != AuthSourceLocal {
+		return nil, ErrAuthenticatorNotApplicable
+	}
+
+	if !user.CheckPassword(password) {
+		return &user, ErrInvalidCredentials
+	}
+	return &user, nil
+}
+
+// authenticate runs the authenticator chain until one handles the login
+func (s *UserService) authenticate(login, password string) (*database.User, string, error) {
+	for _, authenticator := range s.authenticators {
+		user, err := authenticator.Authenticate(login, password)
+		if errors.Is(err, ErrAuthenticatorNotApplicable) {
+			continue
+		}
+		return user, authenticator.Name(), err
+	}
+	return nil, "", ErrAuthenticatorNotApplicable
+}
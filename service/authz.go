@@ -0,0 +1,223 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"miniauth/database"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// AuthzRequest asks whether a subject may perform an action on a resource.
+//
+// Subjects have the form "user:<id>". Resources are "org:<slug>" or "system".
+// Actions are permission names such as "members:invite", "role:<role>" to require
+// at least a built-in organization role such as "role:admin", or "team:<slug>" to require
+// membership of a team, directly or through one of its child teams.
+type AuthzRequest struct {
+	Subject  string `json:"subject" validate:"required"`
+	Action   string `json:"action" validate:"required"`
+	Resource string `json:"resource" validate:"required"`
+}
+
+// AuthzDecision is the outcome of an authorization check
+type AuthzDecision struct {
+	Subject  string `json:"subject"`
+	Action   string `json:"action"`
+	Resource string `json:"resource"`
+	Allowed  bool   `json:"allowed"`
+	Reason   string `json:"reason"`
+}
+
+// AuthzCaller identifies the downstream service asking for authorization checks
+type AuthzCaller struct {
+	ClientID string // OAuth client calling with its credentials; empty for service accounts
+}
+
+// AuthzService answers authorization checks for downstream services from organization
+// membership, built-in roles, custom roles and teams
+type AuthzService struct {
+	db         *gorm.DB
+	org        *OrgService
+	permission *PermissionService
+}
+
+func NewAuthzService(db *gorm.DB, org *OrgService, permission *PermissionService) *AuthzService {
+	return &AuthzService{db: db, org: org, permission: permission}
+}
+
+// Check evaluates a single authorization request. Errors are only returned for
+// failures to evaluate, not for denied requests.
+func (s *AuthzService) Check(caller AuthzCaller, req AuthzRequest) (*AuthzDecision, error) {
+	callerOrgID, err := s.callerOrgID(caller)
+	if err != nil {
+		return nil, err
+	}
+	return s.check(callerOrgID, req)
+}
+
+// CheckBatch evaluates several authorization requests
+func (s *AuthzService) CheckBatch(caller AuthzCaller, reqs []AuthzRequest) ([]AuthzDecision, error) {
+	callerOrgID, err := s.callerOrgID(caller)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := make([]AuthzDecision, 0, len(reqs))
+	for _, req := range reqs {
+		decision, err := s.check(callerOrgID, req)
+		if err != nil {
+			return nil, err
+		}
+		decisions = append(decisions, *decision)
+	}
+	return decisions, nil
+}
+
+// callerOrgID returns the organization a caller is limited to. Applications owned by an
+// organization can be created by any of its members, so they only get answers about it.
+func (s *AuthzService) callerOrgID(caller AuthzCaller) (*uint, error) {
+	if caller.ClientID == "" {
+		return nil, nil
+	}
+
+	var app database.OAuthApplication
+	if err := s.db.Where("client_id = ?", caller.ClientID).First(&app).Error; err != nil {
+		return nil, err
+	}
+	return app.OrgID, nil
+}
+
+// check evaluates an authorization request for a caller limited to callerOrgID, when set
+func (s *AuthzService) check(callerOrgID *uint, req AuthzRequest) (*AuthzDecision, error) {
+	decision := &AuthzDecision{Subject: req.Subject, Action: req.Action, Resource: req.Resource}
+
+	user, reason, err := s.resolveSubject(req.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return deny(decision, reason), nil
+	}
+
+	resourceType, resourceID, _ := strings.Cut(req.Resource, ":")
+	switch resourceType {
+	case PermissionScopeSystem:
+		if callerOrgID != nil {
+			return deny(decision, "caller is limited to its organization"), nil
+		}
+		return s.checkSystem(decision, user), nil
+	case PermissionScopeOrg:
+		return s.checkOrg(decision, user, resourceID, callerOrgID)
+	}
+
+	return deny(decision, "unsupported resource type"), nil
+}
+
+func (s *AuthzService) checkSystem(decision *AuthzDecision, user *database.User) *AuthzDecision {
+	if containsPermission(builtinSystemRolePermissions[user.Role], decision.Action) {
+		return allow(decision, fmt.Sprintf("granted by system role %s", user.Role))
+	}
+	return deny(decision, fmt.Sprintf("not granted by system role %s", user.Role))
+}
+
+func (s *AuthzService) checkOrg(decision *AuthzDecision, user *database.User, slug string, callerOrgID *uint) (*AuthzDecision, error) {
+	org, err := s.org.GetOrgBySlug(slug)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return deny(decision, "organization not found"), nil
+		}
+		return nil, err
+	}
+	// Reported like a missing organization, so callers cannot probe other organizations
+	if callerOrgID != nil && *callerOrgID != org.ID {
+		return deny(decision, "organization not found"), nil
+	}
+
+	member, err := s.org.GetOrgMemberByUserID(org.ID, user.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return deny(decision, "subject is not a member of the organization"), nil
+		}
+		return nil, err
+	}
+
+	// Role checks compare against the built-in role hierarchy
+	if role, ok := strings.CutPrefix(decision.Action, "role:"); ok {
+		required := database.OrgMemberRole(role)
+		if _, known := orgRoleRank[required]; !known {
+			return deny(decision, "unknown role"), nil
+		}
+		if OrgRoleAtLeast(member.Role, required) {
+			return allow(decision, fmt.Sprintf("subject has role %s", member.Role)), nil
+		}
+		return deny(decision, fmt.Sprintf("subject has role %s, %s required", member.Role, required)), nil
+	}
+
+	if teamSlug, ok := strings.CutPrefix(decision.Action, "team:"); ok {
+		teams, err := userTeams(s.db, org.ID, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, team := range teams {
+			if team.Slug == teamSlug {
+				return allow(decision, fmt.Sprintf("subject is a member of team %s", team.Slug)), nil
+			}
+		}
+		return deny(decision, "subject is not a member of the team"), nil
+	}
+
+	if !isOrgPermission(decision.Action) {
+		return deny(decision, "unknown action"), nil
+	}
+
+	permissions, err := s.permission.memberPermissions(member)
+	if err != nil {
+		return nil, err
+	}
+	if containsPermission(permissions, decision.Action) {
+		return allow(decision, "granted by the subject's role, custom role or teams"), nil
+	}
+	return deny(decision, fmt.Sprintf("not granted by role %s, a custom role or teams", member.Role)), nil
+}
+
+// resolveSubject loads the user referenced by a subject. A nil user with a reason is returned
+// when the subject is malformed, does not exist or is disabled.
+func (s *AuthzService) resolveSubject(subject string) (*database.User, string, error) {
+	subjectType, subjectID, _ := strings.Cut(subject, ":")
+	if subjectType != "user" {
+		return nil, "unsupported subject type", nil
+	}
+
+	id, err := strconv.ParseUint(subjectID, 10, 32)
+	if err != nil {
+		return nil, "invalid subject", nil
+	}
+
+	var user database.User
+	if err := s.db.First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "subject not found", nil
+		}
+		return nil, "", err
+	}
+	if user.Disabled {
+		return nil, "subject disabled", nil
+	}
+
+	return &user, "", nil
+}
+
+func allow(decision *AuthzDecision, reason string) *AuthzDecision {
+	decision.Allowed = true
+	decision.Reason = reason
+	return decision
+}
+
+func deny(decision *AuthzDecision, reason string) *AuthzDecision {
+	decision.Allowed = false
+	decision.Reason = reason
+	return decision
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"miniauth/database"
+	"strconv"
+	"testing"
+)
+
+func TestAuthzCheckCallerScope(t *testing.T) {
+	db := newTestDB(t)
+	authz := NewAuthzService(db, NewOrgService(db, nil), NewPermissionService(db))
+
+	user := &database.User{Username: "alice", Email: "alice@example.com", PasswordHash: "x"}
+	mustCreate(t, db, user)
+	orgA := &database.Org{Name: "Org A", Slug: "org-a"}
+	mustCreate(t, db, orgA)
+	orgB := &database.Org{Name: "Org B", Slug: "org-b"}
+	mustCreate(t, db, orgB)
+	mustCreate(t, db, &database.OrgMember{OrgID: orgA.ID, UserID: user.ID, Role: database.OrgMemberRoleOwner})
+	mustCreate(t, db, &database.OrgMember{OrgID: orgB.ID, UserID: user.ID, Role: database.OrgMemberRoleOwner})
+	mustCreate(t, db, &database.OAuthApplication{Name: "Org A app", ClientID: "org-a-app", ClientSecret: "secret", Active: true, OrgID: &orgA.ID})
+	mustCreate(t, db, &database.OAuthApplication{Name: "System app", ClientID: "system-app", ClientSecret: "secret", Active: true})
+
+	subject := "user:" + strconv.FormatUint(uint64(user.ID), 10)
+	tests := []struct {
+		name     string
+		caller   AuthzCaller
+		resource string
+		allowed  bool
+		reason   string
+	}{
+		{"org app about its own org", AuthzCaller{ClientID: "org-a-app"}, "org:org-a", true, "subject has role owner"},
+		{"org app about another org", AuthzCaller{ClientID: "org-a-app"}, "org:org-b", false, "organization not found"},
+		{"org app about the system", AuthzCaller{ClientID: "org-a-app"}, "system", false, "caller is limited to its organization"},
+		{"system app about any org", AuthzCaller{ClientID: "system-app"}, "org:org-b", true, "subject has role owner"},
+		{"service account about any org", AuthzCaller{}, "org:org-b", true, "subject has role owner"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, err := authz.Check(tt.caller, AuthzRequest{Subject: subject, Action: "role:member", Resource: tt.resource})
+			if err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+			if decision.Allowed != tt.allowed || decision.Reason != tt.reason {
+				t.Errorf("got allowed=%v reason=%q, want allowed=%v reason=%q", decision.Allowed, decision.Reason, tt.allowed, tt.reason)
+			}
+		})
+	}
+
+	decisions, err := authz.CheckBatch(AuthzCaller{ClientID: "org-a-app"}, []AuthzRequest{
+		{Subject: subject, Action: "role:member", Resource: "org:org-a"},
+		{Subject: subject, Action: "role:member", Resource: "org:org-b"},
+	})
+	if err != nil {
+		t.Fatalf("CheckBatch: %v", err)
+	}
+	if !decisions[0].Allowed || decisions[1].Allowed {
+		t.Errorf("batch from org A app: got allowed %v and %v, want true and false", decisions[0].Allowed, decisions[1].Allowed)
+	}
+}
+
+func TestAuthzCheckSubject(t *testing.T) {
+	db := newTestDB(t)
+	authz := NewAuthzService(db, NewOrgService(db, nil), NewPermissionService(db))
+
+	org := &database.Org{Name: "Org", Slug: "org"}
+	mustCreate(t, db, org)
+	active := &database.User{Username: "active", Email: "active@example.com", PasswordHash: "x"}
+	mustCreate(t, db, active)
+	disabled := &database.User{Username: "disabled", Email: "disabled@example.com", PasswordHash: "x", Disabled: true}
+	mustCreate(t, db, disabled)
+	for _, user := range []*database.User{active, disabled} {
+		mustCreate(t, db, &database.OrgMember{OrgID: org.ID, UserID: user.ID, Role: database.OrgMemberRoleOwner})
+	}
+
+	tests := []struct {
+		name     string
+		subject  string
+		resource string
+		allowed  bool
+		reason   string
+	}{
+		{"active member", "user:" + strconv.FormatUint(uint64(active.ID), 10), "org:org", true, "subject has role owner"},
+		{"disabled member", "user:" + strconv.FormatUint(uint64(disabled.ID), 10), "org:org", false, "subject disabled"},
+		{"disabled user on the system", "user:" + strconv.FormatUint(uint64(disabled.ID), 10), "system", false, "subject disabled"},
+		{"unknown user", "user:999", "org:org", false, "subject not found"},
+		{"malformed id", "user:abc", "org:org", false, "invalid subject"},
+		{"unsupported type", "group:1", "org:org", false, "unsupported subject type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, err := authz.Check(AuthzCaller{}, AuthzRequest{Subject: tt.subject, Action: "role:member", Resource: tt.resource})
+			if err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+			if decision.Allowed != tt.allowed || decision.Reason != tt.reason {
+				t.Errorf("got allowed=%v reason=%q, want allowed=%v reason=%q", decision.Allowed, decision.Reason, tt.allowed, tt.reason)
+			}
+		})
+	}
+}
+
+func TestAuthzCheckPermissions(t *testing.T) {
+	db := newTestDB(t)
+	authz := NewAuthzService(db, NewOrgService(db, nil), NewPermissionService(db))
+
+	org := &database.Org{Name: "Org", Slug: "org"}
+	mustCreate(t, db, org)
+	user := &database.User{Username: "alice", Email: "alice@example.com", PasswordHash: "x"}
+	mustCreate(t, db, user)
+	role := &database.OrgRole{OrgID: org.ID, Name: "Developer", Permissions: PermissionAppsManage}
+	mustCreate(t, db, role)
+	mustCreate(t, db, &database.OrgMember{OrgID: org.ID, UserID: user.ID, Role: database.OrgMemberRoleMember, OrgRoleID: &role.ID})
+	parent := &database.Team{OrgID: org.ID, Name: "Platform", Slug: "platform", Permissions: PermissionDomainsManage}
+	mustCreate(t, db, parent)
+	team := &database.Team{OrgID: org.ID, ParentID: &parent.ID, Name: "SRE", Slug: "sre"}
+	mustCreate(t, db, team)
+	mustCreate(t, db, &database.TeamMember{TeamID: team.ID, UserID: user.ID})
+
+	const granted = "granted by the subject's role, custom role or teams"
+	tests := []struct {
+		action  string
+		allowed bool
+		reason  string
+	}{
+		{PermissionAppsRead, true, granted},
+		{PermissionAppsManage, true, granted},
+		{PermissionDomainsManage, true, granted},
+		{PermissionOrgDelete, false, "not granted by role member, a custom role or teams"},
+		{"team:platform", true, "subject is a member of team platform"},
+		{"team:other", false, "subject is not a member of the team"},
+		{"payments:write", false, "unknown action"},
+	}
+	subject := "user:" + strconv.FormatUint(uint64(user.ID), 10)
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			decision, err := authz.Check(AuthzCaller{}, AuthzRequest{Subject: subject, Action: tt.action, Resource: "org:org"})
+			if err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+			if decision.Allowed != tt.allowed || decision.Reason != tt.reason {
+				t.Errorf("got allowed=%v reason=%q, want allowed=%v reason=%q", decision.Allowed, decision.Reason, tt.allowed, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package service
+
+import "log"
+
+// backgroundQueue runs tasks on a fixed number of workers. Tasks submitted while its buffer is
+// full are dropped, so a flood of requests or a slow mailer cannot pile up goroutines.
+type backgroundQueue struct {
+	name  string
+	tasks chan func() error
+}
+
+func newBackgroundQueue(name string, size int) *backgroundQueue {
+	if size < 1 {
+		size = 1
+	}
+	return &backgroundQueue{name: name, tasks: make(chan func() error, size)}
+}
+
+// start runs the workers, which log the errors of failed tasks
+func (q *backgroundQueue) start(workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for task := range q.tasks {
+				if err := task(); err != nil {
+					log.Printf("%s: %v", q.name, err)
+				}
+			}
+		}()
+	}
+}
+
+// submit queues a task, and reports false when the queue is full and the task was dropped
+func (q *backgroundQueue) submit(task func() error) bool {
+	select {
+	case q.tasks <- task:
+		return true
+	default:
+		log.Printf("%s queue is full, dropping a task", q.name)
+		return false
+	}
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestBackgroundQueue(t *testing.T) {
+	queue := newBackgroundQueue("test", 2)
+
+	var wg sync.WaitGroup
+	ran := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		task := func() error {
+			defer wg.Done()
+			ran <- i
+			return errors.New("logged, not fatal")
+		}
+		wg.Add(1)
+		if !queue.submit(task) {
+			if i != 2 {
+				t.Errorf("task %d was dropped, want it queued", i)
+			}
+			wg.Done()
+		} else if i == 2 {
+			t.Error("task 2 was queued beyond the queue size")
+		}
+	}
+
+	queue.start(1)
+	wg.Wait()
+	close(ran)
+	var got []int
+	for i := range ran {
+		got = append(got, i)
+	}
+	if len(got) != 2 || got[0] != 0 || got[1] != 1 {
+		t.Errorf("ran tasks %v, want [0 1]", got)
+	}
+}
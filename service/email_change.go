@@ -0,0 +1,192 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"miniauth/database"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	emailChangeTokenPurpose       = "email_change"
+	emailChangeRevertTokenPurpose = "email_change_revert"
+)
+
+// ErrEmailInUse is returned when an email address already belongs to another account
+var ErrEmailInUse = errors.New("email address is already in use")
+
+// ErrEmailUnchanged is returned when the requested email address equals the current one
+var ErrEmailUnchanged = errors.New("new email address is the same as the current one")
+
+// EnsureEmailAvailable checks that no account other than exceptUserID uses the email address
+func (s *UserService) EnsureEmailAvailable(email string, exceptUserID uint) error {
+	return ensureEmailAvailable(s.db, email, exceptUserID)
+}
+
+func ensureEmailAvailable(tx *gorm.DB, email string, exceptUserID uint) error {
+	var count int64
+	err := tx.Unscoped().Model(&database.User{}).
+		Where("email = ? AND id <> ?", email, exceptUserID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrEmailInUse
+	}
+	return nil
+}
+
+// RequestEmailChange sends a confirmation link to the new address.
+// The email is not changed until the link is confirmed.
+func (s *UserService) RequestEmailChange(user *database.User, newEmail string) error {
+	if newEmail == user.Email {
+		return ErrEmailUnchanged
+	}
+	if err := s.EnsureEmailAvailable(newEmail, user.ID); err != nil {
+		return err
+	}
+
+	subject := strconv.FormatUint(uint64(user.ID), 10)
+	data := map[string]string{"old_email": user.Email, "new_email": newEmail}
+
+	confirmToken, err := s.signer.Sign(emailChangeTokenPurpose, subject, data, s.emailVerificationTTL)
+	if err != nil {
+		return fmt.Errorf("failed to create email change token: %w", err)
+	}
+
+	confirmLink := fmt.Sprintf("%s/api/email/change/confirm?token=%s", s.baseURL, url.QueryEscape(confirmToken))
+	return s.mailer.Send(MailMessage{
+		To:      []string{newEmail},
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hello %s,\n\nPlease confirm that you want to use this address for your account by opening the link below:\n\n%s\n\nThe link expires in %s.\n",
+			user.Username, confirmLink, s.emailVerificationTTL),
+	})
+}
+
+// ConfirmEmailChange applies a pending email change using the token sent to the new address,
+// and notifies the previous address with a link to revert the change
+func (s *UserService) ConfirmEmailChange(token string) (*database.User, error) {
+	claims, err := s.signer.Verify(token, emailChangeTokenPurpose)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userFromTokenSubject(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	oldEmail, newEmail := claims.Data["old_email"], claims.Data["new_email"]
+	if user.Email == newEmail {
+		return user, nil
+	}
+	// The account's email changed since the request was made
+	if user.Email != oldEmail {
+		return nil, ErrInvalidSignedToken
+	}
+
+	if err := s.changeEmail(user, newEmail); err != nil {
+		return nil, err
+	}
+
+	revertToken, err := s.signer.Sign(emailChangeRevertTokenPurpose, claims.Subject, claims.Data, s.emailChangeRevertTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create email change revert token: %w", err)
+	}
+
+	revertLink := fmt.Sprintf("%s/api/email/change/revert?token=%s", s.baseURL, url.QueryEscape(revertToken))
+	if err := s.mailer.Send(MailMessage{
+		To:      []string{oldEmail},
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("Hello %s,\n\nThe email address of your account was changed from %s to %s.\n\nIf you did not make this change, open the link below to restore your previous address and sign out all sessions:\n\n%s\n\nThe link expires in %s.\n",
+			user.Username, oldEmail, newEmail, revertLink, s.emailChangeRevertTTL),
+	}); err != nil {
+		// The change is already applied; report the failed notification without failing the request
+		log.Printf("failed to send email change notification to user %d: %v", user.ID, err)
+	}
+
+	return user, nil
+}
+
+// RevertEmailChange restores the previous email address and revokes all sessions and OAuth tokens
+func (s *UserService) RevertEmailChange(token string) (*database.User, error) {
+	claims, err := s.signer.Verify(token, emailChangeRevertTokenPurpose)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userFromTokenSubject(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	oldEmail, newEmail := claims.Data["old_email"], claims.Data["new_email"]
+	if user.Email != newEmail {
+		return nil, ErrInvalidSignedToken
+	}
+
+	if err := s.EnsureEmailAvailable(oldEmail, user.ID); err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"email":             oldEmail,
+			"email_verified":    true,
+			"email_verified_at": now,
+		}).Error; err != nil {
+			return err
+		}
+
+		return revokeUserCredentials(tx, user.ID, "email_change_reverted")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// changeEmail sets a new, verified email address after checking it is still available
+func (s *UserService) changeEmail(user *database.User, newEmail string) error {
+	if err := s.EnsureEmailAvailable(newEmail, user.ID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := s.db.Model(user).Updates(map[string]interface{}{
+		"email":             newEmail,
+		"email_verified":    true,
+		"email_verified_at": now,
+	}).Error; err != nil {
+		// A concurrent change may still hit the unique index
+		if s.EnsureEmailAvailable(newEmail, user.ID) == ErrEmailInUse {
+			return ErrEmailInUse
+		}
+		return err
+	}
+
+	return nil
+}
+
+// userFromTokenSubject loads the user referenced by the subject of a signed token
+func (s *UserService) userFromTokenSubject(claims *SignedTokenClaims) (*database.User, error) {
+	userID, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignedToken
+	}
+
+	user, err := s.GetUserByID(uint(userID))
+	if err != nil {
+		return nil, ErrInvalidSignedToken
+	}
+
+	return user, nil
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"miniauth/database"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Email verification policies
+const (
+	// EmailVerificationOptional never blocks unverified users
+	EmailVerificationOptional = "optional"
+	// EmailVerificationRequiredForOAuth blocks OAuth authorization until the email is verified
+	EmailVerificationRequiredForOAuth = "oauth"
+	// EmailVerificationRequiredForLogin blocks login (and therefore OAuth) until the email is verified
+	EmailVerificationRequiredForLogin = "login"
+)
+
+const emailVerificationTokenPurpose = "email_verification"
+
+// ErrEmailNotVerified is returned when the verification policy blocks an unverified user
+var ErrEmailNotVerified = errors.New("email address not verified")
+
+// isEmailVerificationPolicy reports whether policy is one of the email verification policies
+func isEmailVerificationPolicy(policy string) bool {
+	switch policy {
+	case EmailVerificationOptional, EmailVerificationRequiredForOAuth, EmailVerificationRequiredForLogin:
+		return true
+	}
+	return false
+}
+
+// LoadEmailVerificationPolicy reads EMAIL_VERIFICATION_POLICY from the environment
+func LoadEmailVerificationPolicy() (string, error) {
+	policy := getEnv("EMAIL_VERIFICATION_POLICY", EmailVerificationOptional)
+	if !isEmailVerificationPolicy(policy) {
+		return "", fmt.Errorf("invalid EMAIL_VERIFICATION_POLICY %q: must be optional, oauth or login", policy)
+	}
+	return policy, nil
+}
+
+// EmailVerificationPolicy returns the configured email verification policy
+func (s *UserService) EmailVerificationPolicy() string {
+	return s.emailVerificationPolicy
+}
+
+// SendVerificationEmail sends a signed verification link to the user's email address
+func (s *UserService) SendVerificationEmail(user *database.User) error {
+	if user.EmailVerified {
+		return nil
+	}
+
+	token, err := s.signer.Sign(emailVerificationTokenPurpose, strconv.FormatUint(uint64(user.ID), 10),
+		map[string]string{"email": user.Email}, s.emailVerificationTTL)
+	if err != nil {
+		return fmt.Errorf("failed to create verification token: %w", err)
+	}
+
+	link := fmt.Sprintf("%s/api/email/verify?token=%s", s.baseURL, url.QueryEscape(token))
+	body := fmt.Sprintf("Hello %s,\n\nPlease confirm your email address by opening the link below:\n\n%s\n\nThe link expires in %s. If you did not create an account, you can ignore this email.\n",
+		user.Username, link, s.emailVerificationTTL)
+
+	return s.mailer.Send(MailMessage{
+		To:      []string{user.Email},
+		Subject: "Verify your email address",
+		Body:    body,
+	})
+}
+
+// ResendVerificationEmail sends a new verification email to the given address, at most once per
+// cooldown for each account. Unknown or already verified addresses are ignored so the caller
+// cannot probe for accounts.
+func (s *UserService) ResendVerificationEmail(email string) error {
+	user, err := s.GetUserByEmail(email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if user.EmailVerified {
+		return nil
+	}
+
+	// Claim the cooldown atomically, so concurrent requests send a single email
+	now := time.Now()
+	result := s.db.Model(&database.User{}).
+		Where("id = ? AND (verification_sent_at IS NULL OR verification_sent_at <= ?)", user.ID, now.Add(-s.verificationCooldown)).
+		Update("verification_sent_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	return s.SendVerificationEmail(user)
+}
+
+// QueueVerificationEmail handles a resend request in the background, so neither the response nor
+// its timing reveals which addresses exist. Requests are dropped while the mail queue is full.
+func (s *UserService) QueueVerificationEmail(email string) {
+	s.mailQueue.submit(func() error {
+		if err := s.ResendVerificationEmail(email); err != nil {
+			return fmt.Errorf("failed to handle verification email request: %w", err)
+		}
+		return nil
+	})
+}
+
+// VerifyEmail validates a verification token and marks the user's email as verified
+func (s *UserService) VerifyEmail(token string) (*database.User, error) {
+	claims, err := s.signer.Verify(token, emailVerificationTokenPurpose)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userFromTokenSubject(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	// The token is bound to the address it was sent to
+	if user.Email != claims.Data["email"] {
+		return nil, ErrInvalidSignedToken
+	}
+
+	if user.EmailVerified {
+		return user, nil
+	}
+
+	now := time.Now()
+	user.EmailVerified = true
+	user.EmailVerifiedAt = &now
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"email_verified":    true,
+			"email_verified_at": now,
+		}).Error; err != nil {
+			return err
+		}
+
+		// Automatic domain membership waits for a verified address
+		return applyDomainJoinPolicies(tx, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// EnsureEmailVerifiedForLogin returns ErrEmailNotVerified if the policy requires verification before login
+func (s *UserService) EnsureEmailVerifiedForLogin(user *database.User) error {
+	if s.emailVerificationPolicy == EmailVerificationRequiredForLogin && !user.EmailVerified {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+// EnsureEmailVerifiedForOAuth returns ErrEmailNotVerified if the policy requires verification before OAuth authorization
+func (s *UserService) EnsureEmailVerifiedForOAuth(user *database.User) error {
+	switch s.emailVerificationPolicy {
+	case EmailVerificationRequiredForLogin, EmailVerificationRequiredForOAuth:
+		if !user.EmailVerified {
+			return ErrEmailNotVerified
+		}
+	}
+	return nil
+}
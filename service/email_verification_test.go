@@ -0,0 +1,170 @@
+package service
+
+import (
+	"errors"
+	"miniauth/database"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var verificationLinkToken = regexp.MustCompile(`/api/email/verify\?token=(\S+)`)
+
+// mailedToken returns the unescaped token of the verification link in a message
+func mailedToken(t *testing.T, msg MailMessage) string {
+	t.Helper()
+	match := verificationLinkToken.FindStringSubmatch(msg.Body)
+	if match == nil {
+		t.Fatalf("no verification link in %q", msg.Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("unescape token: %v", err)
+	}
+	return token
+}
+
+func TestVerifyEmail(t *testing.T) {
+	db := newTestDB(t)
+	mailer := &fakeMailer{}
+	users := NewUserService(db, mailer, &TokenSigner{secret: []byte("test secret")})
+
+	user := &database.User{Username: "alice", Email: "alice@example.com", PasswordHash: "x"}
+	mustCreate(t, db, user)
+
+	if err := users.SendVerificationEmail(user); err != nil {
+		t.Fatalf("SendVerificationEmail: %v", err)
+	}
+	if len(mailer.messages) != 1 || mailer.messages[0].To[0] != "alice@example.com" {
+		t.Fatalf("got messages %+v, want one to alice@example.com", mailer.messages)
+	}
+	staleToken := mailedToken(t, mailer.messages[0])
+
+	// A link stops working once the address it was sent to changes
+	if err := db.Model(user).Update("email", "alice@example.org").Error; err != nil {
+		t.Fatalf("change email: %v", err)
+	}
+	if _, err := users.VerifyEmail(staleToken); !errors.Is(err, ErrInvalidSignedToken) {
+		t.Fatalf("VerifyEmail with a link to the old address = %v, want ErrInvalidSignedToken", err)
+	}
+
+	if err := users.SendVerificationEmail(user); err != nil {
+		t.Fatalf("SendVerificationEmail: %v", err)
+	}
+	if _, err := users.VerifyEmail(mailedToken(t, mailer.messages[1])); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+
+	var stored database.User
+	if err := db.First(&stored, user.ID).Error; err != nil {
+		t.Fatalf("load user: %v", err)
+	}
+	if !stored.EmailVerified || stored.EmailVerifiedAt == nil {
+		t.Errorf("email not marked verified: %+v", stored)
+	}
+
+	// Verified users are not mailed again
+	if err := users.SendVerificationEmail(&stored); err != nil {
+		t.Fatalf("SendVerificationEmail: %v", err)
+	}
+	if len(mailer.messages) != 2 {
+		t.Errorf("got %d messages, want no new one for a verified address", len(mailer.messages))
+	}
+	if _, err := users.VerifyEmail("not a token"); !errors.Is(err, ErrInvalidSignedToken) {
+		t.Errorf("VerifyEmail with a malformed token = %v, want ErrInvalidSignedToken", err)
+	}
+}
+
+func TestResendVerificationEmailCooldown(t *testing.T) {
+	db := newTestDB(t)
+	mailer := &fakeMailer{}
+	users := NewUserService(db, mailer, &TokenSigner{secret: []byte("test secret")})
+	users.verificationCooldown = time.Hour
+
+	user := &database.User{Username: "alice", Email: "alice@example.com", PasswordHash: "x"}
+	mustCreate(t, db, user)
+	verified := &database.User{Username: "bob", Email: "bob@example.com", PasswordHash: "x", EmailVerified: true}
+	mustCreate(t, db, verified)
+
+	tests := []struct {
+		name      string
+		email     string
+		prepare   func()
+		wantMails int
+	}{
+		{name: "unknown address", email: "carol@example.com", wantMails: 0},
+		{name: "verified address", email: "bob@example.com", wantMails: 0},
+		{name: "first request", email: "alice@example.com", wantMails: 1},
+		{name: "within the cooldown", email: "alice@example.com", wantMails: 1},
+		{name: "after the cooldown", email: "alice@example.com", wantMails: 2, prepare: func() {
+			if err := db.Model(user).Update("verification_sent_at", time.Now().Add(-2*time.Hour)).Error; err != nil {
+				t.Fatalf("age cooldown: %v", err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		if tt.prepare != nil {
+			tt.prepare()
+		}
+		if err := users.ResendVerificationEmail(tt.email); err != nil {
+			t.Fatalf("%s: ResendVerificationEmail: %v", tt.name, err)
+		}
+		if len(mailer.messages) != tt.wantMails {
+			t.Errorf("%s: got %d messages, want %d", tt.name, len(mailer.messages), tt.wantMails)
+		}
+	}
+}
+
+func TestEmailVerificationPolicy(t *testing.T) {
+	tests := []struct {
+		policy      string
+		verified    bool
+		blocksLogin bool
+		blocksOAuth bool
+	}{
+		{EmailVerificationOptional, false, false, false},
+		{EmailVerificationRequiredForOAuth, false, false, true},
+		{EmailVerificationRequiredForLogin, false, true, true},
+		{EmailVerificationRequiredForLogin, true, false, false},
+	}
+	for _, tt := range tests {
+		users := &UserService{emailVerificationPolicy: tt.policy}
+		user := &database.User{EmailVerified: tt.verified}
+
+		if err := users.EnsureEmailVerifiedForLogin(user); (err != nil) != tt.blocksLogin {
+			t.Errorf("policy %s, verified %v: EnsureEmailVerifiedForLogin = %v", tt.policy, tt.verified, err)
+		}
+		if err := users.EnsureEmailVerifiedForOAuth(user); (err != nil) != tt.blocksOAuth {
+			t.Errorf("policy %s, verified %v: EnsureEmailVerifiedForOAuth = %v", tt.policy, tt.verified, err)
+		}
+	}
+}
+
+func TestLoadEmailVerificationPolicy(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: EmailVerificationOptional},
+		{value: "oauth", want: EmailVerificationRequiredForOAuth},
+		{value: "login", want: EmailVerificationRequiredForLogin},
+		{value: "always", wantErr: true},
+		{value: "Login", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("EMAIL_VERIFICATION_POLICY", tt.value)
+		got, err := LoadEmailVerificationPolicy()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("LoadEmailVerificationPolicy() with %q = %q, %v, want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewServiceManagerRejectsUnknownEmailVerificationPolicy(t *testing.T) {
+	t.Setenv("EMAIL_VERIFICATION_POLICY", "always")
+	if _, err := NewServiceManager(newTestDB(t)); err == nil {
+		t.Error("NewServiceManager accepted an unknown email verification policy")
+	}
+}
@@ -0,0 +1,636 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"miniauth/database"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuthSourceFederated marks users created by signing in with an upstream identity provider
+const AuthSourceFederated = "federated"
+
+const (
+	federatedLoginPurpose = "federated_login"
+	federatedLoginTTL     = 10 * time.Minute
+	// DefaultFederatedRedirect is where users land after signing in when no redirect was requested
+	DefaultFederatedRedirect = "/profile"
+)
+
+// Codes of federated sign in failures, passed to the frontend as the federated_error query parameter
+const (
+	FederationErrorInvalidState     = "invalid_state"
+	FederationErrorProvider         = "provider_error"
+	FederationErrorAccountExists    = "account_exists"
+	FederationErrorSignupDisabled   = "signup_disabled"
+	FederationErrorIdentityInUse    = "identity_in_use"
+	FederationErrorAccountDisabled  = "account_disabled"
+	FederationErrorEmailNotVerified = "email_not_verified"
+)
+
+var (
+	// ErrInvalidIdentityProvider is returned when a provider configuration is incomplete
+	ErrInvalidIdentityProvider = errors.New("invalid identity provider configuration")
+	// ErrLastIdentity is returned when unlinking the only way a federated user can sign in
+	ErrLastIdentity = errors.New("set a password before unlinking the last linked identity")
+	// ErrIdentityProviderExists is returned when another identity provider uses the slug
+	ErrIdentityProviderExists = errors.New("an identity provider with this slug already exists")
+)
+
+// FederationError is a failed federated sign in or link, with the code shown to the user
+type FederationError struct {
+	Code string
+	Err  error
+}
+
+func (e *FederationError) Error() string {
+	if e.Err != nil {
+		return e.Code + ": " + e.Err.Error()
+	}
+	return e.Code
+}
+
+func (e *FederationError) Unwrap() error {
+	return e.Err
+}
+
+// IdentityProviderRequest creates or updates an identity provider. Endpoints of OIDC providers are
+// discovered from the issuer when omitted; OAuth 2.0 providers need them all.
+type IdentityProviderRequest struct {
+	Slug             string                        `json:"slug" validate:"required,max=50"`
+	Name             string                        `json:"name" validate:"required,max=100"`
+	Type             database.IdentityProviderType `json:"type" validate:"required,oneof=oidc oauth2"`
+	ClientID         string                        `json:"client_id" validate:"required"`
+	ClientSecret     string                        `json:"client_secret"` // Kept when empty on update
+	Issuer           string                        `json:"issuer"`
+	AuthorizationURL string                        `json:"authorization_url"`
+	TokenURL         string                        `json:"token_url"`
+	UserInfoURL      string                        `json:"userinfo_url"`
+	EmailsURL        string                        `json:"emails_url"`
+	Scopes           string                        `json:"scopes"`
+	SubjectClaim     string                        `json:"subject_claim"`
+	EmailClaim       string                        `json:"email_claim"`
+	UsernameClaim    string                        `json:"username_claim"`
+	TrustEmail       bool                          `json:"trust_email"`
+	AllowSignup      *bool                         `json:"allow_signup"`
+	Enabled          *bool                         `json:"enabled"`
+}
+
+// FederatedLogin is a started sign in: the user is sent to AuthorizationURL, and State is kept in
+// a cookie until the provider redirects back
+type FederatedLogin struct {
+	AuthorizationURL string
+	State            string
+}
+
+// FederatedLoginResult is a completed sign in or link
+type FederatedLoginResult struct {
+	User     *database.User
+	Redirect string
+	Linked   bool // The identity was linked to the signed-in user rather than signing in
+}
+
+type FederationService struct {
+	db      *gorm.DB
+	users   *UserService
+	signer  *TokenSigner
+	client  *http.Client
+	baseURL string
+
+	mu          sync.Mutex
+	discoveries map[string]cachedDiscovery
+	jwks        map[string]cachedJWKS
+}
+
+func NewFederationService(db *gorm.DB, users *UserService, signer *TokenSigner) *FederationService {
+	return &FederationService{
+		db:          db,
+		users:       users,
+		signer:      signer,
+		client:      &http.Client{Timeout: getEnvDuration("FEDERATION_HTTP_TIMEOUT", 10*time.Second)},
+		baseURL:     strings.TrimRight(getEnv("APP_BASE_URL", "http://localhost:8080"), "/"),
+		discoveries: map[string]cachedDiscovery{},
+		jwks:        map[string]cachedJWKS{},
+	}
+}
+
+// ListProviders returns all identity providers
+func (s *FederationService) ListProviders() ([]database.IdentityProvider, error) {
+	var providers []database.IdentityProvider
+	err := s.db.Order("id").Find(&providers).Error
+	return providers, err
+}
+
+// ListEnabledProviders returns the identity providers users can sign in with
+func (s *FederationService) ListEnabledProviders() ([]database.IdentityProvider, error) {
+	var providers []database.IdentityProvider
+	err := s.db.Where("enabled = ?", true).Order("name").Find(&providers).Error
+	return providers, err
+}
+
+// GetProvider retrieves an identity provider by ID
+func (s *FederationService) GetProvider(id uint) (*database.IdentityProvider, error) {
+	var provider database.IdentityProvider
+	if err := s.db.First(&provider, id).Error; err != nil {
+		return nil, err
+	}
+	return &provider, nil
+}
+
+// GetEnabledProvider retrieves an enabled identity provider by slug
+func (s *FederationService) GetEnabledProvider(slug string) (*database.IdentityProvider, error) {
+	var provider database.IdentityProvider
+	if err := s.db.Where("slug = ? AND enabled = ?", slug, true).First(&provider).Error; err != nil {
+		return nil, err
+	}
+	return &provider, nil
+}
+
+// CreateProvider creates an identity provider
+func (s *FederationService) CreateProvider(req IdentityProviderRequest) (*database.IdentityProvider, error) {
+	provider := &database.IdentityProvider{}
+	if err := applyIdentityProviderRequest(provider, req); err != nil {
+		return nil, err
+	}
+	if provider.ClientSecret == "" {
+		return nil, fmt.Errorf("%w: client_secret is required", ErrInvalidIdentityProvider)
+	}
+	if err := s.ensureSlugAvailable(provider.Slug, 0); err != nil {
+		return nil, err
+	}
+
+	allowSignup, enabled := provider.AllowSignup, provider.Enabled
+	if err := s.db.Create(provider).Error; err != nil {
+		return nil, err
+	}
+	// Create replaces false zero values with the column defaults
+	updates := map[string]interface{}{}
+	if !allowSignup {
+		updates["allow_signup"] = false
+	}
+	if !enabled {
+		updates["enabled"] = false
+	}
+	if len(updates) > 0 {
+		if err := s.db.Model(provider).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+	return provider, nil
+}
+
+// UpdateProvider updates an identity provider, keeping its client secret when none is given
+func (s *FederationService) UpdateProvider(id uint, req IdentityProviderRequest) (*database.IdentityProvider, error) {
+	provider, err := s.GetProvider(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyIdentityProviderRequest(provider, req); err != nil {
+		return nil, err
+	}
+	if err := s.ensureSlugAvailable(provider.Slug, provider.ID); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(provider).Error; err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// DeleteProvider deletes an identity provider and the identities linked through it
+func (s *FederationService) DeleteProvider(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&database.IdentityProvider{}, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("provider_id = ?", id).Delete(&database.LinkedIdentity{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&database.IdentityProvider{}, id).Error
+	})
+}
+
+func (s *FederationService) ensureSlugAvailable(slug string, exceptID uint) error {
+	var count int64
+	if err := s.db.Model(&database.IdentityProvider{}).Where("slug = ? AND id <> ?", slug, exceptID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrIdentityProviderExists
+	}
+	return nil
+}
+
+func applyIdentityProviderRequest(provider *database.IdentityProvider, req IdentityProviderRequest) error {
+	slug := NormalizeOrgSlug(req.Slug)
+	if slug == "" {
+		return fmt.Errorf("%w: invalid slug", ErrInvalidIdentityProvider)
+	}
+	for _, endpoint := range []string{req.Issuer, req.AuthorizationURL, req.TokenURL, req.UserInfoURL, req.EmailsURL} {
+		if endpoint == "" {
+			continue
+		}
+		if parsed, err := url.Parse(endpoint); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return fmt.Errorf("%w: %s is not an absolute http or https URL", ErrInvalidIdentityProvider, endpoint)
+		}
+	}
+
+	scopes, subjectClaim, emailClaim, usernameClaim := req.Scopes, req.SubjectClaim, req.EmailClaim, req.UsernameClaim
+	switch req.Type {
+	case database.IdentityProviderTypeOIDC:
+		if req.Issuer == "" {
+			return fmt.Errorf("%w: OIDC providers need an issuer", ErrInvalidIdentityProvider)
+		}
+		scopes = firstNonEmpty(scopes, "openid email profile")
+		subjectClaim = firstNonEmpty(subjectClaim, "sub")
+		usernameClaim = firstNonEmpty(usernameClaim, "preferred_username")
+	case database.IdentityProviderTypeOAuth2:
+		if req.AuthorizationURL == "" || req.TokenURL == "" || req.UserInfoURL == "" {
+			return fmt.Errorf("%w: OAuth 2.0 providers need authorization, token and user info URLs", ErrInvalidIdentityProvider)
+		}
+		subjectClaim = firstNonEmpty(subjectClaim, "id")
+		usernameClaim = firstNonEmpty(usernameClaim, "login")
+	default:
+		return fmt.Errorf("%w: unknown type %s", ErrInvalidIdentityProvider, req.Type)
+	}
+
+	provider.Slug = slug
+	provider.Name = req.Name
+	provider.Type = req.Type
+	provider.ClientID = req.ClientID
+	if req.ClientSecret != "" {
+		provider.ClientSecret = req.ClientSecret
+	}
+	provider.Issuer = strings.TrimRight(req.Issuer, "/")
+	provider.AuthorizationURL = req.AuthorizationURL
+	provider.TokenURL = req.TokenURL
+	provider.UserInfoURL = req.UserInfoURL
+	provider.EmailsURL = req.EmailsURL
+	provider.Scopes = scopes
+	provider.SubjectClaim = subjectClaim
+	provider.EmailClaim = firstNonEmpty(emailClaim, "email")
+	provider.UsernameClaim = usernameClaim
+	provider.TrustEmail = req.TrustEmail
+	if provider.ID == 0 {
+		provider.AllowSignup, provider.Enabled = true, true
+	}
+	if req.AllowSignup != nil {
+		provider.AllowSignup = *req.AllowSignup
+	}
+	if req.Enabled != nil {
+		provider.Enabled = *req.Enabled
+	}
+	return nil
+}
+
+func firstNonEmpty(value, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
+
+// CallbackURL returns the redirect URI to register at a provider
+func (s *FederationService) CallbackURL(slug string) string {
+	return s.baseURL + "/api/auth/providers/" + slug + "/callback"
+}
+
+// StartLogin prepares the authorization request of a sign in, or of linking the provider to the
+// user linkUserID when it is not zero. The redirect is where the user lands afterwards and must be
+// a path on this site.
+func (s *FederationService) StartLogin(slug, redirect string, linkUserID uint) (*FederatedLogin, error) {
+	provider, err := s.GetEnabledProvider(slug)
+	if err != nil {
+		return nil, err
+	}
+	authorizationURL, _, _, err := s.providerEndpoints(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	state, nonce, verifier := generateSecureToken(), generateSecureToken(), generateSecureToken()
+	data := map[string]string{
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"redirect": SafeFederatedRedirect(redirect),
+	}
+	if linkUserID != 0 {
+		data["link_user_id"] = strconv.FormatUint(uint64(linkUserID), 10)
+	}
+	cookie, err := s.signer.Sign(federatedLoginPurpose, provider.Slug, data, federatedLoginTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.ClientID},
+		"redirect_uri":          {s.CallbackURL(provider.Slug)},
+		"scope":                 {provider.Scopes},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if provider.Type == database.IdentityProviderTypeOIDC {
+		query.Set("nonce", nonce)
+	}
+	separator := "?"
+	if strings.Contains(authorizationURL, "?") {
+		separator = "&"
+	}
+	return &FederatedLogin{AuthorizationURL: authorizationURL + separator + query.Encode(), State: cookie}, nil
+}
+
+// SafeFederatedRedirect returns the redirect when it is a path on this site, and the default otherwise,
+// so sign in cannot be used as an open redirect
+func SafeFederatedRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		return DefaultFederatedRedirect
+	}
+	return redirect
+}
+
+// LoginRedirect returns the redirect stored in the state cookie of a sign in, for reporting failures
+func (s *FederationService) LoginRedirect(stateCookie string) string {
+	claims, err := s.signer.Verify(stateCookie, federatedLoginPurpose)
+	if err != nil {
+		return DefaultFederatedRedirect
+	}
+	return SafeFederatedRedirect(claims.Data["redirect"])
+}
+
+// IsLinkState reports whether the state cookie belongs to linking an identity rather than signing in
+func (s *FederationService) IsLinkState(stateCookie string) bool {
+	claims, err := s.signer.Verify(stateCookie, federatedLoginPurpose)
+	return err == nil && claims.Data["link_user_id"] != ""
+}
+
+// CompleteLogin handles the redirect back from the provider: it checks the state, redeems the code,
+// and signs in, links or provisions the user of the upstream account. sessionUserID is the signed-in
+// user, if any, which must be the user who started a link.
+func (s *FederationService) CompleteLogin(actor AuditActor, slug, code, state, stateCookie string, sessionUserID uint) (*FederatedLoginResult, error) {
+	claims, err := s.signer.Verify(stateCookie, federatedLoginPurpose)
+	if err != nil || claims.Subject != slug || state == "" ||
+		subtle.ConstantTimeCompare([]byte(claims.Data["state"]), []byte(state)) != 1 {
+		return nil, &FederationError{Code: FederationErrorInvalidState}
+	}
+	result := &FederatedLoginResult{Redirect: SafeFederatedRedirect(claims.Data["redirect"])}
+
+	var linkUserID uint
+	if raw := claims.Data["link_user_id"]; raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil || uint(id) != sessionUserID {
+			return nil, &FederationError{Code: FederationErrorInvalidState}
+		}
+		linkUserID = uint(id)
+	}
+
+	provider, err := s.GetEnabledProvider(slug)
+	if err != nil {
+		return nil, err
+	}
+	providerName := string(provider.Type) + ":" + provider.Slug
+	if code == "" {
+		return nil, &FederationError{Code: FederationErrorProvider, Err: errors.New("no authorization code")}
+	}
+
+	_, tokenURL, userInfoURL, err := s.providerEndpoints(provider)
+	if err != nil {
+		return nil, &FederationError{Code: FederationErrorProvider, Err: err}
+	}
+	tokens, err := s.exchangeCode(provider, tokenURL, code, claims.Data["verifier"])
+	if err != nil {
+		return nil, &FederationError{Code: FederationErrorProvider, Err: err}
+	}
+	profile, err := s.fetchProfile(provider, userInfoURL, tokens, claims.Data["nonce"])
+	if err != nil {
+		return nil, &FederationError{Code: FederationErrorProvider, Err: err}
+	}
+
+	if linkUserID != 0 {
+		if err := s.linkIdentity(provider, profile, linkUserID); err != nil {
+			return nil, err
+		}
+		result.Linked = true
+		result.User, err = s.users.GetUserByID(linkUserID)
+		return result, err
+	}
+
+	failed := func(reason string, user *database.User) {
+		entry := auditEntry{
+			Action:   AuditActionLogin,
+			Outcome:  AuditOutcomeFailure,
+			Metadata: map[string]interface{}{"email": profile.Email, "reason": reason, "provider": providerName},
+		}
+		if user != nil {
+			entry.TargetType, entry.TargetID = AuditTargetUser, auditID(user.ID)
+		}
+		recordAuditOrLog(s.db, actor, entry)
+	}
+
+	user, err := s.resolveUser(provider, profile)
+	if err != nil {
+		var federationErr *FederationError
+		if errors.As(err, &federationErr) {
+			failed(federationErr.Code, nil)
+		}
+		return nil, err
+	}
+	if user.Disabled {
+		failed("disabled", user)
+		return nil, &FederationError{Code: FederationErrorAccountDisabled, Err: ErrUserDisabled}
+	}
+	if err := s.users.EnsureEmailVerifiedForLogin(user); err != nil {
+		failed("email_not_verified", user)
+		return nil, &FederationError{Code: FederationErrorEmailNotVerified, Err: err}
+	}
+
+	actor.UserID = &user.ID
+	if err := recordAudit(s.db, actor, auditEntry{
+		Action:     AuditActionLogin,
+		TargetType: AuditTargetUser,
+		TargetID:   auditID(user.ID),
+		Metadata:   map[string]interface{}{"provider": providerName},
+	}); err != nil {
+		return nil, err
+	}
+
+	result.User = user
+	return result, nil
+}
+
+// resolveUser finds the user of an upstream account: by linked identity, then by verified email,
+// linking the identity, and finally by creating a user when the provider allows sign up
+func (s *FederationService) resolveUser(provider *database.IdentityProvider, profile *federatedProfile) (*database.User, error) {
+	var identity database.LinkedIdentity
+	err := s.db.Where("provider_id = ? AND subject = ?", provider.ID, profile.Subject).First(&identity).Error
+	if err == nil {
+		now := time.Now()
+		if err := s.db.Model(&identity).Updates(map[string]interface{}{"email": profile.Email, "last_login_at": now}).Error; err != nil {
+			return nil, err
+		}
+		return s.users.GetUserByID(identity.UserID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if profile.Email == "" {
+		return nil, &FederationError{Code: FederationErrorProvider, Err: errors.New("provider did not return an email address")}
+	}
+
+	var user database.User
+	err = s.db.Where("email = ?", profile.Email).First(&user).Error
+	switch {
+	case err == nil:
+		// Linking by email is only safe when both sides have proven ownership of the address
+		if !profile.EmailVerified || !user.EmailVerified {
+			return nil, &FederationError{Code: FederationErrorAccountExists}
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if !provider.AllowSignup {
+			return nil, &FederationError{Code: FederationErrorSignupDisabled}
+		}
+		created, err := s.createUser(profile)
+		if err != nil {
+			return nil, err
+		}
+		user = *created
+	default:
+		return nil, err
+	}
+
+	now := time.Now()
+	identity = database.LinkedIdentity{
+		ProviderID:  provider.ID,
+		Subject:     profile.Subject,
+		UserID:      user.ID,
+		Email:       profile.Email,
+		LastLoginAt: &now,
+	}
+	if err := s.db.Create(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *FederationService) createUser(profile *federatedProfile) (*database.User, error) {
+	username := profile.Username
+	if username == "" {
+		username, _, _ = strings.Cut(profile.Email, "@")
+	}
+	user := &database.User{
+		Username:    username,
+		Email:       profile.Email,
+		AuthSource:  AuthSourceFederated,
+		AuthSubject: profile.Subject,
+	}
+	if profile.EmailVerified {
+		now := time.Now()
+		user.EmailVerified, user.EmailVerifiedAt = true, &now
+	}
+	// The provider verifies the user, so the local hash is a random placeholder until a password is set
+	if err := user.SetPassword(generateSecureToken()); err != nil {
+		return nil, err
+	}
+	if err := s.users.CreateUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// linkIdentity links an upstream account to a signed-in user
+func (s *FederationService) linkIdentity(provider *database.IdentityProvider, profile *federatedProfile, userID uint) error {
+	var identity database.LinkedIdentity
+	err := s.db.Where("provider_id = ? AND subject = ?", provider.ID, profile.Subject).First(&identity).Error
+	switch {
+	case err == nil && identity.UserID != userID:
+		return &FederationError{Code: FederationErrorIdentityInUse}
+	case err == nil:
+		return s.db.Model(&identity).Update("email", profile.Email).Error
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	}
+
+	identity = database.LinkedIdentity{
+		ProviderID: provider.ID,
+		Subject:    profile.Subject,
+		UserID:     userID,
+		Email:      profile.Email,
+	}
+	return s.db.Create(&identity).Error
+}
+
+// ListUserIdentities returns the identities linked to a user, with their providers
+func (s *FederationService) ListUserIdentities(userID uint) ([]database.LinkedIdentity, map[uint]database.IdentityProvider, error) {
+	var identities []database.LinkedIdentity
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&identities).Error; err != nil {
+		return nil, nil, err
+	}
+
+	providerIDs := make([]uint, 0, len(identities))
+	for _, identity := range identities {
+		providerIDs = append(providerIDs, identity.ProviderID)
+	}
+	var providers []database.IdentityProvider
+	if err := s.db.Where("id IN ?", providerIDs).Find(&providers).Error; err != nil {
+		return nil, nil, err
+	}
+	byID := make(map[uint]database.IdentityProvider, len(providers))
+	for _, provider := range providers {
+		byID[provider.ID] = provider
+	}
+	return identities, byID, nil
+}
+
+// UnlinkIdentity removes an identity linked to a user. Users created through a provider keep at
+// least one identity until they set a password.
+func (s *FederationService) UnlinkIdentity(userID, identityID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var identity database.LinkedIdentity
+		if err := tx.Where("id = ? AND user_id = ?", identityID, userID).First(&identity).Error; err != nil {
+			return err
+		}
+
+		var user database.User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		if user.AuthSource == AuthSourceFederated {
+			var count int64
+			if err := tx.Model(&database.LinkedIdentity{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count <= 1 {
+				return ErrLastIdentity
+			}
+		}
+		return tx.Delete(&identity).Error
+	})
+}
+
+// FederationErrorCode returns the code of a failed sign in, treating unexpected errors as provider errors
+func FederationErrorCode(err error) string {
+	var federationErr *FederationError
+	if errors.As(err, &federationErr) {
+		return federationErr.Code
+	}
+	return FederationErrorProvider
+}
+
+// SecureCookies reports whether the site is served over HTTPS, so sign in cookies must be Secure
+func (s *FederationService) SecureCookies() bool {
+	return strings.HasPrefix(s.baseURL, "https://")
+}
@@ -0,0 +1,375 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"miniauth/database"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const federationMetadataTTL = time.Hour
+
+// oidcDiscovery is the part of an OpenID Provider configuration miniauth uses
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type cachedDiscovery struct {
+	discovery *oidcDiscovery
+	fetchedAt time.Time
+}
+
+type cachedJWKS struct {
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// jsonWebKey is an RSA or EC public key of a JWK set (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// federatedProfile is the account at the upstream provider
+type federatedProfile struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+// providerEndpoints returns the authorization, token and user info endpoints of a provider,
+// discovering those of OIDC providers that are not configured explicitly
+func (s *FederationService) providerEndpoints(provider *database.IdentityProvider) (authorization, token, userInfo string, err error) {
+	authorization, token, userInfo = provider.AuthorizationURL, provider.TokenURL, provider.UserInfoURL
+	if provider.Type != database.IdentityProviderTypeOIDC || (authorization != "" && token != "") {
+		return authorization, token, userInfo, nil
+	}
+
+	discovery, err := s.discover(provider.Issuer)
+	if err != nil {
+		return "", "", "", err
+	}
+	if authorization == "" {
+		authorization = discovery.AuthorizationEndpoint
+	}
+	if token == "" {
+		token = discovery.TokenEndpoint
+	}
+	if userInfo == "" {
+		userInfo = discovery.UserInfoEndpoint
+	}
+	return authorization, token, userInfo, nil
+}
+
+// discover fetches and caches the OpenID Provider configuration of an issuer
+func (s *FederationService) discover(issuer string) (*oidcDiscovery, error) {
+	s.mu.Lock()
+	cached, ok := s.discoveries[issuer]
+	s.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < federationMetadataTTL {
+		return cached.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := s.getJSON(strings.TrimRight(issuer, "/")+"/.well-known/openid-configuration", "", &discovery); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if discovery.Issuer != issuer {
+		return nil, fmt.Errorf("discovery: issuer %q does not match %q", discovery.Issuer, issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("discovery: missing endpoints")
+	}
+
+	s.mu.Lock()
+	s.discoveries[issuer] = cachedDiscovery{discovery: &discovery, fetchedAt: time.Now()}
+	s.mu.Unlock()
+	return &discovery, nil
+}
+
+// exchangeCode redeems an authorization code with PKCE and returns the token response
+func (s *FederationService) exchangeCode(provider *database.IdentityProvider, tokenURL, code, verifier string) (map[string]interface{}, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {s.CallbackURL(provider.Slug)},
+		"client_id":     {provider.ClientID},
+		"client_secret": {provider.ClientSecret},
+		"code_verifier": {verifier},
+	}
+	request, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	var response map[string]interface{}
+	if err := s.doJSON(request, &response); err != nil {
+		return nil, fmt.Errorf("token exchange: %w", err)
+	}
+	if errorCode, _ := response["error"].(string); errorCode != "" {
+		return nil, fmt.Errorf("token exchange: %s", errorCode)
+	}
+	if accessToken, _ := response["access_token"].(string); accessToken == "" {
+		return nil, errors.New("token exchange: no access token")
+	}
+	return response, nil
+}
+
+// verifyIDToken checks the signature, issuer, audience, expiry and nonce of an ID token and returns its claims
+func (s *FederationService) verifyIDToken(provider *database.IdentityProvider, rawIDToken, nonce string) (jwt.MapClaims, error) {
+	discovery, err := s.discover(provider.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return s.signingKey(discovery.JWKSURI, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(provider.Issuer),
+		jwt.WithAudience(provider.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("id token: %w", err)
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, errors.New("id token: nonce mismatch")
+	}
+	return claims, nil
+}
+
+// signingKey returns a key of the provider's JWK set, refreshing the set when the key is unknown
+// so rotated keys are picked up
+func (s *FederationService) signingKey(jwksURI, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	cached, ok := s.jwks[jwksURI]
+	s.mu.Unlock()
+
+	if ok {
+		if key := selectJWK(cached.keys, kid); key != nil && time.Since(cached.fetchedAt) < federationMetadataTTL {
+			return key, nil
+		}
+		// Refresh at most once a minute, so tokens with unknown key IDs cannot hammer the provider
+		if time.Since(cached.fetchedAt) < time.Minute {
+			if key := selectJWK(cached.keys, kid); key != nil {
+				return key, nil
+			}
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := s.getJSON(jwksURI, "", &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+
+	s.mu.Lock()
+	s.jwks[jwksURI] = cachedJWKS{keys: keys, fetchedAt: time.Now()}
+	s.mu.Unlock()
+
+	if key := selectJWK(keys, kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// selectJWK returns the key with the ID, or the only key when the token names none
+func selectJWK(keys map[string]crypto.PublicKey, kid string) crypto.PublicKey {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return keys[kid]
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(value string) (*big.Int, error) {
+		bytes, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(bytes), nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// fetchProfile reads the account from the ID token of OIDC providers, or from the user info API of
+// OAuth 2.0 providers
+func (s *FederationService) fetchProfile(provider *database.IdentityProvider, userInfoURL string, tokens map[string]interface{}, nonce string) (*federatedProfile, error) {
+	var claims map[string]interface{}
+	if provider.Type == database.IdentityProviderTypeOIDC {
+		rawIDToken, _ := tokens["id_token"].(string)
+		if rawIDToken == "" {
+			return nil, errors.New("token response has no id_token")
+		}
+		idClaims, err := s.verifyIDToken(provider, rawIDToken, nonce)
+		if err != nil {
+			return nil, err
+		}
+		claims = idClaims
+	} else {
+		accessToken, _ := tokens["access_token"].(string)
+		if err := s.getJSON(userInfoURL, accessToken, &claims); err != nil {
+			return nil, fmt.Errorf("user info: %w", err)
+		}
+	}
+
+	profile := &federatedProfile{
+		Subject:  claimString(claims[provider.SubjectClaim]),
+		Email:    strings.TrimSpace(claimString(claims[provider.EmailClaim])),
+		Username: strings.TrimSpace(claimString(claims[provider.UsernameClaim])),
+	}
+	if profile.Subject == "" {
+		return nil, fmt.Errorf("missing %s claim", provider.SubjectClaim)
+	}
+	verified, _ := claims["email_verified"].(bool)
+	profile.EmailVerified = profile.Email != "" && (verified || provider.TrustEmail)
+
+	if provider.EmailsURL != "" {
+		accessToken, _ := tokens["access_token"].(string)
+		if err := s.fetchVerifiedEmail(provider.EmailsURL, accessToken, profile); err != nil {
+			return nil, err
+		}
+	}
+	return profile, nil
+}
+
+// fetchVerifiedEmail uses the primary address from a GitHub-style email list when it is verified
+func (s *FederationService) fetchVerifiedEmail(emailsURL, accessToken string, profile *federatedProfile) error {
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := s.getJSON(emailsURL, accessToken, &emails); err != nil {
+		return fmt.Errorf("emails: %w", err)
+	}
+	for _, email := range emails {
+		if email.Primary {
+			profile.Email = email.Email
+			profile.EmailVerified = email.Verified
+			return nil
+		}
+	}
+	return nil
+}
+
+// claimString renders string and numeric claims, e.g. GitHub's numeric user ID
+func claimString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+func (s *FederationService) getJSON(rawURL, accessToken string, dest interface{}) error {
+	request, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		request.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return s.doJSON(request, dest)
+}
+
+func (s *FederationService) doJSON(request *http.Request, dest interface{}) error {
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	// Token endpoints report errors as JSON with a 400 status, which callers inspect
+	if response.StatusCode >= 300 && !(response.StatusCode == http.StatusBadRequest && json.Valid(body)) {
+		return fmt.Errorf("%s returned status %d", request.URL.Host, response.StatusCode)
+	}
+	return json.Unmarshal(body, dest)
+}
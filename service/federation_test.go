@@ -0,0 +1,380 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"miniauth/database"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIdP is an OpenID Provider serving discovery, a JWK set and a token endpoint that checks PKCE
+type mockIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	challenge string
+	idToken   string
+}
+
+func startMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	idp := &mockIdP{key: generateTestRSAKey(t), codes: map[string]mockAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                idp.URL,
+			AuthorizationEndpoint: idp.URL + "/authorize",
+			TokenEndpoint:         idp.URL + "/token",
+			JWKSURI:               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {{
+			Kty: "RSA",
+			Kid: "key-1",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		authorization, ok := idp.codes[r.PostFormValue("code")]
+		delete(idp.codes, r.PostFormValue("code"))
+		idp.mu.Unlock()
+
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || r.PostFormValue("client_id") != "miniauth" || r.PostFormValue("client_secret") != "idp secret" ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != authorization.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access token",
+			"token_type":   "Bearer",
+			"id_token":     authorization.idToken,
+		})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func generateTestRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	return key
+}
+
+// authorize plays the user approving the authorization request, and returns the code and state
+// the provider redirects back with. idToken builds the ID token issued for the code from the nonce.
+func (idp *mockIdP) authorize(t *testing.T, authorizationURL string, idToken func(nonce string) string) (code, state string) {
+	t.Helper()
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatalf("parse authorization URL: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization URL %s has no S256 code challenge", authorizationURL)
+	}
+
+	code = generateSecureToken()
+	idp.mu.Lock()
+	idp.codes[code] = mockAuthorization{challenge: query.Get("code_challenge"), idToken: idToken(query.Get("nonce"))}
+	idp.mu.Unlock()
+	return code, query.Get("state")
+}
+
+// claims returns valid ID token claims of an account at the provider
+func (idp *mockIdP) claims(subject, email string, emailVerified bool, nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            idp.URL,
+		"aud":            "miniauth",
+		"sub":            subject,
+		"email":          email,
+		"email_verified": emailVerified,
+		"nonce":          nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+func signTestIDToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign ID token: %v", err)
+	}
+	return signed
+}
+
+func newTestFederationService(t *testing.T, idp *mockIdP, allowSignup bool) *FederationService {
+	t.Helper()
+	db := newTestDB(t)
+	signer := &TokenSigner{secret: []byte("test secret")}
+	federation := NewFederationService(db, NewUserService(db, &fakeMailer{}, signer), signer)
+	federation.client = idp.Client()
+
+	if _, err := federation.CreateProvider(IdentityProviderRequest{
+		Slug:         "mock",
+		Name:         "Mock",
+		Type:         database.IdentityProviderTypeOIDC,
+		ClientID:     "miniauth",
+		ClientSecret: "idp secret",
+		Issuer:       idp.URL,
+		AllowSignup:  &allowSignup,
+	}); err != nil {
+		t.Fatalf("CreateProvider: %v", err)
+	}
+	return federation
+}
+
+func TestFederatedLoginState(t *testing.T) {
+	idp := startMockIdP(t)
+	federation := newTestFederationService(t, idp, true)
+	validToken := func(nonce string) string {
+		return signTestIDToken(t, jwt.SigningMethodRS256, idp.key, "key-1", idp.claims("alice-id", "alice@example.com", true, nonce))
+	}
+
+	login, err := federation.StartLogin("mock", "//evil.example.com", 0)
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	if got := federation.LoginRedirect(login.State); got != DefaultFederatedRedirect {
+		t.Errorf("LoginRedirect = %q, want the default for an open redirect", got)
+	}
+	link, err := federation.StartLogin("mock", "/profile", 7)
+	if err != nil {
+		t.Fatalf("StartLogin to link: %v", err)
+	}
+	other, err := federation.StartLogin("mock", "/profile", 0)
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+
+	code, state := idp.authorize(t, login.AuthorizationURL, validToken)
+	linkCode, linkState := idp.authorize(t, link.AuthorizationURL, validToken)
+	_, otherState := idp.authorize(t, other.AuthorizationURL, validToken)
+
+	tests := []struct {
+		name          string
+		slug          string
+		code          string
+		state         string
+		cookie        string
+		sessionUserID uint
+		wantCode      string
+	}{
+		{"missing state", "mock", code, "", login.State, 0, FederationErrorInvalidState},
+		{"state of another login", "mock", code, otherState, login.State, 0, FederationErrorInvalidState},
+		{"missing cookie", "mock", code, state, "", 0, FederationErrorInvalidState},
+		{"tampered cookie", "mock", code, state, login.State + "x", 0, FederationErrorInvalidState},
+		{"cookie of another provider", "other", code, state, login.State, 0, FederationErrorInvalidState},
+		{"link by another user", "mock", linkCode, linkState, link.State, 8, FederationErrorInvalidState},
+		{"link signed out", "mock", linkCode, linkState, link.State, 0, FederationErrorInvalidState},
+		// PKCE: the code was issued for the challenge of another login, so its verifier does not match
+		{"code of another login", "mock", code, otherState, other.State, 0, FederationErrorProvider},
+		{"missing code", "mock", "", state, login.State, 0, FederationErrorProvider},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := federation.CompleteLogin(AuditActor{}, tt.slug, tt.code, tt.state, tt.cookie, tt.sessionUserID)
+			if got := FederationErrorCode(err); err == nil || got != tt.wantCode {
+				t.Errorf("CompleteLogin = %v, want %s", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestFederatedLoginIDToken(t *testing.T) {
+	idp := startMockIdP(t)
+	otherKey := generateTestRSAKey(t)
+
+	tests := []struct {
+		name    string
+		idToken func(t *testing.T, claims jwt.MapClaims) string
+		wantErr bool
+	}{
+		{"valid", func(t *testing.T, claims jwt.MapClaims) string {
+			return signTestIDToken(t, jwt.SigningMethodRS256, idp.key, "key-1", claims)
+		}, false},
+		{"only key without kid", func(t *testing.T, claims jwt.MapClaims) string {
+			return signTestIDToken(t, jwt.SigningMethodRS256, idp.key, "", claims)
+		}, false},
+		{"wrong issuer", func(t *testing.T, claims jwt.MapClaims) string {
+			claims["iss"] = "https://evil.example.com"
+			return signTestIDToken(t, jwt.SigningMethodRS256, idp.key, "key-1", claims)
+		}, true},
+		{"wrong audience", func(t *testing.T, claims jwt.MapClaims) string {
+			claims["aud"] = "another-client"
+			return signTestIDToken(t, jwt.SigningMethodRS256, idp.key, "key-1", claims)
+		}, true},
+		{"wrong nonce", func(t *testing.T, claims jwt.MapClaims) string {
+			claims["nonce"] = "replayed"
+			return signTestIDToken(t, jwt.SigningMethodRS256, idp.key, "key-1", claims)
+		}, true},
+		{"missing nonce", func(t *testing.T, claims jwt.MapClaims) string {
+			delete(claims, "nonce")
+			return signTestIDToken(t, jwt.SigningMethodRS256, idp.key, "key-1", claims)
+		}, true},
+		{"expired", func(t *testing.T, claims jwt.MapClaims) string {
+			claims["exp"] = time.Now().Add(-2 * time.Minute).Unix()
+			return signTestIDToken(t, jwt.SigningMethodRS256, idp.key, "key-1", claims)
+		}, true},
+		{"missing expiry", func(t *testing.T, claims jwt.MapClaims) string {
+			delete(claims, "exp")
+			return signTestIDToken(t, jwt.SigningMethodRS256, idp.key, "key-1", claims)
+		}, true},
+		{"signed by another key", func(t *testing.T, claims jwt.MapClaims) string {
+			return signTestIDToken(t, jwt.SigningMethodRS256, otherKey, "key-1", claims)
+		}, true},
+		{"unknown kid", func(t *testing.T, claims jwt.MapClaims) string {
+			return signTestIDToken(t, jwt.SigningMethodRS256, otherKey, "key-2", claims)
+		}, true},
+		{"signed with the client secret", func(t *testing.T, claims jwt.MapClaims) string {
+			return signTestIDToken(t, jwt.SigningMethodHS256, []byte("idp secret"), "key-1", claims)
+		}, true},
+		{"unsigned", func(t *testing.T, claims jwt.MapClaims) string {
+			return signTestIDToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "key-1", claims)
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			federation := newTestFederationService(t, idp, true)
+			login, err := federation.StartLogin("mock", "/apps", 0)
+			if err != nil {
+				t.Fatalf("StartLogin: %v", err)
+			}
+			code, state := idp.authorize(t, login.AuthorizationURL, func(nonce string) string {
+				return tt.idToken(t, idp.claims("alice-id", "alice@example.com", true, nonce))
+			})
+
+			result, err := federation.CompleteLogin(AuditActor{}, "mock", code, state, login.State, 0)
+			if tt.wantErr {
+				if got := FederationErrorCode(err); err == nil || got != FederationErrorProvider {
+					t.Errorf("CompleteLogin = %v, want %s", err, FederationErrorProvider)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CompleteLogin: %v", err)
+			}
+			if result.User.Email != "alice@example.com" || result.Redirect != "/apps" || result.Linked {
+				t.Errorf("CompleteLogin = %+v, user %+v", result, result.User)
+			}
+		})
+	}
+}
+
+func TestFederatedLoginResolvesUsers(t *testing.T) {
+	idp := startMockIdP(t)
+
+	tests := []struct {
+		name          string
+		allowSignup   bool
+		existing      *database.User
+		subject       string
+		email         string
+		emailVerified bool
+		wantCode      string
+		wantExisting  bool // Signs in as the existing user rather than a new one
+	}{
+		{name: "creates a user", allowSignup: true, subject: "new-id", email: "new@example.com", emailVerified: true},
+		{name: "creates a user with an unverified address", allowSignup: true, subject: "new-id", email: "new@example.com"},
+		{name: "sign up disabled", subject: "new-id", email: "new@example.com", emailVerified: true, wantCode: FederationErrorSignupDisabled},
+		{name: "links by verified email",
+			existing: &database.User{Username: "alice", Email: "alice@example.com", EmailVerified: true},
+			subject:  "alice-id", email: "alice@example.com", emailVerified: true, wantExisting: true},
+		{name: "address unverified at the provider",
+			existing: &database.User{Username: "alice", Email: "alice@example.com", EmailVerified: true},
+			subject:  "alice-id", email: "alice@example.com", wantCode: FederationErrorAccountExists},
+		{name: "address unverified locally", allowSignup: true,
+			existing: &database.User{Username: "alice", Email: "alice@example.com"},
+			subject:  "alice-id", email: "alice@example.com", emailVerified: true, wantCode: FederationErrorAccountExists},
+		{name: "disabled user",
+			existing: &database.User{Username: "alice", Email: "alice@example.com", EmailVerified: true, Disabled: true},
+			subject:  "alice-id", email: "alice@example.com", emailVerified: true, wantCode: FederationErrorAccountDisabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			federation := newTestFederationService(t, idp, tt.allowSignup)
+			if tt.existing != nil {
+				if err := tt.existing.SetPassword("alice secret"); err != nil {
+					t.Fatalf("SetPassword: %v", err)
+				}
+				mustCreate(t, federation.db, tt.existing)
+			}
+
+			login := func() (*FederatedLoginResult, error) {
+				started, err := federation.StartLogin("mock", "/profile", 0)
+				if err != nil {
+					t.Fatalf("StartLogin: %v", err)
+				}
+				code, state := idp.authorize(t, started.AuthorizationURL, func(nonce string) string {
+					return signTestIDToken(t, jwt.SigningMethodRS256, idp.key, "key-1", idp.claims(tt.subject, tt.email, tt.emailVerified, nonce))
+				})
+				return federation.CompleteLogin(AuditActor{}, "mock", code, state, started.State, 0)
+			}
+
+			result, err := login()
+			if tt.wantCode != "" {
+				if got := FederationErrorCode(err); err == nil || got != tt.wantCode {
+					t.Errorf("CompleteLogin = %v, want %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CompleteLogin: %v", err)
+			}
+			user := result.User
+			if tt.wantExisting {
+				if user.ID != tt.existing.ID {
+					t.Errorf("signed in as user %d, want existing user %d", user.ID, tt.existing.ID)
+				}
+			} else if user.AuthSource != AuthSourceFederated || user.AuthSubject != tt.subject || user.EmailVerified != tt.emailVerified {
+				t.Errorf("created user = %+v", user)
+			}
+
+			// The identity is linked, so the next sign in finds the user by subject
+			again, err := login()
+			if err != nil {
+				t.Fatalf("second CompleteLogin: %v", err)
+			}
+			if again.User.ID != user.ID {
+				t.Errorf("second sign in as user %d, want %d", again.User.ID, user.ID)
+			}
+			var identities int64
+			federation.db.Model(&database.LinkedIdentity{}).Where("user_id = ? AND subject = ?", user.ID, tt.subject).Count(&identities)
+			if identities != 1 {
+				t.Errorf("linked identities = %d, want 1", identities)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"time"
+)
+
+// SigningKeyPair is the server's RSA key with its certificate, used to sign assertions for
+// relying parties that verify them with public keys
+type SigningKeyPair struct {
+	Key         *rsa.PrivateKey
+	Certificate *x509.Certificate
+}
+
+// NewSigningKeyPairFromEnv loads the PEM key from SIGNING_KEY_FILE and its certificate from
+// SIGNING_CERT_FILE. A self-signed certificate is created when only the key is configured, and a
+// random key when neither is, so relying parties must be reconfigured after every restart.
+func NewSigningKeyPairFromEnv() (*SigningKeyPair, error) {
+	keyFile, certFile := getEnv("SIGNING_KEY_FILE", ""), getEnv("SIGNING_CERT_FILE", "")
+	if keyFile == "" {
+		log.Println("SIGNING_KEY_FILE is not set, using a random key; signed assertions will not verify after restarts")
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		return selfSignedKeyPair(key)
+	}
+
+	key, err := loadRSAPrivateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if certFile == "" {
+		return selfSignedKeyPair(key)
+	}
+
+	certificate, err := loadCertificate(certFile)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := certificate.PublicKey.(*rsa.PublicKey)
+	if !ok || !publicKey.Equal(key.Public()) {
+		return nil, fmt.Errorf("%s does not certify the key in %s", certFile, keyFile)
+	}
+	return &SigningKeyPair{Key: key, Certificate: certificate}, nil
+}
+
+func loadRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s contains no PEM data", path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s does not contain an RSA key", path)
+	}
+	return key, nil
+}
+
+func loadCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s contains no PEM certificate", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func selfSignedKeyPair(key *rsa.PrivateKey) (*SigningKeyPair, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "miniauth"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKeyPair{Key: key, Certificate: certificate}, nil
+}
+
+// SignSHA256 signs data with RSASSA-PKCS1-v1_5 over its SHA-256 digest
+func (k *SigningKeyPair) SignSHA256(digest []byte) ([]byte, error) {
+	if len(digest) != crypto.SHA256.Size() {
+		return nil, errors.New("invalid SHA-256 digest")
+	}
+	return rsa.SignPKCS1v15(rand.Reader, k.Key, crypto.SHA256, digest)
+}
@@ -0,0 +1,632 @@
+package service
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LDAP result codes used by the client (RFC 4511 section 4.1.9)
+const (
+	ldapResultSuccess            = 0
+	ldapResultSizeLimitExceeded  = 4
+	ldapResultInvalidCredentials = 49
+)
+
+// BER universal tags
+const (
+	berTagBoolean     = 0x01
+	berTagInteger     = 0x02
+	berTagOctetString = 0x04
+	berTagEnumerated  = 0x0a
+	berTagSequence    = 0x30
+)
+
+// LDAP protocol operation and filter tags
+const (
+	ldapTagBindRequest           = 0x60
+	ldapTagBindResponse          = 0x61
+	ldapTagUnbindRequest         = 0x42
+	ldapTagSearchRequest         = 0x63
+	ldapTagSearchResultEntry     = 0x64
+	ldapTagSearchResultDone      = 0x65
+	ldapTagSearchResultReference = 0x73
+	ldapTagExtendedRequest       = 0x77
+	ldapTagExtendedResponse      = 0x78
+	ldapTagSimpleAuthentication  = 0x80
+	ldapTagExtendedRequestName   = 0x80
+	ldapTagFilterAnd             = 0xa0
+	ldapTagFilterOr              = 0xa1
+	ldapTagFilterNot             = 0xa2
+	ldapTagFilterEquality        = 0xa3
+	ldapTagFilterSubstrings      = 0xa4
+	ldapTagFilterGreaterOrEqual  = 0xa5
+	ldapTagFilterLessOrEqual     = 0xa6
+	ldapTagFilterPresent         = 0x87
+	ldapTagFilterApproxMatch     = 0xa8
+	ldapTagSubstringInitial      = 0x80
+	ldapTagSubstringAny          = 0x81
+	ldapTagSubstringFinal        = 0x82
+)
+
+const (
+	ldapScopeWholeSubtree = 2
+	ldapDerefNever        = 0
+	ldapStartTLSOID       = "1.3.6.1.4.1.1466.20037"
+	ldapMaxMessageSize    = 16 << 20
+	ldapDefaultPort       = "389"
+	ldapDefaultTLSPort    = "636"
+)
+
+// LDAPResultError is a non-success result returned by the directory
+type LDAPResultError struct {
+	Code    int
+	Message string
+}
+
+func (e *LDAPResultError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.Code)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// ldapEntry is an entry returned by a search, with attribute names as returned by the directory
+type ldapEntry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Get returns the first value of an attribute, matching its name case-insensitively
+func (e *ldapEntry) Get(attribute string) string {
+	if values := e.GetAll(attribute); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// GetAll returns the values of an attribute, matching its name case-insensitively
+func (e *ldapEntry) GetAll(attribute string) []string {
+	for name, values := range e.Attributes {
+		if strings.EqualFold(name, attribute) {
+			return values
+		}
+	}
+	return nil
+}
+
+// ldapConn is a minimal LDAPv3 client (RFC 4511) supporting StartTLS, simple bind and search,
+// which is all password authentication against a directory needs
+type ldapConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	messageID int
+	timeout   time.Duration
+}
+
+// dialLDAP connects to an ldap:// or ldaps:// URL, upgrading ldap:// connections with StartTLS when requested
+func dialLDAP(rawURL string, startTLS bool, tlsConfig *tls.Config, timeout time.Duration) (*ldapConn, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid URL: %w", err)
+	}
+
+	host := parsed.Hostname()
+	port := parsed.Port()
+	useTLS := false
+	switch strings.ToLower(parsed.Scheme) {
+	case "ldap":
+		if port == "" {
+			port = ldapDefaultPort
+		}
+	case "ldaps":
+		if port == "" {
+			port = ldapDefaultTLSPort
+		}
+		useTLS = true
+	default:
+		return nil, fmt.Errorf("ldap: unsupported URL scheme %q", parsed.Scheme)
+	}
+
+	config := tlsConfig.Clone()
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	address := net.JoinHostPort(host, port)
+	var conn net.Conn
+	if useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, config)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap: %w", err)
+	}
+
+	c := &ldapConn{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}
+	if startTLS && !useTLS {
+		if err := c.startTLS(config); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Close unbinds and closes the connection
+func (c *ldapConn) Close() error {
+	c.messageID++
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	c.conn.Write(berEncode(berTagSequence, berInt(berTagInteger, c.messageID), []byte{ldapTagUnbindRequest, 0}))
+	return c.conn.Close()
+}
+
+func (c *ldapConn) startTLS(config *tls.Config) error {
+	op, err := c.roundTrip(berEncode(ldapTagExtendedRequest, berEncode(ldapTagExtendedRequestName, []byte(ldapStartTLSOID))))
+	if err != nil {
+		return err
+	}
+	if err := parseLDAPResult(op, ldapTagExtendedResponse); err != nil {
+		return fmt.Errorf("ldap: StartTLS: %w", err)
+	}
+
+	tlsConn := tls.Client(c.conn, config)
+	tlsConn.SetDeadline(time.Now().Add(c.timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("ldap: StartTLS: %w", err)
+	}
+	c.conn = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Bind performs a simple bind. An empty password would be an unauthenticated bind, which
+// directories accept without checking anything, so it is rejected here.
+func (c *ldapConn) Bind(dn, password string) error {
+	if password == "" {
+		return &LDAPResultError{Code: ldapResultInvalidCredentials, Message: "empty password"}
+	}
+	op, err := c.roundTrip(berEncode(ldapTagBindRequest,
+		berInt(berTagInteger, 3),
+		berEncode(berTagOctetString, []byte(dn)),
+		berEncode(ldapTagSimpleAuthentication, []byte(password)),
+	))
+	if err != nil {
+		return err
+	}
+	return parseLDAPResult(op, ldapTagBindResponse)
+}
+
+// Search runs a whole subtree search and returns at most sizeLimit entries
+func (c *ldapConn) Search(baseDN, filter string, attributes []string, sizeLimit int) ([]ldapEntry, error) {
+	encodedFilter, err := compileLDAPFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	encodedAttributes := make([][]byte, 0, len(attributes))
+	for _, attribute := range attributes {
+		encodedAttributes = append(encodedAttributes, berEncode(berTagOctetString, []byte(attribute)))
+	}
+
+	messageID, err := c.send(berEncode(ldapTagSearchRequest,
+		berEncode(berTagOctetString, []byte(baseDN)),
+		berInt(berTagEnumerated, ldapScopeWholeSubtree),
+		berInt(berTagEnumerated, ldapDerefNever),
+		berInt(berTagInteger, sizeLimit),
+		berInt(berTagInteger, int(c.timeout/time.Second)),
+		berEncode(berTagBoolean, []byte{0}),
+		encodedFilter,
+		berEncode(berTagSequence, encodedAttributes...),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ldapEntry
+	for {
+		op, err := c.receive(messageID)
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case ldapTagSearchResultEntry:
+			entry, err := parseLDAPEntry(op.content)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case ldapTagSearchResultReference:
+			// Referrals to other servers are not followed
+		case ldapTagSearchResultDone:
+			err := parseLDAPResult(op, ldapTagSearchResultDone)
+			var resultErr *LDAPResultError
+			if errors.As(err, &resultErr) && resultErr.Code == ldapResultSizeLimitExceeded {
+				err = nil
+			}
+			return entries, err
+		default:
+			return nil, fmt.Errorf("ldap: unexpected response tag 0x%02x", op.tag)
+		}
+	}
+}
+
+// berElement is a decoded BER tag and its content
+type berElement struct {
+	tag     byte
+	content []byte
+}
+
+func (c *ldapConn) roundTrip(protocolOp []byte) (berElement, error) {
+	messageID, err := c.send(protocolOp)
+	if err != nil {
+		return berElement{}, err
+	}
+	return c.receive(messageID)
+}
+
+func (c *ldapConn) send(protocolOp []byte) (int, error) {
+	c.messageID++
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(berEncode(berTagSequence, berInt(berTagInteger, c.messageID), protocolOp)); err != nil {
+		return 0, fmt.Errorf("ldap: %w", err)
+	}
+	return c.messageID, nil
+}
+
+// receive reads the next message, which must answer messageID, and returns its protocol operation
+func (c *ldapConn) receive(messageID int) (berElement, error) {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	message, err := readBERElement(c.reader)
+	if err != nil {
+		return berElement{}, fmt.Errorf("ldap: %w", err)
+	}
+	if message.tag != berTagSequence {
+		return berElement{}, errors.New("ldap: malformed message")
+	}
+
+	id, rest, err := parseBER(message.content)
+	if err != nil || id.tag != berTagInteger {
+		return berElement{}, errors.New("ldap: malformed message ID")
+	}
+	if berParseInt(id.content) != messageID {
+		return berElement{}, fmt.Errorf("ldap: unexpected message ID %d", berParseInt(id.content))
+	}
+	op, _, err := parseBER(rest)
+	if err != nil {
+		return berElement{}, fmt.Errorf("ldap: %w", err)
+	}
+	return op, nil
+}
+
+// parseLDAPResult checks the tag of a response and returns its result code as an error unless it is success
+func parseLDAPResult(op berElement, expectedTag byte) error {
+	if op.tag != expectedTag {
+		return fmt.Errorf("ldap: unexpected response tag 0x%02x", op.tag)
+	}
+	code, rest, err := parseBER(op.content)
+	if err != nil || code.tag != berTagEnumerated {
+		return errors.New("ldap: malformed result")
+	}
+	_, rest, err = parseBER(rest) // matchedDN
+	if err != nil {
+		return errors.New("ldap: malformed result")
+	}
+	message, _, err := parseBER(rest)
+	if err != nil {
+		return errors.New("ldap: malformed result")
+	}
+
+	if result := berParseInt(code.content); result != ldapResultSuccess {
+		return &LDAPResultError{Code: result, Message: string(message.content)}
+	}
+	return nil
+}
+
+func parseLDAPEntry(content []byte) (ldapEntry, error) {
+	entry := ldapEntry{Attributes: map[string][]string{}}
+	dn, rest, err := parseBER(content)
+	if err != nil {
+		return entry, errors.New("ldap: malformed entry")
+	}
+	entry.DN = string(dn.content)
+
+	attributes, _, err := parseBER(rest)
+	if err != nil {
+		return entry, errors.New("ldap: malformed entry")
+	}
+	for data := attributes.content; len(data) > 0; {
+		var attribute berElement
+		if attribute, data, err = parseBER(data); err != nil {
+			return entry, errors.New("ldap: malformed attribute")
+		}
+		name, valueSet, err := parseBER(attribute.content)
+		if err != nil {
+			return entry, errors.New("ldap: malformed attribute")
+		}
+		values, _, err := parseBER(valueSet)
+		if err != nil {
+			return entry, errors.New("ldap: malformed attribute")
+		}
+		for valueData := values.content; len(valueData) > 0; {
+			var value berElement
+			if value, valueData, err = parseBER(valueData); err != nil {
+				return entry, errors.New("ldap: malformed attribute value")
+			}
+			entry.Attributes[string(name.content)] = append(entry.Attributes[string(name.content)], string(value.content))
+		}
+	}
+	return entry, nil
+}
+
+// compileLDAPFilter encodes a string filter (RFC 4515), e.g. (&(objectClass=person)(mail=alice@example.com))
+func compileLDAPFilter(filter string) ([]byte, error) {
+	encoded, rest, err := parseLDAPFilter(strings.TrimSpace(filter))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: invalid filter %q: trailing characters", filter)
+	}
+	return encoded, nil
+}
+
+func parseLDAPFilter(filter string) ([]byte, string, error) {
+	if !strings.HasPrefix(filter, "(") || len(filter) < 3 {
+		return nil, "", fmt.Errorf("ldap: invalid filter %q", filter)
+	}
+	filter = filter[1:]
+
+	switch filter[0] {
+	case '&', '|', '!':
+		tag := map[byte]byte{'&': ldapTagFilterAnd, '|': ldapTagFilterOr, '!': ldapTagFilterNot}[filter[0]]
+		filter = filter[1:]
+		var children [][]byte
+		for strings.HasPrefix(filter, "(") {
+			child, rest, err := parseLDAPFilter(filter)
+			if err != nil {
+				return nil, "", err
+			}
+			children = append(children, child)
+			filter = rest
+		}
+		if !strings.HasPrefix(filter, ")") || (tag == ldapTagFilterNot && len(children) != 1) {
+			return nil, "", errors.New("ldap: invalid filter")
+		}
+		if tag == ldapTagFilterNot {
+			return berEncode(tag, children[0]), filter[1:], nil
+		}
+		return berEncode(tag, children...), filter[1:], nil
+	}
+
+	end := strings.IndexByte(filter, ')')
+	if end < 0 {
+		return nil, "", errors.New("ldap: invalid filter: missing )")
+	}
+	item, rest := filter[:end], filter[end+1:]
+	encoded, err := encodeLDAPFilterItem(item)
+	return encoded, rest, err
+}
+
+func encodeLDAPFilterItem(item string) ([]byte, error) {
+	index := strings.IndexByte(item, '=')
+	if index < 1 {
+		return nil, fmt.Errorf("ldap: invalid filter item %q", item)
+	}
+	attribute, value := item[:index], item[index+1:]
+
+	tag := byte(ldapTagFilterEquality)
+	switch attribute[len(attribute)-1] {
+	case '>':
+		tag = ldapTagFilterGreaterOrEqual
+	case '<':
+		tag = ldapTagFilterLessOrEqual
+	case '~':
+		tag = ldapTagFilterApproxMatch
+	}
+	if tag != ldapTagFilterEquality {
+		attribute = attribute[:len(attribute)-1]
+	}
+	if attribute == "" {
+		return nil, fmt.Errorf("ldap: invalid filter item %q", item)
+	}
+
+	if tag == ldapTagFilterEquality && value == "*" {
+		return berEncode(ldapTagFilterPresent, []byte(attribute)), nil
+	}
+	if tag == ldapTagFilterEquality && strings.Contains(value, "*") {
+		parts := strings.Split(value, "*")
+		var substrings [][]byte
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			unescaped, err := unescapeLDAPFilterValue(part)
+			if err != nil {
+				return nil, err
+			}
+			partTag := byte(ldapTagSubstringAny)
+			if i == 0 {
+				partTag = ldapTagSubstringInitial
+			} else if i == len(parts)-1 {
+				partTag = ldapTagSubstringFinal
+			}
+			substrings = append(substrings, berEncode(partTag, unescaped))
+		}
+		return berEncode(ldapTagFilterSubstrings,
+			berEncode(berTagOctetString, []byte(attribute)),
+			berEncode(berTagSequence, substrings...),
+		), nil
+	}
+
+	unescaped, err := unescapeLDAPFilterValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return berEncode(tag, berEncode(berTagOctetString, []byte(attribute)), berEncode(berTagOctetString, unescaped)), nil
+}
+
+// unescapeLDAPFilterValue decodes \XX escapes in a filter value
+func unescapeLDAPFilterValue(value string) ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			out = append(out, value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return nil, fmt.Errorf("ldap: invalid escape in filter value %q", value)
+		}
+		decoded, err := strconv.ParseUint(value[i+1:i+3], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: invalid escape in filter value %q", value)
+		}
+		out = append(out, byte(decoded))
+		i += 2
+	}
+	return out, nil
+}
+
+// EscapeLDAPFilterValue escapes a value for use in a filter (RFC 4515 section 3)
+func EscapeLDAPFilterValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// berEncode encodes a tag with the concatenation of contents
+func berEncode(tag byte, contents ...[]byte) []byte {
+	length := 0
+	for _, content := range contents {
+		length += len(content)
+	}
+	out := append([]byte{tag}, berLength(length)...)
+	for _, content := range contents {
+		out = append(out, content...)
+	}
+	return out
+}
+
+func berLength(length int) []byte {
+	if length < 0x80 {
+		return []byte{byte(length)}
+	}
+	var bytes []byte
+	for ; length > 0; length >>= 8 {
+		bytes = append([]byte{byte(length)}, bytes...)
+	}
+	return append([]byte{0x80 | byte(len(bytes))}, bytes...)
+}
+
+// berInt encodes a non-negative integer
+func berInt(tag byte, value int) []byte {
+	bytes := []byte{byte(value)}
+	for value >>= 8; value > 0; value >>= 8 {
+		bytes = append([]byte{byte(value)}, bytes...)
+	}
+	if bytes[0]&0x80 != 0 {
+		bytes = append([]byte{0}, bytes...)
+	}
+	return berEncode(tag, bytes)
+}
+
+func berParseInt(content []byte) int {
+	value := 0
+	for i, b := range content {
+		if i == 0 && b&0x80 != 0 {
+			value = -1
+		}
+		value = value<<8 | int(b)
+	}
+	return value
+}
+
+// parseBER decodes the first element of data and returns it with the remaining bytes
+func parseBER(data []byte) (berElement, []byte, error) {
+	if len(data) < 2 {
+		return berElement{}, nil, io.ErrUnexpectedEOF
+	}
+	tag := data[0]
+	length, header, err := berParseLength(data[1:])
+	if err != nil {
+		return berElement{}, nil, err
+	}
+	start := 1 + header
+	if length > len(data)-start {
+		return berElement{}, nil, io.ErrUnexpectedEOF
+	}
+	return berElement{tag: tag, content: data[start : start+length]}, data[start+length:], nil
+}
+
+// berParseLength decodes a definite length and returns it with the number of bytes it used
+func berParseLength(data []byte) (int, int, error) {
+	if len(data) == 0 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	if data[0] < 0x80 {
+		return int(data[0]), 1, nil
+	}
+	count := int(data[0] & 0x7f)
+	if count == 0 || count > 4 {
+		return 0, 0, errors.New("unsupported BER length")
+	}
+	if len(data) < 1+count {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	length := 0
+	for _, b := range data[1 : 1+count] {
+		length = length<<8 | int(b)
+	}
+	return length, 1 + count, nil
+}
+
+// readBERElement reads one element from a stream
+func readBERElement(reader *bufio.Reader) (berElement, error) {
+	tag, err := reader.ReadByte()
+	if err != nil {
+		return berElement{}, err
+	}
+	first, err := reader.ReadByte()
+	if err != nil {
+		return berElement{}, err
+	}
+	lengthBytes := []byte{first}
+	if first >= 0x80 {
+		extra := make([]byte, int(first&0x7f))
+		if _, err := io.ReadFull(reader, extra); err != nil {
+			return berElement{}, err
+		}
+		lengthBytes = append(lengthBytes, extra...)
+	}
+	length, _, err := berParseLength(lengthBytes)
+	if err != nil {
+		return berElement{}, err
+	}
+	if length > ldapMaxMessageSize {
+		return berElement{}, errors.New("message too large")
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(reader, content); err != nil {
+		return berElement{}, err
+	}
+	return berElement{tag: tag, content: content}, nil
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"miniauth/database"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrLDAPAccountConflict is returned when a directory user matches a local account, which is never
+// taken over by the directory
+var ErrLDAPAccountConflict = errors.New("a local account already uses this email address")
+
+// LDAPConfig configures authentication against an LDAP directory such as Active Directory
+type LDAPConfig struct {
+	URL                string // ldap:// or ldaps:// URL of the directory
+	StartTLS           bool   // Upgrade ldap:// connections with StartTLS
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+	BindDN             string // Service account used to search for users; anonymous when empty
+	BindPassword       string
+	BaseDN             string // Search base for users
+	UserFilter         string // Filter finding the user, with {login} replaced by the escaped login
+	UsernameAttribute  string
+	EmailAttribute     string
+	GroupAttribute     string // Attribute listing the DNs of the user's groups, e.g. memberOf
+	GroupMappings      []LDAPGroupMapping
+}
+
+// LDAPGroupMapping gives the members of a directory group a role in an organization
+type LDAPGroupMapping struct {
+	GroupDN string
+	OrgSlug string
+	Role    database.OrgMemberRole
+}
+
+// LoadLDAPConfig reads the LDAP settings from the environment
+func LoadLDAPConfig() (*LDAPConfig, error) {
+	config := &LDAPConfig{
+		URL:                getEnv("LDAP_URL", ""),
+		StartTLS:           getEnvBool("LDAP_START_TLS", false),
+		InsecureSkipVerify: getEnvBool("LDAP_INSECURE_SKIP_VERIFY", false),
+		Timeout:            getEnvDuration("LDAP_TIMEOUT", 10*time.Second),
+		BindDN:             getEnv("LDAP_BIND_DN", ""),
+		BindPassword:       getEnv("LDAP_BIND_PASSWORD", ""),
+		BaseDN:             getEnv("LDAP_BASE_DN", ""),
+		UserFilter:         getEnv("LDAP_USER_FILTER", "(&(objectClass=person)(mail={login}))"),
+		UsernameAttribute:  getEnv("LDAP_USERNAME_ATTRIBUTE", "uid"),
+		EmailAttribute:     getEnv("LDAP_EMAIL_ATTRIBUTE", "mail"),
+		GroupAttribute:     getEnv("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+	}
+	if config.URL == "" || config.BaseDN == "" {
+		return nil, errors.New("LDAP_URL and LDAP_BASE_DN are required")
+	}
+	if !strings.Contains(config.UserFilter, "{login}") {
+		return nil, errors.New("LDAP_USER_FILTER must contain {login}")
+	}
+	if _, err := compileLDAPFilter(strings.ReplaceAll(config.UserFilter, "{login}", "x")); err != nil {
+		return nil, fmt.Errorf("invalid LDAP_USER_FILTER: %w", err)
+	}
+
+	mappings, err := ParseLDAPGroupMappings(getEnv("LDAP_GROUP_MAPPINGS", ""))
+	if err != nil {
+		return nil, err
+	}
+	config.GroupMappings = mappings
+	return config, nil
+}
+
+// ParseLDAPGroupMappings parses mappings separated by ";", each written as "group DN=>org-slug:role",
+// e.g. "cn=engineering,ou=groups,dc=example,dc=com=>engineering:admin". The role defaults to member.
+func ParseLDAPGroupMappings(value string) ([]LDAPGroupMapping, error) {
+	var mappings []LDAPGroupMapping
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		groupDN, target, ok := strings.Cut(entry, "=>")
+		if !ok || strings.TrimSpace(groupDN) == "" {
+			return nil, fmt.Errorf("invalid LDAP group mapping %q, expected group DN=>org-slug:role", entry)
+		}
+		slug, role, _ := strings.Cut(strings.TrimSpace(target), ":")
+		mapping := LDAPGroupMapping{
+			GroupDN: strings.TrimSpace(groupDN),
+			OrgSlug: strings.TrimSpace(slug),
+			Role:    database.OrgMemberRole(strings.TrimSpace(role)),
+		}
+		if mapping.Role == "" {
+			mapping.Role = database.OrgMemberRoleMember
+		}
+		if mapping.OrgSlug == "" {
+			return nil, fmt.Errorf("invalid LDAP group mapping %q: missing organization slug", entry)
+		}
+		if orgRoleRank[mapping.Role] == 0 {
+			return nil, fmt.Errorf("invalid LDAP group mapping %q: unknown role %s", entry, mapping.Role)
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+// LDAPAuthenticator verifies passwords with a bind as the directory entry of the user. Users are
+// provisioned on their first login and their memberships of mapped organizations follow their
+// directory groups on every login.
+type LDAPAuthenticator struct {
+	config *LDAPConfig
+	users  *UserService
+}
+
+func NewLDAPAuthenticator(config *LDAPConfig, users *UserService) *LDAPAuthenticator {
+	return &LDAPAuthenticator{config: config, users: users}
+}
+
+func (a *LDAPAuthenticator) Name() string {
+	return AuthSourceLDAP
+}
+
+func (a *LDAPAuthenticator) Authenticate(login, password string) (*database.User, error) {
+	entry, err := a.verify(login, password)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := a.provision(entry)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.syncGroups(user, entry.GetAll(a.config.GroupAttribute)); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// verify finds the directory entry of the login and binds as it with the password
+func (a *LDAPAuthenticator) verify(login, password string) (*ldapEntry, error) {
+	conn, err := dialLDAP(a.config.URL, a.config.StartTLS, &tls.Config{InsecureSkipVerify: a.config.InsecureSkipVerify}, a.config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if a.config.BindDN != "" {
+		if err := conn.Bind(a.config.BindDN, a.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("service account bind: %w", err)
+		}
+	}
+
+	filter := strings.ReplaceAll(a.config.UserFilter, "{login}", EscapeLDAPFilterValue(login))
+	attributes := []string{a.config.UsernameAttribute, a.config.EmailAttribute, a.config.GroupAttribute}
+	entries, err := conn.Search(a.config.BaseDN, filter, attributes, 2)
+	if err != nil {
+		return nil, err
+	}
+	switch len(entries) {
+	case 0:
+		return nil, ErrAuthenticatorNotApplicable
+	case 1:
+	default:
+		return nil, fmt.Errorf("ldap: %s matches several entries", filter)
+	}
+
+	if err := conn.Bind(entries[0].DN, password); err != nil {
+		var resultErr *LDAPResultError
+		if errors.As(err, &resultErr) && resultErr.Code == ldapResultInvalidCredentials {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	return &entries[0], nil
+}
+
+// provision creates the user of a directory entry on first login and updates it on later logins.
+// Users are matched by entry DN, then by email for entries that were moved in the directory.
+func (a *LDAPAuthenticator) provision(entry *ldapEntry) (*database.User, error) {
+	email := strings.TrimSpace(entry.Get(a.config.EmailAttribute))
+	if email == "" {
+		return nil, fmt.Errorf("ldap: entry %s has no %s attribute", entry.DN, a.config.EmailAttribute)
+	}
+	username := strings.TrimSpace(entry.Get(a.config.UsernameAttribute))
+	if username == "" {
+		username, _, _ = strings.Cut(email, "@")
+	}
+
+	var user database.User
+	err := a.users.db.Where("auth_source = ? AND auth_subject = ?", AuthSourceLDAP, entry.DN).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = a.users.db.Where("email = ?", email).First(&user).Error
+	}
+
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return a.createUser(entry.DN, username, email)
+	case err != nil:
+		return nil, err
+	case user.AuthSource != AuthSourceLDAP:
+		return nil, ErrLDAPAccountConflict
+	}
+
+	updates := map[string]interface{}{}
+	if user.AuthSubject != entry.DN {
+		updates["auth_subject"] = entry.DN
+	}
+	if user.Username != username {
+		updates["username"] = username
+	}
+	if user.Email != email {
+		if err := a.users.EnsureEmailAvailable(email, user.ID); err != nil {
+			return nil, err
+		}
+		// The directory is trusted to own the address
+		updates["email"] = email
+		updates["email_verified"] = true
+		updates["email_verified_at"] = time.Now()
+	}
+	if len(updates) > 0 {
+		if err := a.users.db.Model(&user).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+func (a *LDAPAuthenticator) createUser(dn, username, email string) (*database.User, error) {
+	now := time.Now()
+	user := &database.User{
+		Username:        username,
+		Email:           email,
+		EmailVerified:   true,
+		EmailVerifiedAt: &now,
+		AuthSource:      AuthSourceLDAP,
+		AuthSubject:     dn,
+	}
+	// The directory verifies passwords, so the local hash is a random placeholder
+	if err := user.SetPassword(generateSecureToken()); err != nil {
+		return nil, err
+	}
+	if err := a.users.CreateUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// syncGroups applies the group mappings: the user gets the highest role mapped from their groups
+// in each mapped organization, and leaves mapped organizations none of their groups map to.
+// Changes that would leave an organization without an owner are skipped.
+func (a *LDAPAuthenticator) syncGroups(user *database.User, groupDNs []string) error {
+	if len(a.config.GroupMappings) == 0 {
+		return nil
+	}
+
+	groups := make(map[string]bool, len(groupDNs))
+	for _, dn := range groupDNs {
+		groups[normalizeLDAPDN(dn)] = true
+	}
+	var slugs []string
+	desired := map[string]database.OrgMemberRole{}
+	for _, mapping := range a.config.GroupMappings {
+		if _, seen := desired[mapping.OrgSlug]; !seen {
+			slugs = append(slugs, mapping.OrgSlug)
+			desired[mapping.OrgSlug] = ""
+		}
+		if groups[normalizeLDAPDN(mapping.GroupDN)] && OrgRoleAtLeast(mapping.Role, desired[mapping.OrgSlug]) {
+			desired[mapping.OrgSlug] = mapping.Role
+		}
+	}
+
+	return a.users.db.Transaction(func(tx *gorm.DB) error {
+		for _, slug := range slugs {
+			var org database.Org
+			if err := tx.Where("slug = ?", slug).First(&org).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					log.Printf("ldap group mapping refers to unknown organization %q", slug)
+					continue
+				}
+				return err
+			}
+
+			var member database.OrgMember
+			err := tx.Where("user_id = ? AND org_id = ?", user.ID, org.ID).First(&member).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			isMember := err == nil
+			role := desired[slug]
+
+			switch {
+			case role != "" && !isMember:
+				err = addOrgMember(tx, user.ID, org.ID, role)
+			case role != "" && member.Role != role:
+				err = updateOrgMemberRole(tx, user.ID, org.ID, role)
+			case role == "" && isMember:
+				err = removeOrgMember(tx, user.ID, org.ID)
+			}
+			if errors.Is(err, ErrLastOrgOwner) {
+				log.Printf("ldap group sync kept user %d as the last owner of organization %q", user.ID, slug)
+				err = nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// normalizeLDAPDN lowercases a DN and removes spaces around its separators so equivalent DNs compare equal
+func normalizeLDAPDN(dn string) string {
+	parts := strings.Split(strings.ToLower(dn), ",")
+	for i, part := range parts {
+		attribute, value, _ := strings.Cut(part, "=")
+		parts[i] = strings.TrimSpace(attribute) + "=" + strings.TrimSpace(value)
+	}
+	return strings.Join(parts, ",")
+}
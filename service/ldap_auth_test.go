@@ -0,0 +1,162 @@
+package service
+
+import (
+	"errors"
+	"miniauth/database"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLDAPGroupMappings(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []LDAPGroupMapping
+		wantErr bool
+	}{
+		{value: "", want: nil},
+		{value: "cn=eng,ou=groups,dc=example,dc=com=>engineering:admin", want: []LDAPGroupMapping{
+			{GroupDN: "cn=eng,ou=groups,dc=example,dc=com", OrgSlug: "engineering", Role: database.OrgMemberRoleAdmin},
+		}},
+		{value: " cn=eng,dc=example => engineering ; cn=ops,dc=example=>ops:guest;", want: []LDAPGroupMapping{
+			{GroupDN: "cn=eng,dc=example", OrgSlug: "engineering", Role: database.OrgMemberRoleMember},
+			{GroupDN: "cn=ops,dc=example", OrgSlug: "ops", Role: database.OrgMemberRoleGuest},
+		}},
+		{value: "cn=eng,dc=example", wantErr: true},
+		{value: "=>engineering", wantErr: true},
+		{value: "cn=eng,dc=example=>:admin", wantErr: true},
+		{value: "cn=eng,dc=example=>engineering:boss", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLDAPGroupMappings(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLDAPGroupMappings(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseLDAPGroupMappings(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeLDAPDN(t *testing.T) {
+	if got := normalizeLDAPDN("CN=Eng , OU=Groups,dc = Example"); got != "cn=eng,ou=groups,dc=example" {
+		t.Errorf("normalizeLDAPDN = %q", got)
+	}
+}
+
+func TestLDAPAuthenticator(t *testing.T) {
+	const (
+		aliceDN = "uid=alice,ou=people,dc=example,dc=com"
+		engDN   = "cn=eng,ou=groups,dc=example,dc=com"
+		leadsDN = "cn=leads,ou=groups,dc=example,dc=com"
+	)
+	serviceAccount := ldapTestEntry{DN: "cn=miniauth,dc=example,dc=com", Password: "service secret"}
+	alice := func(mail string, groups ...string) ldapTestEntry {
+		return ldapTestEntry{DN: aliceDN, Password: "alice secret", Attributes: map[string][]string{
+			"objectClass": {"person"}, "uid": {"alice"}, "mail": {mail}, "memberOf": groups,
+		}}
+	}
+	server := startLDAPTestServer(t, serviceAccount, alice("alice@example.com", "CN=Eng, OU=Groups, DC=Example, DC=Com"))
+
+	db := newTestDB(t)
+	users := NewUserService(db, &fakeMailer{}, &TokenSigner{secret: []byte("test secret")})
+	users.authenticators = []Authenticator{
+		&LocalAuthenticator{db: db},
+		NewLDAPAuthenticator(&LDAPConfig{
+			URL:               server.URL,
+			Timeout:           5 * time.Second,
+			BindDN:            serviceAccount.DN,
+			BindPassword:      serviceAccount.Password,
+			BaseDN:            "ou=people,dc=example,dc=com",
+			UserFilter:        "(&(objectClass=person)(mail={login}))",
+			UsernameAttribute: "uid",
+			EmailAttribute:    "mail",
+			GroupAttribute:    "memberOf",
+			GroupMappings: []LDAPGroupMapping{
+				{GroupDN: engDN, OrgSlug: "engineering", Role: database.OrgMemberRoleMember},
+				{GroupDN: leadsDN, OrgSlug: "engineering", Role: database.OrgMemberRoleAdmin},
+				{GroupDN: engDN, OrgSlug: "missing", Role: database.OrgMemberRoleMember},
+			},
+		}, users),
+	}
+
+	engineering := &database.Org{Name: "Engineering", Slug: "engineering"}
+	mustCreate(t, db, engineering)
+	local := &database.User{Username: "bob", Email: "bob@example.com"}
+	if err := local.SetPassword("bob secret"); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	mustCreate(t, db, local)
+
+	role := func(userID uint) database.OrgMemberRole {
+		var member database.OrgMember
+		if err := db.Where("org_id = ? AND user_id = ?", engineering.ID, userID).Limit(1).Find(&member).Error; err != nil {
+			t.Fatalf("load membership: %v", err)
+		}
+		return member.Role
+	}
+
+	// First login provisions the user from the directory
+	user, err := users.AuthenticateUser(AuditActor{}, "alice@example.com", "alice secret")
+	if err != nil {
+		t.Fatalf("AuthenticateUser: %v", err)
+	}
+	if user.Username != "alice" || user.AuthSource != AuthSourceLDAP || user.AuthSubject != aliceDN || !user.EmailVerified {
+		t.Errorf("provisioned user = %+v", user)
+	}
+	if got := role(user.ID); got != database.OrgMemberRoleMember {
+		t.Errorf("engineering role = %q, want member", got)
+	}
+	if bound := server.boundDNs(); len(bound) != 2 || bound[0] != serviceAccount.DN || bound[1] != aliceDN {
+		t.Errorf("bound as %v, want the service account then alice", bound)
+	}
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		wantErr  error
+	}{
+		{"wrong password", "alice@example.com", "wrong", ErrInvalidCredentials},
+		{"unknown login", "carol@example.com", "carol secret", ErrInvalidCredentials},
+		{"filter injection", "*", "alice secret", ErrInvalidCredentials},
+		{"local account", "bob@example.com", "bob secret", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := users.AuthenticateUser(AuditActor{}, tt.login, tt.password); !errors.Is(err, tt.wantErr) {
+				t.Errorf("AuthenticateUser = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Later logins follow the directory: a new address and group changes
+	server.setEntries(serviceAccount, alice("alice@example.org", engDN, leadsDN))
+	again, err := users.AuthenticateUser(AuditActor{}, "alice@example.org", "alice secret")
+	if err != nil {
+		t.Fatalf("AuthenticateUser after changes: %v", err)
+	}
+	if again.ID != user.ID || again.Email != "alice@example.org" {
+		t.Errorf("user after changes = %+v, want user %d with the new address", again, user.ID)
+	}
+	if got := role(user.ID); got != database.OrgMemberRoleAdmin {
+		t.Errorf("engineering role = %q, want admin from the highest mapped group", got)
+	}
+
+	server.setEntries(serviceAccount, alice("alice@example.org"))
+	if _, err := users.AuthenticateUser(AuditActor{}, "alice@example.org", "alice secret"); err != nil {
+		t.Fatalf("AuthenticateUser after leaving groups: %v", err)
+	}
+	if got := role(user.ID); got != "" {
+		t.Errorf("engineering role = %q, want removed", got)
+	}
+
+	// The directory never takes over a local account
+	server.setEntries(serviceAccount, ldapTestEntry{DN: "uid=bob,ou=people,dc=example,dc=com", Password: "directory secret",
+		Attributes: map[string][]string{"objectClass": {"person"}, "uid": {"bob"}, "mail": {"bob@example.com"}}})
+	ldap := users.authenticators[1]
+	if _, err := ldap.Authenticate("bob@example.com", "directory secret"); !errors.Is(err, ErrLDAPAccountConflict) {
+		t.Errorf("Authenticate as a local user = %v, want ErrLDAPAccountConflict", err)
+	}
+}
@@ -0,0 +1,446 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBERLength(t *testing.T) {
+	tests := []struct {
+		length int
+		want   string
+	}{
+		{0, "00"},
+		{127, "7f"},
+		{128, "8180"},
+		{255, "81ff"},
+		{256, "820100"},
+		{70000, "83011170"},
+	}
+	for _, tt := range tests {
+		encoded := berLength(tt.length)
+		if got := hex.EncodeToString(encoded); got != tt.want {
+			t.Errorf("berLength(%d) = %s, want %s", tt.length, got, tt.want)
+		}
+		length, used, err := berParseLength(encoded)
+		if err != nil || length != tt.length || used != len(encoded) {
+			t.Errorf("berParseLength(%s) = %d, %d, %v", tt.want, length, used, err)
+		}
+	}
+}
+
+func TestBERInt(t *testing.T) {
+	tests := []struct {
+		value int
+		want  string
+	}{
+		{0, "020100"},
+		{3, "020103"},
+		{127, "02017f"},
+		{128, "02020080"}, // A leading zero keeps it positive
+		{256, "02020100"},
+		{65535, "020300ffff"},
+	}
+	for _, tt := range tests {
+		encoded := berInt(berTagInteger, tt.value)
+		if got := hex.EncodeToString(encoded); got != tt.want {
+			t.Errorf("berInt(%d) = %s, want %s", tt.value, got, tt.want)
+		}
+		element, rest, err := parseBER(encoded)
+		if err != nil || len(rest) != 0 || berParseInt(element.content) != tt.value {
+			t.Errorf("parseBER(%s) = %v, %x, %v", tt.want, element, rest, err)
+		}
+	}
+	if got := berParseInt([]byte{0xff}); got != -1 {
+		t.Errorf("berParseInt(ff) = %d, want -1", got)
+	}
+}
+
+func TestParseBER(t *testing.T) {
+	long := bytes.Repeat([]byte{'x'}, 200)
+	encoded := append(berEncode(berTagOctetString, long), berEncode(berTagBoolean, []byte{0xff})...)
+
+	first, rest, err := parseBER(encoded)
+	if err != nil || first.tag != berTagOctetString || !bytes.Equal(first.content, long) {
+		t.Fatalf("parseBER first element = %v, %v", first, err)
+	}
+	second, rest, err := parseBER(rest)
+	if err != nil || second.tag != berTagBoolean || len(rest) != 0 {
+		t.Fatalf("parseBER second element = %v, %x, %v", second, rest, err)
+	}
+
+	malformed := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"tag only", "04"},
+		{"content shorter than length", "04036162"},
+		{"long form without length bytes", "0482"},
+		{"indefinite length", "0480"},
+		{"length over four bytes", "048501000000000000"},
+	}
+	for _, tt := range malformed {
+		data, _ := hex.DecodeString(tt.data)
+		if _, _, err := parseBER(data); err == nil {
+			t.Errorf("parseBER(%s) succeeded on %s input", tt.data, tt.name)
+		}
+	}
+}
+
+func TestReadBERElement(t *testing.T) {
+	message := berEncode(berTagSequence, berInt(berTagInteger, 1), berEncode(berTagOctetString, bytes.Repeat([]byte{'y'}, 300)))
+	reader := bufio.NewReader(bytes.NewReader(append(message, message...)))
+	for i := 0; i < 2; i++ {
+		element, err := readBERElement(reader)
+		if err != nil || element.tag != berTagSequence || len(element.content) != len(message)-4 {
+			t.Fatalf("readBERElement #%d = %v, %v", i, element.tag, err)
+		}
+	}
+	if _, err := readBERElement(reader); err != io.EOF {
+		t.Errorf("readBERElement at end = %v, want EOF", err)
+	}
+
+	truncated := bufio.NewReader(bytes.NewReader(message[:len(message)-1]))
+	if _, err := readBERElement(truncated); err == nil {
+		t.Error("readBERElement accepted a truncated message")
+	}
+	tooLarge := bufio.NewReader(bytes.NewReader([]byte{berTagSequence, 0x84, 0x7f, 0xff, 0xff, 0xff}))
+	if _, err := readBERElement(tooLarge); err == nil {
+		t.Error("readBERElement accepted a message over the size limit")
+	}
+}
+
+func TestCompileLDAPFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   []byte
+	}{
+		{"(uid=alice)", berEncode(ldapTagFilterEquality, berEncode(berTagOctetString, []byte("uid")), berEncode(berTagOctetString, []byte("alice")))},
+		{" (mail=*) ", berEncode(ldapTagFilterPresent, []byte("mail"))},
+		{"(uidNumber>=1000)", berEncode(ldapTagFilterGreaterOrEqual, berEncode(berTagOctetString, []byte("uidNumber")), berEncode(berTagOctetString, []byte("1000")))},
+		{"(uidNumber<=1000)", berEncode(ldapTagFilterLessOrEqual, berEncode(berTagOctetString, []byte("uidNumber")), berEncode(berTagOctetString, []byte("1000")))},
+		{"(cn~=alice)", berEncode(ldapTagFilterApproxMatch, berEncode(berTagOctetString, []byte("cn")), berEncode(berTagOctetString, []byte("alice")))},
+		{`(cn=a\2ab\28\29\5c)`, berEncode(ldapTagFilterEquality, berEncode(berTagOctetString, []byte("cn")), berEncode(berTagOctetString, []byte(`a*b()\`)))},
+		{"(cn=al*ce*)", berEncode(ldapTagFilterSubstrings, berEncode(berTagOctetString, []byte("cn")), berEncode(berTagSequence,
+			berEncode(ldapTagSubstringInitial, []byte("al")), berEncode(ldapTagSubstringAny, []byte("ce"))))},
+		{"(cn=*li*ce)", berEncode(ldapTagFilterSubstrings, berEncode(berTagOctetString, []byte("cn")), berEncode(berTagSequence,
+			berEncode(ldapTagSubstringAny, []byte("li")), berEncode(ldapTagSubstringFinal, []byte("ce"))))},
+		{"(&(objectClass=person)(!(uid=bob)))", berEncode(ldapTagFilterAnd,
+			berEncode(ldapTagFilterEquality, berEncode(berTagOctetString, []byte("objectClass")), berEncode(berTagOctetString, []byte("person"))),
+			berEncode(ldapTagFilterNot, berEncode(ldapTagFilterEquality, berEncode(berTagOctetString, []byte("uid")), berEncode(berTagOctetString, []byte("bob")))))},
+		{"(|(uid=a)(uid=b))", berEncode(ldapTagFilterOr,
+			berEncode(ldapTagFilterEquality, berEncode(berTagOctetString, []byte("uid")), berEncode(berTagOctetString, []byte("a"))),
+			berEncode(ldapTagFilterEquality, berEncode(berTagOctetString, []byte("uid")), berEncode(berTagOctetString, []byte("b"))))},
+	}
+	for _, tt := range tests {
+		got, err := compileLDAPFilter(tt.filter)
+		if err != nil {
+			t.Errorf("compileLDAPFilter(%q): %v", tt.filter, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("compileLDAPFilter(%q) = %x, want %x", tt.filter, got, tt.want)
+		}
+	}
+
+	for _, filter := range []string{
+		"",
+		"uid=alice",
+		"(uid=alice",
+		"(uid=alice))",
+		"(=alice)",
+		"(>=1)",
+		"(uidalice)",
+		"(!(uid=a)(uid=b))",
+		"(&(uid=a)",
+		`(cn=a\2)`,
+		`(cn=a\zz)`,
+	} {
+		if _, err := compileLDAPFilter(filter); err == nil {
+			t.Errorf("compileLDAPFilter(%q) succeeded, want an error", filter)
+		}
+	}
+}
+
+func TestEscapeLDAPFilterValue(t *testing.T) {
+	tests := map[string]string{
+		"alice@example.com": "alice@example.com",
+		"*)(uid=*":          `\2a\29\28uid=\2a`,
+		`a\b`:               `a\5cb`,
+		"nul\x00":           `nul\00`,
+	}
+	for value, want := range tests {
+		escaped := EscapeLDAPFilterValue(value)
+		if escaped != want {
+			t.Errorf("EscapeLDAPFilterValue(%q) = %q, want %q", value, escaped, want)
+		}
+		unescaped, err := unescapeLDAPFilterValue(escaped)
+		if err != nil || string(unescaped) != value {
+			t.Errorf("unescapeLDAPFilterValue(%q) = %q, %v", escaped, unescaped, err)
+		}
+	}
+}
+
+// ldapTestEntry is an entry of the in-process directory
+type ldapTestEntry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// ldapTestServer is an in-process LDAP directory answering simple binds and searches
+type ldapTestServer struct {
+	URL      string
+	listener net.Listener
+
+	mu      sync.Mutex
+	entries []ldapTestEntry
+	binds   []string // DNs bound successfully
+}
+
+func startLDAPTestServer(t *testing.T, entries ...ldapTestEntry) *ldapTestServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &ldapTestServer{URL: "ldap://" + listener.Addr().String(), listener: listener, entries: entries}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+// setEntries replaces the entries of the directory
+func (s *ldapTestServer) setEntries(entries ...ldapTestEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = entries
+}
+
+func (s *ldapTestServer) boundDNs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.binds...)
+}
+
+func (s *ldapTestServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	bound := false
+
+	for {
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		message, err := readBERElement(reader)
+		if err != nil {
+			return
+		}
+		id, rest, _ := parseBER(message.content)
+		op, _, _ := parseBER(rest)
+		respond := func(ops ...[]byte) {
+			for _, op := range ops {
+				conn.Write(berEncode(berTagSequence, berEncode(berTagInteger, id.content), op))
+			}
+		}
+		result := func(tag byte, code int, message string) []byte {
+			return berEncode(tag, berInt(berTagEnumerated, code), berEncode(berTagOctetString), berEncode(berTagOctetString, []byte(message)))
+		}
+
+		switch op.tag {
+		case ldapTagBindRequest:
+			_, fields, _ := parseBER(op.content) // version
+			name, fields, _ := parseBER(fields)
+			password, _, _ := parseBER(fields)
+			bound = s.bind(string(name.content), string(password.content))
+			if !bound {
+				respond(result(ldapTagBindResponse, ldapResultInvalidCredentials, "invalid credentials"))
+				continue
+			}
+			respond(result(ldapTagBindResponse, ldapResultSuccess, ""))
+		case ldapTagSearchRequest:
+			if !bound {
+				respond(result(ldapTagSearchResultDone, 50, "bind required")) // insufficientAccessRights
+				continue
+			}
+			baseDN, fields, _ := parseBER(op.content)
+			_, fields, _ = parseBER(fields) // scope
+			_, fields, _ = parseBER(fields) // derefAliases
+			sizeLimit, fields, _ := parseBER(fields)
+			_, fields, _ = parseBER(fields) // timeLimit
+			_, fields, _ = parseBER(fields) // typesOnly
+			filter, _, _ := parseBER(fields)
+
+			var ops [][]byte
+			code := ldapResultSuccess
+			for _, entry := range s.search(string(baseDN.content), filter) {
+				if limit := berParseInt(sizeLimit.content); limit > 0 && len(ops) == limit {
+					code = ldapResultSizeLimitExceeded
+					break
+				}
+				ops = append(ops, encodeLDAPTestEntry(entry))
+			}
+			respond(append(ops, result(ldapTagSearchResultDone, code, ""))...)
+		case ldapTagUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (s *ldapTestServer) bind(dn, password string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.DN, dn) && entry.Password != "" && entry.Password == password {
+			s.binds = append(s.binds, entry.DN)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ldapTestServer) search(baseDN string, filter berElement) []ldapTestEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matches []ldapTestEntry
+	for _, entry := range s.entries {
+		if strings.HasSuffix(strings.ToLower(entry.DN), strings.ToLower(baseDN)) && matchLDAPTestFilter(filter, entry) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches
+}
+
+// matchLDAPTestFilter evaluates the and, or, not, equality and presence filters the authenticator uses
+func matchLDAPTestFilter(filter berElement, entry ldapTestEntry) bool {
+	values := func(attribute string) []string {
+		for name, values := range entry.Attributes {
+			if strings.EqualFold(name, attribute) {
+				return values
+			}
+		}
+		return nil
+	}
+
+	switch filter.tag {
+	case ldapTagFilterAnd, ldapTagFilterOr:
+		matchedAny := false
+		for data := filter.content; len(data) > 0; {
+			var child berElement
+			child, data, _ = parseBER(data)
+			matched := matchLDAPTestFilter(child, entry)
+			if filter.tag == ldapTagFilterAnd && !matched {
+				return false
+			}
+			matchedAny = matchedAny || matched
+		}
+		return filter.tag == ldapTagFilterAnd || matchedAny
+	case ldapTagFilterNot:
+		child, _, _ := parseBER(filter.content)
+		return !matchLDAPTestFilter(child, entry)
+	case ldapTagFilterEquality:
+		attribute, rest, _ := parseBER(filter.content)
+		value, _, _ := parseBER(rest)
+		for _, v := range values(string(attribute.content)) {
+			if strings.EqualFold(v, string(value.content)) {
+				return true
+			}
+		}
+		return false
+	case ldapTagFilterPresent:
+		return len(values(string(filter.content))) > 0
+	}
+	return false
+}
+
+func encodeLDAPTestEntry(entry ldapTestEntry) []byte {
+	var attributes [][]byte
+	for name, values := range entry.Attributes {
+		var encoded [][]byte
+		for _, value := range values {
+			encoded = append(encoded, berEncode(berTagOctetString, []byte(value)))
+		}
+		attributes = append(attributes, berEncode(berTagSequence, berEncode(berTagOctetString, []byte(name)), berEncode(0x31, encoded...)))
+	}
+	return berEncode(ldapTagSearchResultEntry, berEncode(berTagOctetString, []byte(entry.DN)), berEncode(berTagSequence, attributes...))
+}
+
+func TestLDAPConnBindAndSearch(t *testing.T) {
+	server := startLDAPTestServer(t,
+		ldapTestEntry{DN: "cn=admin,dc=example,dc=com", Password: "admin secret"},
+		ldapTestEntry{DN: "uid=alice,ou=people,dc=example,dc=com", Password: "alice secret", Attributes: map[string][]string{
+			"objectClass": {"person"}, "uid": {"alice"}, "mail": {"alice@example.com"},
+			"memberOf": {"cn=eng,ou=groups,dc=example,dc=com", "cn=ops,ou=groups,dc=example,dc=com"},
+		}},
+		ldapTestEntry{DN: "uid=bob,ou=people,dc=example,dc=com", Attributes: map[string][]string{
+			"objectClass": {"person"}, "uid": {"bob"}, "mail": {"bob@example.com"},
+		}},
+	)
+
+	conn, err := dialLDAP(server.URL, false, nil, 5*time.Second)
+	if err != nil {
+		t.Fatalf("dialLDAP: %v", err)
+	}
+	defer conn.Close()
+
+	var resultErr *LDAPResultError
+	if err := conn.Bind("cn=admin,dc=example,dc=com", "wrong"); !errors.As(err, &resultErr) || resultErr.Code != ldapResultInvalidCredentials {
+		t.Fatalf("Bind with a wrong password = %v, want invalid credentials", err)
+	}
+	if err := conn.Bind("cn=admin,dc=example,dc=com", ""); !errors.As(err, &resultErr) || resultErr.Code != ldapResultInvalidCredentials {
+		t.Fatalf("Bind with an empty password = %v, want invalid credentials", err)
+	}
+	if err := conn.Bind("cn=admin,dc=example,dc=com", "admin secret"); err != nil {
+		t.Fatalf("Bind: %v", err)
+	}
+
+	entries, err := conn.Search("ou=people,dc=example,dc=com", "(&(objectClass=person)(mail=ALICE@example.com))", []string{"uid", "mail", "memberOf"}, 2)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(entries) != 1 || entries[0].DN != "uid=alice,ou=people,dc=example,dc=com" {
+		t.Fatalf("Search = %+v, want alice", entries)
+	}
+	if entries[0].Get("MAIL") != "alice@example.com" || len(entries[0].GetAll("memberof")) != 2 || entries[0].Get("cn") != "" {
+		t.Errorf("entry attributes = %+v", entries[0].Attributes)
+	}
+
+	// Hitting the size limit returns the entries received so far
+	entries, err = conn.Search("dc=example,dc=com", "(objectClass=person)", nil, 1)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Search over the size limit = %d entries, %v", len(entries), err)
+	}
+
+	entries, err = conn.Search("dc=example,dc=com", "(!(uid=*))", nil, 0)
+	if err != nil || len(entries) != 1 || entries[0].DN != "cn=admin,dc=example,dc=com" {
+		t.Fatalf("Search with not = %+v, %v", entries, err)
+	}
+
+	if _, err := conn.Search("dc=example,dc=com", "(objectClass=person", nil, 0); err == nil {
+		t.Error("Search accepted an invalid filter")
+	}
+}
+
+func TestDialLDAPRejectsUnsupportedScheme(t *testing.T) {
+	if _, err := dialLDAP("http://localhost", false, nil, time.Second); err == nil {
+		t.Error("dialLDAP accepted an http URL")
+	}
+}
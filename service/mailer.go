@@ -0,0 +1,114 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MailMessage represents an outgoing email
+type MailMessage struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(msg MailMessage) error
+}
+
+// NewMailerFromEnv creates a mailer based on the MAIL_BACKEND environment variable
+//
+// Supported backends:
+//   - log (default): writes messages to stdout
+//   - file: appends messages to MAIL_FILE_PATH
+//   - smtp: sends messages through SMTP_HOST:SMTP_PORT
+func NewMailerFromEnv() Mailer {
+	from := getEnv("MAIL_FROM", "miniauth@localhost")
+
+	switch getEnv("MAIL_BACKEND", "log") {
+	case "smtp":
+		return &SMTPMailer{
+			Host:     getEnv("SMTP_HOST", "localhost"),
+			Port:     getEnv("SMTP_PORT", "587"),
+			Username: getEnv("SMTP_USERNAME", ""),
+			Password: getEnv("SMTP_PASSWORD", ""),
+			From:     from,
+		}
+	case "file":
+		path := getEnv("MAIL_FILE_PATH", "mail.log")
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			log.Printf("failed to open mail file %s, falling back to stdout: %v", path, err)
+			return NewLogMailer(os.Stdout, from)
+		}
+		return NewLogMailer(file, from)
+	default:
+		return NewLogMailer(os.Stdout, from)
+	}
+}
+
+// SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send sends an email through the configured SMTP server
+func (m *SMTPMailer) Send(msg MailMessage) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	addr := m.Host + ":" + m.Port
+	if err := smtp.SendMail(addr, auth, m.From, msg.To, formatMailMessage(m.From, msg)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// LogMailer writes emails to a writer instead of sending them (development and tests)
+type LogMailer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from string
+}
+
+// NewLogMailer creates a mailer that writes messages to w
+func NewLogMailer(w io.Writer, from string) *LogMailer {
+	return &LogMailer{w: w, from: from}
+}
+
+// Send writes the email to the underlying writer
+func (m *LogMailer) Send(msg MailMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.w, "----- %s -----\n%s\n", time.Now().Format(time.RFC3339), formatMailMessage(m.from, msg))
+	return err
+}
+
+// formatMailMessage renders an RFC 5322 plain text message
+func formatMailMessage(from string, msg MailMessage) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + strings.Join(msg.To, ", ") + "\r\n")
+	b.WriteString("Subject: " + msg.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
@@ -1,19 +1,61 @@
 package service
 
-import "gorm.io/gorm"
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
 
 // ServiceManager holds all service instances
 type ServiceManager struct {
-	User  *UserService
-	Org   *OrgService
-	OAuth *OAuthService
+	User            *UserService
+	Org             *OrgService
+	Permission      *PermissionService
+	Authz           *AuthzService
+	OAuth           *OAuthService
+	Audit           *AuditService
+	Webhook         *WebhookService
+	Scim            *ScimService
+	Federation      *FederationService
+	SAML            *SAMLService
+	Tokens          *PersonalAccessTokenService
+	ServiceAccounts *ServiceAccountService
+	APIKeys         *APIKeyService
+	Mailer          Mailer
 }
 
-// NewServiceManager creates a new service manager with all services initialized
-func NewServiceManager(db *gorm.DB) *ServiceManager {
-	return &ServiceManager{
-		User:  NewUserService(db),
-		Org:   NewOrgService(db),
-		OAuth: NewOAuthService(db),
+// NewServiceManager creates a new service manager with all services initialized. It returns an
+// error when the configuration is invalid.
+func NewServiceManager(db *gorm.DB) (*ServiceManager, error) {
+	if _, err := LoadEmailVerificationPolicy(); err != nil {
+		return nil, err
+	}
+
+	mailer := NewMailerFromEnv()
+	signer := NewTokenSignerFromEnv()
+	keyPair, err := NewSigningKeyPairFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing key: %w", err)
 	}
+
+	userService := NewUserService(db, mailer, signer)
+	orgService := NewOrgService(db, mailer)
+	permissionService := NewPermissionService(db)
+
+	return &ServiceManager{
+		User:            userService,
+		Org:             orgService,
+		Permission:      permissionService,
+		Authz:           NewAuthzService(db, orgService, permissionService),
+		OAuth:           NewOAuthService(db),
+		Audit:           NewAuditService(db),
+		Webhook:         NewWebhookService(db),
+		Scim:            NewScimService(db, userService),
+		Federation:      NewFederationService(db, userService, signer),
+		SAML:            NewSAMLService(db, keyPair, signer),
+		Tokens:          NewPersonalAccessTokenService(db),
+		ServiceAccounts: NewServiceAccountService(db),
+		APIKeys:         NewAPIKeyService(db),
+		Mailer:          mailer,
+	}, nil
 }
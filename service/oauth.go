@@ -3,6 +3,7 @@ package service
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -34,6 +35,7 @@ type AuthorizeRequest struct {
 	State               string `json:"state"`
 	CodeChallenge       string `json:"code_challenge"`
 	CodeChallengeMethod string `json:"code_challenge_method"`
+	Organization        string `json:"organization"` // Slug of the active organization chosen by the user
 }
 
 type TokenRequest struct {
@@ -47,11 +49,12 @@ type TokenRequest struct {
 }
 
 type TokenResponse struct {
-	AccessToken  string `json:"access_token"`
-	TokenType    string `json:"token_type"`
-	ExpiresIn    int    `json:"expires_in"`
-	RefreshToken string `json:"refresh_token,omitempty"`
-	Scope        string `json:"scope"`
+	AccessToken  string             `json:"access_token"`
+	TokenType    string             `json:"token_type"`
+	ExpiresIn    int                `json:"expires_in"`
+	RefreshToken string             `json:"refresh_token,omitempty"`
+	Scope        string             `json:"scope"`
+	Organization *TokenOrganization `json:"organization,omitempty"`
 }
 
 type ApplicationCreateRequest struct {
@@ -61,6 +64,7 @@ type ApplicationCreateRequest struct {
 	Description  string   `json:"description"`
 	Website      string   `json:"website"`
 	Trusted      bool     `json:"trusted"`
+	MembersOnly  bool     `json:"members_only"` // Only applies to applications owned by an organization
 }
 
 // InternalApplicationCreateRequest allows specifying custom client_id and secret for internal use
@@ -81,11 +85,14 @@ type ApplicationResponse struct {
 	Description  string   `json:"description"`
 	Website      string   `json:"website"`
 	ClientID     string   `json:"client_id"`
-	ClientSecret string   `json:"client_secret"`
+	ClientSecret string   `json:"client_secret,omitempty"`
 	RedirectURIs []string `json:"redirect_uris"`
 	Scopes       []string `json:"scopes"`
 	Trusted      bool     `json:"trusted"`
 	Active       bool     `json:"active"`
+	OrgID        *uint    `json:"org_id,omitempty"`
+	OrgSlug      string   `json:"org_slug,omitempty"`
+	MembersOnly  bool     `json:"members_only"`
 	CreatedBy    string   `json:"created_by"`
 	CreatedAt    string   `json:"created_at"`
 }
@@ -115,7 +122,7 @@ func (s *OAuthService) CreateApplication(adminUserID uint, req ApplicationCreate
 		ClientSecret: clientSecret,
 		RedirectURIs: string(redirectURIsJSON),
 		Scopes:       strings.Join(scopes, " "),
-		CreatedByID:  adminUserID,
+		CreatedByID:  &adminUserID,
 		Trusted:      req.Trusted,
 		Active:       true,
 	}
@@ -135,13 +142,14 @@ func (s *OAuthService) CreateApplication(adminUserID uint, req ApplicationCreate
 		Scopes:       scopes,
 		Trusted:      app.Trusted,
 		Active:       app.Active,
-		CreatedBy:    app.CreatedBy.Username,
+		CreatedBy:    applicationCreator(app),
 		CreatedAt:    app.CreatedAt.Format(time.RFC3339),
 	}, nil
 }
 
-// CreateApplicationWithCustomCredentials creates a new OAuth application with specified client_id and secret (internal use)
-func (s *OAuthService) CreateApplicationWithCustomCredentials(adminUserID uint, req InternalApplicationCreateRequest) (*ApplicationResponse, error) {
+// CreateApplicationWithCustomCredentials creates a new OAuth application with specified client_id and secret (internal use).
+// The application is attributed to the admin or service account acting.
+func (s *OAuthService) CreateApplicationWithCustomCredentials(actor AuditActor, req InternalApplicationCreateRequest) (*ApplicationResponse, error) {
 	// Check if client_id already exists
 	var existingApp database.OAuthApplication
 	if err := s.db.Where("client_id = ?", req.ClientID).First(&existingApp).Error; err == nil {
@@ -169,14 +177,32 @@ func (s *OAuthService) CreateApplicationWithCustomCredentials(adminUserID uint,
 		ClientSecret: req.ClientSecret, // Use provided client secret
 		RedirectURIs: string(redirectURIsJSON),
 		Scopes:       strings.Join(scopes, " "),
-		CreatedByID:  adminUserID,
+		CreatedByID:  actor.UserID,
 		Trusted:      req.Trusted,
 		Active:       true,
+
+		CreatedByServiceAccountID: actor.ServiceAccountID,
 	}
 
-	if err := s.db.Preload("CreatedBy").Create(app).Error; err != nil {
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(app).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, auditEntry{
+			Action:     AuditActionApplicationCreated,
+			TargetType: AuditTargetApplication,
+			TargetID:   app.ClientID,
+			Metadata: map[string]interface{}{
+				"name": app.Name,
+			},
+		})
+	})
+	if err != nil {
 		return nil, fmt.Errorf("failed to create OAuth application: %w", err)
 	}
+	if err := s.db.Preload("CreatedBy").Preload("CreatedByServiceAccount").First(app, app.ID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load OAuth application: %w", err)
+	}
 
 	return &ApplicationResponse{
 		ID:           app.ID,
@@ -189,7 +215,7 @@ func (s *OAuthService) CreateApplicationWithCustomCredentials(adminUserID uint,
 		Scopes:       scopes,
 		Trusted:      app.Trusted,
 		Active:       app.Active,
-		CreatedBy:    app.CreatedBy.Username,
+		CreatedBy:    applicationCreator(app),
 		CreatedAt:    app.CreatedAt.Format(time.RFC3339),
 	}, nil
 }
@@ -197,36 +223,13 @@ func (s *OAuthService) CreateApplicationWithCustomCredentials(adminUserID uint,
 // GetAllApplications retrieves all OAuth applications (admin only)
 func (s *OAuthService) GetAllApplications() ([]*ApplicationResponse, error) {
 	var apps []database.OAuthApplication
-	if err := s.db.Preload("CreatedBy").Find(&apps).Error; err != nil {
+	if err := s.db.Preload("CreatedBy").Preload("CreatedByServiceAccount").Preload("Org").Find(&apps).Error; err != nil {
 		return nil, fmt.Errorf("failed to get applications: %w", err)
 	}
 
 	result := make([]*ApplicationResponse, len(apps))
-	for i, app := range apps {
-		var redirectURIs []string
-		if err := json.Unmarshal([]byte(app.RedirectURIs), &redirectURIs); err != nil {
-			redirectURIs = []string{}
-		}
-
-		scopes := strings.Split(app.Scopes, " ")
-		if len(scopes) == 1 && scopes[0] == "" {
-			scopes = []string{}
-		}
-
-		result[i] = &ApplicationResponse{
-			ID:           app.ID,
-			Name:         app.Name,
-			Description:  app.Description,
-			Website:      app.Website,
-			ClientID:     app.ClientID,
-			ClientSecret: app.ClientSecret,
-			RedirectURIs: redirectURIs,
-			Scopes:       scopes,
-			Trusted:      app.Trusted,
-			Active:       app.Active,
-			CreatedBy:    app.CreatedBy.Username,
-			CreatedAt:    app.CreatedAt.Format(time.RFC3339),
-		}
+	for i := range apps {
+		result[i] = newApplicationResponse(&apps[i])
 	}
 
 	return result, nil
@@ -235,36 +238,13 @@ func (s *OAuthService) GetAllApplications() ([]*ApplicationResponse, error) {
 // GetActiveApplications retrieves all active OAuth applications
 func (s *OAuthService) GetActiveApplications() ([]*ApplicationResponse, error) {
 	var apps []database.OAuthApplication
-	if err := s.db.Preload("CreatedBy").Where("active = ?", true).Find(&apps).Error; err != nil {
+	if err := s.db.Preload("CreatedBy").Preload("CreatedByServiceAccount").Preload("Org").Where("active = ?", true).Find(&apps).Error; err != nil {
 		return nil, fmt.Errorf("failed to get active applications: %w", err)
 	}
 
 	result := make([]*ApplicationResponse, len(apps))
-	for i, app := range apps {
-		var redirectURIs []string
-		if err := json.Unmarshal([]byte(app.RedirectURIs), &redirectURIs); err != nil {
-			redirectURIs = []string{}
-		}
-
-		scopes := strings.Split(app.Scopes, " ")
-		if len(scopes) == 1 && scopes[0] == "" {
-			scopes = []string{}
-		}
-
-		result[i] = &ApplicationResponse{
-			ID:           app.ID,
-			Name:         app.Name,
-			Description:  app.Description,
-			Website:      app.Website,
-			ClientID:     app.ClientID,
-			ClientSecret: app.ClientSecret,
-			RedirectURIs: redirectURIs,
-			Scopes:       scopes,
-			Trusted:      app.Trusted,
-			Active:       app.Active,
-			CreatedBy:    app.CreatedBy.Username,
-			CreatedAt:    app.CreatedAt.Format(time.RFC3339),
-		}
+	for i := range apps {
+		result[i] = newApplicationResponse(&apps[i])
 	}
 
 	return result, nil
@@ -286,6 +266,11 @@ func (s *OAuthService) ValidateAuthorizationRequest(req AuthorizeRequest) (*data
 		return nil, fmt.Errorf("failed to get OAuth application: %w", err)
 	}
 
+	// Applications of deleted organizations cannot be authorized
+	if err := s.ensureApplicationOrgActive(&app); err != nil {
+		return nil, fmt.Errorf("invalid or inactive client_id")
+	}
+
 	// Validate redirect URI
 	var redirectURIs []string
 	if err := json.Unmarshal([]byte(app.RedirectURIs), &redirectURIs); err != nil {
@@ -314,8 +299,8 @@ func (s *OAuthService) ValidateAuthorizationRequest(req AuthorizeRequest) (*data
 	return &app, nil
 }
 
-// CreateAuthorizationCode creates an authorization code for a user
-func (s *OAuthService) CreateAuthorizationCode(userID uint, app *database.OAuthApplication, req AuthorizeRequest) (string, error) {
+// CreateAuthorizationCode creates an authorization code for a user, bound to the active organization if one was chosen
+func (s *OAuthService) CreateAuthorizationCode(userID uint, app *database.OAuthApplication, req AuthorizeRequest, org *TokenOrganization) (string, error) {
 	// Generate authorization code
 	code := s.generateAuthorizationCode()
 
@@ -338,6 +323,9 @@ func (s *OAuthService) CreateAuthorizationCode(userID uint, app *database.OAuthA
 		CodeChallenge:       req.CodeChallenge,
 		CodeChallengeMethod: req.CodeChallengeMethod,
 	}
+	if org != nil {
+		authCode.OrgID = &org.ID
+	}
 
 	if err := s.db.Create(authCode).Error; err != nil {
 		return "", fmt.Errorf("failed to create authorization code: %w", err)
@@ -347,7 +335,13 @@ func (s *OAuthService) CreateAuthorizationCode(userID uint, app *database.OAuthA
 }
 
 // ExchangeCodeForToken exchanges an authorization code for access token
-func (s *OAuthService) ExchangeCodeForToken(req TokenRequest) (*TokenResponse, error) {
+func (s *OAuthService) ExchangeCodeForToken(actor AuditActor, req TokenRequest) (_ *TokenResponse, err error) {
+	defer func() {
+		if err != nil {
+			s.auditTokenGrant(actor, req, nil, err)
+		}
+	}()
+
 	// Validate grant type
 	if req.GrantType != "authorization_code" {
 		return nil, fmt.Errorf("unsupported grant_type: %s", req.GrantType)
@@ -400,6 +394,12 @@ func (s *OAuthService) ExchangeCodeForToken(req TokenRequest) (*TokenResponse, e
 		}
 	}
 
+	// The user must still belong to the organization chosen during authorization
+	org, err := s.boundOrganization(authCode.UserID, authCode.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("organization is no longer available: %w", err)
+	}
+
 	// Mark authorization code as used
 	if err := s.db.Model(&authCode).Update("used", true).Error; err != nil {
 		return nil, fmt.Errorf("failed to mark authorization code as used: %w", err)
@@ -415,6 +415,7 @@ func (s *OAuthService) ExchangeCodeForToken(req TokenRequest) (*TokenResponse, e
 		ClientID:  req.ClientID,
 		UserID:    authCode.UserID,
 		Scopes:    authCode.Scopes,
+		OrgID:     authCode.OrgID,
 		ExpiresAt: time.Now().Add(1 * time.Hour), // Access tokens expire in 1 hour
 	}
 
@@ -435,17 +436,26 @@ func (s *OAuthService) ExchangeCodeForToken(req TokenRequest) (*TokenResponse, e
 		return nil, fmt.Errorf("failed to create refresh token: %w", err)
 	}
 
+	s.auditTokenGrant(actor, req, accessTokenRecord, nil)
+
 	return &TokenResponse{
 		AccessToken:  accessToken,
 		TokenType:    "Bearer",
 		ExpiresIn:    3600, // 1 hour in seconds
 		RefreshToken: refreshToken,
 		Scope:        authCode.Scopes,
+		Organization: org,
 	}, nil
 }
 
 // RefreshAccessToken refreshes an access token using a refresh token
-func (s *OAuthService) RefreshAccessToken(req TokenRequest) (*TokenResponse, error) {
+func (s *OAuthService) RefreshAccessToken(actor AuditActor, req TokenRequest) (_ *TokenResponse, err error) {
+	defer func() {
+		if err != nil {
+			s.auditTokenGrant(actor, req, nil, err)
+		}
+	}()
+
 	// Validate grant type
 	if req.GrantType != "refresh_token" {
 		return nil, fmt.Errorf("unsupported grant_type: %s", req.GrantType)
@@ -470,16 +480,42 @@ func (s *OAuthService) RefreshAccessToken(req TokenRequest) (*TokenResponse, err
 		return nil, fmt.Errorf("client_id mismatch")
 	}
 
+	// Users who can no longer authorize the application cannot refresh their tokens either
+	var app database.OAuthApplication
+	if err := s.db.Where("client_id = ? AND active = ?", req.ClientID, true).First(&app).Error; err != nil {
+		return nil, fmt.Errorf("invalid or inactive client_id")
+	}
+	if err := s.ensureApplicationOrgActive(&app); err != nil {
+		return nil, fmt.Errorf("invalid or inactive client_id")
+	}
+	if err := s.CheckApplicationAccess(&app, refreshTokenRecord.UserID); err != nil {
+		return nil, err
+	}
+
 	// Get the associated access token to get scopes
 	var oldAccessToken database.OAuthAccessToken
 	if err := s.db.Where("token = ?", refreshTokenRecord.AccessToken).First(&oldAccessToken).Error; err != nil {
 		return nil, fmt.Errorf("failed to get associated access token: %w", err)
 	}
 
+	// The user must still belong to the organization chosen during authorization
+	org, err := s.boundOrganization(refreshTokenRecord.UserID, oldAccessToken.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("organization is no longer available: %w", err)
+	}
+
 	// Revoke old access token
 	if err := s.db.Model(&oldAccessToken).Update("revoked", true).Error; err != nil {
 		return nil, fmt.Errorf("failed to revoke old access token: %w", err)
 	}
+	if err := emitWebhookEvent(s.db, WebhookEventTokenRevoked, map[string]interface{}{
+		"user_id":    oldAccessToken.UserID,
+		"client_id":  oldAccessToken.ClientID,
+		"token_type": "access_token",
+		"reason":     "refreshed",
+	}); err != nil {
+		return nil, fmt.Errorf("failed to queue webhook event: %w", err)
+	}
 
 	// Generate new access token
 	newAccessToken := s.generateAccessToken()
@@ -490,6 +526,7 @@ func (s *OAuthService) RefreshAccessToken(req TokenRequest) (*TokenResponse, err
 		ClientID:  req.ClientID,
 		UserID:    refreshTokenRecord.UserID,
 		Scopes:    oldAccessToken.Scopes,
+		OrgID:     oldAccessToken.OrgID,
 		ExpiresAt: time.Now().Add(1 * time.Hour), // Access tokens expire in 1 hour
 	}
 
@@ -502,32 +539,64 @@ func (s *OAuthService) RefreshAccessToken(req TokenRequest) (*TokenResponse, err
 		return nil, fmt.Errorf("failed to update refresh token: %w", err)
 	}
 
+	s.auditTokenGrant(actor, req, newAccessTokenRecord, nil)
+
 	return &TokenResponse{
 		AccessToken:  newAccessToken,
 		TokenType:    "Bearer",
 		ExpiresIn:    3600, // 1 hour in seconds
 		RefreshToken: req.RefreshToken,
 		Scope:        oldAccessToken.Scopes,
+		Organization: org,
 	}, nil
 }
 
-// ValidateAccessToken validates an access token and returns user info
-func (s *OAuthService) ValidateAccessToken(token string) (*database.User, []string, error) {
+// ValidateAccessToken validates an access token and returns user info together with the
+// active organization bound to the token, if any
+func (s *OAuthService) ValidateAccessToken(token string) (*database.User, []string, *TokenOrganization, error) {
 	var accessToken database.OAuthAccessToken
 	if err := s.db.Preload("User").Where("token = ? AND revoked = false", token).First(&accessToken).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, nil, fmt.Errorf("invalid access token")
+			return nil, nil, nil, fmt.Errorf("invalid access token")
 		}
-		return nil, nil, fmt.Errorf("failed to get access token: %w", err)
+		return nil, nil, nil, fmt.Errorf("failed to get access token: %w", err)
 	}
 
 	// Check if token is expired
 	if time.Now().After(accessToken.ExpiresAt) {
-		return nil, nil, fmt.Errorf("access token expired")
+		return nil, nil, nil, fmt.Errorf("access token expired")
+	}
+
+	// Tokens bound to an organization stop working once the user leaves it
+	org, err := s.boundOrganization(accessToken.UserID, accessToken.OrgID)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("organization is no longer available: %w", err)
 	}
 
 	scopes := strings.Split(accessToken.Scopes, " ")
-	return &accessToken.User, scopes, nil
+	return &accessToken.User, scopes, org, nil
+}
+
+// AuthenticateClient validates the credentials of an active OAuth application
+func (s *OAuthService) AuthenticateClient(clientID, clientSecret string) (*database.OAuthApplication, error) {
+	if clientID == "" || clientSecret == "" {
+		return nil, fmt.Errorf("invalid client credentials")
+	}
+
+	var app database.OAuthApplication
+	if err := s.db.Where("client_id = ? AND active = ?", clientID, true).First(&app).Error; err != nil {
+		return nil, fmt.Errorf("invalid client credentials")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(app.ClientSecret), []byte(clientSecret)) != 1 {
+		return nil, fmt.Errorf("invalid client credentials")
+	}
+
+	if err := s.ensureApplicationOrgActive(&app); err != nil {
+		return nil, fmt.Errorf("invalid client credentials")
+	}
+
+	return &app, nil
 }
 
 // Helper functions
@@ -633,6 +702,11 @@ func (s *OAuthService) DeleteApplication(appID uint) error {
 			return err
 		}
 
+		// Delete API keys
+		if err := tx.Where("application_id = ?", app.ID).Delete(&database.APIKey{}).Error; err != nil {
+			return err
+		}
+
 		// Delete the application
 		if err := tx.Delete(&app).Error; err != nil {
 			return err
@@ -645,7 +719,7 @@ func (s *OAuthService) DeleteApplication(appID uint) error {
 // UpdateApplication updates an OAuth application (admin only)
 func (s *OAuthService) UpdateApplication(appID uint, req ApplicationCreateRequest) (*ApplicationResponse, error) {
 	var app database.OAuthApplication
-	if err := s.db.Preload("CreatedBy").Where("id = ?", appID).First(&app).Error; err != nil {
+	if err := s.db.Preload("CreatedBy").Preload("CreatedByServiceAccount").Preload("Org").Where("id = ?", appID).First(&app).Error; err != nil {
 		return nil, err
 	}
 
@@ -667,25 +741,13 @@ func (s *OAuthService) UpdateApplication(appID uint, req ApplicationCreateReques
 	app.RedirectURIs = string(redirectURIsJSON)
 	app.Scopes = strings.Join(scopes, " ")
 	app.Trusted = req.Trusted
+	app.MembersOnly = app.OrgID != nil && req.MembersOnly
 
-	if err := s.db.Save(&app).Error; err != nil {
+	if err := s.db.Omit("CreatedBy", "CreatedByServiceAccount", "Org").Save(&app).Error; err != nil {
 		return nil, fmt.Errorf("failed to update OAuth application: %w", err)
 	}
 
-	return &ApplicationResponse{
-		ID:           app.ID,
-		Name:         app.Name,
-		Description:  app.Description,
-		Website:      app.Website,
-		ClientID:     app.ClientID,
-		ClientSecret: app.ClientSecret,
-		RedirectURIs: req.RedirectURIs,
-		Scopes:       scopes,
-		Trusted:      app.Trusted,
-		Active:       app.Active,
-		CreatedBy:    app.CreatedBy.Username,
-		CreatedAt:    app.CreatedAt.Format(time.RFC3339),
-	}, nil
+	return newApplicationResponse(&app), nil
 }
 
 // ToggleApplicationStatus toggles the active status of an OAuth application
@@ -696,7 +758,22 @@ func (s *OAuthService) ToggleApplicationStatus(appID uint) error {
 	}
 
 	app.Active = !app.Active
-	return s.db.Save(&app).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&app).Error; err != nil {
+			return err
+		}
+
+		event := WebhookEventOAuthAppDisabled
+		if app.Active {
+			event = WebhookEventOAuthAppEnabled
+		}
+		return emitWebhookEvent(tx, event, map[string]interface{}{
+			"id":        app.ID,
+			"client_id": app.ClientID,
+			"name":      app.Name,
+			"org_id":    app.OrgID,
+		})
+	})
 }
 
 // ToggleApplicationTrustedStatus toggles the trusted status of an OAuth application
@@ -709,3 +786,41 @@ func (s *OAuthService) ToggleApplicationTrustedStatus(appID uint) error {
 	app.Trusted = !app.Trusted
 	return s.db.Save(&app).Error
 }
+
+// auditTokenGrant records the outcome of a token request. The access token is nil when the request failed.
+func (s *OAuthService) auditTokenGrant(actor AuditActor, req TokenRequest, accessToken *database.OAuthAccessToken, grantErr error) {
+	actor.ClientID = req.ClientID
+	entry := auditEntry{
+		Action:   AuditActionTokenGranted,
+		Outcome:  AuditOutcomeSuccess,
+		Metadata: map[string]interface{}{"grant_type": req.GrantType},
+	}
+	if accessToken != nil {
+		entry.TargetType = AuditTargetUser
+		entry.TargetID = auditID(accessToken.UserID)
+		entry.OrgID = accessToken.OrgID
+		entry.Metadata["scope"] = accessToken.Scopes
+	}
+	if grantErr != nil {
+		entry.Outcome = AuditOutcomeFailure
+		entry.Metadata["error"] = grantErr.Error()
+	}
+	recordAuditOrLog(s.db, actor, entry)
+}
+
+// AuditClientSecretReads records that the client secrets of applications were disclosed to the actor.
+// Applications whose secret has been hidden from the response are skipped.
+func (s *OAuthService) AuditClientSecretReads(actor AuditActor, apps ...*ApplicationResponse) {
+	for _, app := range apps {
+		if app.ClientSecret == "" {
+			continue
+		}
+		recordAuditOrLog(s.db, actor, auditEntry{
+			Action:     AuditActionClientSecretRead,
+			TargetType: AuditTargetApplication,
+			TargetID:   auditID(app.ID),
+			OrgID:      app.OrgID,
+			Metadata:   map[string]interface{}{"client_id": app.ClientID},
+		})
+	}
+}
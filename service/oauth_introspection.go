@@ -0,0 +1,101 @@
+package service
+
+import (
+	"errors"
+	"miniauth/database"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TokenIntrospection describes an access token as reported by the introspection endpoint (RFC 7662).
+// Inactive tokens report nothing else.
+type TokenIntrospection struct {
+	Active    bool                   `json:"active"`
+	Scope     string                 `json:"scope,omitempty"`
+	ClientID  string                 `json:"client_id,omitempty"`
+	Username  string                 `json:"username,omitempty"`
+	TokenType string                 `json:"token_type,omitempty"`
+	ExpiresAt int64                  `json:"exp,omitempty"`
+	IssuedAt  int64                  `json:"iat,omitempty"`
+	Subject   string                 `json:"sub,omitempty"`
+	OrgID     uint                   `json:"org_id,omitempty"` // Active organization bound to the token, if any
+	OrgSlug   string                 `json:"org_slug,omitempty"`
+	OrgRole   database.OrgMemberRole `json:"org_role,omitempty"`
+}
+
+// IntrospectionCaller limits the tokens a caller of the introspection endpoint can see
+type IntrospectionCaller struct {
+	ClientID string // Only tokens issued to this OAuth client, when set
+	OrgID    *uint  // Otherwise only tokens issued to applications of this organization
+}
+
+// IntrospectToken reports whether an access token is active, and what it grants. Tokens the caller
+// cannot see are reported as inactive, like unknown ones.
+func (s *OAuthService) IntrospectToken(caller IntrospectionCaller, token string) (*TokenIntrospection, error) {
+	inactive := &TokenIntrospection{Active: false}
+
+	var accessToken database.OAuthAccessToken
+	if err := s.db.Preload("User").Where("token = ? AND revoked = false", token).First(&accessToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return inactive, nil
+		}
+		return nil, err
+	}
+	if !time.Now().Before(accessToken.ExpiresAt) || accessToken.User.Disabled {
+		return inactive, nil
+	}
+
+	var app database.OAuthApplication
+	if err := s.db.Where("client_id = ? AND active = ?", accessToken.ClientID, true).First(&app).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return inactive, nil
+		}
+		return nil, err
+	}
+	switch {
+	case caller.ClientID != "":
+		if app.ClientID != caller.ClientID {
+			return inactive, nil
+		}
+	case caller.OrgID != nil:
+		if app.OrgID == nil || *app.OrgID != *caller.OrgID {
+			return inactive, nil
+		}
+	default:
+		return inactive, nil
+	}
+	if err := s.ensureApplicationOrgActive(&app); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return inactive, nil
+		}
+		return nil, err
+	}
+
+	// Tokens bound to an organization stop working once the user leaves it
+	org, err := s.boundOrganization(accessToken.UserID, accessToken.OrgID)
+	if err != nil {
+		if errors.Is(err, ErrInvalidAuthorizationOrg) {
+			return inactive, nil
+		}
+		return nil, err
+	}
+
+	introspection := &TokenIntrospection{
+		Active:    true,
+		Scope:     accessToken.Scopes,
+		ClientID:  accessToken.ClientID,
+		Username:  accessToken.User.Username,
+		TokenType: "Bearer",
+		ExpiresAt: accessToken.ExpiresAt.Unix(),
+		IssuedAt:  accessToken.CreatedAt.Unix(),
+		Subject:   strconv.FormatUint(uint64(accessToken.UserID), 10),
+	}
+	if org != nil {
+		introspection.OrgID = org.ID
+		introspection.OrgSlug = org.Slug
+		introspection.OrgRole = org.Role
+	}
+	return introspection, nil
+}
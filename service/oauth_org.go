@@ -0,0 +1,73 @@
+package service
+
+import (
+	"errors"
+	"miniauth/database"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidAuthorizationOrg is returned when the organization chosen during authorization does not exist,
+// the user is not a member of it, or the application cannot be used with it
+var ErrInvalidAuthorizationOrg = errors.New("invalid organization")
+
+// TokenOrganization is the active organization bound to an authorization code and the tokens issued for it
+type TokenOrganization struct {
+	ID   uint                   `json:"id"`
+	Slug string                 `json:"slug"`
+	Role database.OrgMemberRole `json:"role"`
+}
+
+// ResolveAuthorizationOrg resolves the active organization chosen by slug during authorization.
+// Without a choice, members-only applications are bound to their own organization and other
+// applications to none. Members-only applications cannot be bound to another organization.
+func (s *OAuthService) ResolveAuthorizationOrg(app *database.OAuthApplication, userID uint, organization string) (*TokenOrganization, error) {
+	restricted := app.OrgID != nil && app.MembersOnly
+
+	if organization == "" {
+		if !restricted {
+			return nil, nil
+		}
+		return s.tokenOrganization(*app.OrgID, userID)
+	}
+
+	var org database.Org
+	if err := s.db.Select("id").Where("slug = ?", organization).First(&org).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidAuthorizationOrg
+		}
+		return nil, err
+	}
+
+	if restricted && *app.OrgID != org.ID {
+		return nil, ErrInvalidAuthorizationOrg
+	}
+
+	return s.tokenOrganization(org.ID, userID)
+}
+
+// boundOrganization returns the current slug and role of the organization bound to a code or token.
+// ErrInvalidAuthorizationOrg is returned once the user has left the organization.
+func (s *OAuthService) boundOrganization(userID uint, orgID *uint) (*TokenOrganization, error) {
+	if orgID == nil {
+		return nil, nil
+	}
+	return s.tokenOrganization(*orgID, userID)
+}
+
+func (s *OAuthService) tokenOrganization(orgID, userID uint) (*TokenOrganization, error) {
+	var org TokenOrganization
+	err := s.db.Table("orgs").
+		Select("orgs.id, orgs.slug, org_members.role").
+		Joins("JOIN org_members ON orgs.id = org_members.org_id").
+		Where("orgs.id = ? AND org_members.user_id = ?", orgID, userID).
+		Where("orgs.deleted_at IS NULL AND org_members.deleted_at IS NULL").
+		Take(&org).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidAuthorizationOrg
+		}
+		return nil, err
+	}
+	return &org, nil
+}
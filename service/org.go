@@ -3,16 +3,72 @@ package service
 import (
 	"errors"
 	"miniauth/database"
+	"net"
+	"regexp"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+var (
+	// ErrNotOrgMember is returned when the user is not a member of the organization
+	ErrNotOrgMember = errors.New("user is not a member of the organization")
+	// ErrOrgPermissionDenied is returned when the member's role is insufficient for the operation
+	ErrOrgPermissionDenied = errors.New("insufficient organization role")
+	// ErrAlreadyOrgMember is returned when the user already belongs to the organization
+	ErrAlreadyOrgMember = errors.New("user is already a member of the organization")
+	// ErrLastOrgOwner is returned when an operation would leave the organization without an owner
+	ErrLastOrgOwner = errors.New("organization must keep at least one owner")
+	// ErrOrgRestoreExpired is returned when a deleted organization is past its restore grace period
+	ErrOrgRestoreExpired = errors.New("organization restore grace period has ended")
+	// ErrOrgSlugTaken is returned when another organization already uses the slug
+	ErrOrgSlugTaken = errors.New("organization slug is already taken")
+	// ErrInvalidOrgSlug is returned when a slug contains unsupported characters
+	ErrInvalidOrgSlug = errors.New("organization slug may only contain lowercase letters, digits and hyphens")
+)
+
+// orgSlugPattern matches lowercase alphanumeric slugs with single inner hyphens
+var orgSlugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// orgRoleRank orders organization roles from least to most privileged
+var orgRoleRank = map[database.OrgMemberRole]int{
+	database.OrgMemberRoleGuest:  1,
+	database.OrgMemberRoleMember: 2,
+	database.OrgMemberRoleAdmin:  3,
+	database.OrgMemberRoleOwner:  4,
+}
+
+// OrgRoleAtLeast reports whether role grants at least the privileges of required
+func OrgRoleAtLeast(role, required database.OrgMemberRole) bool {
+	return orgRoleRank[role] >= orgRoleRank[required]
+}
+
+// CanManageOrgMember reports whether a member with actorRole may change or remove a member with targetRole,
+// given they hold the members:manage permission. Members manage roles up to their own; only owners manage owners.
+func CanManageOrgMember(actorRole, targetRole database.OrgMemberRole) bool {
+	return OrgRoleAtLeast(actorRole, targetRole)
+}
+
 type OrgService struct {
-	db *gorm.DB
+	db                  *gorm.DB
+	mailer              Mailer
+	invitationTTL       time.Duration
+	deletionGracePeriod time.Duration
+	baseURL             string
+	resolver            TXTResolver
+	domainLookupTimeout time.Duration
 }
 
-func NewOrgService(db *gorm.DB) *OrgService {
-	return &OrgService{db: db}
+func NewOrgService(db *gorm.DB, mailer Mailer) *OrgService {
+	return &OrgService{
+		db:                  db,
+		mailer:              mailer,
+		invitationTTL:       getEnvDuration("ORG_INVITATION_TTL", 7*24*time.Hour),
+		deletionGracePeriod: getEnvDuration("ORG_DELETION_GRACE_PERIOD", 30*24*time.Hour),
+		baseURL:             getEnv("APP_BASE_URL", "http://localhost:8080"),
+		resolver:            net.DefaultResolver,
+		domainLookupTimeout: getEnvDuration("ORG_DOMAIN_LOOKUP_TIMEOUT", 10*time.Second),
+	}
 }
 
 // CreateOrg creates a new organization
@@ -27,6 +83,56 @@ func (s *OrgService) CreateOrg(org *database.Org) error {
 	return s.db.Create(org).Error
 }
 
+// CreateOrgWithOwner creates a new organization with the given user as its owner
+func (s *OrgService) CreateOrgWithOwner(org *database.Org, ownerID uint) error {
+	if org.Name == "" {
+		return errors.New("organization name is required")
+	}
+	if !orgSlugPattern.MatchString(org.Slug) {
+		return ErrInvalidOrgSlug
+	}
+
+	var count int64
+	if err := s.db.Unscoped().Model(&database.Org{}).Where("slug = ?", org.Slug).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrOrgSlugTaken
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+
+		return addOrgMember(tx, ownerID, org.ID, database.OrgMemberRoleOwner)
+	})
+}
+
+// RequireOrgRole returns the user's membership if their role in the organization is at least required
+func (s *OrgService) RequireOrgRole(orgID, userID uint, required database.OrgMemberRole) (*database.OrgMember, error) {
+	member, err := s.GetOrgMemberByUserID(orgID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotOrgMember
+		}
+		return nil, err
+	}
+
+	if !OrgRoleAtLeast(member.Role, required) {
+		return member, ErrOrgPermissionDenied
+	}
+
+	return member, nil
+}
+
+// CountOrgMembers returns the number of members of an organization
+func (s *OrgService) CountOrgMembers(orgID uint) (int64, error) {
+	var count int64
+	err := s.db.Model(&database.OrgMember{}).Where("org_id = ?", orgID).Count(&count).Error
+	return count, err
+}
+
 // GetOrgByID retrieves an organization by ID with optional preloading
 func (s *OrgService) GetOrgByID(id uint, preload ...string) (*database.Org, error) {
 	var org database.Org
@@ -70,17 +176,11 @@ func (s *OrgService) UpdateOrg(org *database.Org) error {
 	return s.db.Save(org).Error
 }
 
-// DeleteOrg soft deletes an organization by ID
+// DeleteOrg schedules an organization for deletion. The organization and its memberships are
+// soft deleted and can be restored with RestoreOrg until the grace period ends.
 func (s *OrgService) DeleteOrg(id uint) error {
-	// Start a transaction to delete organization and its members
 	return s.db.Transaction(func(tx *gorm.DB) error {
-		// Delete all org members first
-		if err := tx.Where("org_id = ?", id).Delete(&database.OrgMember{}).Error; err != nil {
-			return err
-		}
-
-		// Delete the organization
-		return tx.Delete(&database.Org{}, id).Error
+		return scheduleOrgDeletion(tx, id, time.Now())
 	})
 }
 
@@ -140,7 +240,7 @@ func (s *OrgService) GetUserOrgs(userID uint, preload ...string) ([]database.Org
 	}
 
 	err := query.Joins("JOIN org_members ON orgs.id = org_members.org_id").
-		Where("org_members.user_id = ?", userID).
+		Where("org_members.user_id = ? AND org_members.deleted_at IS NULL", userID).
 		Find(&orgs).Error
 
 	return orgs, err
@@ -156,7 +256,7 @@ func (s *OrgService) GetOrgsByRole(userID uint, role database.OrgMemberRole, pre
 	}
 
 	err := query.Joins("JOIN org_members ON orgs.id = org_members.org_id").
-		Where("org_members.user_id = ? AND org_members.role = ?", userID, role).
+		Where("org_members.user_id = ? AND org_members.role = ? AND org_members.deleted_at IS NULL", userID, role).
 		Find(&orgs).Error
 
 	return orgs, err
@@ -175,7 +275,7 @@ func (s *OrgService) GetUserOrgsWithRoles(userID uint) ([]OrgWithRole, error) {
 	err := s.db.Table("orgs").
 		Select("orgs.*, org_members.role").
 		Joins("JOIN org_members ON orgs.id = org_members.org_id").
-		Where("org_members.user_id = ? AND org_members.deleted_at IS NULL", userID).
+		Where("org_members.user_id = ? AND org_members.deleted_at IS NULL AND orgs.deleted_at IS NULL", userID).
 		Scan(&results).Error
 
 	return results, err
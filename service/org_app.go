@@ -0,0 +1,275 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"miniauth/database"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrApplicationMembersOnly is returned when a user outside the owning organization authorizes a members-only application
+	ErrApplicationMembersOnly = errors.New("application can only be authorized by members of its organization")
+	// ErrInvalidApplicationScope is returned when an application request names a scope that is not registered
+	ErrInvalidApplicationScope = errors.New("unknown application scope")
+	// ErrOrgApplicationAdminScope is returned when an organization application requests the admin scope
+	ErrOrgApplicationAdminScope = errors.New("organization applications cannot request the admin scope")
+)
+
+// OrgApplicationRequest creates or updates an OAuth application owned by an organization.
+// Organization applications cannot be marked trusted or request the admin scope; only global
+// admins can grant those.
+type OrgApplicationRequest struct {
+	Name         string   `json:"name" validate:"required"`
+	RedirectURIs []string `json:"redirect_uris" validate:"required,min=1"`
+	Scopes       []string `json:"scopes"`
+	Description  string   `json:"description"`
+	Website      string   `json:"website"`
+	MembersOnly  bool     `json:"members_only"` // Only members of the organization can authorize the application
+}
+
+// CreateOrgApplication creates an OAuth application owned by an organization, attributed to the acting user
+func (s *OAuthService) CreateOrgApplication(actor AuditActor, orgID uint, req OrgApplicationRequest) (*ApplicationResponse, error) {
+	redirectURIsJSON, err := json.Marshal(req.RedirectURIs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal redirect URIs: %w", err)
+	}
+
+	scopes, err := s.orgApplicationScopes(req.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	app := &database.OAuthApplication{
+		Name:         req.Name,
+		Description:  req.Description,
+		Website:      req.Website,
+		ClientID:     uuid.New().String(),
+		ClientSecret: s.generateClientSecret(),
+		RedirectURIs: string(redirectURIsJSON),
+		Scopes:       strings.Join(scopes, " "),
+		CreatedByID:  actor.UserID,
+		Active:       true,
+		OrgID:        &orgID,
+		MembersOnly:  req.MembersOnly,
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(app).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, auditEntry{
+			Action:     AuditActionApplicationCreated,
+			TargetType: AuditTargetApplication,
+			TargetID:   app.ClientID,
+			OrgID:      &orgID,
+			Metadata: map[string]interface{}{
+				"name": app.Name,
+			},
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OAuth application: %w", err)
+	}
+
+	return s.GetOrgApplication(orgID, app.ID)
+}
+
+// GetOrgApplications retrieves the OAuth applications owned by an organization
+func (s *OAuthService) GetOrgApplications(orgID uint) ([]*ApplicationResponse, error) {
+	var apps []database.OAuthApplication
+	if err := s.db.Preload("CreatedBy").Preload("Org").Where("org_id = ?", orgID).Find(&apps).Error; err != nil {
+		return nil, fmt.Errorf("failed to get applications: %w", err)
+	}
+
+	result := make([]*ApplicationResponse, len(apps))
+	for i := range apps {
+		result[i] = newApplicationResponse(&apps[i])
+	}
+
+	return result, nil
+}
+
+// GetOrgApplication retrieves an OAuth application owned by an organization
+func (s *OAuthService) GetOrgApplication(orgID, appID uint) (*ApplicationResponse, error) {
+	var app database.OAuthApplication
+	if err := s.db.Preload("CreatedBy").Preload("Org").Where("id = ? AND org_id = ?", appID, orgID).First(&app).Error; err != nil {
+		return nil, err
+	}
+
+	return newApplicationResponse(&app), nil
+}
+
+// UpdateOrgApplication updates an OAuth application owned by an organization. The trusted
+// and active flags are left unchanged.
+func (s *OAuthService) UpdateOrgApplication(orgID, appID uint, req OrgApplicationRequest) (*ApplicationResponse, error) {
+	var app database.OAuthApplication
+	if err := s.db.Where("id = ? AND org_id = ?", appID, orgID).First(&app).Error; err != nil {
+		return nil, err
+	}
+
+	redirectURIsJSON, err := json.Marshal(req.RedirectURIs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal redirect URIs: %w", err)
+	}
+
+	scopes, err := s.orgApplicationScopes(req.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	app.Name = req.Name
+	app.Description = req.Description
+	app.Website = req.Website
+	app.RedirectURIs = string(redirectURIsJSON)
+	app.Scopes = strings.Join(scopes, " ")
+	app.MembersOnly = req.MembersOnly
+
+	if err := s.db.Save(&app).Error; err != nil {
+		return nil, fmt.Errorf("failed to update OAuth application: %w", err)
+	}
+
+	return s.GetOrgApplication(orgID, app.ID)
+}
+
+// orgApplicationScopes checks the scopes requested for an organization application against the
+// registered OAuth scopes, defaulting to read
+func (s *OAuthService) orgApplicationScopes(requested []string) ([]string, error) {
+	scopes := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return []string{"read"}, nil
+	}
+	if slices.Contains(scopes, database.TokenScopeAdmin) {
+		return nil, ErrOrgApplicationAdminScope
+	}
+
+	var registered []string
+	if err := s.db.Model(&database.OAuthScope{}).Where("name IN ?", scopes).Pluck("name", &registered).Error; err != nil {
+		return nil, err
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(registered, scope) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidApplicationScope, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// DeleteOrgApplication deletes an OAuth application owned by an organization and all associated tokens
+func (s *OAuthService) DeleteOrgApplication(orgID, appID uint) error {
+	var app database.OAuthApplication
+	if err := s.db.Select("id").Where("id = ? AND org_id = ?", appID, orgID).First(&app).Error; err != nil {
+		return err
+	}
+
+	return s.DeleteApplication(app.ID)
+}
+
+// CheckApplicationAccess returns ErrApplicationMembersOnly if the application is restricted to
+// members of its organization and the user is not one
+func (s *OAuthService) CheckApplicationAccess(app *database.OAuthApplication, userID uint) error {
+	if app.OrgID == nil || !app.MembersOnly {
+		return nil
+	}
+
+	var count int64
+	if err := s.db.Model(&database.OrgMember{}).
+		Where("org_id = ? AND user_id = ?", *app.OrgID, userID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrApplicationMembersOnly
+	}
+	return nil
+}
+
+// ensureApplicationOrgActive returns gorm.ErrRecordNotFound if the application belongs to an
+// organization that has been deleted
+func (s *OAuthService) ensureApplicationOrgActive(app *database.OAuthApplication) error {
+	if app.OrgID == nil {
+		return nil
+	}
+	return s.db.Select("id").First(&database.Org{}, *app.OrgID).Error
+}
+
+// deleteOrgApplications permanently deletes the OAuth applications of organizations together with their codes, tokens and API keys
+func deleteOrgApplications(tx *gorm.DB, orgIDs []uint) error {
+	var clientIDs []string
+	if err := tx.Unscoped().Model(&database.OAuthApplication{}).
+		Where("org_id IN ?", orgIDs).
+		Pluck("client_id", &clientIDs).Error; err != nil || len(clientIDs) == 0 {
+		return err
+	}
+
+	if err := tx.Unscoped().Where("client_id IN ?", clientIDs).Delete(&database.OAuthAuthorizationCode{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("client_id IN ?", clientIDs).Delete(&database.OAuthAccessToken{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("client_id IN ?", clientIDs).Delete(&database.OAuthRefreshToken{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("application_id IN (?)", tx.Unscoped().Model(&database.OAuthApplication{}).Select("id").Where("org_id IN ?", orgIDs)).
+		Delete(&database.APIKey{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Where("org_id IN ?", orgIDs).Delete(&database.OAuthApplication{}).Error
+}
+
+// newApplicationResponse converts an application, with its creator and Org preloaded, to its API representation
+func newApplicationResponse(app *database.OAuthApplication) *ApplicationResponse {
+	var redirectURIs []string
+	if err := json.Unmarshal([]byte(app.RedirectURIs), &redirectURIs); err != nil {
+		redirectURIs = []string{}
+	}
+
+	scopes := strings.Split(app.Scopes, " ")
+	if len(scopes) == 1 && scopes[0] == "" {
+		scopes = []string{}
+	}
+
+	response := &ApplicationResponse{
+		ID:           app.ID,
+		Name:         app.Name,
+		Description:  app.Description,
+		Website:      app.Website,
+		ClientID:     app.ClientID,
+		ClientSecret: app.ClientSecret,
+		RedirectURIs: redirectURIs,
+		Scopes:       scopes,
+		Trusted:      app.Trusted,
+		Active:       app.Active,
+		OrgID:        app.OrgID,
+		MembersOnly:  app.MembersOnly,
+		CreatedBy:    applicationCreator(app),
+		CreatedAt:    app.CreatedAt.Format(time.RFC3339),
+	}
+	if app.Org != nil {
+		response.OrgSlug = app.Org.Slug
+	}
+	return response
+}
+
+// applicationCreator names the admin or service account that created an application, when preloaded
+func applicationCreator(app *database.OAuthApplication) string {
+	switch {
+	case app.CreatedBy != nil:
+		return app.CreatedBy.Username
+	case app.CreatedByServiceAccount != nil:
+		return "service-account:" + app.CreatedByServiceAccount.Name
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"errors"
+	"miniauth/database"
+	"reflect"
+	"testing"
+)
+
+func TestOrgApplicationScopes(t *testing.T) {
+	db := newTestDB(t)
+	oauth := NewOAuthService(db)
+
+	owner := &database.User{Username: "alice", Email: "alice@example.com", PasswordHash: "x"}
+	mustCreate(t, db, owner)
+	org := &database.Org{Name: "Engineering", Slug: "engineering"}
+	mustCreate(t, db, org)
+	actor := AuditActor{UserID: &owner.ID}
+
+	tests := []struct {
+		name    string
+		scopes  []string
+		want    []string
+		wantErr error
+	}{
+		{name: "defaults to read", want: []string{"read"}},
+		{name: "registered scopes", scopes: []string{"profile", "read", "profile"}, want: []string{"profile", "read"}},
+		{name: "admin scope", scopes: []string{"read", "admin"}, wantErr: ErrOrgApplicationAdminScope},
+		{name: "unknown scope", scopes: []string{"read", "payments:write"}, wantErr: ErrInvalidApplicationScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := OrgApplicationRequest{Name: "App", RedirectURIs: []string{"https://app.example.com/callback"}, Scopes: tt.scopes}
+			created, err := oauth.CreateOrgApplication(actor, org.ID, req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateOrgApplication = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !reflect.DeepEqual(created.Scopes, tt.want) {
+				t.Errorf("created scopes = %v, want %v", created.Scopes, tt.want)
+			}
+
+			var event database.AuditEvent
+			if err := db.Where("action = ? AND target_id = ?", AuditActionApplicationCreated, created.ClientID).First(&event).Error; err != nil {
+				t.Fatalf("load audit event: %v", err)
+			}
+			if event.ActorID == nil || *event.ActorID != owner.ID || event.OrgID == nil || *event.OrgID != org.ID {
+				t.Errorf("audit event = %+v, want actor %d in organization %d", event, owner.ID, org.ID)
+			}
+
+			// Updates are checked the same way
+			req.Scopes = []string{"admin"}
+			if _, err := oauth.UpdateOrgApplication(org.ID, created.ID, req); !errors.Is(err, ErrOrgApplicationAdminScope) {
+				t.Errorf("UpdateOrgApplication with the admin scope = %v, want ErrOrgApplicationAdminScope", err)
+			}
+			req.Scopes = []string{"unknown"}
+			if _, err := oauth.UpdateOrgApplication(org.ID, created.ID, req); !errors.Is(err, ErrInvalidApplicationScope) {
+				t.Errorf("UpdateOrgApplication with an unknown scope = %v, want ErrInvalidApplicationScope", err)
+			}
+		})
+	}
+}
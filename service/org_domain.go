@@ -0,0 +1,352 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"miniauth/database"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidDomain is returned when a domain name is malformed
+	ErrInvalidDomain = errors.New("invalid domain name")
+	// ErrDomainTaken is returned when the organization already claims the domain or another organization has verified it
+	ErrDomainTaken = errors.New("domain is already claimed")
+	// ErrInvalidJoinPolicy is returned when a domain join policy is not none, auto or request
+	ErrInvalidJoinPolicy = errors.New("invalid join policy")
+	// ErrDomainNotVerified is returned when the verification TXT record could not be found
+	ErrDomainNotVerified = errors.New("domain verification record not found")
+	// ErrDomainLookupFailed is returned when the verification TXT record could not be looked up
+	ErrDomainLookupFailed = errors.New("domain lookup failed")
+	// ErrInvalidJoinRequest is returned when a join request is unknown or already answered
+	ErrInvalidJoinRequest = errors.New("invalid join request")
+)
+
+// orgDomainChallengeLabel is prepended to a claimed domain to form the name of its verification TXT record
+const orgDomainChallengeLabel = "_miniauth-challenge"
+
+// orgDomainChallengePrefix prefixes the verification token in the TXT record value
+const orgDomainChallengePrefix = "miniauth-domain-verification="
+
+// domainPattern matches lowercase DNS names with at least two labels
+var domainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// TXTResolver looks up DNS TXT records. *net.Resolver satisfies it.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// SetTXTResolver replaces the resolver used to verify domain claims
+func (s *OrgService) SetTXTResolver(resolver TXTResolver) {
+	s.resolver = resolver
+}
+
+// OrgDomainChallenge returns the name and value of the TXT record that proves ownership of a domain
+func OrgDomainChallenge(domain *database.OrgDomain) (name, value string) {
+	return orgDomainChallengeLabel + "." + domain.Domain, orgDomainChallengePrefix + domain.VerificationToken
+}
+
+// NormalizeDomain lowercases a domain name and strips a leading "@" and trailing dot
+func NormalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	domain = strings.TrimPrefix(domain, "@")
+	return strings.TrimSuffix(domain, ".")
+}
+
+// ListOrgDomains retrieves the domains claimed by an organization
+func (s *OrgService) ListOrgDomains(orgID uint) ([]database.OrgDomain, error) {
+	var domains []database.OrgDomain
+	err := s.db.Where("org_id = ?", orgID).Order("domain").Find(&domains).Error
+	return domains, err
+}
+
+// GetOrgDomain retrieves a domain claimed by an organization
+func (s *OrgService) GetOrgDomain(orgID, domainID uint) (*database.OrgDomain, error) {
+	var domain database.OrgDomain
+	if err := s.db.Where("id = ? AND org_id = ?", domainID, orgID).First(&domain).Error; err != nil {
+		return nil, err
+	}
+	return &domain, nil
+}
+
+// AddOrgDomain claims a domain for an organization. The claim is unverified until VerifyOrgDomain
+// finds the challenge TXT record. Actors cannot set a default role above their own.
+func (s *OrgService) AddOrgDomain(domain *database.OrgDomain, actorRole database.OrgMemberRole) error {
+	domain.Domain = NormalizeDomain(domain.Domain)
+	if err := s.validateOrgDomain(domain, actorRole); err != nil {
+		return err
+	}
+
+	var count int64
+	if err := s.db.Model(&database.OrgDomain{}).
+		Where("domain = ? AND (org_id = ? OR verified_at IS NOT NULL)", domain.Domain, domain.OrgID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrDomainTaken
+	}
+
+	domain.VerificationToken = generateSecureToken()
+	domain.VerifiedAt = nil
+	return s.db.Create(domain).Error
+}
+
+// UpdateOrgDomain updates the join policy and default role of a claimed domain
+func (s *OrgService) UpdateOrgDomain(domain *database.OrgDomain, actorRole database.OrgMemberRole) error {
+	if err := s.validateOrgDomain(domain, actorRole); err != nil {
+		return err
+	}
+
+	return s.db.Model(domain).Updates(map[string]interface{}{
+		"join_policy":  domain.JoinPolicy,
+		"default_role": domain.DefaultRole,
+	}).Error
+}
+
+// DeleteOrgDomain releases a domain claim. Pending join requests made through it are rejected.
+func (s *OrgService) DeleteOrgDomain(orgID, domainID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND org_id = ?", domainID, orgID).Delete(&database.OrgDomain{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return tx.Model(&database.OrgJoinRequest{}).
+			Where("domain_id = ? AND status = ?", domainID, database.OrgJoinRequestStatusPending).
+			Updates(map[string]interface{}{
+				"status":       database.OrgJoinRequestStatusRejected,
+				"responded_at": time.Now(),
+			}).Error
+	})
+}
+
+// VerifyOrgDomain looks up the challenge TXT record of a claimed domain and marks the claim verified
+// when it contains the verification token. A domain can only be verified by one organization.
+func (s *OrgService) VerifyOrgDomain(ctx context.Context, orgID, domainID uint) (*database.OrgDomain, error) {
+	domain, err := s.GetOrgDomain(orgID, domainID)
+	if err != nil {
+		return nil, err
+	}
+	if domain.VerifiedAt != nil {
+		return domain, nil
+	}
+
+	name, expected := OrgDomainChallenge(domain)
+	ctx, cancel := context.WithTimeout(ctx, s.domainLookupTimeout)
+	defer cancel()
+
+	records, err := s.resolver.LookupTXT(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, ErrDomainNotVerified
+		}
+		return nil, fmt.Errorf("%w: %s: %v", ErrDomainLookupFailed, name, err)
+	}
+
+	found := false
+	for _, record := range records {
+		if strings.TrimSpace(record) == expected {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, ErrDomainNotVerified
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&database.OrgDomain{}).
+			Where("domain = ? AND org_id <> ? AND verified_at IS NOT NULL", domain.Domain, orgID).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrDomainTaken
+		}
+
+		now := time.Now()
+		domain.VerifiedAt = &now
+		return tx.Model(domain).Update("verified_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return domain, nil
+}
+
+// validateOrgDomain checks the name, join policy and default role of a domain claim
+func (s *OrgService) validateOrgDomain(domain *database.OrgDomain, actorRole database.OrgMemberRole) error {
+	if len(domain.Domain) > 253 || !domainPattern.MatchString(domain.Domain) {
+		return ErrInvalidDomain
+	}
+
+	switch domain.JoinPolicy {
+	case "":
+		domain.JoinPolicy = database.OrgDomainJoinPolicyNone
+	case database.OrgDomainJoinPolicyNone, database.OrgDomainJoinPolicyAuto, database.OrgDomainJoinPolicyRequest:
+	default:
+		return ErrInvalidJoinPolicy
+	}
+
+	if domain.DefaultRole == "" {
+		domain.DefaultRole = database.OrgMemberRoleMember
+	}
+	// Joining through a domain never grants ownership
+	if domain.DefaultRole == database.OrgMemberRoleOwner || orgRoleRank[domain.DefaultRole] == 0 {
+		return ErrOrgPermissionDenied
+	}
+	if !OrgRoleAtLeast(actorRole, domain.DefaultRole) {
+		return ErrOrgPermissionDenied
+	}
+	return nil
+}
+
+// ListOrgJoinRequests retrieves the pending join requests of an organization
+func (s *OrgService) ListOrgJoinRequests(orgID uint) ([]database.OrgJoinRequest, error) {
+	var requests []database.OrgJoinRequest
+	err := s.db.Preload("User").
+		Where("org_id = ? AND status = ?", orgID, database.OrgJoinRequestStatusPending).
+		Order("created_at").
+		Find(&requests).Error
+	return requests, err
+}
+
+// ListUserJoinRequests retrieves the pending join requests of a user for organizations that still exist
+func (s *OrgService) ListUserJoinRequests(userID uint) ([]database.OrgJoinRequest, error) {
+	var requests []database.OrgJoinRequest
+	err := s.db.Preload("Org").
+		Joins("JOIN orgs ON orgs.id = org_join_requests.org_id AND orgs.deleted_at IS NULL").
+		Where("org_join_requests.user_id = ? AND org_join_requests.status = ?", userID, database.OrgJoinRequestStatusPending).
+		Order("org_join_requests.created_at").
+		Find(&requests).Error
+	return requests, err
+}
+
+// RespondToJoinRequest approves or rejects a pending join request. Approving adds the user to the
+// organization with the requested role, which cannot exceed the approver's role.
+func (s *OrgService) RespondToJoinRequest(orgID, requestID uint, actor *database.OrgMember, approve bool) (*database.OrgJoinRequest, error) {
+	var request database.OrgJoinRequest
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Preload("User").
+			Where("id = ? AND org_id = ? AND status = ?", requestID, orgID, database.OrgJoinRequestStatusPending).
+			First(&request).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidJoinRequest
+			}
+			return err
+		}
+
+		status := database.OrgJoinRequestStatusRejected
+		if approve {
+			if !OrgRoleAtLeast(actor.Role, request.Role) {
+				return ErrOrgPermissionDenied
+			}
+			if err := addOrgMember(tx, request.UserID, orgID, request.Role); err != nil {
+				return err
+			}
+			status = database.OrgJoinRequestStatusApproved
+		}
+
+		now := time.Now()
+		result := tx.Model(&database.OrgJoinRequest{}).
+			Where("id = ? AND status = ?", request.ID, database.OrgJoinRequestStatusPending).
+			Updates(map[string]interface{}{
+				"status":          status,
+				"responded_by_id": actor.UserID,
+				"responded_at":    now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidJoinRequest
+		}
+
+		request.Status = status
+		request.RespondedByID = &actor.UserID
+		request.RespondedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+// applyDomainJoinPolicies admits a user to the organizations that verified the domain of their email
+// address: organizations with the auto policy add the user directly, those with the request policy
+// receive a join request. Automatic membership waits until the email address is verified; the join
+// request is created right away since an administrator reviews it.
+func applyDomainJoinPolicies(tx *gorm.DB, user *database.User) error {
+	at := strings.LastIndex(user.Email, "@")
+	if at < 0 {
+		return nil
+	}
+
+	var domains []database.OrgDomain
+	err := tx.Model(&database.OrgDomain{}).
+		Joins("JOIN orgs ON orgs.id = org_domains.org_id AND orgs.deleted_at IS NULL").
+		Where("org_domains.domain = ? AND org_domains.verified_at IS NOT NULL AND org_domains.join_policy <> ?",
+			NormalizeDomain(user.Email[at+1:]), database.OrgDomainJoinPolicyNone).
+		Find(&domains).Error
+	if err != nil {
+		return err
+	}
+
+	for _, domain := range domains {
+		var members int64
+		if err := tx.Model(&database.OrgMember{}).
+			Where("org_id = ? AND user_id = ?", domain.OrgID, user.ID).
+			Count(&members).Error; err != nil {
+			return err
+		}
+		if members > 0 {
+			continue
+		}
+
+		if domain.JoinPolicy == database.OrgDomainJoinPolicyAuto {
+			if !user.EmailVerified {
+				continue
+			}
+			if err := addOrgMember(tx, user.ID, domain.OrgID, domain.DefaultRole); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var pending int64
+		if err := tx.Model(&database.OrgJoinRequest{}).
+			Where("org_id = ? AND user_id = ? AND status = ?", domain.OrgID, user.ID, database.OrgJoinRequestStatusPending).
+			Count(&pending).Error; err != nil {
+			return err
+		}
+		if pending > 0 {
+			continue
+		}
+
+		if err := tx.Create(&database.OrgJoinRequest{
+			OrgID:    domain.OrgID,
+			UserID:   user.ID,
+			DomainID: domain.ID,
+			Role:     domain.DefaultRole,
+			Status:   database.OrgJoinRequestStatusPending,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
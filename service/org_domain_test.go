@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"miniauth/database"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeResolver answers TXT lookups from a fixed set of records
+type fakeResolver struct {
+	records map[string][]string
+	err     error // Returned for every lookup when set
+}
+
+func (r *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	records, ok := r.records[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := map[string]string{
+		"example.com":    "example.com",
+		"@Example.COM":   "example.com",
+		" example.com. ": "example.com",
+	}
+	for input, want := range tests {
+		if got := NormalizeDomain(input); got != want {
+			t.Errorf("NormalizeDomain(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestAddOrgDomainValidation(t *testing.T) {
+	db := newTestDB(t)
+	orgs := NewOrgService(db, &fakeMailer{})
+	org := &database.Org{Name: "Org", Slug: "org"}
+	mustCreate(t, db, org)
+
+	tests := []struct {
+		name      string
+		domain    database.OrgDomain
+		actorRole database.OrgMemberRole
+		wantErr   error
+	}{
+		{"valid", database.OrgDomain{Domain: "@Example.com"}, database.OrgMemberRoleAdmin, nil},
+		{"already claimed", database.OrgDomain{Domain: "example.com"}, database.OrgMemberRoleAdmin, ErrDomainTaken},
+		{"single label", database.OrgDomain{Domain: "localhost"}, database.OrgMemberRoleAdmin, ErrInvalidDomain},
+		{"invalid characters", database.OrgDomain{Domain: "exa_mple.com"}, database.OrgMemberRoleAdmin, ErrInvalidDomain},
+		{"unknown join policy", database.OrgDomain{Domain: "policy.example", JoinPolicy: "always"}, database.OrgMemberRoleAdmin, ErrInvalidJoinPolicy},
+		{"owner default role", database.OrgDomain{Domain: "owner.example", DefaultRole: database.OrgMemberRoleOwner}, database.OrgMemberRoleOwner, ErrOrgPermissionDenied},
+		{"default role above the actor", database.OrgDomain{Domain: "admin.example", DefaultRole: database.OrgMemberRoleAdmin}, database.OrgMemberRoleMember, ErrOrgPermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain := tt.domain
+			domain.OrgID = org.ID
+			domain.CreatedByID = 1
+			err := orgs.AddOrgDomain(&domain, tt.actorRole)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AddOrgDomain = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (domain.Domain != "example.com" || domain.VerificationToken == "" || domain.VerifiedAt != nil) {
+				t.Errorf("claim not normalized and unverified: %+v", domain)
+			}
+		})
+	}
+}
+
+func TestVerifyOrgDomain(t *testing.T) {
+	tests := []struct {
+		name     string
+		records  func(name, value string) map[string][]string
+		err      error
+		verified bool // Another organization verified the domain first
+		wantErr  error
+	}{
+		{
+			name: "record found",
+			records: func(name, value string) map[string][]string {
+				return map[string][]string{name: {"unrelated", " " + value + " "}}
+			},
+		},
+		{
+			name:    "record missing",
+			records: func(name, value string) map[string][]string { return nil },
+			wantErr: ErrDomainNotVerified,
+		},
+		{
+			name:    "wrong token",
+			records: func(name, value string) map[string][]string { return map[string][]string{name: {value + "x"}} },
+			wantErr: ErrDomainNotVerified,
+		},
+		{
+			name:    "record on the domain itself",
+			records: func(name, value string) map[string][]string { return map[string][]string{"example.com": {value}} },
+			wantErr: ErrDomainNotVerified,
+		},
+		{
+			name:    "lookup failure",
+			records: func(name, value string) map[string][]string { return nil },
+			err:     &net.DNSError{Err: "server misbehaving", IsTemporary: true},
+			wantErr: ErrDomainLookupFailed,
+		},
+		{
+			name:     "verified by another organization",
+			records:  func(name, value string) map[string][]string { return map[string][]string{name: {value}} },
+			verified: true,
+			wantErr:  ErrDomainTaken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			orgs := NewOrgService(db, &fakeMailer{})
+			org := &database.Org{Name: "Org", Slug: "org"}
+			mustCreate(t, db, org)
+
+			domain := &database.OrgDomain{OrgID: org.ID, Domain: "example.com", CreatedByID: 1}
+			if err := orgs.AddOrgDomain(domain, database.OrgMemberRoleOwner); err != nil {
+				t.Fatalf("AddOrgDomain: %v", err)
+			}
+			if tt.verified {
+				other := &database.Org{Name: "Other", Slug: "other"}
+				mustCreate(t, db, other)
+				now := time.Now()
+				mustCreate(t, db, &database.OrgDomain{OrgID: other.ID, Domain: "example.com", VerificationToken: "other", VerifiedAt: &now, CreatedByID: 1})
+			}
+
+			name, value := OrgDomainChallenge(domain)
+			if name != "_miniauth-challenge.example.com" {
+				t.Fatalf("challenge record name = %q", name)
+			}
+			orgs.SetTXTResolver(&fakeResolver{records: tt.records(name, value), err: tt.err})
+
+			verified, err := orgs.VerifyOrgDomain(context.Background(), org.ID, domain.ID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyOrgDomain = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && verified.VerifiedAt == nil {
+				t.Error("domain not marked verified")
+			}
+		})
+	}
+}
+
+func TestCreateUserAppliesDomainJoinPolicies(t *testing.T) {
+	db := newTestDB(t)
+	users := NewUserService(db, &fakeMailer{}, &TokenSigner{secret: []byte("test secret")})
+
+	now := time.Now()
+	autoOrg := &database.Org{Name: "Auto", Slug: "auto"}
+	mustCreate(t, db, autoOrg)
+	mustCreate(t, db, &database.OrgDomain{OrgID: autoOrg.ID, Domain: "example.com", VerificationToken: "a", VerifiedAt: &now,
+		JoinPolicy: database.OrgDomainJoinPolicyAuto, DefaultRole: database.OrgMemberRoleGuest, CreatedByID: 1})
+	requestOrg := &database.Org{Name: "Request", Slug: "request"}
+	mustCreate(t, db, requestOrg)
+	mustCreate(t, db, &database.OrgDomain{OrgID: requestOrg.ID, Domain: "example.com", VerificationToken: "b", VerifiedAt: &now,
+		JoinPolicy: database.OrgDomainJoinPolicyRequest, CreatedByID: 1})
+	unverifiedOrg := &database.Org{Name: "Unverified", Slug: "unverified"}
+	mustCreate(t, db, unverifiedOrg)
+	mustCreate(t, db, &database.OrgDomain{OrgID: unverifiedOrg.ID, Domain: "example.com", VerificationToken: "c",
+		JoinPolicy: database.OrgDomainJoinPolicyAuto, CreatedByID: 1})
+
+	tests := []struct {
+		name          string
+		email         string
+		emailVerified bool
+		autoRole      database.OrgMemberRole // Empty when not added to the auto organization
+		requested     bool
+	}{
+		{"verified address", "alice@example.com", true, database.OrgMemberRoleGuest, true},
+		{"unverified address waits for verification", "bob@Example.com", false, "", true},
+		{"other domain", "carol@example.org", true, "", false},
+		{"subdomain", "dave@mail.example.com", true, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &database.User{Username: tt.email, Email: tt.email, PasswordHash: "x", EmailVerified: tt.emailVerified}
+			if err := users.CreateUser(user); err != nil {
+				t.Fatalf("CreateUser: %v", err)
+			}
+
+			var member database.OrgMember
+			err := db.Where("org_id = ? AND user_id = ?", autoOrg.ID, user.ID).Limit(1).Find(&member).Error
+			if err != nil {
+				t.Fatalf("load membership: %v", err)
+			}
+			if member.Role != tt.autoRole {
+				t.Errorf("auto organization role = %q, want %q", member.Role, tt.autoRole)
+			}
+
+			var requests, unverified int64
+			db.Model(&database.OrgJoinRequest{}).Where("org_id = ? AND user_id = ? AND status = ?",
+				requestOrg.ID, user.ID, database.OrgJoinRequestStatusPending).Count(&requests)
+			if (requests == 1) != tt.requested {
+				t.Errorf("got %d join requests, want requested %v", requests, tt.requested)
+			}
+			db.Model(&database.OrgMember{}).Where("org_id = ? AND user_id = ?", unverifiedOrg.ID, user.ID).Count(&unverified)
+			if unverified != 0 {
+				t.Error("user added to an organization whose domain is not verified")
+			}
+		})
+	}
+}
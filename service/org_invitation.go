@@ -0,0 +1,178 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"miniauth/database"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidInvitation is returned when an invitation token is unknown, expired or already answered
+	ErrInvalidInvitation = errors.New("invalid or expired invitation")
+	// ErrInvitationEmailMismatch is returned when the accepting user's email differs from the invited address
+	ErrInvitationEmailMismatch = errors.New("invitation was sent to a different email address")
+)
+
+// CreateOrgInvitation invites an email address to join the organization with a role and mails the invitation link.
+// Inviters cannot grant a role above their own. A previous pending invitation for the same address is revoked.
+func (s *OrgService) CreateOrgInvitation(org *database.Org, inviter *database.User, inviterRole database.OrgMemberRole, email string, role database.OrgMemberRole) (*database.OrgInvitation, error) {
+	if !OrgRoleAtLeast(inviterRole, role) {
+		return nil, ErrOrgPermissionDenied
+	}
+
+	var members int64
+	err := s.db.Model(&database.OrgMember{}).
+		Joins("JOIN users ON users.id = org_members.user_id").
+		Where("org_members.org_id = ? AND LOWER(users.email) = LOWER(?)", org.ID, email).
+		Count(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	if members > 0 {
+		return nil, ErrAlreadyOrgMember
+	}
+
+	token := generateSecureToken()
+	invitation := &database.OrgInvitation{
+		OrgID:       org.ID,
+		Email:       email,
+		Role:        role,
+		TokenHash:   hashToken(token),
+		InvitedByID: inviter.ID,
+		Status:      database.OrgInvitationStatusPending,
+		ExpiresAt:   time.Now().Add(s.invitationTTL),
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&database.OrgInvitation{}).
+			Where("org_id = ? AND LOWER(email) = LOWER(?) AND status = ?", org.ID, email, database.OrgInvitationStatusPending).
+			Update("status", database.OrgInvitationStatusRevoked).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Create(invitation).Error; err != nil {
+			return err
+		}
+
+		// Roll back the invitation if it cannot be delivered
+		link := fmt.Sprintf("%s/invitations?token=%s", s.baseURL, url.QueryEscape(token))
+		return s.mailer.Send(MailMessage{
+			To:      []string{email},
+			Subject: fmt.Sprintf("You have been invited to join %s", org.Name),
+			Body: fmt.Sprintf("Hello,\n\n%s has invited you to join the organization %s as %s. Open the link below to accept or decline the invitation:\n\n%s\n\nThe invitation expires in %s.\n",
+				inviter.Username, org.Name, role, link, s.invitationTTL),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	invitation.Org = *org
+	return invitation, nil
+}
+
+// GetOrgInvitationByToken retrieves a pending, unexpired invitation by its token
+func (s *OrgService) GetOrgInvitationByToken(token string) (*database.OrgInvitation, error) {
+	var invitation database.OrgInvitation
+	err := s.db.Preload("Org").
+		Where("token_hash = ? AND status = ?", hashToken(token), database.OrgInvitationStatusPending).
+		First(&invitation).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidInvitation
+		}
+		return nil, err
+	}
+
+	// The organization may have been deleted since the invitation was sent
+	if time.Now().After(invitation.ExpiresAt) || invitation.Org.ID == 0 {
+		return nil, ErrInvalidInvitation
+	}
+
+	return &invitation, nil
+}
+
+// AcceptOrgInvitation adds the user to the organization with the invited role.
+// The user's email address must match the invited address.
+func (s *OrgService) AcceptOrgInvitation(token string, user *database.User) (*database.OrgInvitation, error) {
+	invitation, err := s.GetOrgInvitationByToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(invitation.Email, user.Email) {
+		return nil, ErrInvitationEmailMismatch
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := respondToInvitation(tx, invitation, database.OrgInvitationStatusAccepted); err != nil {
+			return err
+		}
+
+		return addOrgMember(tx, user.ID, invitation.OrgID, invitation.Role)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+// DeclineOrgInvitation marks a pending invitation as declined
+func (s *OrgService) DeclineOrgInvitation(token string) error {
+	invitation, err := s.GetOrgInvitationByToken(token)
+	if err != nil {
+		return err
+	}
+
+	return respondToInvitation(s.db, invitation, database.OrgInvitationStatusDeclined)
+}
+
+// ListOrgInvitations retrieves the pending, unexpired invitations of an organization
+func (s *OrgService) ListOrgInvitations(orgID uint) ([]database.OrgInvitation, error) {
+	var invitations []database.OrgInvitation
+	err := s.db.Where("org_id = ? AND status = ? AND expires_at > ?", orgID, database.OrgInvitationStatusPending, time.Now()).
+		Order("created_at DESC").
+		Find(&invitations).Error
+	return invitations, err
+}
+
+// RevokeOrgInvitation revokes a pending invitation of an organization
+func (s *OrgService) RevokeOrgInvitation(orgID, invitationID uint) error {
+	result := s.db.Model(&database.OrgInvitation{}).
+		Where("id = ? AND org_id = ? AND status = ?", invitationID, orgID, database.OrgInvitationStatusPending).
+		Update("status", database.OrgInvitationStatusRevoked)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// respondToInvitation moves a pending invitation to its final status exactly once
+func respondToInvitation(tx *gorm.DB, invitation *database.OrgInvitation, status database.OrgInvitationStatus) error {
+	now := time.Now()
+	result := tx.Model(&database.OrgInvitation{}).
+		Where("id = ? AND status = ?", invitation.ID, database.OrgInvitationStatusPending).
+		Updates(map[string]interface{}{
+			"status":       status,
+			"responded_at": now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidInvitation
+	}
+
+	invitation.Status = status
+	invitation.RespondedAt = &now
+	return nil
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"miniauth/database"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeletedOrg represents an organization scheduled for deletion with its restore deadline
+type DeletedOrg struct {
+	database.Org
+	RestoreUntil time.Time `json:"restore_until"`
+}
+
+// TransferOrgOwnership makes toUserID an owner of the organization and demotes fromUserID to admin.
+// If it was the personal organization of fromUserID, it becomes a regular organization.
+func (s *OrgService) TransferOrgOwnership(orgID, fromUserID, toUserID uint) error {
+	if fromUserID == toUserID {
+		return errors.New("cannot transfer ownership to yourself")
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		from, err := lockOrgMember(tx, fromUserID, orgID)
+		if err != nil {
+			return err
+		}
+		if from.Role != database.OrgMemberRoleOwner {
+			return ErrOrgPermissionDenied
+		}
+
+		if _, err := lockOrgMember(tx, toUserID, orgID); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&database.OrgMember{}).
+			Where("user_id = ? AND org_id = ?", toUserID, orgID).
+			Update("role", database.OrgMemberRoleOwner).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&database.OrgMember{}).
+			Where("user_id = ? AND org_id = ?", fromUserID, orgID).
+			Update("role", database.OrgMemberRoleAdmin).Error; err != nil {
+			return err
+		}
+
+		// A personal organization handed over to someone else becomes a regular organization
+		return tx.Model(&database.Org{}).
+			Where("id = ? AND personal_user_id = ?", orgID, fromUserID).
+			Update("personal_user_id", nil).Error
+	})
+}
+
+// DeletionGracePeriod returns how long a deleted organization can be restored
+func (s *OrgService) DeletionGracePeriod() time.Duration {
+	return s.deletionGracePeriod
+}
+
+// GetUserDeletedOrgs retrieves the organizations scheduled for deletion that the user owned and can still restore
+func (s *OrgService) GetUserDeletedOrgs(userID uint) ([]DeletedOrg, error) {
+	var orgs []database.Org
+	err := s.db.Unscoped().Model(&database.Org{}).
+		Joins("JOIN org_members ON orgs.id = org_members.org_id AND org_members.deleted_at = orgs.deleted_at").
+		Where("org_members.user_id = ? AND org_members.role = ?", userID, database.OrgMemberRoleOwner).
+		Where("orgs.deleted_at > ?", time.Now().Add(-s.deletionGracePeriod)).
+		Find(&orgs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]DeletedOrg, 0, len(orgs))
+	for _, org := range orgs {
+		results = append(results, DeletedOrg{
+			Org:          org,
+			RestoreUntil: org.DeletedAt.Time.Add(s.deletionGracePeriod),
+		})
+	}
+	return results, nil
+}
+
+// RestoreOrg restores an organization scheduled for deletion, together with the memberships it had
+// when it was deleted. Only an owner at the time of deletion may restore it, within the grace period.
+func (s *OrgService) RestoreOrg(slug string, userID uint) (*database.Org, error) {
+	var org database.Org
+	if err := s.db.Unscoped().Where("slug = ? AND deleted_at IS NOT NULL", slug).First(&org).Error; err != nil {
+		return nil, err
+	}
+
+	// Memberships removed together with the organization share its deletion time
+	var member database.OrgMember
+	err := s.db.Unscoped().
+		Where("org_id = ? AND user_id = ? AND deleted_at = ?", org.ID, userID, org.DeletedAt.Time).
+		First(&member).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotOrgMember
+		}
+		return nil, err
+	}
+	if member.Role != database.OrgMemberRoleOwner {
+		return nil, ErrOrgPermissionDenied
+	}
+
+	if time.Since(org.DeletedAt.Time) > s.deletionGracePeriod {
+		return nil, ErrOrgRestoreExpired
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&database.OrgMember{}).
+			Where("org_id = ? AND deleted_at = ?", org.ID, org.DeletedAt.Time).
+			Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Model(&org).Update("deleted_at", nil).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	org.DeletedAt = gorm.DeletedAt{}
+	return &org, nil
+}
+
+// PurgeDeletedOrgs permanently deletes organizations whose restore grace period has ended,
+// together with their invitations, roles, applications, API keys, teams and memberships
+func (s *OrgService) PurgeDeletedOrgs() (int, error) {
+	var orgIDs []uint
+	err := s.db.Unscoped().Model(&database.Org{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", time.Now().Add(-s.deletionGracePeriod)).
+		Pluck("id", &orgIDs).Error
+	if err != nil || len(orgIDs) == 0 {
+		return 0, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("org_id IN ?", orgIDs).Delete(&database.OrgInvitation{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("org_id IN ?", orgIDs).Delete(&database.OrgRole{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("org_id IN ?", orgIDs).Delete(&database.OrgJoinRequest{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("org_id IN ?", orgIDs).Delete(&database.OrgDomain{}).Error; err != nil {
+			return err
+		}
+		if err := deleteOrgApplications(tx, orgIDs); err != nil {
+			return err
+		}
+		if err := tx.Where("org_id IN ?", orgIDs).Delete(&database.APIKey{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("org_id IN ?", orgIDs).Delete(&database.SAMLServiceProvider{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("team_id IN (?)", tx.Model(&database.Team{}).Select("id").Where("org_id IN ?", orgIDs)).
+			Delete(&database.TeamMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("org_id IN ?", orgIDs).Delete(&database.Team{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("org_id IN ?", orgIDs).Delete(&database.OrgMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&database.Org{}, orgIDs).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(orgIDs), nil
+}
+
+// StartDeletedOrgPurger periodically purges organizations whose restore grace period has ended
+func (s *OrgService) StartDeletedOrgPurger(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			purged, err := s.PurgeDeletedOrgs()
+			if err != nil {
+				log.Printf("failed to purge deleted organizations: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("purged %d deleted organizations", purged)
+			}
+		}
+	}()
+}
+
+// scheduleOrgDeletion soft deletes an organization and its memberships with the same deletion time,
+// so the memberships can be restored along with the organization, and revokes pending invitations
+func scheduleOrgDeletion(tx *gorm.DB, orgID uint, now time.Time) error {
+	if err := tx.Model(&database.OrgInvitation{}).
+		Where("org_id = ? AND status = ?", orgID, database.OrgInvitationStatusPending).
+		Update("status", database.OrgInvitationStatusRevoked).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(&database.OrgMember{}).
+		Where("org_id = ?", orgID).
+		Update("deleted_at", now).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&database.Org{}).
+		Where("id = ?", orgID).
+		Update("deleted_at", now).Error
+}
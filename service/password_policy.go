@@ -0,0 +1,188 @@
+package service
+
+import (
+	_ "embed"
+	"fmt"
+	"miniauth/database"
+	"strings"
+	"unicode"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsData string
+
+// commonPasswords is the bundled offline list of frequently used (breached) passwords
+var commonPasswords = loadCommonPasswords(commonPasswordsData)
+
+func loadCommonPasswords(data string) map[string]bool {
+	passwords := make(map[string]bool)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			passwords[strings.ToLower(line)] = true
+		}
+	}
+	return passwords
+}
+
+// Password policy violation codes
+const (
+	PasswordViolationTooShort       = "too_short"
+	PasswordViolationTooLong        = "too_long"
+	PasswordViolationMissingUpper   = "missing_uppercase"
+	PasswordViolationMissingLower   = "missing_lowercase"
+	PasswordViolationMissingDigit   = "missing_digit"
+	PasswordViolationMissingSymbol  = "missing_symbol"
+	PasswordViolationContainsUser   = "contains_user_info"
+	PasswordViolationCommonPassword = "common_password"
+	PasswordViolationReused         = "reused_password"
+)
+
+// PasswordPolicy describes the rules a new password must satisfy
+type PasswordPolicy struct {
+	MinLength        int  `json:"min_length"`
+	MaxLength        int  `json:"max_length"`
+	RequireUppercase bool `json:"require_uppercase"`
+	RequireLowercase bool `json:"require_lowercase"`
+	RequireDigit     bool `json:"require_digit"`
+	RequireSymbol    bool `json:"require_symbol"`
+	DisallowUserInfo bool `json:"disallow_user_info"` // Reject passwords containing the username or email
+	CheckCommon      bool `json:"check_common"`       // Reject passwords from the bundled common password list
+	HistorySize      int  `json:"history_size"`       // Number of previous passwords that may not be reused (0 disables)
+}
+
+// PasswordViolation describes a single failed password rule
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError is returned when a password does not satisfy the policy
+type PasswordPolicyError struct {
+	Violations []PasswordViolation `json:"violations"`
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return "password does not meet policy: " + strings.Join(messages, "; ")
+}
+
+// LoadPasswordPolicy builds the password policy from environment variables
+func LoadPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:        getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength:        getEnvInt("PASSWORD_MAX_LENGTH", 128),
+		RequireUppercase: getEnvBool("PASSWORD_REQUIRE_UPPERCASE", false),
+		RequireLowercase: getEnvBool("PASSWORD_REQUIRE_LOWERCASE", false),
+		RequireDigit:     getEnvBool("PASSWORD_REQUIRE_DIGIT", false),
+		RequireSymbol:    getEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+		DisallowUserInfo: getEnvBool("PASSWORD_DISALLOW_USER_INFO", true),
+		CheckCommon:      getEnvBool("PASSWORD_CHECK_COMMON", true),
+		HistorySize:      getEnvInt("PASSWORD_HISTORY_SIZE", 0),
+	}
+}
+
+// Validate checks a password against the static policy rules.
+// The user is used for the username/email check and may be nil.
+func (p *PasswordPolicy) Validate(password string, user *database.User) error {
+	var violations []PasswordViolation
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordViolationTooShort,
+			Message: fmt.Sprintf("Password must be at least %d characters long", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordViolationTooLong,
+			Message: fmt.Sprintf("Password must be at most %d characters long", p.MaxLength),
+		})
+	} else if maxBytes := database.MaxPasswordBytes(); maxBytes > 0 && len(password) > maxBytes {
+		// The hashing algorithm cannot take longer passwords, whatever the policy allows
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordViolationTooLong,
+			Message: fmt.Sprintf("Password must be at most %d bytes long; non-ASCII characters take several bytes", maxBytes),
+		})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUppercase && !hasUpper {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordViolationMissingUpper,
+			Message: "Password must contain an uppercase letter",
+		})
+	}
+	if p.RequireLowercase && !hasLower {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordViolationMissingLower,
+			Message: "Password must contain a lowercase letter",
+		})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordViolationMissingDigit,
+			Message: "Password must contain a digit",
+		})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordViolationMissingSymbol,
+			Message: "Password must contain a symbol",
+		})
+	}
+
+	if p.DisallowUserInfo && user != nil && containsUserInfo(password, user) {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordViolationContainsUser,
+			Message: "Password must not contain your username or email",
+		})
+	}
+
+	if p.CheckCommon && commonPasswords[strings.ToLower(password)] {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordViolationCommonPassword,
+			Message: "Password is too common, please choose a less predictable one",
+		})
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// containsUserInfo reports whether the password contains the username or the local part of the email
+func containsUserInfo(password string, user *database.User) bool {
+	lowered := strings.ToLower(password)
+
+	candidates := []string{strings.ToLower(user.Username), strings.ToLower(user.Email)}
+	if at := strings.Index(user.Email, "@"); at > 0 {
+		candidates = append(candidates, strings.ToLower(user.Email[:at]))
+	}
+
+	for _, candidate := range candidates {
+		// Very short identifiers would reject too many legitimate passwords
+		if len(candidate) >= 3 && strings.Contains(lowered, candidate) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"errors"
+	"miniauth/database"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// violationCodes returns the codes of a password policy error, or nil for any other error
+func violationCodes(err error) []string {
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return nil
+	}
+	var codes []string
+	for _, violation := range policyErr.Violations {
+		codes = append(codes, violation.Code)
+	}
+	return codes
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	user := &database.User{Username: "alice", Email: "wonderland@example.com"}
+	strict := &PasswordPolicy{MinLength: 8, RequireUppercase: true, RequireLowercase: true, RequireDigit: true, RequireSymbol: true}
+
+	tests := []struct {
+		name     string
+		policy   *PasswordPolicy
+		password string
+		user     *database.User
+		want     []string
+	}{
+		{"long enough", &PasswordPolicy{MinLength: 8}, "abcdefgh", nil, nil},
+		{"too short", &PasswordPolicy{MinLength: 8}, "abcdefg", nil, []string{PasswordViolationTooShort}},
+		{"length counts characters", &PasswordPolicy{MinLength: 8}, "ééééééé", nil, []string{PasswordViolationTooShort}},
+		{"all character classes", strict, "Abcdef1!", nil, nil},
+		{"space counts as a symbol", strict, "Abcdef1 ", nil, nil},
+		{"missing uppercase", strict, "abcdef1!", nil, []string{PasswordViolationMissingUpper}},
+		{"missing lowercase", strict, "ABCDEF1!", nil, []string{PasswordViolationMissingLower}},
+		{"missing digit", strict, "Abcdefg!", nil, []string{PasswordViolationMissingDigit}},
+		{"missing symbol", strict, "Abcdefg1", nil, []string{PasswordViolationMissingSymbol}},
+		{"every violation is reported", strict, "abc", nil,
+			[]string{PasswordViolationTooShort, PasswordViolationMissingUpper, PasswordViolationMissingDigit, PasswordViolationMissingSymbol}},
+		{"contains the username", &PasswordPolicy{DisallowUserInfo: true}, "xx-ALICE-xx", user, []string{PasswordViolationContainsUser}},
+		{"contains the email", &PasswordPolicy{DisallowUserInfo: true}, "wonderland@example.com!", user, []string{PasswordViolationContainsUser}},
+		{"contains the email local part", &PasswordPolicy{DisallowUserInfo: true}, "my-Wonderland-1", user, []string{PasswordViolationContainsUser}},
+		{"unrelated to the user", &PasswordPolicy{DisallowUserInfo: true}, "correct horse", user, nil},
+		{"user info check disabled", &PasswordPolicy{}, "alice-1234", user, nil},
+		{"no user to compare", &PasswordPolicy{DisallowUserInfo: true}, "alice-1234", nil, nil},
+		{"common password", &PasswordPolicy{CheckCommon: true}, "password", nil, []string{PasswordViolationCommonPassword}},
+		{"common password in another case", &PasswordPolicy{CheckCommon: true}, "QWERTY", nil, []string{PasswordViolationCommonPassword}},
+		{"uncommon password", &PasswordPolicy{CheckCommon: true}, "correct horse battery", nil, nil},
+		{"common password check disabled", &PasswordPolicy{}, "password", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate(tt.password, tt.user)
+			if got := violationCodes(err); !reflect.DeepEqual(got, tt.want) || (err != nil) != (tt.want != nil) {
+				t.Errorf("Validate(%q) = %v, want violations %v", tt.password, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordHistory(t *testing.T) {
+	db := newTestDB(t)
+	users := NewUserService(db, &fakeMailer{}, &TokenSigner{secret: []byte("test secret")})
+	users.passwordPolicy = &PasswordPolicy{MinLength: 8, HistorySize: 2}
+
+	user := &database.User{Username: "alice", Email: "alice@example.com"}
+	if err := user.SetPassword("first password"); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	mustCreate(t, db, user)
+	for _, password := range []string{"second password", "third password"} {
+		if err := users.UpdateUserPassword(AuditActor{}, user.ID, password); err != nil {
+			t.Fatalf("UpdateUserPassword(%q): %v", password, err)
+		}
+	}
+
+	tests := []struct {
+		password string
+		reused   bool
+	}{
+		{"third password", true},  // the current password
+		{"second password", true}, // within the history
+		{"first password", false}, // beyond the history size
+		{"fourth password", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			err := users.UpdateUserPassword(AuditActor{}, user.ID, tt.password)
+			reused := reflect.DeepEqual(violationCodes(err), []string{PasswordViolationReused})
+			if reused != tt.reused || (!tt.reused && err != nil) {
+				t.Errorf("UpdateUserPassword(%q) = %v, want reused %v", tt.password, err, tt.reused)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyMaxLength(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 8, MaxLength: 128}
+
+	tests := []struct {
+		name      string
+		algorithm string
+		password  string
+		tooLong   bool
+	}{
+		{"bcrypt at its limit", database.PasswordAlgorithmBcrypt, strings.Repeat("a", 72), false},
+		{"bcrypt over its limit", database.PasswordAlgorithmBcrypt, strings.Repeat("a", 73), true},
+		{"bcrypt multibyte over its limit", database.PasswordAlgorithmBcrypt, strings.Repeat("é", 37), true},
+		{"argon2id within the policy", database.PasswordAlgorithmArgon2id, strings.Repeat("a", 128), false},
+		{"argon2id over the policy", database.PasswordAlgorithmArgon2id, strings.Repeat("a", 129), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := database.LoadPasswordHashConfig()
+			config.Algorithm = tt.algorithm
+			database.SetPasswordHashConfig(config)
+			t.Cleanup(func() { database.SetPasswordHashConfig(database.LoadPasswordHashConfig()) })
+
+			err := policy.Validate(tt.password, nil)
+			var policyErr *PasswordPolicyError
+			tooLong := errors.As(err, &policyErr) && len(policyErr.Violations) == 1 &&
+				policyErr.Violations[0].Code == PasswordViolationTooLong
+			if tooLong != tt.tooLong {
+				t.Fatalf("Validate() = %v, want too long %v", err, tt.tooLong)
+			}
+			// Whatever the policy accepts must be hashable
+			if err == nil {
+				if _, err := database.HashPassword(tt.password); err != nil {
+					t.Errorf("HashPassword: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"miniauth/database"
+	"net/url"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidResetToken is returned when a password reset token is unknown, used or expired
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// RequestPasswordReset creates a single-use reset token for the account with the given email and mails it.
+// Unknown addresses and rate limited requests are silently ignored so the caller cannot probe for accounts.
+func (s *UserService) RequestPasswordReset(email, requestIP string) error {
+	user, err := s.GetUserByEmail(email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	// Limit the number of reset emails per account
+	var recent int64
+	err = s.db.Model(&database.PasswordResetToken{}).
+		Where("user_id = ? AND created_at > ?", user.ID, time.Now().Add(-time.Hour)).
+		Count(&recent).Error
+	if err != nil {
+		return err
+	}
+	if recent >= int64(s.passwordResetMaxPerHour) {
+		return nil
+	}
+
+	token := generateSecureToken()
+	resetToken := &database.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(s.passwordResetTTL),
+		RequestIP: requestIP,
+	}
+	if err := s.db.Create(resetToken).Error; err != nil {
+		return fmt.Errorf("failed to create password reset token: %w", err)
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", s.baseURL, url.QueryEscape(token))
+	body := fmt.Sprintf("Hello %s,\n\nA password reset was requested for your account. Open the link below to choose a new password:\n\n%s\n\nThe link expires in %s and can only be used once. If you did not request a reset, you can ignore this email.\n",
+		user.Username, link, s.passwordResetTTL)
+
+	return s.mailer.Send(MailMessage{
+		To:      []string{user.Email},
+		Subject: "Reset your password",
+		Body:    body,
+	})
+}
+
+// QueuePasswordReset handles a reset request in the background, so neither the response nor its
+// timing reveals which addresses exist. Requests are dropped while the mail queue is full.
+func (s *UserService) QueuePasswordReset(email, requestIP string) {
+	s.mailQueue.submit(func() error {
+		if err := s.RequestPasswordReset(email, requestIP); err != nil {
+			return fmt.Errorf("failed to handle password reset request: %w", err)
+		}
+		return nil
+	})
+}
+
+// ResetPassword sets a new password using a reset token.
+// All existing sessions and OAuth tokens of the user are invalidated.
+func (s *UserService) ResetPassword(actor AuditActor, token, newPassword string) error {
+	var resetToken database.PasswordResetToken
+	err := s.db.Preload("User").
+		Where("token_hash = ? AND used_at IS NULL", hashToken(token)).
+		First(&resetToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	if time.Now().After(resetToken.ExpiresAt) {
+		return ErrInvalidResetToken
+	}
+
+	user := &resetToken.User
+	if err := s.SetUserPassword(user, newPassword); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// Consume the token (and any other outstanding token of this user) exactly once
+		now := time.Now()
+		result := tx.Model(&database.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", resetToken.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidResetToken
+		}
+		if err := tx.Model(&database.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{"password_hash": user.PasswordHash}
+		// Users created by an identity provider can sign in with the password from now on
+		if user.AuthSource == AuthSourceFederated {
+			updates["auth_source"] = AuthSourceLocal
+		}
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := s.recordPasswordHistory(tx, user); err != nil {
+			return err
+		}
+		if err := recordAudit(tx, actor, auditEntry{
+			Action:     AuditActionPasswordReset,
+			TargetType: AuditTargetUser,
+			TargetID:   auditID(user.ID),
+		}); err != nil {
+			return err
+		}
+
+		return revokeUserCredentials(tx, user.ID, "password_reset")
+	})
+}
+
+// IsSessionValid reports whether a session issued with the given version is still valid for the user
+func (s *UserService) IsSessionValid(userID, sessionVersion uint) bool {
+	var user database.User
+	if err := s.db.Select("id", "session_version").First(&user, userID).Error; err != nil {
+		return false
+	}
+	return user.SessionVersion == sessionVersion
+}
+
+// revokeUserCredentials invalidates all sessions, OAuth access tokens, refresh tokens and personal
+// access tokens of a user.
+// The reason is passed on to webhook subscribers.
+func revokeUserCredentials(tx *gorm.DB, userID uint, reason string) error {
+	if err := tx.Model(&database.User{}).
+		Where("id = ?", userID).
+		Update("session_version", gorm.Expr("session_version + 1")).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(&database.OAuthAccessToken{}).
+		Where("user_id = ? AND revoked = ?", userID, false).
+		Update("revoked", true).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(&database.OAuthRefreshToken{}).
+		Where("user_id = ? AND revoked = ?", userID, false).
+		Update("revoked", true).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(&database.PersonalAccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		return err
+	}
+
+	return emitWebhookEvent(tx, WebhookEventTokenRevoked, map[string]interface{}{
+		"user_id":    userID,
+		"token_type": "all",
+		"reason":     reason,
+	})
+}
+
+// generateSecureToken returns a random URL-safe token
+func generateSecureToken() string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a token for storage
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,396 @@
+package service
+
+import (
+	"errors"
+	"miniauth/database"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Permission scopes
+const (
+	// PermissionScopeOrg covers permissions granted by organization membership
+	PermissionScopeOrg = "org"
+	// PermissionScopeSystem covers permissions granted by the global user role
+	PermissionScopeSystem = "system"
+)
+
+// Organization permissions
+const (
+	PermissionOrgRead       = "org:read"
+	PermissionOrgUpdate     = "org:update"
+	PermissionOrgDelete     = "org:delete"
+	PermissionOrgTransfer   = "org:transfer"
+	PermissionMembersRead   = "members:read"
+	PermissionMembersInvite = "members:invite"
+	PermissionMembersManage = "members:manage"
+	PermissionRolesManage   = "roles:manage"
+	PermissionTeamsRead     = "teams:read"
+	PermissionTeamsManage   = "teams:manage"
+	PermissionAppsRead      = "apps:read"
+	PermissionAppsManage    = "apps:manage"
+	PermissionDomainsManage = "domains:manage"
+)
+
+// System permissions
+const (
+	PermissionUsersRead   = "users:read"
+	PermissionUsersManage = "users:manage"
+)
+
+var (
+	// ErrUnknownPermission is returned when a custom role refers to a permission that does not exist
+	ErrUnknownPermission = errors.New("unknown permission")
+	// ErrReservedPermission is returned when a custom role includes a permission reserved for owners
+	ErrReservedPermission = errors.New("permission is reserved for organization owners")
+	// ErrOrgRoleNameTaken is returned when a custom role name is already used in the organization or by a built-in role
+	ErrOrgRoleNameTaken = errors.New("role name is already taken")
+)
+
+// PermissionInfo describes a permission that can be granted
+type PermissionInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// OrgPermissionCatalog lists every organization permission
+var OrgPermissionCatalog = []PermissionInfo{
+	{PermissionOrgRead, "View the organization"},
+	{PermissionOrgUpdate, "Rename the organization"},
+	{PermissionOrgDelete, "Delete the organization (owners only)"},
+	{PermissionOrgTransfer, "Transfer ownership of the organization (owners only)"},
+	{PermissionMembersRead, "List members and roles"},
+	{PermissionMembersInvite, "Invite members and manage pending invitations and join requests"},
+	{PermissionMembersManage, "Change member roles and remove members"},
+	{PermissionRolesManage, "Create, update and delete custom roles"},
+	{PermissionTeamsRead, "List teams and their members"},
+	{PermissionTeamsManage, "Create, update and delete teams and manage team members"},
+	{PermissionAppsRead, "View the organization's OAuth applications"},
+	{PermissionAppsManage, "Create and manage the organization's OAuth applications"},
+	{PermissionDomainsManage, "Claim and verify email domains and set their join policy"},
+}
+
+// ownerOnlyPermissions cannot be granted through custom roles
+var ownerOnlyPermissions = map[string]bool{
+	PermissionOrgDelete:   true,
+	PermissionOrgTransfer: true,
+}
+
+// builtinOrgRolePermissions maps the built-in organization roles to their permission sets
+var builtinOrgRolePermissions = map[database.OrgMemberRole][]string{
+	database.OrgMemberRoleOwner: {
+		PermissionOrgRead, PermissionOrgUpdate, PermissionOrgDelete, PermissionOrgTransfer,
+		PermissionMembersRead, PermissionMembersInvite, PermissionMembersManage,
+		PermissionRolesManage, PermissionTeamsRead, PermissionTeamsManage,
+		PermissionAppsRead, PermissionAppsManage, PermissionDomainsManage,
+	},
+	database.OrgMemberRoleAdmin: {
+		PermissionOrgRead, PermissionOrgUpdate,
+		PermissionMembersRead, PermissionMembersInvite, PermissionMembersManage,
+		PermissionRolesManage, PermissionTeamsRead, PermissionTeamsManage,
+		PermissionAppsRead, PermissionAppsManage, PermissionDomainsManage,
+	},
+	database.OrgMemberRoleMember: {
+		PermissionOrgRead, PermissionMembersRead, PermissionTeamsRead, PermissionAppsRead,
+	},
+	database.OrgMemberRoleGuest: {
+		PermissionOrgRead,
+	},
+}
+
+// builtinSystemRolePermissions maps the global user roles to their permission sets
+var builtinSystemRolePermissions = map[database.UserRole][]string{
+	database.UserRoleAdmin: {PermissionUsersRead, PermissionUsersManage, PermissionAppsManage},
+	database.UserRoleUser:  {},
+}
+
+// BuiltinOrgRolePermissions returns the permission set of a built-in organization role
+func BuiltinOrgRolePermissions(role database.OrgMemberRole) []string {
+	return append([]string(nil), builtinOrgRolePermissions[role]...)
+}
+
+// BuiltinSystemRolePermissions returns the permission set of a global user role
+func BuiltinSystemRolePermissions(role database.UserRole) []string {
+	return append([]string(nil), builtinSystemRolePermissions[role]...)
+}
+
+type PermissionService struct {
+	db *gorm.DB
+}
+
+func NewPermissionService(db *gorm.DB) *PermissionService {
+	return &PermissionService{db: db}
+}
+
+// GetOrgPermissions returns the effective permissions of a member: the permissions of the
+// built-in role combined with those of the assigned custom role and of the member's teams
+func (s *PermissionService) GetOrgPermissions(orgID, userID uint) ([]string, error) {
+	var member database.OrgMember
+	if err := s.db.Where("org_id = ? AND user_id = ?", orgID, userID).First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotOrgMember
+		}
+		return nil, err
+	}
+
+	return s.memberPermissions(&member)
+}
+
+// HasOrgPermission reports whether the user holds a permission in the organization
+func (s *PermissionService) HasOrgPermission(orgID, userID uint, permission string) (bool, error) {
+	permissions, err := s.GetOrgPermissions(orgID, userID)
+	if err != nil {
+		if errors.Is(err, ErrNotOrgMember) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return containsPermission(permissions, permission), nil
+}
+
+// RequireOrgPermission returns the user's membership if they hold the permission in the organization.
+// An empty permission only requires membership.
+func (s *PermissionService) RequireOrgPermission(orgID, userID uint, permission string) (*database.OrgMember, error) {
+	var member database.OrgMember
+	if err := s.db.Where("org_id = ? AND user_id = ?", orgID, userID).First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotOrgMember
+		}
+		return nil, err
+	}
+
+	if permission == "" {
+		return &member, nil
+	}
+
+	permissions, err := s.memberPermissions(&member)
+	if err != nil {
+		return nil, err
+	}
+	if !containsPermission(permissions, permission) {
+		return &member, ErrOrgPermissionDenied
+	}
+
+	return &member, nil
+}
+
+// HasSystemPermission reports whether the user's global role grants a system permission
+func (s *PermissionService) HasSystemPermission(userID uint, permission string) (bool, error) {
+	var user database.User
+	if err := s.db.Select("id", "role").First(&user, userID).Error; err != nil {
+		return false, err
+	}
+
+	return containsPermission(builtinSystemRolePermissions[user.Role], permission), nil
+}
+
+// Check reports whether the user holds a permission in a scope. For the org scope the resource
+// is the organization slug; a missing organization, or one the user is not a member of, is
+// reported as gorm.ErrRecordNotFound. The system scope ignores the resource.
+func (s *PermissionService) Check(userID uint, scope, resource, permission string) (bool, error) {
+	switch scope {
+	case PermissionScopeSystem:
+		return s.HasSystemPermission(userID, permission)
+	case PermissionScopeOrg:
+		var org database.Org
+		if err := s.db.Select("id").Where("slug = ?", resource).First(&org).Error; err != nil {
+			return false, err
+		}
+
+		permissions, err := s.GetOrgPermissions(org.ID, userID)
+		if err != nil {
+			if errors.Is(err, ErrNotOrgMember) {
+				return false, gorm.ErrRecordNotFound
+			}
+			return false, err
+		}
+		return containsPermission(permissions, permission), nil
+	}
+
+	return false, errors.New("unknown permission scope")
+}
+
+// ValidateOrgRolePermissions checks that permissions exist and may be granted through a custom role
+func ValidateOrgRolePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if ownerOnlyPermissions[permission] {
+			return ErrReservedPermission
+		}
+		if !isOrgPermission(permission) {
+			return ErrUnknownPermission
+		}
+	}
+	return nil
+}
+
+// ListOrgRoles retrieves the custom roles of an organization
+func (s *PermissionService) ListOrgRoles(orgID uint) ([]database.OrgRole, error) {
+	var roles []database.OrgRole
+	err := s.db.Where("org_id = ?", orgID).Order("name").Find(&roles).Error
+	return roles, err
+}
+
+// GetOrgRole retrieves a custom role of an organization
+func (s *PermissionService) GetOrgRole(orgID, roleID uint) (*database.OrgRole, error) {
+	var role database.OrgRole
+	if err := s.db.Where("id = ? AND org_id = ?", roleID, orgID).First(&role).Error; err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// CreateOrgRole creates a custom role in an organization
+func (s *PermissionService) CreateOrgRole(role *database.OrgRole, permissions []string) error {
+	if err := ValidateOrgRolePermissions(permissions); err != nil {
+		return err
+	}
+	if err := s.ensureOrgRoleNameAvailable(role.OrgID, role.Name, 0); err != nil {
+		return err
+	}
+
+	role.Permissions = joinPermissions(permissions)
+	return s.db.Create(role).Error
+}
+
+// UpdateOrgRole updates the name, description and permissions of a custom role
+func (s *PermissionService) UpdateOrgRole(role *database.OrgRole, permissions []string) error {
+	if err := ValidateOrgRolePermissions(permissions); err != nil {
+		return err
+	}
+	if err := s.ensureOrgRoleNameAvailable(role.OrgID, role.Name, role.ID); err != nil {
+		return err
+	}
+
+	role.Permissions = joinPermissions(permissions)
+	return s.db.Save(role).Error
+}
+
+// DeleteOrgRole deletes a custom role and unassigns it from all members
+func (s *PermissionService) DeleteOrgRole(orgID, roleID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// Include removed memberships so they do not point to a missing role when restored
+		if err := tx.Unscoped().Model(&database.OrgMember{}).
+			Where("org_id = ? AND org_role_id = ?", orgID, roleID).
+			Update("org_role_id", nil).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("id = ? AND org_id = ?", roleID, orgID).Delete(&database.OrgRole{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// AssignOrgRole assigns a custom role to a member, or removes it when roleID is nil
+func (s *PermissionService) AssignOrgRole(orgID, userID uint, roleID *uint) error {
+	if roleID != nil {
+		if _, err := s.GetOrgRole(orgID, *roleID); err != nil {
+			return err
+		}
+	}
+
+	result := s.db.Model(&database.OrgMember{}).
+		Where("org_id = ? AND user_id = ?", orgID, userID).
+		Update("org_role_id", roleID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotOrgMember
+	}
+	return nil
+}
+
+// memberPermissions combines the permissions of a member's built-in role, custom role and teams
+func (s *PermissionService) memberPermissions(member *database.OrgMember) ([]string, error) {
+	permissions := BuiltinOrgRolePermissions(member.Role)
+	grant := func(granted string) {
+		for _, permission := range SplitPermissions(granted) {
+			if !containsPermission(permissions, permission) {
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+
+	if member.OrgRoleID != nil {
+		role, err := s.GetOrgRole(member.OrgID, *member.OrgRoleID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if role != nil {
+			grant(role.Permissions)
+		}
+	}
+
+	teams, err := userTeams(s.db, member.OrgID, member.UserID)
+	if err != nil {
+		return nil, err
+	}
+	for _, team := range teams {
+		grant(team.Permissions)
+	}
+
+	sort.Strings(permissions)
+	return permissions, nil
+}
+
+// ensureOrgRoleNameAvailable checks that no built-in role or other custom role of the organization uses the name
+func (s *PermissionService) ensureOrgRoleNameAvailable(orgID uint, name string, exceptRoleID uint) error {
+	if _, builtin := builtinOrgRolePermissions[database.OrgMemberRole(strings.ToLower(name))]; builtin {
+		return ErrOrgRoleNameTaken
+	}
+
+	var count int64
+	if err := s.db.Model(&database.OrgRole{}).
+		Where("org_id = ? AND name = ? AND id <> ?", orgID, name, exceptRoleID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrOrgRoleNameTaken
+	}
+	return nil
+}
+
+// SplitPermissions parses a space-separated permission list
+func SplitPermissions(permissions string) []string {
+	return strings.Fields(permissions)
+}
+
+// joinPermissions formats permissions as a deduplicated space-separated list
+func joinPermissions(permissions []string) string {
+	unique := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		if !containsPermission(unique, permission) {
+			unique = append(unique, permission)
+		}
+	}
+	sort.Strings(unique)
+	return strings.Join(unique, " ")
+}
+
+func isOrgPermission(permission string) bool {
+	for _, info := range OrgPermissionCatalog {
+		if info.Name == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPermission(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
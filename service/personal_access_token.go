@@ -0,0 +1,184 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"miniauth/database"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	personalAccessTokenPrefix = "mpat_"
+	// personalAccessTokenPrefixLength is how much of a token is kept to tell tokens apart
+	personalAccessTokenPrefixLength = len(personalAccessTokenPrefix) + 6
+	// personalAccessTokenUsageInterval limits how often the last use of a token is written
+	personalAccessTokenUsageInterval = time.Minute
+)
+
+// PersonalAccessTokenScopes lists the scopes personal access tokens can be granted
+var PersonalAccessTokenScopes = []string{
+	database.TokenScopeRead,
+	database.TokenScopeWrite,
+	database.TokenScopeAdmin,
+}
+
+var (
+	// ErrInvalidTokenScope is returned when a token request names an unknown scope
+	ErrInvalidTokenScope = errors.New("unknown token scope")
+	// ErrAdminScopeForbidden is returned when a user who is not an admin asks for the admin scope
+	ErrAdminScopeForbidden = errors.New("only admins can create tokens with the admin scope")
+	// ErrInvalidPersonalAccessToken is returned when a token is unknown, revoked or expired, or its user is disabled
+	ErrInvalidPersonalAccessToken = errors.New("invalid personal access token")
+)
+
+// PersonalAccessTokenRequest creates a personal access token
+type PersonalAccessTokenRequest struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes" validate:"required,min=1"`
+	ExpiresInDays *int     `json:"expires_in_days" validate:"omitempty,min=1,max=3650"` // Omit for a token that does not expire
+}
+
+// PersonalAccessTokenService manages the personal access tokens users call the API with
+type PersonalAccessTokenService struct {
+	db *gorm.DB
+}
+
+// NewPersonalAccessTokenService creates a new personal access token service
+func NewPersonalAccessTokenService(db *gorm.DB) *PersonalAccessTokenService {
+	return &PersonalAccessTokenService{db: db}
+}
+
+// CreateToken creates a personal access token for a user. The token is returned only here;
+// just its hash is stored.
+func (s *PersonalAccessTokenService) CreateToken(actor AuditActor, userID uint, req PersonalAccessTokenRequest) (*database.PersonalAccessToken, string, error) {
+	var user database.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return nil, "", err
+	}
+
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		if !slices.Contains(PersonalAccessTokenScopes, scope) {
+			return nil, "", fmt.Errorf("%w: %q", ErrInvalidTokenScope, scope)
+		}
+		if scope == database.TokenScopeAdmin && user.Role != database.UserRoleAdmin {
+			return nil, "", ErrAdminScopeForbidden
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	token := personalAccessTokenPrefix + generateSecureToken()
+	accessToken := &database.PersonalAccessToken{
+		UserID:    userID,
+		Name:      strings.TrimSpace(req.Name),
+		TokenHash: hashToken(token),
+		Prefix:    token[:personalAccessTokenPrefixLength],
+		Scopes:    strings.Join(scopes, " "),
+	}
+	if req.ExpiresInDays != nil {
+		expiresAt := time.Now().AddDate(0, 0, *req.ExpiresInDays)
+		accessToken.ExpiresAt = &expiresAt
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(accessToken).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, auditEntry{
+			Action:     AuditActionPersonalAccessTokenCreated,
+			TargetType: AuditTargetPersonalAccessToken,
+			TargetID:   auditID(accessToken.ID),
+			Metadata: map[string]interface{}{
+				"name":   accessToken.Name,
+				"scopes": scopes,
+			},
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return accessToken, token, nil
+}
+
+// ListTokens lists the personal access tokens of a user that have not been revoked, newest first
+func (s *PersonalAccessTokenService) ListTokens(userID uint) ([]database.PersonalAccessToken, error) {
+	var tokens []database.PersonalAccessToken
+	err := s.db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("id DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// RevokeToken revokes a personal access token of a user
+func (s *PersonalAccessTokenService) RevokeToken(actor AuditActor, userID, tokenID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var accessToken database.PersonalAccessToken
+		if err := tx.Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).First(&accessToken).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&accessToken).Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		if err := recordAudit(tx, actor, auditEntry{
+			Action:     AuditActionPersonalAccessTokenRevoked,
+			TargetType: AuditTargetPersonalAccessToken,
+			TargetID:   auditID(accessToken.ID),
+			Metadata: map[string]interface{}{
+				"name": accessToken.Name,
+			},
+		}); err != nil {
+			return err
+		}
+		return emitWebhookEvent(tx, WebhookEventTokenRevoked, map[string]interface{}{
+			"user_id":    userID,
+			"token_type": "personal_access_token",
+			"reason":     "revoked",
+		})
+	})
+}
+
+// Authenticate resolves a bearer token to its user, recording where the token was last used from
+func (s *PersonalAccessTokenService) Authenticate(token, ip string) (*database.User, *database.PersonalAccessToken, error) {
+	if !strings.HasPrefix(token, personalAccessTokenPrefix) {
+		return nil, nil, ErrInvalidPersonalAccessToken
+	}
+
+	var accessToken database.PersonalAccessToken
+	if err := s.db.Where("token_hash = ? AND revoked_at IS NULL", hashToken(token)).First(&accessToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidPersonalAccessToken
+		}
+		return nil, nil, err
+	}
+	now := time.Now()
+	if accessToken.ExpiresAt != nil && !now.Before(*accessToken.ExpiresAt) {
+		return nil, nil, ErrInvalidPersonalAccessToken
+	}
+
+	var user database.User
+	if err := s.db.First(&user, accessToken.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidPersonalAccessToken
+		}
+		return nil, nil, err
+	}
+	if user.Disabled {
+		return nil, nil, ErrInvalidPersonalAccessToken
+	}
+
+	if accessToken.LastUsedAt == nil || now.Sub(*accessToken.LastUsedAt) >= personalAccessTokenUsageInterval || accessToken.LastUsedIP != ip {
+		if err := s.db.Model(&accessToken).Updates(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": ip,
+		}).Error; err != nil {
+			log.Printf("failed to record use of personal access token %d: %v", accessToken.ID, err)
+		}
+	}
+	return &user, &accessToken, nil
+}
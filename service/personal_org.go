@@ -0,0 +1,177 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"miniauth/database"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Personal organization policies
+const (
+	// PersonalOrgPolicyAlways creates a personal organization for every new user
+	PersonalOrgPolicyAlways = "always"
+	// PersonalOrgPolicyNever never creates personal organizations
+	PersonalOrgPolicyNever = "never"
+	// PersonalOrgPolicyOnDemand creates a personal organization when the user asks for one
+	PersonalOrgPolicyOnDemand = "on_demand"
+)
+
+// maxOrgSlugLength is the longest slug accepted for an organization
+const maxOrgSlugLength = 64
+
+var (
+	// ErrPersonalOrgDisabled is returned when personal organizations are disabled by policy
+	ErrPersonalOrgDisabled = errors.New("personal organizations are disabled")
+	// ErrPersonalOrgExists is returned when the user already has a personal organization
+	ErrPersonalOrgExists = errors.New("user already has a personal organization")
+	// ErrNoPersonalOrg is returned when the user has no personal organization
+	ErrNoPersonalOrg = errors.New("user has no personal organization")
+)
+
+// PersonalOrgPolicy returns the configured personal organization policy
+func (s *UserService) PersonalOrgPolicy() string {
+	return s.personalOrgPolicy
+}
+
+// GetPersonalOrg retrieves the personal organization of a user
+func (s *UserService) GetPersonalOrg(userID uint) (*database.Org, error) {
+	var org database.Org
+	if err := s.db.Where("personal_user_id = ?", userID).First(&org).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNoPersonalOrg
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+// CreatePersonalOrg creates a personal organization for a user who has none, unless the policy is never
+func (s *UserService) CreatePersonalOrg(userID uint) (*database.Org, error) {
+	if s.personalOrgPolicy == PersonalOrgPolicyNever {
+		return nil, ErrPersonalOrgDisabled
+	}
+
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var org *database.Org
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&database.Org{}).Where("personal_user_id = ?", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrPersonalOrgExists
+		}
+
+		org, err = createPersonalOrg(tx, user)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// DetachPersonalOrg turns the personal organization of a user into a regular organization.
+// The user stays its owner and can create a new personal organization unless the policy is never.
+func (s *UserService) DetachPersonalOrg(userID uint) (*database.Org, error) {
+	org, err := s.GetPersonalOrg(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Model(org).Update("personal_user_id", nil).Error; err != nil {
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// createPersonalOrg creates an organization named after the user with a unique slug derived from
+// the username, and makes the user its owner
+func createPersonalOrg(tx *gorm.DB, user *database.User) (*database.Org, error) {
+	// An organization pending deletion keeps its personal marker until it is released here
+	if err := tx.Unscoped().Model(&database.Org{}).
+		Where("personal_user_id = ? AND deleted_at IS NOT NULL", user.ID).
+		Update("personal_user_id", nil).Error; err != nil {
+		return nil, err
+	}
+
+	slug, err := uniqueOrgSlug(tx, user.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	org := &database.Org{
+		Name:           user.Username,
+		Slug:           slug,
+		PersonalUserID: &user.ID,
+	}
+	if err := tx.Create(org).Error; err != nil {
+		return nil, err
+	}
+
+	if err := addOrgMember(tx, user.ID, org.ID, database.OrgMemberRoleOwner); err != nil {
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// NormalizeOrgSlug converts a name into a valid organization slug: lowercase letters and digits
+// separated by single hyphens. Names without usable characters become "org".
+func NormalizeOrgSlug(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			hyphen = false
+			b.WriteRune(r)
+			continue
+		}
+		hyphen = true
+	}
+
+	slug := b.String()
+	if len(slug) > maxOrgSlugLength {
+		slug = strings.TrimRight(slug[:maxOrgSlugLength], "-")
+	}
+	if len(slug) < 2 {
+		return "org"
+	}
+	return slug
+}
+
+// uniqueOrgSlug returns the normalized slug of a name, suffixed with -2, -3, ... when it is already
+// used by an organization, including organizations pending deletion
+func uniqueOrgSlug(tx *gorm.DB, name string) (string, error) {
+	base := NormalizeOrgSlug(name)
+	slug := base
+
+	for n := 2; ; n++ {
+		var count int64
+		if err := tx.Unscoped().Model(&database.Org{}).Where("slug = ?", slug).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return slug, nil
+		}
+
+		suffix := fmt.Sprintf("-%d", n)
+		trimmed := base
+		if len(trimmed)+len(suffix) > maxOrgSlugLength {
+			trimmed = strings.TrimRight(trimmed[:maxOrgSlugLength-len(suffix)], "-")
+		}
+		slug = trimmed + suffix
+	}
+}
@@ -0,0 +1,698 @@
+package service
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"miniauth/database"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// NameID formats service providers can receive
+const (
+	SAMLNameIDFormatEmail       = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	SAMLNameIDFormatPersistent  = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+	SAMLNameIDFormatUnspecified = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+)
+
+// SAMLNameIDFormats lists the supported NameID formats. Email sends the address, persistent the
+// user ID and unspecified the username.
+var SAMLNameIDFormats = []string{SAMLNameIDFormatEmail, SAMLNameIDFormatPersistent, SAMLNameIDFormatUnspecified}
+
+// User fields SAML attributes can be mapped from
+const (
+	SAMLAttributeSourceID            = "id"
+	SAMLAttributeSourceUsername      = "username"
+	SAMLAttributeSourceEmail         = "email"
+	SAMLAttributeSourceEmailVerified = "email_verified"
+	SAMLAttributeSourceRole          = "role"
+	SAMLAttributeSourceOrgs          = "orgs"      // Slugs of the user's organizations, one value each
+	SAMLAttributeSourceOrgRoles      = "org_roles" // "slug:role" for each of the user's organizations
+	SAMLAttributeSourceOrgRole       = "org_role"  // Role in the organization owning the service provider
+)
+
+// SAMLAttributeSources lists the user fields SAML attributes can be mapped from
+var SAMLAttributeSources = []string{
+	SAMLAttributeSourceID,
+	SAMLAttributeSourceUsername,
+	SAMLAttributeSourceEmail,
+	SAMLAttributeSourceEmailVerified,
+	SAMLAttributeSourceRole,
+	SAMLAttributeSourceOrgs,
+	SAMLAttributeSourceOrgRoles,
+	SAMLAttributeSourceOrgRole,
+}
+
+// defaultSAMLAttributeMappings are sent to service providers without mappings of their own
+var defaultSAMLAttributeMappings = map[string]string{
+	"uid":      SAMLAttributeSourceID,
+	"username": SAMLAttributeSourceUsername,
+	"email":    SAMLAttributeSourceEmail,
+	"role":     SAMLAttributeSourceRole,
+	"groups":   SAMLAttributeSourceOrgs,
+}
+
+const (
+	samlSSOPurpose = "saml_sso"
+	samlSSOTTL     = 10 * time.Minute
+	// samlAssertionLifetime is how long service providers accept an assertion
+	samlAssertionLifetime = 5 * time.Minute
+	// samlRequestSizeLimit bounds decoded and inflated authentication requests
+	samlRequestSizeLimit = 64 << 10
+)
+
+var (
+	// ErrInvalidSAMLRequest is returned when an authentication request cannot be decoded or names
+	// an unknown service provider or assertion consumer service
+	ErrInvalidSAMLRequest = errors.New("invalid SAML authentication request")
+	// ErrInvalidSAMLServiceProvider is returned when a service provider registration is invalid
+	ErrInvalidSAMLServiceProvider = errors.New("invalid SAML service provider")
+	// ErrSAMLServiceProviderExists is returned when another service provider uses the entity ID
+	ErrSAMLServiceProviderExists = errors.New("a service provider with this entity ID already exists")
+	// ErrSAMLServiceProviderMembersOnly is returned when a user outside the owning organization signs in to a members-only service provider
+	ErrSAMLServiceProviderMembersOnly = errors.New("service provider can only be used by members of its organization")
+)
+
+// SAMLServiceProviderRequest registers or updates a SAML service provider
+type SAMLServiceProviderRequest struct {
+	Name              string            `json:"name" validate:"required"`
+	EntityID          string            `json:"entity_id" validate:"required"`
+	ACSURLs           []string          `json:"acs_urls" validate:"required,min=1"` // The first is used when requests name none
+	NameIDFormat      string            `json:"name_id_format"`                     // Defaults to the email address format
+	AttributeMappings map[string]string `json:"attribute_mappings"`                 // SAML attribute name to user field; defaults apply when empty
+	Description       string            `json:"description"`
+	Website           string            `json:"website"`
+	OrgSlug           string            `json:"org_slug"`     // Owning organization, if any
+	MembersOnly       bool              `json:"members_only"` // Only applies to service providers owned by an organization
+}
+
+type SAMLServiceProviderResponse struct {
+	ID                uint              `json:"id"`
+	Name              string            `json:"name"`
+	EntityID          string            `json:"entity_id"`
+	ACSURLs           []string          `json:"acs_urls"`
+	NameIDFormat      string            `json:"name_id_format"`
+	AttributeMappings map[string]string `json:"attribute_mappings"`
+	Description       string            `json:"description"`
+	Website           string            `json:"website"`
+	Active            bool              `json:"active"`
+	OrgID             *uint             `json:"org_id,omitempty"`
+	OrgSlug           string            `json:"org_slug,omitempty"`
+	MembersOnly       bool              `json:"members_only"`
+	CreatedBy         string            `json:"created_by"`
+	CreatedAt         string            `json:"created_at"`
+}
+
+// SAMLSSORequest is an authentication request being answered. It is carried through the login
+// page in a signed token while the user signs in.
+type SAMLSSORequest struct {
+	ServiceProviderID uint
+	RequestID         string // Empty for IdP-initiated sign in
+	ACSURL            string
+	RelayState        string
+}
+
+// SAMLResponse is a signed response to post to the service provider's assertion consumer service
+type SAMLResponse struct {
+	ACSURL       string
+	SAMLResponse string // Base64-encoded Response document
+	RelayState   string
+}
+
+// samlAuthnRequest is the part of an AuthnRequest miniauth uses
+type samlAuthnRequest struct {
+	XMLName                     xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string   `xml:"ID,attr"`
+	Version                     string   `xml:"Version,attr"`
+	AssertionConsumerServiceURL string   `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string   `xml:"ProtocolBinding,attr"`
+	Issuer                      string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+}
+
+type SAMLService struct {
+	db      *gorm.DB
+	keyPair *SigningKeyPair
+	signer  *TokenSigner
+	baseURL string
+}
+
+func NewSAMLService(db *gorm.DB, keyPair *SigningKeyPair, signer *TokenSigner) *SAMLService {
+	return &SAMLService{
+		db:      db,
+		keyPair: keyPair,
+		signer:  signer,
+		baseURL: strings.TrimRight(getEnv("APP_BASE_URL", "http://localhost:8080"), "/"),
+	}
+}
+
+// EntityID returns the identity provider's entity ID, which is also the URL of its metadata
+func (s *SAMLService) EntityID() string {
+	return s.baseURL + "/api/saml/metadata"
+}
+
+// SSOURL returns the single sign-on endpoint of both bindings
+func (s *SAMLService) SSOURL() string {
+	return s.baseURL + "/api/saml/sso"
+}
+
+// Metadata returns the identity provider's metadata document
+func (s *SAMLService) Metadata() string {
+	descriptor := newXMLElement("md:IDPSSODescriptor").
+		attr("WantAuthnRequestsSigned", "false").
+		attr("protocolSupportEnumeration", samlProtocolNS).
+		add(newXMLElement("md:KeyDescriptor").attr("use", "signing").add(
+			newXMLElement("ds:KeyInfo").ns("ds", xmlDSigNS).add(
+				newXMLElement("ds:X509Data").add(
+					newXMLElement("ds:X509Certificate").withText(base64.StdEncoding.EncodeToString(s.keyPair.Certificate.Raw)),
+				),
+			),
+		))
+	for _, format := range SAMLNameIDFormats {
+		descriptor.add(newXMLElement("md:NameIDFormat").withText(format))
+	}
+	descriptor.add(
+		newXMLElement("md:SingleSignOnService").attr("Binding", samlBindingRedirect).attr("Location", s.SSOURL()),
+		newXMLElement("md:SingleSignOnService").attr("Binding", samlBindingPOST).attr("Location", s.SSOURL()),
+	)
+
+	entity := newXMLElement("md:EntityDescriptor").ns("md", samlMetadataNS).attr("entityID", s.EntityID()).add(descriptor)
+	return xml.Header + entity.String()
+}
+
+// ListServiceProviders returns all SAML service providers
+func (s *SAMLService) ListServiceProviders() ([]*SAMLServiceProviderResponse, error) {
+	var providers []database.SAMLServiceProvider
+	if err := s.db.Preload("CreatedBy").Preload("Org").Order("id").Find(&providers).Error; err != nil {
+		return nil, err
+	}
+
+	responses := make([]*SAMLServiceProviderResponse, 0, len(providers))
+	for i := range providers {
+		responses = append(responses, newSAMLServiceProviderResponse(&providers[i]))
+	}
+	return responses, nil
+}
+
+// GetServiceProvider returns a SAML service provider by ID
+func (s *SAMLService) GetServiceProvider(id uint) (*SAMLServiceProviderResponse, error) {
+	var provider database.SAMLServiceProvider
+	if err := s.db.Preload("CreatedBy").Preload("Org").First(&provider, id).Error; err != nil {
+		return nil, err
+	}
+	return newSAMLServiceProviderResponse(&provider), nil
+}
+
+// CreateServiceProvider registers a SAML service provider (admin only)
+func (s *SAMLService) CreateServiceProvider(adminUserID uint, req SAMLServiceProviderRequest) (*SAMLServiceProviderResponse, error) {
+	provider := &database.SAMLServiceProvider{CreatedByID: adminUserID, Active: true}
+	if err := s.applyServiceProviderRequest(provider, req); err != nil {
+		return nil, err
+	}
+
+	membersOnly := provider.MembersOnly
+	if err := s.db.Omit("CreatedBy", "Org").Create(provider).Error; err != nil {
+		return nil, fmt.Errorf("failed to create SAML service provider: %w", err)
+	}
+	// Create replaces the false zero value with the column default
+	if !membersOnly {
+		if err := s.db.Model(provider).Update("members_only", false).Error; err != nil {
+			return nil, err
+		}
+	}
+	return s.GetServiceProvider(provider.ID)
+}
+
+// UpdateServiceProvider updates a SAML service provider (admin only)
+func (s *SAMLService) UpdateServiceProvider(id uint, req SAMLServiceProviderRequest) (*SAMLServiceProviderResponse, error) {
+	var provider database.SAMLServiceProvider
+	if err := s.db.First(&provider, id).Error; err != nil {
+		return nil, err
+	}
+	if err := s.applyServiceProviderRequest(&provider, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Omit("CreatedBy", "Org").Save(&provider).Error; err != nil {
+		return nil, fmt.Errorf("failed to update SAML service provider: %w", err)
+	}
+	return s.GetServiceProvider(provider.ID)
+}
+
+// ToggleServiceProviderStatus toggles the active status of a SAML service provider
+func (s *SAMLService) ToggleServiceProviderStatus(id uint) error {
+	var provider database.SAMLServiceProvider
+	if err := s.db.First(&provider, id).Error; err != nil {
+		return err
+	}
+	return s.db.Model(&provider).Update("active", !provider.Active).Error
+}
+
+// DeleteServiceProvider deletes a SAML service provider
+func (s *SAMLService) DeleteServiceProvider(id uint) error {
+	result := s.db.Delete(&database.SAMLServiceProvider{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *SAMLService) applyServiceProviderRequest(provider *database.SAMLServiceProvider, req SAMLServiceProviderRequest) error {
+	for _, acsURL := range req.ACSURLs {
+		if parsed, err := url.Parse(acsURL); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return fmt.Errorf("%w: %s is not an absolute http or https URL", ErrInvalidSAMLServiceProvider, acsURL)
+		}
+	}
+	nameIDFormat := firstNonEmpty(req.NameIDFormat, SAMLNameIDFormatEmail)
+	if !slices.Contains(SAMLNameIDFormats, nameIDFormat) {
+		return fmt.Errorf("%w: unsupported NameID format %s", ErrInvalidSAMLServiceProvider, nameIDFormat)
+	}
+	for name, source := range req.AttributeMappings {
+		if strings.TrimSpace(name) == "" || !slices.Contains(SAMLAttributeSources, source) {
+			return fmt.Errorf("%w: cannot map attribute %q from %q", ErrInvalidSAMLServiceProvider, name, source)
+		}
+	}
+
+	var count int64
+	if err := s.db.Model(&database.SAMLServiceProvider{}).Where("entity_id = ? AND id <> ?", req.EntityID, provider.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrSAMLServiceProviderExists
+	}
+
+	provider.OrgID = nil
+	if req.OrgSlug != "" {
+		var org database.Org
+		if err := s.db.Select("id").Where("slug = ?", req.OrgSlug).First(&org).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: unknown organization %s", ErrInvalidSAMLServiceProvider, req.OrgSlug)
+			}
+			return err
+		}
+		provider.OrgID = &org.ID
+	}
+
+	acsURLs, err := json.Marshal(req.ACSURLs)
+	if err != nil {
+		return err
+	}
+	mappings := ""
+	if len(req.AttributeMappings) > 0 {
+		encoded, err := json.Marshal(req.AttributeMappings)
+		if err != nil {
+			return err
+		}
+		mappings = string(encoded)
+	}
+
+	provider.Name = req.Name
+	provider.EntityID = req.EntityID
+	provider.ACSURLs = string(acsURLs)
+	provider.NameIDFormat = nameIDFormat
+	provider.AttributeMappings = mappings
+	provider.Description = req.Description
+	provider.Website = req.Website
+	provider.MembersOnly = provider.OrgID != nil && req.MembersOnly
+	return nil
+}
+
+func newSAMLServiceProviderResponse(provider *database.SAMLServiceProvider) *SAMLServiceProviderResponse {
+	response := &SAMLServiceProviderResponse{
+		ID:                provider.ID,
+		Name:              provider.Name,
+		EntityID:          provider.EntityID,
+		ACSURLs:           samlACSURLs(provider),
+		NameIDFormat:      provider.NameIDFormat,
+		AttributeMappings: samlAttributeMappings(provider),
+		Description:       provider.Description,
+		Website:           provider.Website,
+		Active:            provider.Active,
+		OrgID:             provider.OrgID,
+		MembersOnly:       provider.MembersOnly,
+		CreatedBy:         provider.CreatedBy.Username,
+		CreatedAt:         provider.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+	if provider.Org != nil {
+		response.OrgSlug = provider.Org.Slug
+	}
+	return response
+}
+
+func samlACSURLs(provider *database.SAMLServiceProvider) []string {
+	var acsURLs []string
+	if err := json.Unmarshal([]byte(provider.ACSURLs), &acsURLs); err != nil {
+		log.Printf("SAML service provider %d has invalid ACS URLs: %v", provider.ID, err)
+	}
+	return acsURLs
+}
+
+func samlAttributeMappings(provider *database.SAMLServiceProvider) map[string]string {
+	if provider.AttributeMappings == "" {
+		return defaultSAMLAttributeMappings
+	}
+	var mappings map[string]string
+	if err := json.Unmarshal([]byte(provider.AttributeMappings), &mappings); err != nil {
+		log.Printf("SAML service provider %d has invalid attribute mappings: %v", provider.ID, err)
+	}
+	return mappings
+}
+
+// ParseAuthnRequest decodes an AuthnRequest of the HTTP-Redirect binding (deflated) or of the
+// HTTP-POST binding and checks it against the registered service provider
+func (s *SAMLService) ParseAuthnRequest(encoded string, deflated bool, relayState string) (*SAMLSSORequest, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSAMLRequest, err)
+	}
+	if deflated {
+		data, err = io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), samlRequestSizeLimit+1))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSAMLRequest, err)
+		}
+	}
+	if len(data) > samlRequestSizeLimit {
+		return nil, fmt.Errorf("%w: request too large", ErrInvalidSAMLRequest)
+	}
+
+	var request samlAuthnRequest
+	if err := xml.Unmarshal(data, &request); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSAMLRequest, err)
+	}
+	if request.ID == "" || request.Version != "2.0" || strings.TrimSpace(request.Issuer) == "" {
+		return nil, fmt.Errorf("%w: missing ID, version or issuer", ErrInvalidSAMLRequest)
+	}
+	if request.ProtocolBinding != "" && request.ProtocolBinding != samlBindingPOST {
+		return nil, fmt.Errorf("%w: unsupported response binding %s", ErrInvalidSAMLRequest, request.ProtocolBinding)
+	}
+
+	provider, err := s.activeServiceProvider(strings.TrimSpace(request.Issuer))
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := resolveSAMLACSURL(provider, request.AssertionConsumerServiceURL)
+	if err != nil {
+		return nil, err
+	}
+	return &SAMLSSORequest{
+		ServiceProviderID: provider.ID,
+		RequestID:         request.ID,
+		ACSURL:            acsURL,
+		RelayState:        relayState,
+	}, nil
+}
+
+// IdPInitiatedRequest starts an unsolicited sign in to a service provider, identified by entity ID
+func (s *SAMLService) IdPInitiatedRequest(entityID, relayState string) (*SAMLSSORequest, error) {
+	provider, err := s.activeServiceProvider(entityID)
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := resolveSAMLACSURL(provider, "")
+	if err != nil {
+		return nil, err
+	}
+	return &SAMLSSORequest{ServiceProviderID: provider.ID, ACSURL: acsURL, RelayState: relayState}, nil
+}
+
+func (s *SAMLService) activeServiceProvider(entityID string) (*database.SAMLServiceProvider, error) {
+	var provider database.SAMLServiceProvider
+	if err := s.db.Where("entity_id = ? AND active = ?", entityID, true).First(&provider).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: unknown service provider %s", ErrInvalidSAMLRequest, entityID)
+		}
+		return nil, err
+	}
+	return &provider, nil
+}
+
+// resolveSAMLACSURL returns the requested assertion consumer service when it is registered, and
+// the default one when none is requested
+func resolveSAMLACSURL(provider *database.SAMLServiceProvider, requested string) (string, error) {
+	acsURLs := samlACSURLs(provider)
+	if requested == "" && len(acsURLs) > 0 {
+		return acsURLs[0], nil
+	}
+	if requested == "" || !slices.Contains(acsURLs, requested) {
+		return "", fmt.Errorf("%w: assertion consumer service %q is not registered", ErrInvalidSAMLRequest, requested)
+	}
+	return requested, nil
+}
+
+// SignSSORequest encodes a request in a signed token, to resume it once the user has signed in
+func (s *SAMLService) SignSSORequest(request *SAMLSSORequest) (string, error) {
+	return s.signer.Sign(samlSSOPurpose, strconv.FormatUint(uint64(request.ServiceProviderID), 10), map[string]string{
+		"request_id":  request.RequestID,
+		"acs_url":     request.ACSURL,
+		"relay_state": request.RelayState,
+	}, samlSSOTTL)
+}
+
+// VerifySSORequest decodes a request encoded by SignSSORequest
+func (s *SAMLService) VerifySSORequest(token string) (*SAMLSSORequest, error) {
+	claims, err := s.signer.Verify(token, samlSSOPurpose)
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignedToken
+	}
+	return &SAMLSSORequest{
+		ServiceProviderID: uint(id),
+		RequestID:         claims.Data["request_id"],
+		ACSURL:            claims.Data["acs_url"],
+		RelayState:        claims.Data["relay_state"],
+	}, nil
+}
+
+// IssueResponse signs an assertion about the user for the service provider of the request
+func (s *SAMLService) IssueResponse(actor AuditActor, request *SAMLSSORequest, user *database.User) (*SAMLResponse, error) {
+	var provider database.SAMLServiceProvider
+	if err := s.db.Where("id = ? AND active = ?", request.ServiceProviderID, true).First(&provider).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: service provider is no longer active", ErrInvalidSAMLRequest)
+		}
+		return nil, err
+	}
+	if err := s.checkAccess(&provider, user.ID); err != nil {
+		return nil, err
+	}
+
+	attributes, err := s.userAttributes(&provider, user)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	assertion, err := s.buildAssertion(&provider, request, user, attributes, now)
+	if err != nil {
+		return nil, err
+	}
+
+	response := newXMLElement("samlp:Response").
+		ns("samlp", samlProtocolNS).
+		ns("saml", samlAssertionNS).
+		attr("ID", samlID()).
+		attr("Version", "2.0").
+		attr("IssueInstant", samlTime(now)).
+		attr("Destination", request.ACSURL).
+		attr("InResponseTo", request.RequestID).
+		add(
+			newXMLElement("saml:Issuer").withText(s.EntityID()),
+			newXMLElement("samlp:Status").add(
+				newXMLElement("samlp:StatusCode").attr("Value", "urn:oasis:names:tc:SAML:2.0:status:Success"),
+			),
+			assertion,
+		)
+
+	actor.UserID = &user.ID
+	if err := recordAudit(s.db, actor, auditEntry{
+		Action:     AuditActionSAMLAssertionIssued,
+		TargetType: AuditTargetSAMLServiceProvider,
+		TargetID:   auditID(provider.ID),
+		Metadata:   map[string]interface{}{"entity_id": provider.EntityID, "sp_initiated": request.RequestID != ""},
+	}); err != nil {
+		return nil, err
+	}
+
+	return &SAMLResponse{
+		ACSURL:       request.ACSURL,
+		SAMLResponse: base64.StdEncoding.EncodeToString([]byte(response.String())),
+		RelayState:   request.RelayState,
+	}, nil
+}
+
+func (s *SAMLService) buildAssertion(provider *database.SAMLServiceProvider, request *SAMLSSORequest, user *database.User, attributes map[string][]string, now time.Time) (*xmlElement, error) {
+	nameID := user.Email
+	switch provider.NameIDFormat {
+	case SAMLNameIDFormatPersistent:
+		nameID = strconv.FormatUint(uint64(user.ID), 10)
+	case SAMLNameIDFormatUnspecified:
+		nameID = user.Username
+	}
+	notOnOrAfter := samlTime(now.Add(samlAssertionLifetime))
+
+	assertion := newXMLElement("saml:Assertion").
+		ns("saml", samlAssertionNS).
+		attr("ID", samlID()).
+		attr("Version", "2.0").
+		attr("IssueInstant", samlTime(now)).
+		add(
+			newXMLElement("saml:Issuer").withText(s.EntityID()),
+			newXMLElement("saml:Subject").add(
+				newXMLElement("saml:NameID").attr("Format", provider.NameIDFormat).withText(nameID),
+				newXMLElement("saml:SubjectConfirmation").attr("Method", "urn:oasis:names:tc:SAML:2.0:cm:bearer").add(
+					newXMLElement("saml:SubjectConfirmationData").
+						attr("InResponseTo", request.RequestID).
+						attr("NotOnOrAfter", notOnOrAfter).
+						attr("Recipient", request.ACSURL),
+				),
+			),
+			newXMLElement("saml:Conditions").
+				attr("NotBefore", samlTime(now.Add(-time.Minute))).
+				attr("NotOnOrAfter", notOnOrAfter).
+				add(newXMLElement("saml:AudienceRestriction").add(
+					newXMLElement("saml:Audience").withText(provider.EntityID),
+				)),
+			newXMLElement("saml:AuthnStatement").
+				attr("AuthnInstant", samlTime(now)).
+				attr("SessionIndex", samlID()).
+				add(newXMLElement("saml:AuthnContext").add(
+					newXMLElement("saml:AuthnContextClassRef").withText("urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport"),
+				)),
+		)
+
+	if len(attributes) > 0 {
+		names := make([]string, 0, len(attributes))
+		for name := range attributes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		statement := newXMLElement("saml:AttributeStatement")
+		for _, name := range names {
+			attribute := newXMLElement("saml:Attribute").
+				attr("Name", name).
+				attr("NameFormat", "urn:oasis:names:tc:SAML:2.0:attrname-format:basic")
+			for _, value := range attributes[name] {
+				attribute.add(newXMLElement("saml:AttributeValue").withText(value))
+			}
+			statement.add(attribute)
+		}
+		assertion.add(statement)
+	}
+
+	if err := signXMLElement(assertion, 1, s.keyPair); err != nil {
+		return nil, err
+	}
+	return assertion, nil
+}
+
+// userAttributes resolves the attribute mappings of a service provider for a user
+func (s *SAMLService) userAttributes(provider *database.SAMLServiceProvider, user *database.User) (map[string][]string, error) {
+	mappings := samlAttributeMappings(provider)
+
+	var memberships []database.OrgMember
+	needsOrgs := false
+	for _, source := range mappings {
+		needsOrgs = needsOrgs || source == SAMLAttributeSourceOrgs || source == SAMLAttributeSourceOrgRoles || source == SAMLAttributeSourceOrgRole
+	}
+	slugs := map[uint]string{}
+	if needsOrgs {
+		if err := s.db.Where("user_id = ?", user.ID).Order("org_id").Find(&memberships).Error; err != nil {
+			return nil, err
+		}
+		orgIDs := make([]uint, 0, len(memberships))
+		for _, membership := range memberships {
+			orgIDs = append(orgIDs, membership.OrgID)
+		}
+		var orgs []database.Org
+		if err := s.db.Select("id", "slug").Where("id IN ?", orgIDs).Find(&orgs).Error; err != nil {
+			return nil, err
+		}
+		for _, org := range orgs {
+			slugs[org.ID] = org.Slug
+		}
+	}
+
+	attributes := make(map[string][]string, len(mappings))
+	for name, source := range mappings {
+		var values []string
+		switch source {
+		case SAMLAttributeSourceID:
+			values = []string{strconv.FormatUint(uint64(user.ID), 10)}
+		case SAMLAttributeSourceUsername:
+			values = []string{user.Username}
+		case SAMLAttributeSourceEmail:
+			values = []string{user.Email}
+		case SAMLAttributeSourceEmailVerified:
+			values = []string{strconv.FormatBool(user.EmailVerified)}
+		case SAMLAttributeSourceRole:
+			values = []string{string(user.Role)}
+		case SAMLAttributeSourceOrgs, SAMLAttributeSourceOrgRoles:
+			for _, membership := range memberships {
+				slug, ok := slugs[membership.OrgID]
+				if !ok {
+					continue // Deleted organization
+				}
+				if source == SAMLAttributeSourceOrgRoles {
+					slug += ":" + string(membership.Role)
+				}
+				values = append(values, slug)
+			}
+		case SAMLAttributeSourceOrgRole:
+			for _, membership := range memberships {
+				if provider.OrgID != nil && membership.OrgID == *provider.OrgID {
+					values = []string{string(membership.Role)}
+				}
+			}
+		}
+		if len(values) > 0 {
+			attributes[name] = values
+		}
+	}
+	return attributes, nil
+}
+
+// checkAccess returns ErrSAMLServiceProviderMembersOnly if the service provider is restricted to
+// members of its organization and the user is not one
+func (s *SAMLService) checkAccess(provider *database.SAMLServiceProvider, userID uint) error {
+	if provider.OrgID == nil || !provider.MembersOnly {
+		return nil
+	}
+
+	var count int64
+	if err := s.db.Model(&database.OrgMember{}).
+		Where("org_id = ? AND user_id = ?", *provider.OrgID, userID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrSAMLServiceProviderMembersOnly
+	}
+	return nil
+}
+
+// samlID returns a random identifier; XML IDs must not start with a digit
+func samlID() string {
+	return "_" + generateSecureToken()
+}
+
+func samlTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"errors"
+	"miniauth/database"
+	"strings"
+	"testing"
+)
+
+func newTestSAMLService(t *testing.T) (*SAMLService, *database.SAMLServiceProvider) {
+	t.Helper()
+	db := newTestDB(t)
+	admin := &database.User{Username: "admin", Email: "admin@example.com", Role: database.UserRoleAdmin}
+	mustCreate(t, db, admin)
+
+	saml := NewSAMLService(db, newTestSigningKeyPair(t), &TokenSigner{secret: []byte("test secret")})
+	created, err := saml.CreateServiceProvider(admin.ID, SAMLServiceProviderRequest{
+		Name:     "Wiki",
+		EntityID: "https://wiki.example.com/saml",
+		ACSURLs:  []string{"https://wiki.example.com/saml/acs", "https://wiki.example.com/saml/acs2"},
+	})
+	if err != nil {
+		t.Fatalf("CreateServiceProvider: %v", err)
+	}
+	var provider database.SAMLServiceProvider
+	if err := db.First(&provider, created.ID).Error; err != nil {
+		t.Fatalf("load service provider: %v", err)
+	}
+	return saml, &provider
+}
+
+func TestParseAuthnRequest(t *testing.T) {
+	saml, provider := newTestSAMLService(t)
+
+	authnRequest := func(attributes, issuer string) string {
+		return `<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ` +
+			attributes + `><saml:Issuer>` + issuer + `</saml:Issuer></samlp:AuthnRequest>`
+	}
+	deflate := func(data string) string {
+		var b bytes.Buffer
+		writer, _ := flate.NewWriter(&b, flate.DefaultCompression)
+		writer.Write([]byte(data))
+		writer.Close()
+		return base64.StdEncoding.EncodeToString(b.Bytes())
+	}
+	post := func(data string) string {
+		return base64.StdEncoding.EncodeToString([]byte(data))
+	}
+	valid := authnRequest(`ID="_request" Version="2.0"`, provider.EntityID)
+
+	tests := []struct {
+		name       string
+		encoded    string
+		deflated   bool
+		wantACSURL string
+		wantErr    bool
+	}{
+		{name: "redirect binding", encoded: deflate(valid), deflated: true, wantACSURL: "https://wiki.example.com/saml/acs"},
+		{name: "POST binding", encoded: post(valid), wantACSURL: "https://wiki.example.com/saml/acs"},
+		{name: "registered ACS URL",
+			encoded:    post(authnRequest(`ID="_request" Version="2.0" AssertionConsumerServiceURL="https://wiki.example.com/saml/acs2"`, provider.EntityID)),
+			wantACSURL: "https://wiki.example.com/saml/acs2"},
+		{name: "unregistered ACS URL", wantErr: true,
+			encoded: post(authnRequest(`ID="_request" Version="2.0" AssertionConsumerServiceURL="https://evil.example.com/acs"`, provider.EntityID))},
+		{name: "unsupported binding", wantErr: true,
+			encoded: post(authnRequest(`ID="_request" Version="2.0" ProtocolBinding="`+samlBindingRedirect+`"`, provider.EntityID))},
+		{name: "unknown service provider", encoded: post(authnRequest(`ID="_request" Version="2.0"`, "https://evil.example.com")), wantErr: true},
+		{name: "missing ID", encoded: post(authnRequest(`Version="2.0"`, provider.EntityID)), wantErr: true},
+		{name: "SAML 1.1", encoded: post(authnRequest(`ID="_request" Version="1.1"`, provider.EntityID)), wantErr: true},
+		{name: "not deflated", encoded: post(valid), deflated: true, wantErr: true},
+		{name: "not base64", encoded: "%%%", wantErr: true},
+		{name: "not XML", encoded: post("<samlp:AuthnRequest"), wantErr: true},
+		{name: "too large", encoded: deflate(valid + strings.Repeat(" ", samlRequestSizeLimit)), deflated: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := saml.ParseAuthnRequest(tt.encoded, tt.deflated, "relay")
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSAMLRequest) {
+					t.Errorf("ParseAuthnRequest = %v, want ErrInvalidSAMLRequest", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseAuthnRequest: %v", err)
+			}
+			want := SAMLSSORequest{ServiceProviderID: provider.ID, RequestID: "_request", ACSURL: tt.wantACSURL, RelayState: "relay"}
+			if *request != want {
+				t.Errorf("ParseAuthnRequest = %+v, want %+v", *request, want)
+			}
+		})
+	}
+}
+
+func TestIssueResponseSignsAssertion(t *testing.T) {
+	saml, provider := newTestSAMLService(t)
+	user := &database.User{Username: "alice", Email: "alice@example.com"}
+	mustCreate(t, saml.db, user)
+
+	request := &SAMLSSORequest{ServiceProviderID: provider.ID, RequestID: "_request", ACSURL: "https://wiki.example.com/saml/acs", RelayState: "relay"}
+	response, err := saml.IssueResponse(AuditActor{}, request, user)
+	if err != nil {
+		t.Fatalf("IssueResponse: %v", err)
+	}
+	if response.ACSURL != request.ACSURL || response.RelayState != "relay" {
+		t.Errorf("IssueResponse = %+v", response)
+	}
+	document, err := base64.StdEncoding.DecodeString(response.SAMLResponse)
+	if err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	if err := verifyTestXMLSignature(string(document), "saml:Assertion", saml.keyPair.Certificate); err != nil {
+		t.Errorf("assertion signature: %v\n%s", err, document)
+	}
+	for _, want := range []string{
+		`InResponseTo="_request"`,
+		`<saml:NameID Format="` + SAMLNameIDFormatEmail + `">alice@example.com</saml:NameID>`,
+		`<saml:Audience>` + provider.EntityID + `</saml:Audience>`,
+		`Recipient="` + request.ACSURL + `"`,
+	} {
+		if !strings.Contains(string(document), want) {
+			t.Errorf("response does not contain %s:\n%s", want, document)
+		}
+	}
+}
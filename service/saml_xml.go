@@ -0,0 +1,156 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"sort"
+	"strings"
+)
+
+// XML namespaces and algorithms of SAML 2.0 and XML Signature
+const (
+	samlAssertionNS = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlProtocolNS  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlMetadataNS  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	xmlDSigNS       = "http://www.w3.org/2000/09/xmldsig#"
+
+	xmlExcC14N          = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	xmlEnvelopedSig     = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	xmlDSigRSASHA256    = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	xmlDSigSHA256       = "http://www.w3.org/2001/04/xmlenc#sha256"
+	samlBindingPOST     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	samlBindingRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+)
+
+// xmlElement is an element of a document built by miniauth. Elements are always written in
+// exclusive canonical form (XML-EXC-C14N): each element declares the namespace prefixes it uses
+// and its ancestors in the signed subtree do not, attributes are unqualified and sorted, and
+// empty elements have end tags. The serialized document is therefore its own canonical form,
+// which is what signatures are computed over.
+type xmlElement struct {
+	name       string
+	namespaces map[string]string // Prefix to URI
+	attributes map[string]string
+	children   []*xmlElement
+	text       string
+}
+
+func newXMLElement(name string) *xmlElement {
+	return &xmlElement{name: name, attributes: map[string]string{}}
+}
+
+// ns declares a namespace prefix on the element
+func (e *xmlElement) ns(prefix, uri string) *xmlElement {
+	if e.namespaces == nil {
+		e.namespaces = map[string]string{}
+	}
+	e.namespaces[prefix] = uri
+	return e
+}
+
+// attr sets an attribute, skipping empty values
+func (e *xmlElement) attr(name, value string) *xmlElement {
+	if value != "" {
+		e.attributes[name] = value
+	}
+	return e
+}
+
+func (e *xmlElement) add(children ...*xmlElement) *xmlElement {
+	e.children = append(e.children, children...)
+	return e
+}
+
+func (e *xmlElement) withText(text string) *xmlElement {
+	e.text = text
+	return e
+}
+
+func (e *xmlElement) String() string {
+	var b strings.Builder
+	e.write(&b)
+	return b.String()
+}
+
+func (e *xmlElement) write(b *strings.Builder) {
+	b.WriteString("<" + e.name)
+
+	prefixes := make([]string, 0, len(e.namespaces))
+	for prefix := range e.namespaces {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			b.WriteString(` xmlns="` + escapeXMLAttribute(e.namespaces[prefix]) + `"`)
+		} else {
+			b.WriteString(" xmlns:" + prefix + `="` + escapeXMLAttribute(e.namespaces[prefix]) + `"`)
+		}
+	}
+
+	names := make([]string, 0, len(e.attributes))
+	for name := range e.attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString(" " + name + `="` + escapeXMLAttribute(e.attributes[name]) + `"`)
+	}
+
+	b.WriteString(">")
+	b.WriteString(escapeXMLText(e.text))
+	for _, child := range e.children {
+		child.write(b)
+	}
+	b.WriteString("</" + e.name + ">")
+}
+
+// escapeXMLText escapes character data as canonical XML does
+func escapeXMLText(value string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;").Replace(value)
+}
+
+// escapeXMLAttribute escapes an attribute value as canonical XML does
+func escapeXMLAttribute(value string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;").Replace(value)
+}
+
+// signXMLElement adds an enveloped XML signature over the element, referenced by its ID attribute,
+// as the child at position index (SAML places it right after the Issuer)
+func signXMLElement(element *xmlElement, index int, keyPair *SigningKeyPair) error {
+	digest := sha256.Sum256([]byte(element.String()))
+
+	signedInfo := newXMLElement("ds:SignedInfo").ns("ds", xmlDSigNS).add(
+		newXMLElement("ds:CanonicalizationMethod").attr("Algorithm", xmlExcC14N),
+		newXMLElement("ds:SignatureMethod").attr("Algorithm", xmlDSigRSASHA256),
+		newXMLElement("ds:Reference").attr("URI", "#"+element.attributes["ID"]).add(
+			newXMLElement("ds:Transforms").add(
+				newXMLElement("ds:Transform").attr("Algorithm", xmlEnvelopedSig),
+				newXMLElement("ds:Transform").attr("Algorithm", xmlExcC14N),
+			),
+			newXMLElement("ds:DigestMethod").attr("Algorithm", xmlDSigSHA256),
+			newXMLElement("ds:DigestValue").withText(base64.StdEncoding.EncodeToString(digest[:])),
+		),
+	)
+	signedInfoDigest := sha256.Sum256([]byte(signedInfo.String()))
+	signature, err := keyPair.SignSHA256(signedInfoDigest[:])
+	if err != nil {
+		return err
+	}
+
+	signatureElement := newXMLElement("ds:Signature").ns("ds", xmlDSigNS).add(
+		signedInfo,
+		newXMLElement("ds:SignatureValue").withText(base64.StdEncoding.EncodeToString(signature)),
+		newXMLElement("ds:KeyInfo").add(
+			newXMLElement("ds:X509Data").add(
+				newXMLElement("ds:X509Certificate").withText(base64.StdEncoding.EncodeToString(keyPair.Certificate.Raw)),
+			),
+		),
+	)
+
+	children := make([]*xmlElement, 0, len(element.children)+1)
+	children = append(children, element.children[:index]...)
+	children = append(children, signatureElement)
+	element.children = append(children, element.children[index:]...)
+	return nil
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestXMLElementCanonicalForm(t *testing.T) {
+	tests := []struct {
+		name    string
+		element *xmlElement
+		want    string
+	}{
+		{"empty element has an end tag", newXMLElement("a"), "<a></a>"},
+		{"attributes are sorted", newXMLElement("a").attr("b", "2").attr("a", "1").attr("ID", "x"),
+			`<a ID="x" a="1" b="2"></a>`},
+		{"empty attributes are skipped", newXMLElement("a").attr("a", ""), "<a></a>"},
+		{"namespaces come first, default namespace before prefixes",
+			newXMLElement("p:a").attr("a", "1").ns("p", "urn:p").ns("", "urn:default").ns("b", "urn:b"),
+			`<p:a xmlns="urn:default" xmlns:b="urn:b" xmlns:p="urn:p" a="1"></p:a>`},
+		{"text is escaped", newXMLElement("a").withText("a & b < c > d\r\n\"e\""),
+			"<a>a &amp; b &lt; c &gt; d&#xD;\n\"e\"</a>"},
+		{"attributes are escaped", newXMLElement("a").attr("v", "a & b < c > d \"e\"\t\n\r"),
+			`<a v="a &amp; b &lt; c > d &quot;e&quot;&#x9;&#xA;&#xD;"></a>`},
+		{"children follow text", newXMLElement("a").withText("t").add(newXMLElement("b"), newXMLElement("c").withText("u")),
+			"<a>t<b></b><c>u</c></a>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.element.String(); got != tt.want {
+				t.Errorf("String() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestXMLElementRoundTrips(t *testing.T) {
+	value := "a & b < c > d \"e\" 'f'\t\ng"
+	element := newXMLElement("p:a").ns("p", "urn:p").attr("v", value).withText(value)
+
+	var parsed struct {
+		XMLName xml.Name
+		V       string `xml:"v,attr"`
+		Text    string `xml:",chardata"`
+	}
+	if err := xml.Unmarshal([]byte(element.String()), &parsed); err != nil {
+		t.Fatalf("Unmarshal(%s): %v", element, err)
+	}
+	if parsed.XMLName.Space != "urn:p" || parsed.XMLName.Local != "a" || parsed.V != value || parsed.Text != value {
+		t.Errorf("parsed %s as %+v", element, parsed)
+	}
+}
+
+// testXMLSignaturePattern matches the enveloped signature added by signXMLElement
+var testXMLSignaturePattern = regexp.MustCompile(`<ds:Signature xmlns:ds="` + regexp.QuoteMeta(xmlDSigNS) + `">.*?</ds:Signature>`)
+
+// verifyTestXMLSignature checks the enveloped signature of the element name in a document the
+// way a service provider does: the digest of the element without its signature, the reference to
+// its ID, and the signature over SignedInfo by the certificate's key
+func verifyTestXMLSignature(document, name string, certificate *x509.Certificate) error {
+	start := strings.Index(document, "<"+name+" ")
+	end := strings.Index(document, "</"+name+">")
+	if start < 0 || end < 0 {
+		return fmt.Errorf("no %s element", name)
+	}
+	signed := document[start : end+len("</"+name+">")]
+
+	signature := testXMLSignaturePattern.FindString(signed)
+	if signature == "" {
+		return errors.New("no signature")
+	}
+	var parsed struct {
+		SignedInfo struct {
+			Reference struct {
+				URI         string `xml:"URI,attr"`
+				DigestValue string
+			}
+		}
+		SignatureValue  string
+		X509Certificate string `xml:"KeyInfo>X509Data>X509Certificate"`
+	}
+	if err := xml.Unmarshal([]byte(signature), &parsed); err != nil {
+		return err
+	}
+
+	id := regexp.MustCompile(`^<[^>]* ID="([^"]+)"`).FindStringSubmatch(signed)
+	if id == nil || parsed.SignedInfo.Reference.URI != "#"+id[1] {
+		return fmt.Errorf("reference %q does not name the element", parsed.SignedInfo.Reference.URI)
+	}
+	digest := sha256.Sum256([]byte(strings.Replace(signed, signature, "", 1)))
+	if parsed.SignedInfo.Reference.DigestValue != base64.StdEncoding.EncodeToString(digest[:]) {
+		return errors.New("digest mismatch")
+	}
+	if parsed.X509Certificate != base64.StdEncoding.EncodeToString(certificate.Raw) {
+		return errors.New("key info does not carry the certificate")
+	}
+
+	signedInfo := signature[strings.Index(signature, "<ds:SignedInfo ") : strings.Index(signature, "</ds:SignedInfo>")+len("</ds:SignedInfo>")]
+	signedInfoDigest := sha256.Sum256([]byte(signedInfo))
+	value, err := base64.StdEncoding.DecodeString(parsed.SignatureValue)
+	if err != nil {
+		return err
+	}
+	return rsa.VerifyPKCS1v15(certificate.PublicKey.(*rsa.PublicKey), crypto.SHA256, signedInfoDigest[:], value)
+}
+
+func newTestSigningKeyPair(t *testing.T) *SigningKeyPair {
+	t.Helper()
+	keyPair, err := selfSignedKeyPair(generateTestRSAKey(t))
+	if err != nil {
+		t.Fatalf("selfSignedKeyPair: %v", err)
+	}
+	return keyPair
+}
+
+func TestSignXMLElement(t *testing.T) {
+	keyPair := newTestSigningKeyPair(t)
+	otherKeyPair := newTestSigningKeyPair(t)
+
+	sign := func(t *testing.T) string {
+		element := newXMLElement("saml:Assertion").ns("saml", samlAssertionNS).attr("ID", "_assertion").add(
+			newXMLElement("saml:Issuer").withText("https://idp.example.com"),
+			newXMLElement("saml:Subject").add(newXMLElement("saml:NameID").withText("alice@example.com")),
+		)
+		if err := signXMLElement(element, 1, keyPair); err != nil {
+			t.Fatalf("signXMLElement: %v", err)
+		}
+		return element.String()
+	}
+
+	signed := sign(t)
+	issuer := strings.Index(signed, "</saml:Issuer>")
+	if signature := strings.Index(signed, "<ds:Signature "); issuer < 0 || signature != issuer+len("</saml:Issuer>") {
+		t.Errorf("signature is not right after the issuer: %s", signed)
+	}
+	if err := xml.Unmarshal([]byte(signed), new(struct{})); err != nil {
+		t.Errorf("signed element is not well-formed: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		tamper      func(string) string
+		certificate *x509.Certificate
+		wantErr     bool
+	}{
+		{"valid", func(s string) string { return s }, keyPair.Certificate, false},
+		{"changed content", func(s string) string {
+			return strings.Replace(s, "alice@example.com", "admin@example.com", 1)
+		}, keyPair.Certificate, true},
+		{"changed ID", func(s string) string {
+			return strings.Replace(s, `ID="_assertion"`, `ID="_other"`, 1)
+		}, keyPair.Certificate, true},
+		{"changed signed info", func(s string) string {
+			return strings.Replace(s, xmlDSigSHA256, "http://www.w3.org/2000/09/xmldsig#sha1", 1)
+		}, keyPair.Certificate, true},
+		{"not canonical", func(s string) string {
+			return strings.Replace(s, "<saml:Subject>", "<saml:Subject >", 1)
+		}, keyPair.Certificate, true},
+		{"certificate of another key pair", func(s string) string { return s }, otherKeyPair.Certificate, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyTestXMLSignature(tt.tamper(signed), "saml:Assertion", tt.certificate)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verify = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// The digest covers only the element, so signing it again yields the same digest
+	digest := regexp.MustCompile(`<ds:DigestValue>([^<]+)</ds:DigestValue>`)
+	if first, second := digest.FindString(signed), digest.FindString(sign(t)); first == "" || first != second {
+		t.Errorf("digests differ: %s and %s", first, second)
+	}
+}
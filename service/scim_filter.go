@@ -0,0 +1,258 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// scimComparison is one "attribute operator value" expression of a SCIM filter
+type scimComparison struct {
+	Attribute string // Lowercase attribute path, e.g. "emails.value"
+	Operator  string // Lowercase operator: eq, ne, co, sw, ew, gt, ge, lt, le or pr
+	Value     interface{}
+}
+
+// scimAttributeColumn maps a filterable SCIM attribute to a database column
+type scimAttributeColumn struct {
+	Column string
+	Kind   string // string, id or bool
+	Negate bool   // For bool columns storing the opposite of the attribute, e.g. active and disabled
+}
+
+// scimUserColumns are the filterable attributes of SCIM users
+var scimUserColumns = map[string]scimAttributeColumn{
+	"id":           {Column: "id", Kind: "id"},
+	"username":     {Column: "username", Kind: "string"},
+	"displayname":  {Column: "username", Kind: "string"},
+	"emails":       {Column: "email", Kind: "string"},
+	"emails.value": {Column: "email", Kind: "string"},
+	"externalid":   {Column: "external_id", Kind: "string"},
+	"active":       {Column: "disabled", Kind: "bool", Negate: true},
+}
+
+// scimGroupColumns are the filterable attributes of SCIM groups
+var scimGroupColumns = map[string]scimAttributeColumn{
+	"id":          {Column: "id", Kind: "id"},
+	"displayname": {Column: "name", Kind: "string"},
+}
+
+// parseScimFilter parses a filter made of comparisons joined by "and", e.g.
+// userName eq "alice" and emails.value co "@example.com". Grouping, "or" and "not" are not supported.
+func parseScimFilter(filter string) ([]scimComparison, error) {
+	tokens, err := scimFilterTokens(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var comparisons []scimComparison
+	for len(tokens) > 0 {
+		if len(comparisons) > 0 {
+			if !strings.EqualFold(tokens[0], "and") {
+				return nil, scimInvalidFilter("only \"and\" is supported between comparisons")
+			}
+			tokens = tokens[1:]
+		}
+		if len(tokens) < 2 {
+			return nil, scimInvalidFilter("incomplete comparison")
+		}
+
+		comparison := scimComparison{
+			Attribute: strings.ToLower(stripScimSchema(tokens[0])),
+			Operator:  strings.ToLower(tokens[1]),
+		}
+		if comparison.Operator == "pr" {
+			comparisons = append(comparisons, comparison)
+			tokens = tokens[2:]
+			continue
+		}
+
+		switch comparison.Operator {
+		case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+		default:
+			return nil, scimInvalidFilter("unsupported operator " + tokens[1])
+		}
+		if len(tokens) < 3 {
+			return nil, scimInvalidFilter("missing comparison value")
+		}
+		if comparison.Value, err = parseScimFilterValue(tokens[2]); err != nil {
+			return nil, err
+		}
+		comparisons = append(comparisons, comparison)
+		tokens = tokens[3:]
+	}
+	return comparisons, nil
+}
+
+// scimFilterTokens splits a filter on spaces, keeping quoted strings together with their quotes
+func scimFilterTokens(filter string) ([]string, error) {
+	var tokens []string
+	var current strings.Builder
+	inString := false
+	for i := 0; i < len(filter); i++ {
+		c := filter[i]
+		switch {
+		case inString && c == '\\' && i+1 < len(filter):
+			current.WriteByte(c)
+			i++
+			current.WriteByte(filter[i])
+		case c == '"':
+			inString = !inString
+			current.WriteByte(c)
+		case !inString && c == ' ':
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		case !inString && (c == '(' || c == ')' || c == '['):
+			return nil, scimInvalidFilter("grouping is not supported")
+		default:
+			current.WriteByte(c)
+		}
+	}
+	if inString {
+		return nil, scimInvalidFilter("unterminated string")
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens, nil
+}
+
+// parseScimFilterValue parses a JSON literal: a string, true, false, null or a number
+func parseScimFilterValue(token string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(token, `"`):
+		value, err := strconv.Unquote(token)
+		if err != nil {
+			return nil, scimInvalidFilter("invalid string " + token)
+		}
+		return value, nil
+	case token == "true":
+		return true, nil
+	case token == "false":
+		return false, nil
+	case token == "null":
+		return nil, nil
+	}
+	if number, err := strconv.ParseFloat(token, 64); err == nil {
+		return number, nil
+	}
+	return nil, scimInvalidFilter("invalid value " + token)
+}
+
+// applyScimFilter restricts db to the rows matching the comparisons
+func applyScimFilter(db *gorm.DB, comparisons []scimComparison, columns map[string]scimAttributeColumn) (*gorm.DB, error) {
+	for _, comparison := range comparisons {
+		column, ok := columns[comparison.Attribute]
+		if !ok {
+			return nil, scimInvalidFilter("unsupported filter attribute " + comparison.Attribute)
+		}
+
+		if comparison.Operator == "pr" {
+			if column.Kind == "string" {
+				db = db.Where(column.Column + " IS NOT NULL AND " + column.Column + " <> ''")
+			}
+			continue
+		}
+
+		var err error
+		switch column.Kind {
+		case "string":
+			db, err = applyScimStringComparison(db, column.Column, comparison)
+		case "id":
+			db, err = applyScimIDComparison(db, column.Column, comparison)
+		case "bool":
+			db, err = applyScimBoolComparison(db, column, comparison)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// applyScimStringComparison compares case-insensitively, as userName, emails and displayName are caseExact=false
+func applyScimStringComparison(db *gorm.DB, column string, comparison scimComparison) (*gorm.DB, error) {
+	value, ok := comparison.Value.(string)
+	if !ok {
+		return nil, scimInvalidFilter(comparison.Attribute + " must be compared with a string")
+	}
+	value = strings.ToLower(value)
+	lower := "LOWER(" + column + ")"
+
+	switch comparison.Operator {
+	case "eq":
+		return db.Where(lower+" = ?", value), nil
+	case "ne":
+		return db.Where(lower+" <> ?", value), nil
+	case "co":
+		return db.Where(lower+" LIKE ? ESCAPE '!'", "%"+escapeScimLike(value)+"%"), nil
+	case "sw":
+		return db.Where(lower+" LIKE ? ESCAPE '!'", escapeScimLike(value)+"%"), nil
+	case "ew":
+		return db.Where(lower+" LIKE ? ESCAPE '!'", "%"+escapeScimLike(value)), nil
+	case "gt":
+		return db.Where(lower+" > ?", value), nil
+	case "ge":
+		return db.Where(lower+" >= ?", value), nil
+	case "lt":
+		return db.Where(lower+" < ?", value), nil
+	default:
+		return db.Where(lower+" <= ?", value), nil
+	}
+}
+
+func applyScimIDComparison(db *gorm.DB, column string, comparison scimComparison) (*gorm.DB, error) {
+	raw, _ := comparison.Value.(string)
+	id, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil || (comparison.Operator != "eq" && comparison.Operator != "ne") {
+		// No resource has an ID other than a decimal number
+		if comparison.Operator == "eq" {
+			return db.Where("1 = 0"), nil
+		}
+		return nil, scimInvalidFilter("id only supports eq and ne")
+	}
+	if comparison.Operator == "ne" {
+		return db.Where(column+" <> ?", id), nil
+	}
+	return db.Where(column+" = ?", id), nil
+}
+
+func applyScimBoolComparison(db *gorm.DB, column scimAttributeColumn, comparison scimComparison) (*gorm.DB, error) {
+	value, ok := comparison.Value.(bool)
+	if !ok || (comparison.Operator != "eq" && comparison.Operator != "ne") {
+		return nil, scimInvalidFilter(comparison.Attribute + " only supports eq and ne with true or false")
+	}
+	if comparison.Operator == "ne" {
+		value = !value
+	}
+	if column.Negate {
+		value = !value
+	}
+	return db.Where(column.Column+" = ?", value), nil
+}
+
+// escapeScimLike escapes LIKE wildcards using "!" as the escape character, which every supported
+// database accepts without further quoting
+func escapeScimLike(value string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(value)
+}
+
+// stripScimSchema removes a schema URN prefix from an attribute path,
+// e.g. urn:ietf:params:scim:schemas:core:2.0:User:userName becomes userName
+func stripScimSchema(path string) string {
+	for _, schema := range []string{ScimSchemaUser, ScimSchemaGroup} {
+		if len(path) > len(schema) && strings.EqualFold(path[:len(schema)+1], schema+":") {
+			return path[len(schema)+1:]
+		}
+	}
+	return path
+}
+
+func scimInvalidFilter(detail string) error {
+	return &ScimError{Status: http.StatusBadRequest, ScimType: "invalidFilter", Detail: fmt.Sprintf("invalid filter: %s", detail)}
+}
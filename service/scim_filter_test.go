@@ -0,0 +1,113 @@
+package service
+
+import (
+	"errors"
+	"miniauth/database"
+	"reflect"
+	"testing"
+)
+
+func TestParseScimFilter(t *testing.T) {
+	tests := []struct {
+		filter  string
+		want    []scimComparison
+		wantErr bool
+	}{
+		{filter: "", want: nil},
+		{filter: `userName eq "alice"`, want: []scimComparison{{"username", "eq", "alice"}}},
+		{filter: `urn:ietf:params:scim:schemas:core:2.0:User:userName EQ "alice"`, want: []scimComparison{{"username", "eq", "alice"}}},
+		{filter: `emails.value co "@example.com" and active eq true`, want: []scimComparison{{"emails.value", "co", "@example.com"}, {"active", "eq", true}}},
+		{filter: `displayName eq "Alice \"Al\" Smith"`, want: []scimComparison{{"displayname", "eq", `Alice "Al" Smith`}}},
+		{filter: `displayName eq "two  spaces"`, want: []scimComparison{{"displayname", "eq", "two  spaces"}}},
+		{filter: `externalId pr and id ne "3"`, want: []scimComparison{{"externalid", "pr", nil}, {"id", "ne", "3"}}},
+		{filter: `id eq null`, want: []scimComparison{{"id", "eq", nil}}},
+		{filter: `id gt 3`, want: []scimComparison{{"id", "gt", float64(3)}}},
+		{filter: `userName eq "alice" or userName eq "bob"`, wantErr: true},
+		{filter: `not (userName eq "alice")`, wantErr: true},
+		{filter: `emails[type eq "work"]`, wantErr: true},
+		{filter: `userName like "alice"`, wantErr: true},
+		{filter: `userName eq`, wantErr: true},
+		{filter: `userName`, wantErr: true},
+		{filter: `userName eq "alice`, wantErr: true},
+		{filter: `userName eq alice`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			got, err := parseScimFilter(tt.filter)
+			if tt.wantErr {
+				var scimErr *ScimError
+				if !errors.As(err, &scimErr) || scimErr.ScimType != "invalidFilter" {
+					t.Fatalf("parseScimFilter = %v, want an invalidFilter error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseScimFilter: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseScimFilter = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScimListUsersFilter(t *testing.T) {
+	db := newTestDB(t)
+	scim := NewScimService(db, NewUserService(db, &fakeMailer{}, &TokenSigner{secret: []byte("test secret")}))
+
+	for _, user := range []*database.User{
+		{Username: "alice", Email: "alice@example.com", ExternalID: "ext-alice"},
+		{Username: "Bob", Email: "bob@example.org"},
+		{Username: "carol_1", Email: "carol@example.com", Disabled: true},
+		{Username: "carol%", Email: "carol2@example.com"},
+	} {
+		user.PasswordHash = "x"
+		mustCreate(t, db, user)
+	}
+
+	tests := []struct {
+		filter  string
+		want    []string
+		wantErr bool
+	}{
+		{filter: `userName eq "ALICE"`, want: []string{"alice"}},
+		{filter: `userName ne "alice"`, want: []string{"Bob", "carol_1", "carol%"}},
+		{filter: `emails.value ew "@example.com" and active eq true`, want: []string{"alice", "carol%"}},
+		{filter: `active eq false`, want: []string{"carol_1"}},
+		{filter: `active ne true`, want: []string{"carol_1"}},
+		{filter: `userName sw "b"`, want: []string{"Bob"}},
+		{filter: `userName co "_"`, want: []string{"carol_1"}},
+		{filter: `userName co "%"`, want: []string{"carol%"}},
+		{filter: `externalId pr`, want: []string{"alice"}},
+		{filter: `id eq "not a number"`, want: nil},
+		{filter: `password eq "secret"`, wantErr: true},
+		{filter: `active eq "yes"`, wantErr: true},
+		{filter: `userName eq true`, wantErr: true},
+		{filter: `id gt "1"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			query := DefaultScimListQuery()
+			query.Filter = tt.filter
+			response, err := scim.ListUsers(query)
+			if tt.wantErr {
+				var scimErr *ScimError
+				if !errors.As(err, &scimErr) || scimErr.ScimType != "invalidFilter" {
+					t.Fatalf("ListUsers = %v, want an invalidFilter error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ListUsers: %v", err)
+			}
+
+			var got []string
+			for _, resource := range response.Resources {
+				got = append(got, resource.(*ScimUser).UserName)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got users %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// scimPatchPath is a parsed PATCH path: attribute[valueFilter].subAttribute
+type scimPatchPath struct {
+	Attribute    string
+	Filter       []scimComparison
+	SubAttribute string
+}
+
+// patchScimResource applies PATCH operations to the JSON representation of current and decodes the
+// result into dest. Attribute names are matched case-insensitively and the read-only meta and groups
+// attributes are dropped before decoding.
+func patchScimResource(current interface{}, patch ScimPatchRequest, dest interface{}) error {
+	if len(patch.Operations) == 0 {
+		return scimInvalidValue("no operations")
+	}
+
+	encoded, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	var resource map[string]interface{}
+	if err := json.Unmarshal(encoded, &resource); err != nil {
+		return err
+	}
+
+	for _, operation := range patch.Operations {
+		if err := applyScimPatchOperation(resource, operation); err != nil {
+			return err
+		}
+	}
+
+	delete(resource, "meta")
+	delete(resource, "groups")
+	// Some clients send booleans as strings, e.g. Azure AD with "active": "False"
+	if active, ok := resource["active"].(string); ok {
+		value, err := strconv.ParseBool(strings.ToLower(active))
+		if err != nil {
+			return scimInvalidValue("active must be a boolean")
+		}
+		resource["active"] = value
+	}
+
+	if encoded, err = json.Marshal(resource); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(encoded, dest); err != nil {
+		return scimInvalidValue(fmt.Sprintf("invalid resource after patch: %v", err))
+	}
+	return nil
+}
+
+func applyScimPatchOperation(resource map[string]interface{}, operation ScimPatchOperation) error {
+	op := strings.ToLower(operation.Op)
+	if op != "add" && op != "replace" && op != "remove" {
+		return scimInvalidValue("unsupported operation " + operation.Op)
+	}
+
+	var value interface{}
+	if len(operation.Value) > 0 {
+		if err := json.Unmarshal(operation.Value, &value); err != nil {
+			return scimInvalidValue("invalid operation value")
+		}
+	}
+
+	if operation.Path == "" {
+		if op == "remove" {
+			return &ScimError{Status: http.StatusBadRequest, ScimType: "noTarget", Detail: "remove requires a path"}
+		}
+		attributes, ok := value.(map[string]interface{})
+		if !ok {
+			return scimInvalidValue("an operation without a path requires an object value")
+		}
+		for name, attributeValue := range attributes {
+			path, err := parseScimPatchPath(name)
+			if err != nil {
+				return err
+			}
+			if err := applyScimPatchPath(resource, op, path, attributeValue); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path, err := parseScimPatchPath(operation.Path)
+	if err != nil {
+		return err
+	}
+	if op != "remove" && value == nil {
+		return scimInvalidValue(op + " requires a value")
+	}
+	return applyScimPatchPath(resource, op, path, value)
+}
+
+// parseScimPatchPath parses paths such as userName, name.givenName, emails[type eq "work"].value
+// and schema-qualified attributes
+func parseScimPatchPath(raw string) (scimPatchPath, error) {
+	raw = stripScimSchema(strings.TrimSpace(raw))
+	var path scimPatchPath
+
+	if open := strings.IndexByte(raw, '['); open >= 0 {
+		end := strings.LastIndexByte(raw, ']')
+		if end < open {
+			return path, scimInvalidPath(raw)
+		}
+		filter, err := parseScimFilter(raw[open+1 : end])
+		if err != nil || len(filter) == 0 {
+			return path, scimInvalidPath(raw)
+		}
+		path.Attribute = raw[:open]
+		path.Filter = filter
+		rest := raw[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ".") || len(rest) == 1 {
+				return path, scimInvalidPath(raw)
+			}
+			path.SubAttribute = rest[1:]
+		}
+	} else if attribute, sub, ok := strings.Cut(raw, "."); ok {
+		path.Attribute = attribute
+		path.SubAttribute = sub
+	} else {
+		path.Attribute = raw
+	}
+
+	if path.Attribute == "" {
+		return path, scimInvalidPath(raw)
+	}
+	return path, nil
+}
+
+func applyScimPatchPath(resource map[string]interface{}, op string, path scimPatchPath, value interface{}) error {
+	key := scimAttributeKey(resource, path.Attribute)
+
+	if path.Filter != nil {
+		return applyScimFilteredPatch(resource, key, op, path, value)
+	}
+
+	if path.SubAttribute != "" {
+		existing, _ := resource[key].(map[string]interface{})
+		if existing == nil {
+			if _, isList := resource[key].([]interface{}); isList {
+				return scimInvalidPath(path.Attribute + "." + path.SubAttribute)
+			}
+			if op == "remove" {
+				return nil
+			}
+			existing = map[string]interface{}{}
+			resource[key] = existing
+		}
+		subKey := scimAttributeKey(existing, path.SubAttribute)
+		if op == "remove" {
+			delete(existing, subKey)
+		} else {
+			existing[subKey] = value
+		}
+		return nil
+	}
+
+	switch op {
+	case "remove":
+		list, isList := resource[key].([]interface{})
+		values, hasValues := value.([]interface{})
+		if isList && hasValues {
+			// Remove the listed elements only, as sent by Azure AD for group members
+			resource[key] = removeScimValues(list, values)
+		} else {
+			delete(resource, key)
+		}
+	case "add":
+		if list, isList := resource[key].([]interface{}); isList {
+			resource[key] = appendScimValues(list, value)
+		} else if _, isList := value.([]interface{}); isList {
+			resource[key] = appendScimValues(nil, value)
+		} else {
+			resource[key] = value
+		}
+	default:
+		resource[key] = value
+	}
+	return nil
+}
+
+// applyScimFilteredPatch applies an operation to the elements of a multi-valued attribute matching
+// the path filter. Adding or replacing without a match creates the element.
+func applyScimFilteredPatch(resource map[string]interface{}, key, op string, path scimPatchPath, value interface{}) error {
+	list, _ := resource[key].([]interface{})
+	matched := false
+	kept := list[:0:0]
+
+	for _, item := range list {
+		element, ok := item.(map[string]interface{})
+		if !ok || !matchScimElement(element, path.Filter) {
+			kept = append(kept, item)
+			continue
+		}
+		matched = true
+
+		switch {
+		case op == "remove" && path.SubAttribute == "":
+			continue
+		case op == "remove":
+			delete(element, scimAttributeKey(element, path.SubAttribute))
+		case path.SubAttribute != "":
+			element[scimAttributeKey(element, path.SubAttribute)] = value
+		default:
+			replacement, ok := value.(map[string]interface{})
+			if !ok {
+				return scimInvalidValue("a filtered path without a sub-attribute requires an object value")
+			}
+			if op == "replace" {
+				element = map[string]interface{}{}
+			}
+			for name, v := range replacement {
+				element[scimAttributeKey(element, name)] = v
+			}
+		}
+		kept = append(kept, element)
+	}
+
+	if !matched {
+		if op == "remove" {
+			return &ScimError{Status: http.StatusBadRequest, ScimType: "noTarget", Detail: "no values match the path filter"}
+		}
+		element := map[string]interface{}{}
+		for _, comparison := range path.Filter {
+			if comparison.Operator == "eq" {
+				element[comparison.Attribute] = comparison.Value
+			}
+		}
+		if path.SubAttribute != "" {
+			element[path.SubAttribute] = value
+		} else if replacement, ok := value.(map[string]interface{}); ok {
+			for name, v := range replacement {
+				element[name] = v
+			}
+		}
+		kept = append(kept, element)
+	}
+
+	resource[key] = kept
+	return nil
+}
+
+// matchScimElement evaluates a value filter against an element of a multi-valued attribute
+func matchScimElement(element map[string]interface{}, filter []scimComparison) bool {
+	for _, comparison := range filter {
+		actual, present := element[scimAttributeKey(element, comparison.Attribute)]
+		if comparison.Operator == "pr" {
+			if !present || actual == nil || actual == "" {
+				return false
+			}
+			continue
+		}
+
+		actualString, actualIsString := actual.(string)
+		expectedString, expectedIsString := comparison.Value.(string)
+		if !actualIsString || !expectedIsString {
+			equal := present && actual == comparison.Value
+			if (comparison.Operator == "eq") != equal {
+				return false
+			}
+			continue
+		}
+
+		actualString = strings.ToLower(actualString)
+		expectedString = strings.ToLower(expectedString)
+		var ok bool
+		switch comparison.Operator {
+		case "eq":
+			ok = actualString == expectedString
+		case "ne":
+			ok = actualString != expectedString
+		case "co":
+			ok = strings.Contains(actualString, expectedString)
+		case "sw":
+			ok = strings.HasPrefix(actualString, expectedString)
+		case "ew":
+			ok = strings.HasSuffix(actualString, expectedString)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// appendScimValues adds values to a multi-valued attribute, skipping elements whose value is already present
+func appendScimValues(list []interface{}, value interface{}) []interface{} {
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+	for _, item := range values {
+		if !containsScimValue(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func removeScimValues(list, values []interface{}) []interface{} {
+	kept := list[:0:0]
+	for _, item := range list {
+		if !containsScimValue(values, item) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+func containsScimValue(list []interface{}, item interface{}) bool {
+	value := scimElementValue(item)
+	for _, existing := range list {
+		if scimElementValue(existing) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// scimElementValue returns the "value" sub-attribute of an element, or the element itself
+func scimElementValue(item interface{}) interface{} {
+	if element, ok := item.(map[string]interface{}); ok {
+		return element[scimAttributeKey(element, "value")]
+	}
+	return item
+}
+
+// scimAttributeKey returns the key of resource matching name case-insensitively, or name itself
+func scimAttributeKey(resource map[string]interface{}, name string) string {
+	if _, ok := resource[name]; ok {
+		return name
+	}
+	for key := range resource {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return name
+}
+
+func scimInvalidPath(path string) error {
+	return &ScimError{Status: http.StatusBadRequest, ScimType: "invalidPath", Detail: "invalid path " + path}
+}
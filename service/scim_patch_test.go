@@ -0,0 +1,175 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseScimPatchPath(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    scimPatchPath
+		wantErr bool
+	}{
+		{raw: "userName", want: scimPatchPath{Attribute: "userName"}},
+		{raw: "name.givenName", want: scimPatchPath{Attribute: "name", SubAttribute: "givenName"}},
+		{raw: "urn:ietf:params:scim:schemas:core:2.0:User:active", want: scimPatchPath{Attribute: "active"}},
+		{raw: `emails[type eq "work"].value`, want: scimPatchPath{
+			Attribute:    "emails",
+			Filter:       []scimComparison{{"type", "eq", "work"}},
+			SubAttribute: "value",
+		}},
+		{raw: `members[value eq "2"]`, want: scimPatchPath{
+			Attribute: "members",
+			Filter:    []scimComparison{{"value", "eq", "2"}},
+		}},
+		{raw: "", wantErr: true},
+		{raw: `emails[type eq "work"`, wantErr: true},
+		{raw: `emails[]`, wantErr: true},
+		{raw: `emails[type eq "work"]value`, wantErr: true},
+		{raw: `emails[type eq "work"].`, wantErr: true},
+		{raw: `[type eq "work"]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := parseScimPatchPath(tt.raw)
+			if tt.wantErr {
+				var scimErr *ScimError
+				if !errors.As(err, &scimErr) || scimErr.ScimType != "invalidPath" {
+					t.Fatalf("parseScimPatchPath = %v, want an invalidPath error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseScimPatchPath: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseScimPatchPath = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchScimUser(t *testing.T) {
+	active := true
+	current := &ScimUser{
+		Schemas:  []string{ScimSchemaUser},
+		ID:       "1",
+		UserName: "alice",
+		Emails: []ScimMultiValue{
+			{Value: "alice@example.com", Type: "work", Primary: true},
+			{Value: "alice@home.example", Type: "home"},
+		},
+		Active: &active,
+		Groups: []ScimMultiValue{{Value: "7"}},
+		Meta:   &ScimMeta{ResourceType: "User"},
+	}
+
+	tests := []struct {
+		name       string
+		operations string
+		check      func(t *testing.T, user *ScimUser)
+		wantType   string // scimType of the expected error
+	}{
+		{
+			name:       "replace attribute",
+			operations: `[{"op": "Replace", "path": "userName", "value": "alice2"}]`,
+			check: func(t *testing.T, user *ScimUser) {
+				if user.UserName != "alice2" {
+					t.Errorf("userName = %q", user.UserName)
+				}
+			},
+		},
+		{
+			name:       "replace without path, Azure AD string boolean",
+			operations: `[{"op": "replace", "value": {"active": "False", "DISPLAYNAME": "Alice"}}]`,
+			check: func(t *testing.T, user *ScimUser) {
+				if user.Active == nil || *user.Active || user.DisplayName != "Alice" {
+					t.Errorf("active = %v, displayName = %q", user.Active, user.DisplayName)
+				}
+			},
+		},
+		{
+			name:       "replace filtered sub-attribute",
+			operations: `[{"op": "replace", "path": "emails[type eq \"WORK\"].value", "value": "alice@example.org"}]`,
+			check: func(t *testing.T, user *ScimUser) {
+				if user.Emails[0].Value != "alice@example.org" || user.Emails[1].Value != "alice@home.example" {
+					t.Errorf("emails = %+v", user.Emails)
+				}
+			},
+		},
+		{
+			name:       "add filtered value without a match creates it",
+			operations: `[{"op": "add", "path": "emails[type eq \"other\"].value", "value": "alice@other.example"}]`,
+			check: func(t *testing.T, user *ScimUser) {
+				if len(user.Emails) != 3 || user.Emails[2] != (ScimMultiValue{Value: "alice@other.example", Type: "other"}) {
+					t.Errorf("emails = %+v", user.Emails)
+				}
+			},
+		},
+		{
+			name:       "add skips values already present",
+			operations: `[{"op": "add", "path": "emails", "value": [{"value": "alice@example.com"}, {"value": "new@example.com"}]}]`,
+			check: func(t *testing.T, user *ScimUser) {
+				if len(user.Emails) != 3 || user.Emails[2].Value != "new@example.com" {
+					t.Errorf("emails = %+v", user.Emails)
+				}
+			},
+		},
+		{
+			name:       "remove filtered element",
+			operations: `[{"op": "remove", "path": "emails[type eq \"home\"]"}]`,
+			check: func(t *testing.T, user *ScimUser) {
+				if len(user.Emails) != 1 || user.Emails[0].Type != "work" {
+					t.Errorf("emails = %+v", user.Emails)
+				}
+			},
+		},
+		{
+			name:       "read-only attributes are dropped",
+			operations: `[{"op": "replace", "path": "displayName", "value": "Alice"}]`,
+			check: func(t *testing.T, user *ScimUser) {
+				if user.Groups != nil || user.Meta != nil {
+					t.Errorf("groups = %+v, meta = %+v", user.Groups, user.Meta)
+				}
+			},
+		},
+		{name: "no operations", operations: `[]`, wantType: "invalidValue"},
+		{name: "unknown operation", operations: `[{"op": "move", "path": "userName", "value": "x"}]`, wantType: "invalidValue"},
+		{name: "remove without path", operations: `[{"op": "remove"}]`, wantType: "noTarget"},
+		{name: "remove without a match", operations: `[{"op": "remove", "path": "emails[type eq \"other\"]"}]`, wantType: "noTarget"},
+		{name: "replace without value", operations: `[{"op": "replace", "path": "userName"}]`, wantType: "invalidValue"},
+		{name: "invalid path", operations: `[{"op": "replace", "path": "emails[type eq", "value": "x"}]`, wantType: "invalidPath"},
+		{name: "non-boolean active", operations: `[{"op": "replace", "path": "active", "value": "maybe"}]`, wantType: "invalidValue"},
+		{name: "wrong type", operations: `[{"op": "replace", "path": "userName", "value": 3}]`, wantType: "invalidValue"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch ScimPatchRequest
+			if err := json.Unmarshal([]byte(`{"Operations": `+tt.operations+`}`), &patch); err != nil {
+				t.Fatalf("decode operations: %v", err)
+			}
+
+			var patched ScimUser
+			err := patchScimResource(current, patch, &patched)
+			if tt.wantType != "" {
+				var scimErr *ScimError
+				if !errors.As(err, &scimErr) || scimErr.ScimType != tt.wantType {
+					t.Fatalf("patchScimResource = %v, want a %s error", err, tt.wantType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("patchScimResource: %v", err)
+			}
+			tt.check(t, &patched)
+		})
+	}
+
+	// Patching works on a copy
+	if current.UserName != "alice" || len(current.Emails) != 2 || current.Emails[0].Value != "alice@example.com" {
+		t.Errorf("current resource was modified: %+v", current)
+	}
+}
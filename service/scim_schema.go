@@ -0,0 +1,121 @@
+package service
+
+// ServiceProviderConfig describes the SCIM features supported by this server
+func (s *ScimService) ServiceProviderConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":          []string{ScimSchemaServiceProviderConfig},
+		"documentationUri": "https://datatracker.ietf.org/doc/html/rfc7644",
+		"patch":            map[string]bool{"supported": true},
+		"bulk":             map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           map[string]interface{}{"supported": true, "maxResults": MaxScimPageSize},
+		"changePassword":   map[string]bool{"supported": true},
+		"sort":             map[string]bool{"supported": false},
+		"etag":             map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "Authentication with the token configured in SCIM_TOKEN",
+			"primary":     true,
+		}},
+		"meta": map[string]string{
+			"resourceType": "ServiceProviderConfig",
+			"location":     s.baseURL + "/ServiceProviderConfig",
+		},
+	}
+}
+
+// ResourceTypes describes the User and Group resource types
+func (s *ScimService) ResourceTypes() []map[string]interface{} {
+	return []map[string]interface{}{
+		s.resourceType("User", "/Users", ScimSchemaUser),
+		s.resourceType("Group", "/Groups", ScimSchemaGroup),
+	}
+}
+
+func (s *ScimService) resourceType(name, endpoint, schema string) map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":  []string{ScimSchemaResourceType},
+		"id":       name,
+		"name":     name,
+		"endpoint": endpoint,
+		"schema":   schema,
+		"meta": map[string]string{
+			"resourceType": "ResourceType",
+			"location":     s.baseURL + "/ResourceTypes/" + name,
+		},
+	}
+}
+
+// Schemas describes the attributes of users and groups supported by this server
+func (s *ScimService) Schemas() []map[string]interface{} {
+	return []map[string]interface{}{
+		s.schema(ScimSchemaUser, "User", "User Account", []map[string]interface{}{
+			scimAttribute("userName", "string", "Unique identifier for the user, used to sign in", true, "readWrite", "server"),
+			scimAttribute("displayName", "string", "Name of the user, mirrors userName", false, "readOnly", "none"),
+			scimAttribute("externalId", "string", "Identifier of the user in the provisioning client", false, "readWrite", "none"),
+			scimAttribute("active", "boolean", "Whether the user can sign in", false, "readWrite", "none"),
+			scimAttribute("password", "string", "Password of the user, never returned", false, "writeOnly", "none"),
+			scimMultiValuedAttribute("emails", "Email address of the user; only the primary address is kept", true, "readWrite",
+				scimAttribute("value", "string", "Email address", true, "readWrite", "server"),
+				scimAttribute("type", "string", "Label of the address", false, "readWrite", "none"),
+				scimAttribute("primary", "boolean", "Whether this is the primary address", false, "readWrite", "none"),
+			),
+			scimMultiValuedAttribute("groups", "Organizations the user belongs to", false, "readOnly",
+				scimAttribute("value", "string", "ID of the group", false, "readOnly", "none"),
+				scimAttribute("display", "string", "Name of the group", false, "readOnly", "none"),
+			),
+		}),
+		s.schema(ScimSchemaGroup, "Group", "Organization", []map[string]interface{}{
+			scimAttribute("displayName", "string", "Name of the organization", true, "readWrite", "none"),
+			scimMultiValuedAttribute("members", "Users belonging to the organization", false, "readWrite",
+				scimAttribute("value", "string", "ID of the user", true, "immutable", "none"),
+				scimAttribute("display", "string", "userName of the user", false, "readOnly", "none"),
+			),
+		}),
+	}
+}
+
+func (s *ScimService) schema(id, name, description string, attributes []map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":     []string{ScimSchemaSchema},
+		"id":          id,
+		"name":        name,
+		"description": description,
+		"attributes":  attributes,
+		"meta": map[string]string{
+			"resourceType": "Schema",
+			"location":     s.baseURL + "/Schemas/" + id,
+		},
+	}
+}
+
+func scimAttribute(name, attributeType, description string, required bool, mutability, uniqueness string) map[string]interface{} {
+	returned := "default"
+	if mutability == "writeOnly" {
+		returned = "never"
+	}
+	return map[string]interface{}{
+		"name":        name,
+		"type":        attributeType,
+		"multiValued": false,
+		"description": description,
+		"required":    required,
+		"caseExact":   false,
+		"mutability":  mutability,
+		"returned":    returned,
+		"uniqueness":  uniqueness,
+	}
+}
+
+func scimMultiValuedAttribute(name, description string, required bool, mutability string, subAttributes ...map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":          name,
+		"type":          "complex",
+		"multiValued":   true,
+		"description":   description,
+		"required":      required,
+		"mutability":    mutability,
+		"returned":      "default",
+		"subAttributes": subAttributes,
+	}
+}
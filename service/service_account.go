@@ -0,0 +1,319 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"miniauth/database"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Scopes of service accounts, each allowing a group of internal endpoints
+const (
+	ServiceAccountScopeAuthzCheck              = "authz:check"       // POST /api/authz/check
+	ServiceAccountScopeOAuthApplicationsCreate = "oauth_apps:create" // POST /api/admin/oauth/internal/applications
+)
+
+const (
+	serviceAccountSecretPrefix = "msa_"
+	// serviceAccountPrefixLength is how much of a secret is kept to tell credentials apart
+	serviceAccountPrefixLength = len(serviceAccountSecretPrefix) + 6
+	// serviceAccountUsageInterval limits how often the last use of a credential is written
+	serviceAccountUsageInterval = time.Minute
+)
+
+// ServiceAccountScopes lists the scopes service accounts can be granted
+var ServiceAccountScopes = []string{
+	ServiceAccountScopeAuthzCheck,
+	ServiceAccountScopeOAuthApplicationsCreate,
+}
+
+var (
+	// ErrInvalidServiceAccountScope is returned when a service account request names an unknown scope
+	ErrInvalidServiceAccountScope = errors.New("unknown service account scope")
+	// ErrServiceAccountExists is returned when another service account has the name
+	ErrServiceAccountExists = errors.New("a service account with this name already exists")
+	// ErrInvalidServiceAccountCredential is returned when a secret is unknown, revoked or expired, or its account is inactive
+	ErrInvalidServiceAccountCredential = errors.New("invalid service account credential")
+)
+
+// ServiceAccountRequest creates or updates a service account
+type ServiceAccountRequest struct {
+	Name        string   `json:"name" validate:"required,max=100"`
+	Description string   `json:"description" validate:"max=500"`
+	Scopes      []string `json:"scopes" validate:"required,min=1"`
+	Active      *bool    `json:"active"`
+}
+
+// ServiceAccountCredentialRequest issues a credential to a service account
+type ServiceAccountCredentialRequest struct {
+	Name          string `json:"name" validate:"max=100"`
+	ExpiresInDays *int   `json:"expires_in_days" validate:"omitempty,min=1,max=3650"` // Omit for a credential that does not expire
+}
+
+// ServiceAccountService manages service accounts and the credentials they authenticate with
+type ServiceAccountService struct {
+	db *gorm.DB
+}
+
+// NewServiceAccountService creates a new service account service
+func NewServiceAccountService(db *gorm.DB) *ServiceAccountService {
+	if getEnv("MINIAUTH_INTERNAL_TOKEN", "") != "" {
+		log.Println("MINIAUTH_INTERNAL_TOKEN is no longer supported and is ignored; create a service account for internal API clients instead")
+	}
+	return &ServiceAccountService{db: db}
+}
+
+// ListServiceAccounts lists service accounts with their credentials that have not been revoked
+func (s *ServiceAccountService) ListServiceAccounts() ([]database.ServiceAccount, error) {
+	var accounts []database.ServiceAccount
+	err := s.preloadCredentials(s.db).Order("id").Find(&accounts).Error
+	return accounts, err
+}
+
+// GetServiceAccount returns a service account with its credentials that have not been revoked
+func (s *ServiceAccountService) GetServiceAccount(id uint) (*database.ServiceAccount, error) {
+	var account database.ServiceAccount
+	if err := s.preloadCredentials(s.db).First(&account, id).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (s *ServiceAccountService) preloadCredentials(db *gorm.DB) *gorm.DB {
+	return db.Preload("Credentials", func(db *gorm.DB) *gorm.DB {
+		return db.Where("revoked_at IS NULL").Order("id")
+	})
+}
+
+// CreateServiceAccount creates a service account. It cannot authenticate until a credential is issued.
+func (s *ServiceAccountService) CreateServiceAccount(actor AuditActor, req ServiceAccountRequest) (*database.ServiceAccount, error) {
+	account := &database.ServiceAccount{}
+	if err := s.applyServiceAccountRequest(account, req); err != nil {
+		return nil, err
+	}
+	if actor.UserID != nil {
+		account.CreatedByID = *actor.UserID
+	}
+
+	active := account.Active
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(account).Error; err != nil {
+			return err
+		}
+		// Create replaces a false Active with the column default
+		if !active {
+			if err := tx.Model(account).Update("active", false).Error; err != nil {
+				return err
+			}
+		}
+		return recordAudit(tx, actor, auditEntry{
+			Action:     AuditActionServiceAccountCreated,
+			TargetType: AuditTargetServiceAccount,
+			TargetID:   auditID(account.ID),
+			Metadata: map[string]interface{}{
+				"name":   account.Name,
+				"scopes": strings.Fields(account.Scopes),
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetServiceAccount(account.ID)
+}
+
+// UpdateServiceAccount updates the name, description, scopes and status of a service account
+func (s *ServiceAccountService) UpdateServiceAccount(actor AuditActor, id uint, req ServiceAccountRequest) (*database.ServiceAccount, error) {
+	var account database.ServiceAccount
+	if err := s.db.First(&account, id).Error; err != nil {
+		return nil, err
+	}
+	if err := s.applyServiceAccountRequest(&account, req); err != nil {
+		return nil, err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&account).Select("name", "description", "scopes", "active").Updates(&account).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, auditEntry{
+			Action:     AuditActionServiceAccountUpdated,
+			TargetType: AuditTargetServiceAccount,
+			TargetID:   auditID(account.ID),
+			Metadata: map[string]interface{}{
+				"name":   account.Name,
+				"scopes": strings.Fields(account.Scopes),
+				"active": account.Active,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetServiceAccount(account.ID)
+}
+
+// DeleteServiceAccount deletes a service account and its credentials. Audit events keep its ID.
+func (s *ServiceAccountService) DeleteServiceAccount(actor AuditActor, id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var account database.ServiceAccount
+		if err := tx.First(&account, id).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("service_account_id = ?", id).Delete(&database.ServiceAccountCredential{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&database.OAuthApplication{}).
+			Where("created_by_service_account_id = ?", id).
+			Update("created_by_service_account_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&account).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, actor, auditEntry{
+			Action:     AuditActionServiceAccountDeleted,
+			TargetType: AuditTargetServiceAccount,
+			TargetID:   auditID(account.ID),
+			Metadata: map[string]interface{}{
+				"name": account.Name,
+			},
+		})
+	})
+}
+
+// applyServiceAccountRequest validates a request and copies it onto account
+func (s *ServiceAccountService) applyServiceAccountRequest(account *database.ServiceAccount, req ServiceAccountRequest) error {
+	name := strings.TrimSpace(req.Name)
+	var count int64
+	if err := s.db.Model(&database.ServiceAccount{}).Where("name = ? AND id <> ?", name, account.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrServiceAccountExists
+	}
+
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		if !slices.Contains(ServiceAccountScopes, scope) {
+			return fmt.Errorf("%w: %q", ErrInvalidServiceAccountScope, scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	account.Name = name
+	account.Description = req.Description
+	account.Scopes = strings.Join(scopes, " ")
+	account.Active = req.Active == nil || *req.Active
+	return nil
+}
+
+// IssueCredential creates a credential for a service account. The secret is returned only here;
+// just its hash is stored. Existing credentials stay valid, so secrets can be rotated without downtime.
+func (s *ServiceAccountService) IssueCredential(actor AuditActor, accountID uint, req ServiceAccountCredentialRequest) (*database.ServiceAccountCredential, string, error) {
+	var account database.ServiceAccount
+	if err := s.db.First(&account, accountID).Error; err != nil {
+		return nil, "", err
+	}
+
+	secret := serviceAccountSecretPrefix + generateSecureToken()
+	credential := &database.ServiceAccountCredential{
+		ServiceAccountID: account.ID,
+		Name:             strings.TrimSpace(req.Name),
+		SecretHash:       hashToken(secret),
+		Prefix:           secret[:serviceAccountPrefixLength],
+	}
+	if req.ExpiresInDays != nil {
+		expiresAt := time.Now().AddDate(0, 0, *req.ExpiresInDays)
+		credential.ExpiresAt = &expiresAt
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(credential).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, auditEntry{
+			Action:     AuditActionServiceAccountCredentialCreated,
+			TargetType: AuditTargetServiceAccount,
+			TargetID:   auditID(account.ID),
+			Metadata: map[string]interface{}{
+				"credential_id": credential.ID,
+				"prefix":        credential.Prefix,
+			},
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return credential, secret, nil
+}
+
+// RevokeCredential revokes a credential of a service account
+func (s *ServiceAccountService) RevokeCredential(actor AuditActor, accountID, credentialID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var credential database.ServiceAccountCredential
+		if err := tx.Where("id = ? AND service_account_id = ? AND revoked_at IS NULL", credentialID, accountID).First(&credential).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&credential).Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, actor, auditEntry{
+			Action:     AuditActionServiceAccountCredentialRevoked,
+			TargetType: AuditTargetServiceAccount,
+			TargetID:   auditID(accountID),
+			Metadata: map[string]interface{}{
+				"credential_id": credential.ID,
+				"prefix":        credential.Prefix,
+			},
+		})
+	})
+}
+
+// Authenticate resolves a secret to its active service account, recording where the credential
+// was last used from
+func (s *ServiceAccountService) Authenticate(secret, ip string) (*database.ServiceAccount, error) {
+	if !strings.HasPrefix(secret, serviceAccountSecretPrefix) {
+		return nil, ErrInvalidServiceAccountCredential
+	}
+
+	var credential database.ServiceAccountCredential
+	if err := s.db.Where("secret_hash = ? AND revoked_at IS NULL", hashToken(secret)).First(&credential).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidServiceAccountCredential
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if credential.ExpiresAt != nil && !now.Before(*credential.ExpiresAt) {
+		return nil, ErrInvalidServiceAccountCredential
+	}
+
+	var account database.ServiceAccount
+	if err := s.db.Where("id = ? AND active = ?", credential.ServiceAccountID, true).First(&account).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidServiceAccountCredential
+		}
+		return nil, err
+	}
+
+	if credential.LastUsedAt == nil || now.Sub(*credential.LastUsedAt) >= serviceAccountUsageInterval || credential.LastUsedIP != ip {
+		if err := s.db.Model(&credential).Updates(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": ip,
+		}).Error; err != nil {
+			log.Printf("failed to record use of service account credential %d: %v", credential.ID, err)
+		}
+	}
+	return &account, nil
+}
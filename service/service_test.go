@@ -0,0 +1,52 @@
+package service
+
+import (
+	"miniauth/database"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB returns a migrated in-memory database private to the test
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	// Every connection to file::memory: opens a database of its own
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := database.SetupDatabase(db); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+	return db
+}
+
+// mustCreate inserts a record, failing the test on error
+func mustCreate(t *testing.T, db *gorm.DB, value any) {
+	t.Helper()
+	if err := db.Create(value).Error; err != nil {
+		t.Fatalf("create %T: %v", value, err)
+	}
+}
+
+// fakeMailer records the messages it is asked to send
+type fakeMailer struct {
+	messages []MailMessage
+}
+
+func (m *fakeMailer) Send(msg MailMessage) error {
+	m.messages = append(m.messages, msg)
+	return nil
+}
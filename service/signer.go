@@ -0,0 +1,77 @@
+package service
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidSignedToken is returned when a signed token is malformed, expired or issued for another purpose
+var ErrInvalidSignedToken = errors.New("invalid or expired token")
+
+// TokenSigner issues and verifies stateless, HMAC-signed tokens with an expiry
+type TokenSigner struct {
+	secret []byte
+}
+
+// SignedTokenClaims are the claims carried by a signed token
+type SignedTokenClaims struct {
+	Purpose string            `json:"purpose"`
+	Data    map[string]string `json:"data,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// NewTokenSignerFromEnv creates a token signer using TOKEN_SIGNING_SECRET.
+// If the secret is not configured a random one is generated, so tokens do not survive restarts.
+func NewTokenSignerFromEnv() *TokenSigner {
+	secret := getEnv("TOKEN_SIGNING_SECRET", "")
+	if secret == "" {
+		log.Println("TOKEN_SIGNING_SECRET is not set, using a random secret; signed tokens will not survive restarts")
+		random := make([]byte, 32)
+		rand.Read(random)
+		return &TokenSigner{secret: random}
+	}
+	return &TokenSigner{secret: []byte(secret)}
+}
+
+// Sign creates a token for the given purpose and subject that expires after ttl
+func (t *TokenSigner) Sign(purpose, subject string, data map[string]string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := SignedTokenClaims{
+		Purpose: purpose,
+		Data:    data,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			Issuer:    "miniauth",
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(t.secret)
+}
+
+// Verify parses a token and checks its signature, expiry and purpose
+func (t *TokenSigner) Verify(tokenString, purpose string) (*SignedTokenClaims, error) {
+	var claims SignedTokenClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return t.secret, nil
+	}, jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, ErrInvalidSignedToken
+	}
+
+	if claims.Purpose != purpose {
+		return nil, ErrInvalidSignedToken
+	}
+
+	return &claims, nil
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"errors"
+	"miniauth/database"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidTeamSlug is returned when a team slug contains unsupported characters
+	ErrInvalidTeamSlug = errors.New("team slug may only contain lowercase letters, digits and hyphens")
+	// ErrTeamSlugTaken is returned when another team of the organization already uses the slug
+	ErrTeamSlugTaken = errors.New("team slug is already taken")
+	// ErrInvalidTeamParent is returned when the parent team is in another organization or would create a cycle
+	ErrInvalidTeamParent = errors.New("invalid parent team")
+	// ErrAlreadyTeamMember is returned when the user already belongs to the team
+	ErrAlreadyTeamMember = errors.New("user is already a member of the team")
+)
+
+// ListTeams retrieves the teams of an organization
+func (s *OrgService) ListTeams(orgID uint) ([]database.Team, error) {
+	var teams []database.Team
+	err := s.db.Where("org_id = ?", orgID).Order("name").Find(&teams).Error
+	return teams, err
+}
+
+// GetTeamBySlug retrieves a team of an organization by slug
+func (s *OrgService) GetTeamBySlug(orgID uint, slug string) (*database.Team, error) {
+	var team database.Team
+	if err := s.db.Where("org_id = ? AND slug = ?", orgID, slug).First(&team).Error; err != nil {
+		return nil, err
+	}
+	return &team, nil
+}
+
+// CreateTeam creates a team in an organization, optionally nested under a parent team
+func (s *OrgService) CreateTeam(team *database.Team, permissions []string) error {
+	if err := s.validateTeam(team, permissions); err != nil {
+		return err
+	}
+
+	team.Permissions = joinPermissions(permissions)
+	return s.db.Create(team).Error
+}
+
+// UpdateTeam updates the name, slug, description, parent and permissions of a team
+func (s *OrgService) UpdateTeam(team *database.Team, permissions []string) error {
+	if err := s.validateTeam(team, permissions); err != nil {
+		return err
+	}
+
+	team.Permissions = joinPermissions(permissions)
+	return s.db.Save(team).Error
+}
+
+// DeleteTeam deletes a team and its memberships. Child teams are moved to the deleted team's parent.
+func (s *OrgService) DeleteTeam(orgID, teamID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var team database.Team
+		if err := tx.Where("id = ? AND org_id = ?", teamID, orgID).First(&team).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&database.Team{}).
+			Where("org_id = ? AND parent_id = ?", orgID, team.ID).
+			Update("parent_id", team.ParentID).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("team_id = ?", team.ID).Delete(&database.TeamMember{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&team).Error
+	})
+}
+
+// ListTeamMembers retrieves the direct members of a team
+func (s *OrgService) ListTeamMembers(teamID uint) ([]database.TeamMember, error) {
+	var members []database.TeamMember
+	err := s.db.Where("team_id = ?", teamID).Order("created_at").Find(&members).Error
+	return members, err
+}
+
+// AddTeamMember adds an organization member to a team
+func (s *OrgService) AddTeamMember(team *database.Team, userID uint) error {
+	isMember, err := s.IsUserInOrg(userID, team.OrgID)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return ErrNotOrgMember
+	}
+
+	var count int64
+	if err := s.db.Model(&database.TeamMember{}).
+		Where("team_id = ? AND user_id = ?", team.ID, userID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrAlreadyTeamMember
+	}
+
+	return s.db.Create(&database.TeamMember{TeamID: team.ID, UserID: userID}).Error
+}
+
+// RemoveTeamMember removes a user from a team
+func (s *OrgService) RemoveTeamMember(teamID, userID uint) error {
+	result := s.db.Where("team_id = ? AND user_id = ?", teamID, userID).Delete(&database.TeamMember{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetUserTeams retrieves the teams a user belongs to in an organization, including the parent
+// teams inherited through membership of a child team
+func (s *OrgService) GetUserTeams(orgID, userID uint) ([]database.Team, error) {
+	return userTeams(s.db, orgID, userID)
+}
+
+// GetUserTeamsByOrg retrieves the teams of a user in every organization they are an active member of,
+// keyed by organization ID
+func (s *OrgService) GetUserTeamsByOrg(userID uint) (map[uint][]database.Team, error) {
+	var orgIDs []uint
+	err := s.db.Model(&database.Team{}).
+		Distinct("teams.org_id").
+		Joins("JOIN team_members ON team_members.team_id = teams.id").
+		Joins("JOIN org_members ON org_members.org_id = teams.org_id AND org_members.user_id = team_members.user_id").
+		Where("team_members.user_id = ? AND org_members.deleted_at IS NULL", userID).
+		Pluck("teams.org_id", &orgIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	teamsByOrg := make(map[uint][]database.Team, len(orgIDs))
+	for _, orgID := range orgIDs {
+		teams, err := userTeams(s.db, orgID, userID)
+		if err != nil {
+			return nil, err
+		}
+		teamsByOrg[orgID] = teams
+	}
+	return teamsByOrg, nil
+}
+
+// InheritedTeamPermissions returns the permissions granted by a team together with those of
+// its parent teams, which its members also receive. A nil team grants nothing.
+func (s *OrgService) InheritedTeamPermissions(orgID uint, teamID *uint) ([]string, error) {
+	if teamID == nil {
+		return nil, nil
+	}
+
+	teams, err := s.ListTeams(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	var permissions []string
+	for _, team := range teamAncestry(teams, []uint{*teamID}) {
+		permissions = append(permissions, SplitPermissions(team.Permissions)...)
+	}
+	return permissions, nil
+}
+
+// validateTeam checks the slug, permissions and parent of a team before it is saved
+func (s *OrgService) validateTeam(team *database.Team, permissions []string) error {
+	if !orgSlugPattern.MatchString(team.Slug) {
+		return ErrInvalidTeamSlug
+	}
+	if err := ValidateOrgRolePermissions(permissions); err != nil {
+		return err
+	}
+
+	var count int64
+	if err := s.db.Model(&database.Team{}).
+		Where("org_id = ? AND slug = ? AND id <> ?", team.OrgID, team.Slug, team.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrTeamSlugTaken
+	}
+
+	if team.ParentID == nil {
+		return nil
+	}
+
+	teams, err := s.ListTeams(team.OrgID)
+	if err != nil {
+		return err
+	}
+
+	// The parent must belong to the organization and must not be the team or one of its descendants
+	ancestry := teamAncestry(teams, []uint{*team.ParentID})
+	if len(ancestry) == 0 {
+		return ErrInvalidTeamParent
+	}
+	for _, ancestor := range ancestry {
+		if team.ID != 0 && ancestor.ID == team.ID {
+			return ErrInvalidTeamParent
+		}
+	}
+	return nil
+}
+
+// userTeams returns the teams a user belongs to in an organization, directly or through a child team
+func userTeams(db *gorm.DB, orgID, userID uint) ([]database.Team, error) {
+	var direct []uint
+	err := db.Model(&database.TeamMember{}).
+		Joins("JOIN teams ON teams.id = team_members.team_id").
+		Where("teams.org_id = ? AND team_members.user_id = ?", orgID, userID).
+		Pluck("team_members.team_id", &direct).Error
+	if err != nil || len(direct) == 0 {
+		return nil, err
+	}
+
+	var teams []database.Team
+	if err := db.Where("org_id = ?", orgID).Find(&teams).Error; err != nil {
+		return nil, err
+	}
+
+	return teamAncestry(teams, direct), nil
+}
+
+// teamAncestry returns the given teams and all of their ancestors, sorted by name
+func teamAncestry(teams []database.Team, teamIDs []uint) []database.Team {
+	byID := make(map[uint]database.Team, len(teams))
+	for _, team := range teams {
+		byID[team.ID] = team
+	}
+
+	seen := make(map[uint]bool)
+	var result []database.Team
+	for _, id := range teamIDs {
+		for {
+			team, ok := byID[id]
+			if !ok || seen[id] {
+				break
+			}
+			seen[id] = true
+			result = append(result, team)
+
+			if team.ParentID == nil {
+				break
+			}
+			id = *team.ParentID
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// removeTeamMemberships removes a user from all teams of an organization
+func removeTeamMemberships(tx *gorm.DB, orgID, userID uint) error {
+	return tx.Where("user_id = ? AND team_id IN (?)", userID,
+		tx.Model(&database.Team{}).Select("id").Where("org_id = ?", orgID)).
+		Delete(&database.TeamMember{}).Error
+}
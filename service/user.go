@@ -2,20 +2,76 @@ package service
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"miniauth/database"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// ErrUserDisabled is returned when a disabled user tries to sign in
+var ErrUserDisabled = errors.New("user account is disabled")
+
 type UserService struct {
-	db *gorm.DB
+	db                      *gorm.DB
+	mailer                  Mailer
+	signer                  *TokenSigner
+	passwordPolicy          *PasswordPolicy
+	emailVerificationPolicy string
+	emailVerificationTTL    time.Duration
+	verificationCooldown    time.Duration
+	emailChangeRevertTTL    time.Duration
+	passwordResetTTL        time.Duration
+	passwordResetMaxPerHour int
+	soleOwnerPolicy         string
+	personalOrgPolicy       string
+	baseURL                 string
+	authenticators          []Authenticator
+	mailQueue               *backgroundQueue // Requests answered by email, handled off the request path
+}
+
+func NewUserService(db *gorm.DB, mailer Mailer, signer *TokenSigner) *UserService {
+	// NewServiceManager refuses to start with an invalid policy; other callers fall back to the default
+	emailVerificationPolicy, err := LoadEmailVerificationPolicy()
+	if err != nil {
+		log.Printf("%v, using %s", err, EmailVerificationOptional)
+		emailVerificationPolicy = EmailVerificationOptional
+	}
+
+	s := &UserService{
+		db:                      db,
+		mailer:                  mailer,
+		signer:                  signer,
+		passwordPolicy:          LoadPasswordPolicy(),
+		emailVerificationPolicy: emailVerificationPolicy,
+		emailVerificationTTL:    getEnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour),
+		verificationCooldown:    getEnvDuration("EMAIL_VERIFICATION_RESEND_COOLDOWN", 5*time.Minute),
+		emailChangeRevertTTL:    getEnvDuration("EMAIL_CHANGE_REVERT_TTL", 7*24*time.Hour),
+		passwordResetTTL:        getEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+		passwordResetMaxPerHour: getEnvInt("PASSWORD_RESET_MAX_PER_HOUR", 3),
+		soleOwnerPolicy:         getEnv("USER_DELETION_SOLE_OWNER_POLICY", SoleOwnerPolicyBlock),
+		personalOrgPolicy:       getEnv("PERSONAL_ORG_POLICY", PersonalOrgPolicyAlways),
+		baseURL:                 getEnv("APP_BASE_URL", "http://localhost:8080"),
+		mailQueue:               newBackgroundQueue("mail", getEnvInt("MAIL_QUEUE_SIZE", 100)),
+	}
+	s.authenticators = NewAuthenticatorsFromEnv(s)
+	return s
+}
+
+// StartMailWorkers starts the workers handling queued password reset and verification email requests
+func (s *UserService) StartMailWorkers(workers int) {
+	s.mailQueue.start(workers)
 }
 
-func NewUserService(db *gorm.DB) *UserService {
-	return &UserService{db: db}
+// PasswordPolicy returns the password policy enforced by the service
+func (s *UserService) PasswordPolicy() *PasswordPolicy {
+	return s.passwordPolicy
 }
 
-// CreateUser creates a new user and a corresponding organization
+// CreateUser creates a new user and, with the always policy, their personal organization.
+// Organizations that verified the domain of the user's email address add the user or receive a join request.
 func (s *UserService) CreateUser(user *database.User) error {
 	if user.Email == "" {
 		return errors.New("email is required")
@@ -23,36 +79,33 @@ func (s *UserService) CreateUser(user *database.User) error {
 	if user.Username == "" {
 		return errors.New("username is required")
 	}
+	if err := s.EnsureEmailAvailable(user.Email, 0); err != nil {
+		return err
+	}
 
-	// Start a transaction to ensure both user and org are created together
+	// Start a transaction to ensure the user and their memberships are created together
 	return s.db.Transaction(func(tx *gorm.DB) error {
 		// Create the user first
 		if err := tx.Create(user).Error; err != nil {
 			return err
 		}
-
-		// Create a same-name organization for the user
-		org := &database.Org{
-			Name: user.Username,
-			Slug: user.Username,
-		}
-
-		if err := tx.Create(org).Error; err != nil {
+		if err := emitWebhookEvent(tx, WebhookEventUserCreated, webhookUserData(user)); err != nil {
 			return err
 		}
 
-		// Add the user as owner of the organization
-		orgMember := &database.OrgMember{
-			UserID: user.ID,
-			OrgID:  org.ID,
-			Role:   database.OrgMemberRoleOwner,
+		// Create a personal organization named after the user
+		if s.personalOrgPolicy == PersonalOrgPolicyAlways {
+			if _, err := createPersonalOrg(tx, user); err != nil {
+				return err
+			}
 		}
 
-		if err := tx.Create(orgMember).Error; err != nil {
+		if err := s.recordPasswordHistory(tx, user); err != nil {
 			return err
 		}
 
-		return nil
+		// Offer membership of organizations that verified the user's email domain
+		return applyDomainJoinPolicies(tx, user)
 	})
 }
 
@@ -117,7 +170,7 @@ func (s *UserService) UpdateUser(user *database.User) error {
 }
 
 // DeleteUser hard deletes a user by ID and cleans up related records
-func (s *UserService) DeleteUser(id uint) error {
+func (s *UserService) DeleteUser(actor AuditActor, id uint) error {
 	return s.db.Transaction(func(tx *gorm.DB) error {
 		// First, check if user exists
 		var user database.User
@@ -125,16 +178,62 @@ func (s *UserService) DeleteUser(id uint) error {
 			return err
 		}
 
+		// Delete password reset tokens of this user
+		if err := tx.Where("user_id = ?", id).Delete(&database.PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+
+		// Delete the identities linked to this user at upstream providers
+		if err := tx.Where("user_id = ?", id).Delete(&database.LinkedIdentity{}).Error; err != nil {
+			return err
+		}
+
+		// Delete the personal access tokens of this user
+		if err := tx.Where("user_id = ?", id).Delete(&database.PersonalAccessToken{}).Error; err != nil {
+			return err
+		}
+
+		// Delete the password history of this user
+		if err := tx.Where("user_id = ?", id).Delete(&database.PasswordHistory{}).Error; err != nil {
+			return err
+		}
+
+		// Make sure no organization is left without an owner
+		if err := s.resolveSoleOwnedOrgs(tx, id); err != nil {
+			return err
+		}
+
+		// Delete the organization join requests of this user
+		if err := tx.Where("user_id = ?", id).Delete(&database.OrgJoinRequest{}).Error; err != nil {
+			return err
+		}
+
+		// Delete all team memberships for this user
+		if err := tx.Where("user_id = ?", id).Delete(&database.TeamMember{}).Error; err != nil {
+			return err
+		}
+
 		// Delete all organization memberships for this user (hard delete)
 		result := tx.Unscoped().Where("user_id = ?", id).Delete(&database.OrgMember{})
 		if result.Error != nil {
 			return result.Error
 		}
 
-		// Log the number of organization memberships deleted
-		if result.RowsAffected > 0 {
-			// Note: In a real application, you might want to use a proper logger here
-			// For now, this comment serves as a placeholder for logging
+		if err := recordAudit(tx, actor, auditEntry{
+			Action:     AuditActionUserDeleted,
+			TargetType: AuditTargetUser,
+			TargetID:   auditID(user.ID),
+			Metadata: map[string]interface{}{
+				"username":            user.Username,
+				"email":               user.Email,
+				"memberships_removed": result.RowsAffected,
+			},
+		}); err != nil {
+			return err
+		}
+
+		if err := emitWebhookEvent(tx, WebhookEventUserDeleted, webhookUserData(&user)); err != nil {
+			return err
 		}
 
 		// Then hard delete the user (permanent deletion)
@@ -168,64 +267,359 @@ func (s *UserService) CountUsers() (int64, error) {
 
 // AddUserToOrg adds a user to an organization with specified role
 func (s *UserService) AddUserToOrg(userID, orgID uint, role database.OrgMemberRole) error {
-	orgMember := database.OrgMember{
-		UserID: userID,
-		OrgID:  orgID,
-		Role:   role,
-	}
-
-	return s.db.Create(&orgMember).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return addOrgMember(tx, userID, orgID, role)
+	})
 }
 
-// RemoveUserFromOrg removes a user from an organization
+// RemoveUserFromOrg removes a user from an organization.
+// Removing the last owner of an organization is rejected with ErrLastOrgOwner.
 func (s *UserService) RemoveUserFromOrg(userID, orgID uint) error {
-	return s.db.Where("user_id = ? AND org_id = ?", userID, orgID).Delete(&database.OrgMember{}).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return removeOrgMember(tx, userID, orgID)
+	})
+}
+
+// removeOrgMember deletes a membership together with the user's team memberships in the organization
+func removeOrgMember(tx *gorm.DB, userID, orgID uint) error {
+	member, err := lockOrgMember(tx, userID, orgID)
+	if err != nil {
+		return err
+	}
+
+	if member.Role == database.OrgMemberRoleOwner {
+		if err := ensureAnotherOrgOwner(tx, userID, orgID); err != nil {
+			return err
+		}
+	}
+
+	if err := removeTeamMemberships(tx, orgID, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Where("user_id = ? AND org_id = ?", userID, orgID).Delete(&database.OrgMember{}).Error; err != nil {
+		return err
+	}
+
+	return emitWebhookEvent(tx, WebhookEventOrgMemberRemoved, map[string]interface{}{
+		"org_id":  orgID,
+		"user_id": userID,
+		"role":    member.Role,
+	})
 }
 
-// UpdateUserOrgRole updates a user's role in an organization
+// UpdateUserOrgRole updates a user's role in an organization.
+// Demoting the last owner of an organization is rejected with ErrLastOrgOwner.
 func (s *UserService) UpdateUserOrgRole(userID, orgID uint, role database.OrgMemberRole) error {
-	return s.db.Model(&database.OrgMember{}).
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return updateOrgMemberRole(tx, userID, orgID, role)
+	})
+}
+
+// updateOrgMemberRole changes the role of a membership, keeping at least one owner
+func updateOrgMemberRole(tx *gorm.DB, userID, orgID uint, role database.OrgMemberRole) error {
+	member, err := lockOrgMember(tx, userID, orgID)
+	if err != nil {
+		return err
+	}
+
+	if member.Role == database.OrgMemberRoleOwner && role != database.OrgMemberRoleOwner {
+		if err := ensureAnotherOrgOwner(tx, userID, orgID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&database.OrgMember{}).
 		Where("user_id = ? AND org_id = ?", userID, orgID).
 		Update("role", role).Error
 }
 
-// AuthenticateUser verifies user credentials and returns the user if valid
-func (s *UserService) AuthenticateUser(email, password string) (*database.User, error) {
-	user, err := s.GetUserByEmail(email)
+// addOrgMember creates a membership, replacing a previously removed one for the same user, and
+// notifies webhook subscribers
+func addOrgMember(tx *gorm.DB, userID, orgID uint, role database.OrgMemberRole) error {
+	var count int64
+	if err := tx.Model(&database.OrgMember{}).
+		Where("user_id = ? AND org_id = ?", userID, orgID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrAlreadyOrgMember
+	}
+
+	// Soft deleted memberships still occupy the primary key
+	if err := tx.Unscoped().
+		Where("user_id = ? AND org_id = ? AND deleted_at IS NOT NULL", userID, orgID).
+		Delete(&database.OrgMember{}).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Create(&database.OrgMember{
+		UserID: userID,
+		OrgID:  orgID,
+		Role:   role,
+	}).Error; err != nil {
+		return err
+	}
+
+	return emitWebhookEvent(tx, WebhookEventOrgMemberAdded, map[string]interface{}{
+		"org_id":  orgID,
+		"user_id": userID,
+		"role":    role,
+	})
+}
+
+// lockOrgMember loads a membership for update within a transaction
+func lockOrgMember(tx *gorm.DB, userID, orgID uint) (*database.OrgMember, error) {
+	var member database.OrgMember
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND org_id = ?", userID, orgID).
+		First(&member).Error
 	if err != nil {
-		return nil, errors.New("invalid credentials")
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotOrgMember
+		}
+		return nil, err
+	}
+	return &member, nil
+}
+
+// ensureAnotherOrgOwner returns ErrLastOrgOwner unless the organization has an owner other than userID
+func ensureAnotherOrgOwner(tx *gorm.DB, userID, orgID uint) error {
+	var owners int64
+	if err := tx.Model(&database.OrgMember{}).
+		Where("org_id = ? AND role = ? AND user_id <> ?", orgID, database.OrgMemberRoleOwner, userID).
+		Count(&owners).Error; err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOrgOwner
+	}
+	return nil
+}
+
+// AuthenticateUser verifies user credentials through the authenticator chain and returns the user if valid
+func (s *UserService) AuthenticateUser(actor AuditActor, email, password string) (*database.User, error) {
+	failed := func(reason, provider string, user *database.User) {
+		entry := auditEntry{
+			Action:   AuditActionLogin,
+			Outcome:  AuditOutcomeFailure,
+			Metadata: map[string]interface{}{"email": email, "reason": reason},
+		}
+		if provider != "" {
+			entry.Metadata["provider"] = provider
+		}
+		if user != nil {
+			entry.TargetType, entry.TargetID = AuditTargetUser, auditID(user.ID)
+		}
+		recordAuditOrLog(s.db, actor, entry)
+	}
+
+	user, provider, err := s.authenticate(email, password)
+	switch {
+	case errors.Is(err, ErrAuthenticatorNotApplicable):
+		failed("unknown_email", "", nil)
+		return nil, ErrInvalidCredentials
+	case errors.Is(err, ErrInvalidCredentials):
+		failed("invalid_password", provider, user)
+		return nil, ErrInvalidCredentials
+	case err != nil:
+		log.Printf("%s authenticator failed: %v", provider, err)
+		failed("provider_error", provider, nil)
+		return nil, ErrInvalidCredentials
+	}
+
+	if user.Disabled {
+		failed("disabled", provider, user)
+		return nil, ErrUserDisabled
+	}
+
+	if err := s.EnsureEmailVerifiedForLogin(user); err != nil {
+		failed("email_not_verified", provider, user)
+		return nil, err
+	}
+
+	if provider == AuthSourceLocal {
+		if err := s.upgradePasswordHash(user, password); err != nil {
+			log.Printf("failed to upgrade password hash of user %d: %v", user.ID, err)
+		}
 	}
 
-	if !user.CheckPassword(password) {
-		return nil, errors.New("invalid credentials")
+	actor.UserID = &user.ID
+	if err := recordAudit(s.db, actor, auditEntry{
+		Action:     AuditActionLogin,
+		TargetType: AuditTargetUser,
+		TargetID:   auditID(user.ID),
+		Metadata:   map[string]interface{}{"provider": provider},
+	}); err != nil {
+		return nil, err
 	}
 
 	return user, nil
 }
 
-// UpdateUserPassword updates a user's password
-func (s *UserService) UpdateUserPassword(userID uint, newPassword string) error {
+// upgradePasswordHash rehashes a password that was just verified when its stored hash is weaker
+// than the configured algorithm, so hashes are upgraded as users sign in
+func (s *UserService) upgradePasswordHash(user *database.User, password string) error {
+	if !database.PasswordNeedsRehash(user.PasswordHash) {
+		return nil
+	}
+
+	hash, err := database.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Model(user).Update("password_hash", hash).Error; err != nil {
+		return err
+	}
+	user.PasswordHash = hash
+	return nil
+}
+
+// ValidatePassword checks a candidate password against the password policy and,
+// for existing users, against their recent password history
+func (s *UserService) ValidatePassword(user *database.User, password string) error {
+	if err := s.passwordPolicy.Validate(password, user); err != nil {
+		return err
+	}
+
+	if user.ID == 0 || s.passwordPolicy.HistorySize <= 0 {
+		return nil
+	}
+
+	reused, err := s.isPasswordReused(user, password)
+	if err != nil {
+		return err
+	}
+	if reused {
+		return &PasswordPolicyError{Violations: []PasswordViolation{{
+			Code:    PasswordViolationReused,
+			Message: fmt.Sprintf("Password must not match any of your last %d passwords", s.passwordPolicy.HistorySize),
+		}}}
+	}
+
+	return nil
+}
+
+// SetUserPassword validates a password against the policy and sets it on the user without saving
+func (s *UserService) SetUserPassword(user *database.User, password string) error {
+	if err := s.ValidatePassword(user, password); err != nil {
+		return err
+	}
+
+	return user.SetPassword(password)
+}
+
+// UpdateUserPassword validates and updates a user's password
+func (s *UserService) UpdateUserPassword(actor AuditActor, userID uint, newPassword string) error {
 	user, err := s.GetUserByID(userID)
 	if err != nil {
 		return err
 	}
 
-	if err := user.SetPassword(newPassword); err != nil {
+	if err := s.SetUserPassword(user, newPassword); err != nil {
 		return err
 	}
 
-	return s.db.Save(user).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+
+		if err := s.recordPasswordHistory(tx, user); err != nil {
+			return err
+		}
+
+		return recordAudit(tx, actor, auditEntry{
+			Action:     AuditActionPasswordChanged,
+			TargetType: AuditTargetUser,
+			TargetID:   auditID(user.ID),
+		})
+	})
+}
+
+// isPasswordReused checks the password against the current hash and the recent password history
+func (s *UserService) isPasswordReused(user *database.User, password string) (bool, error) {
+	if user.PasswordHash != "" && user.CheckPassword(password) {
+		return true, nil
+	}
+
+	var history []database.PasswordHistory
+	err := s.db.Where("user_id = ?", user.ID).
+		Order("created_at DESC").
+		Limit(s.passwordPolicy.HistorySize).
+		Find(&history).Error
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range history {
+		previous := database.User{PasswordHash: entry.PasswordHash}
+		if previous.CheckPassword(password) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// recordPasswordHistory stores the user's current password hash and prunes entries beyond the history size
+func (s *UserService) recordPasswordHistory(tx *gorm.DB, user *database.User) error {
+	if s.passwordPolicy.HistorySize <= 0 || user.PasswordHash == "" {
+		return nil
+	}
+
+	entry := &database.PasswordHistory{
+		UserID:       user.ID,
+		PasswordHash: user.PasswordHash,
+	}
+	if err := tx.Create(entry).Error; err != nil {
+		return err
+	}
+
+	// Keep only the most recent entries
+	var ids []uint
+	err := tx.Model(&database.PasswordHistory{}).
+		Where("user_id = ?", user.ID).
+		Order("created_at DESC, id DESC").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+	if len(ids) > s.passwordPolicy.HistorySize {
+		return tx.Delete(&database.PasswordHistory{}, ids[s.passwordPolicy.HistorySize:]).Error
+	}
+
+	return nil
 }
 
 // UpdateUserRole updates a user's role
-func (s *UserService) UpdateUserRole(userID uint, role database.UserRole) error {
+func (s *UserService) UpdateUserRole(actor AuditActor, userID uint, role database.UserRole) error {
 	user, err := s.GetUserByID(userID)
 	if err != nil {
 		return errors.New("user not found")
 	}
 
+	previousRole := user.Role
 	user.Role = role
-	return s.db.Save(user).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+
+		if err := recordAudit(tx, actor, auditEntry{
+			Action:     AuditActionUserRoleChanged,
+			TargetType: AuditTargetUser,
+			TargetID:   auditID(user.ID),
+			Metadata:   map[string]interface{}{"from": previousRole, "to": role},
+		}); err != nil {
+			return err
+		}
+
+		data := webhookUserData(user)
+		data["previous_role"] = previousRole
+		return emitWebhookEvent(tx, WebhookEventUserRoleChanged, data)
+	})
 }
 
 // GetUserOrgCount returns the number of organizations a user belongs to
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"fmt"
+	"miniauth/database"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Policies for deleting a user who is the only owner of an organization with other members.
+// Organizations without other members are always scheduled for deletion along with the user.
+const (
+	// SoleOwnerPolicyBlock rejects the deletion until ownership is transferred
+	SoleOwnerPolicyBlock = "block"
+	// SoleOwnerPolicyTransfer promotes the highest ranking, longest standing remaining member to owner
+	SoleOwnerPolicyTransfer = "transfer"
+	// SoleOwnerPolicyDeleteOrg schedules the organization for deletion
+	SoleOwnerPolicyDeleteOrg = "delete_org"
+)
+
+// SoleOwnerError is returned when a user cannot be deleted because they are the only owner of organizations with other members
+type SoleOwnerError struct {
+	Orgs []database.Org
+}
+
+func (e *SoleOwnerError) Error() string {
+	slugs := make([]string, 0, len(e.Orgs))
+	for _, org := range e.Orgs {
+		slugs = append(slugs, org.Slug)
+	}
+	return fmt.Sprintf("user is the only owner of organizations with other members: %s", strings.Join(slugs, ", "))
+}
+
+// resolveSoleOwnedOrgs applies the sole owner policy to every organization the user is the only owner of
+func (s *UserService) resolveSoleOwnedOrgs(tx *gorm.DB, userID uint) error {
+	var ownedOrgs []database.Org
+	err := tx.Model(&database.Org{}).
+		Joins("JOIN org_members ON orgs.id = org_members.org_id").
+		Where("org_members.user_id = ? AND org_members.role = ? AND org_members.deleted_at IS NULL", userID, database.OrgMemberRoleOwner).
+		Find(&ownedOrgs).Error
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var blocked []database.Org
+	for _, org := range ownedOrgs {
+		var others []database.OrgMember
+		if err := tx.Where("org_id = ? AND user_id <> ?", org.ID, userID).Find(&others).Error; err != nil {
+			return err
+		}
+
+		successor := soleOwnerSuccessor(others)
+		switch {
+		case len(others) == 0:
+			if err := scheduleOrgDeletion(tx, org.ID, now); err != nil {
+				return err
+			}
+		case successor.Role == database.OrgMemberRoleOwner:
+			// Another owner remains
+		case s.soleOwnerPolicy == SoleOwnerPolicyTransfer:
+			if err := tx.Model(&database.OrgMember{}).
+				Where("org_id = ? AND user_id = ?", org.ID, successor.UserID).
+				Update("role", database.OrgMemberRoleOwner).Error; err != nil {
+				return err
+			}
+		case s.soleOwnerPolicy == SoleOwnerPolicyDeleteOrg:
+			if err := scheduleOrgDeletion(tx, org.ID, now); err != nil {
+				return err
+			}
+		default:
+			blocked = append(blocked, org)
+		}
+	}
+
+	if len(blocked) > 0 {
+		return &SoleOwnerError{Orgs: blocked}
+	}
+	return nil
+}
+
+// soleOwnerSuccessor returns the highest ranking member, preferring the longest standing one
+func soleOwnerSuccessor(members []database.OrgMember) database.OrgMember {
+	if len(members) == 0 {
+		return database.OrgMember{}
+	}
+
+	sorted := append([]database.OrgMember(nil), members...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if orgRoleRank[sorted[i].Role] != orgRoleRank[sorted[j].Role] {
+			return orgRoleRank[sorted[i].Role] > orgRoleRank[sorted[j].Role]
+		}
+		return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+	})
+	return sorted[0]
+}
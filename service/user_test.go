@@ -0,0 +1,67 @@
+package service
+
+import (
+	"errors"
+	"miniauth/database"
+	"testing"
+)
+
+func TestAuthenticateUserUpgradesPasswordHash(t *testing.T) {
+	db := newTestDB(t)
+	users := NewUserService(db, &fakeMailer{}, &TokenSigner{secret: []byte("test secret")})
+
+	// Stored while bcrypt was configured
+	config := database.LoadPasswordHashConfig()
+	config.Algorithm = database.PasswordAlgorithmBcrypt
+	config.BcryptCost = 4
+	database.SetPasswordHashConfig(config)
+	t.Cleanup(func() { database.SetPasswordHashConfig(database.LoadPasswordHashConfig()) })
+
+	user := &database.User{Username: "alice", Email: "alice@example.com"}
+	if err := user.SetPassword("correct horse battery"); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	mustCreate(t, db, user)
+	bcryptHash := user.PasswordHash
+
+	config.Algorithm = database.PasswordAlgorithmArgon2id
+	config.Argon2Memory = 1024
+	config.Argon2Iterations = 1
+	database.SetPasswordHashConfig(config)
+
+	// Checking a password leaves the hash alone
+	if !user.CheckPassword("correct horse battery") || user.PasswordHash != bcryptHash {
+		t.Fatal("CheckPassword changed the stored hash")
+	}
+
+	storedHash := func() string {
+		var stored database.User
+		if err := db.First(&stored, user.ID).Error; err != nil {
+			t.Fatalf("load user: %v", err)
+		}
+		return stored.PasswordHash
+	}
+
+	if _, err := users.AuthenticateUser(AuditActor{}, "alice@example.com", "wrong password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("AuthenticateUser with a wrong password = %v, want ErrInvalidCredentials", err)
+	}
+	if storedHash() != bcryptHash {
+		t.Fatal("failed login upgraded the hash")
+	}
+
+	if _, err := users.AuthenticateUser(AuditActor{}, "alice@example.com", "correct horse battery"); err != nil {
+		t.Fatalf("AuthenticateUser: %v", err)
+	}
+	upgraded := storedHash()
+	if database.PasswordHashAlgorithm(upgraded) != database.PasswordAlgorithmArgon2id {
+		t.Fatalf("hash not upgraded to argon2id: %s", upgraded)
+	}
+
+	// The upgraded hash still verifies, and is not replaced again
+	if _, err := users.AuthenticateUser(AuditActor{}, "alice@example.com", "correct horse battery"); err != nil {
+		t.Fatalf("AuthenticateUser after upgrade: %v", err)
+	}
+	if storedHash() != upgraded {
+		t.Error("current hash was replaced")
+	}
+}
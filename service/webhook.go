@@ -0,0 +1,461 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"miniauth/database"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Webhook event types
+const (
+	WebhookEventUserCreated      = "user.created"
+	WebhookEventUserDeleted      = "user.deleted"
+	WebhookEventUserRoleChanged  = "user.role_changed"
+	WebhookEventOrgMemberAdded   = "org.member_added"
+	WebhookEventOrgMemberRemoved = "org.member_removed"
+	WebhookEventOAuthAppEnabled  = "oauth.app.enabled"
+	WebhookEventOAuthAppDisabled = "oauth.app.disabled"
+	WebhookEventTokenRevoked     = "token.revoked"
+
+	// webhookEventWildcard subscribes to all event types
+	webhookEventWildcard = "*"
+)
+
+const (
+	webhookSignatureHeader = "X-Miniauth-Signature"
+	webhookEventHeader     = "X-Miniauth-Event"
+	webhookDeliveryHeader  = "X-Miniauth-Delivery"
+
+	// webhookResponseBodyLimit is how much of the endpoint response is kept with an attempt
+	webhookResponseBodyLimit = 1024
+	webhookDispatchBatchSize = 100
+	webhookMaxRetryInterval  = time.Hour
+	// webhookDeliveryLease keeps other instances from sending a delivery that is being sent
+	webhookDeliveryLease = 5 * time.Minute
+)
+
+// WebhookEventTypes lists the events webhook subscriptions can receive
+var WebhookEventTypes = []string{
+	WebhookEventUserCreated,
+	WebhookEventUserDeleted,
+	WebhookEventUserRoleChanged,
+	WebhookEventOrgMemberAdded,
+	WebhookEventOrgMemberRemoved,
+	WebhookEventOAuthAppEnabled,
+	WebhookEventOAuthAppDisabled,
+	WebhookEventTokenRevoked,
+}
+
+var (
+	// ErrInvalidWebhookURL is returned when a webhook URL is not an absolute http(s) URL
+	ErrInvalidWebhookURL = errors.New("webhook URL must be an absolute http or https URL")
+	// ErrInvalidWebhookEvent is returned when a subscription names an unknown event type
+	ErrInvalidWebhookEvent = errors.New("unknown webhook event type")
+)
+
+// WebhookEvent is the JSON document posted to webhook endpoints
+type WebhookEvent struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	CreatedAt string                 `json:"created_at"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// WebhookSubscriptionRequest creates or updates a webhook subscription
+type WebhookSubscriptionRequest struct {
+	Name   string   `json:"name" validate:"required,max=100"`
+	URL    string   `json:"url" validate:"required,url"`
+	Events []string `json:"events" validate:"required,min=1"` // Event types, or ["*"] for all events
+	Active *bool    `json:"active"`
+}
+
+// emitWebhookEvent queues an event for every active subscription to its type. Deliveries are
+// created in the caller's transaction, so they are only sent when the change is committed.
+func emitWebhookEvent(tx *gorm.DB, eventType string, data map[string]interface{}) error {
+	var subscriptions []database.WebhookSubscription
+	if err := tx.Where("active = ?", true).Find(&subscriptions).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	event := WebhookEvent{
+		ID:        uuid.New().String(),
+		Type:      eventType,
+		CreatedAt: now.UTC().Format(time.RFC3339),
+		Data:      data,
+	}
+	var payload []byte
+	for _, subscription := range subscriptions {
+		if !webhookSubscribedTo(subscription, eventType) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(event); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Create(&database.WebhookDelivery{
+			SubscriptionID: subscription.ID,
+			EventID:        event.ID,
+			EventType:      eventType,
+			Payload:        string(payload),
+			Status:         database.WebhookDeliveryStatusPending,
+			NextAttemptAt:  &now,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookSubscribedTo reports whether a subscription receives an event type
+func webhookSubscribedTo(subscription database.WebhookSubscription, eventType string) bool {
+	events := strings.Fields(subscription.Events)
+	return slices.Contains(events, webhookEventWildcard) || slices.Contains(events, eventType)
+}
+
+// webhookUserData describes a user in webhook payloads
+func webhookUserData(user *database.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       user.ID,
+		"username": user.Username,
+		"email":    user.Email,
+		"role":     user.Role,
+	}
+}
+
+// SignWebhookPayload returns the hex encoded HMAC-SHA256 of "<timestamp>.<payload>" keyed by the
+// subscription secret. Deliveries carry it as "X-Miniauth-Signature: t=<timestamp>,v1=<signature>".
+func SignWebhookPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type WebhookService struct {
+	db          *gorm.DB
+	client      *http.Client
+	maxAttempts int
+	retryDelay  time.Duration
+}
+
+func NewWebhookService(db *gorm.DB) *WebhookService {
+	return &WebhookService{
+		db:          db,
+		client:      &http.Client{Timeout: getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second)},
+		maxAttempts: getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		retryDelay:  getEnvDuration("WEBHOOK_RETRY_DELAY", 30*time.Second),
+	}
+}
+
+// ListSubscriptions returns all webhook subscriptions
+func (s *WebhookService) ListSubscriptions() ([]database.WebhookSubscription, error) {
+	var subscriptions []database.WebhookSubscription
+	err := s.db.Order("id").Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+// GetSubscription retrieves a webhook subscription by ID
+func (s *WebhookService) GetSubscription(id uint) (*database.WebhookSubscription, error) {
+	var subscription database.WebhookSubscription
+	if err := s.db.First(&subscription, id).Error; err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// CreateSubscription creates a webhook subscription with a generated signing secret
+func (s *WebhookService) CreateSubscription(createdByID uint, req WebhookSubscriptionRequest) (*database.WebhookSubscription, error) {
+	events, err := normalizeWebhookRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	active := req.Active == nil || *req.Active
+	subscription := &database.WebhookSubscription{
+		Name:        req.Name,
+		URL:         req.URL,
+		Secret:      "whsec_" + generateSecureToken(),
+		Events:      events,
+		Active:      active,
+		CreatedByID: createdByID,
+	}
+	if err := s.db.Create(subscription).Error; err != nil {
+		return nil, err
+	}
+	// Create replaces the false zero value with the column default
+	if !active {
+		if err := s.db.Model(subscription).Update("active", false).Error; err != nil {
+			return nil, err
+		}
+	}
+	return subscription, nil
+}
+
+// UpdateSubscription updates the name, URL, events and state of a webhook subscription
+func (s *WebhookService) UpdateSubscription(id uint, req WebhookSubscriptionRequest) (*database.WebhookSubscription, error) {
+	events, err := normalizeWebhookRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription, err := s.GetSubscription(id)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription.Name = req.Name
+	subscription.URL = req.URL
+	subscription.Events = events
+	if req.Active != nil {
+		subscription.Active = *req.Active
+	}
+	if err := s.db.Save(subscription).Error; err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+// RotateSubscriptionSecret replaces the signing secret of a webhook subscription
+func (s *WebhookService) RotateSubscriptionSecret(id uint) (*database.WebhookSubscription, error) {
+	subscription, err := s.GetSubscription(id)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription.Secret = "whsec_" + generateSecureToken()
+	if err := s.db.Model(subscription).Update("secret", subscription.Secret).Error; err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+// DeleteSubscription deletes a webhook subscription with its deliveries and their attempts
+func (s *WebhookService) DeleteSubscription(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&database.WebhookSubscription{}, id).Error; err != nil {
+			return err
+		}
+
+		deliveries := tx.Model(&database.WebhookDelivery{}).Select("id").Where("subscription_id = ?", id)
+		if err := tx.Where("delivery_id IN (?)", deliveries).Delete(&database.WebhookDeliveryAttempt{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("subscription_id = ?", id).Delete(&database.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&database.WebhookSubscription{}, id).Error
+	})
+}
+
+// ListDeliveries returns the deliveries of a subscription, newest first, optionally filtered by status
+func (s *WebhookService) ListDeliveries(subscriptionID uint, status database.WebhookDeliveryStatus, offset, limit int) ([]database.WebhookDelivery, int64, error) {
+	query := s.db.Model(&database.WebhookDelivery{}).Where("subscription_id = ?", subscriptionID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var deliveries []database.WebhookDelivery
+	err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&deliveries).Error
+	return deliveries, total, err
+}
+
+// GetDelivery retrieves a delivery of a subscription together with its attempts, oldest first
+func (s *WebhookService) GetDelivery(subscriptionID, deliveryID uint) (*database.WebhookDelivery, []database.WebhookDeliveryAttempt, error) {
+	var delivery database.WebhookDelivery
+	if err := s.db.Where("id = ? AND subscription_id = ?", deliveryID, subscriptionID).First(&delivery).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var attempts []database.WebhookDeliveryAttempt
+	if err := s.db.Where("delivery_id = ?", delivery.ID).Order("id").Find(&attempts).Error; err != nil {
+		return nil, nil, err
+	}
+	return &delivery, attempts, nil
+}
+
+// Redeliver queues a delivery again with a fresh retry budget. Earlier attempts are kept.
+func (s *WebhookService) Redeliver(subscriptionID, deliveryID uint) (*database.WebhookDelivery, error) {
+	delivery, _, err := s.GetDelivery(subscriptionID, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := s.db.Model(delivery).Updates(map[string]interface{}{
+		"status":          database.WebhookDeliveryStatusPending,
+		"attempts":        0,
+		"next_attempt_at": now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// StartDispatcher periodically sends the deliveries that are due
+func (s *WebhookService) StartDispatcher(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := s.DispatchDue(); err != nil {
+				log.Printf("failed to dispatch webhook deliveries: %v", err)
+			}
+		}
+	}()
+}
+
+// DispatchDue sends the pending deliveries of active subscriptions whose next attempt is due
+func (s *WebhookService) DispatchDue() error {
+	now := time.Now()
+	var deliveries []database.WebhookDelivery
+	if err := s.db.Model(&database.WebhookDelivery{}).
+		Joins("JOIN webhook_subscriptions ON webhook_subscriptions.id = webhook_deliveries.subscription_id").
+		Where("webhook_deliveries.status = ? AND webhook_deliveries.next_attempt_at <= ? AND webhook_subscriptions.active = ?",
+			database.WebhookDeliveryStatusPending, now, true).
+		Order("webhook_deliveries.id").Limit(webhookDispatchBatchSize).
+		Find(&deliveries).Error; err != nil {
+		return err
+	}
+
+	for i := range deliveries {
+		delivery := &deliveries[i]
+
+		// Claim the delivery so that other instances skip it while it is being sent
+		lease := now.Add(webhookDeliveryLease)
+		result := s.db.Model(&database.WebhookDelivery{}).
+			Where("id = ? AND status = ? AND next_attempt_at = ?", delivery.ID, database.WebhookDeliveryStatusPending, delivery.NextAttemptAt).
+			Update("next_attempt_at", lease)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		if err := s.deliver(delivery); err != nil {
+			log.Printf("failed to record webhook delivery %d: %v", delivery.ID, err)
+		}
+	}
+	return nil
+}
+
+// deliver makes one attempt at a delivery and schedules the next one on failure
+func (s *WebhookService) deliver(delivery *database.WebhookDelivery) error {
+	subscription, err := s.GetSubscription(delivery.SubscriptionID)
+	if err != nil {
+		return err
+	}
+
+	attempt := s.send(subscription, delivery)
+	if err := s.db.Create(attempt).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"attempts": delivery.Attempts + 1}
+	switch {
+	case attempt.Error == "":
+		updates["status"] = database.WebhookDeliveryStatusSucceeded
+		updates["delivered_at"] = now
+		updates["next_attempt_at"] = nil
+	case delivery.Attempts+1 >= s.maxAttempts:
+		updates["status"] = database.WebhookDeliveryStatusFailed
+		updates["next_attempt_at"] = nil
+	default:
+		updates["next_attempt_at"] = now.Add(s.retryBackoff(delivery.Attempts + 1))
+	}
+	return s.db.Model(delivery).Updates(updates).Error
+}
+
+// retryBackoff returns the delay before the next attempt: the retry delay doubled for every
+// failed attempt, up to an hour
+func (s *WebhookService) retryBackoff(attempts int) time.Duration {
+	delay := s.retryDelay
+	for i := 1; i < attempts && delay < webhookMaxRetryInterval; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookMaxRetryInterval)
+}
+
+// send posts the signed payload and describes the outcome; any non-2xx response is a failure
+func (s *WebhookService) send(subscription *database.WebhookSubscription, delivery *database.WebhookDelivery) *database.WebhookDeliveryAttempt {
+	attempt := &database.WebhookDeliveryAttempt{DeliveryID: delivery.ID}
+	payload := []byte(delivery.Payload)
+
+	req, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "miniauth-webhooks")
+	req.Header.Set(webhookEventHeader, delivery.EventType)
+	req.Header.Set(webhookDeliveryHeader, auditID(delivery.ID))
+	req.Header.Set(webhookSignatureHeader, fmt.Sprintf("t=%d,v1=%s", timestamp, SignWebhookPayload(subscription.Secret, timestamp, payload)))
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	attempt.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseBodyLimit))
+	attempt.StatusCode = resp.StatusCode
+	attempt.ResponseBody = string(body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return attempt
+}
+
+// normalizeWebhookRequest validates the URL and events of a subscription request and returns
+// the events in storage form
+func normalizeWebhookRequest(req WebhookSubscriptionRequest) (string, error) {
+	parsed, err := url.Parse(req.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", ErrInvalidWebhookURL
+	}
+
+	var events []string
+	for _, event := range req.Events {
+		if event != webhookEventWildcard && !slices.Contains(WebhookEventTypes, event) {
+			return "", fmt.Errorf("%w: %s", ErrInvalidWebhookEvent, event)
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		return "", ErrInvalidWebhookEvent
+	}
+	return strings.Join(events, " "), nil
+}
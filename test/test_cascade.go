@@ -29,7 +29,7 @@ func main() {
 	}
 
 	// Auto migrate the schema
-	err = db.AutoMigrate(&database.User{}, &database.Org{}, &database.OrgMember{})
+	err = db.AutoMigrate(&database.User{}, &database.Org{}, &database.OrgMember{}, &database.OrgInvitation{})
 	if err != nil {
 		log.Fatal("Failed to migrate:", err)
 	}
@@ -185,7 +185,7 @@ func main() {
 	}
 
 	// Create org service
-	orgService := service.NewOrgService(db)
+	orgService := service.NewOrgService(db, service.NewLogMailer(os.Stdout, "noreply@localhost"))
 
 	// Check memberships before service deletion
 	var serviceOrgCountBefore int64
@@ -6,6 +6,8 @@ import { AuthProvider } from '@/contexts/AuthProvider'
 import { ProtectedRoute } from '@/components/ProtectedRoute'
 import { MainLayout } from '@/components/layout/MainLayout'
 import { LoginPage } from '@/pages/LoginPage'
+import { ResetPasswordPage } from '@/pages/ResetPasswordPage'
+import { InvitationPage } from '@/pages/InvitationPage'
 import { ProfilePage } from '@/pages/ProfilePage'
 import UsersPage from '@/pages/admin/Users'
 import OAuthApplications from '@/pages/OAuthApplications'
@@ -31,6 +33,8 @@ function App() {
             <Routes>
               {/* Public routes */}
               <Route path="/login" element={<LoginPage />} />
+              <Route path="/reset-password" element={<ResetPasswordPage />} />
+              <Route path="/invitations" element={<InvitationPage />} />
               <Route path="/oauth/authorize" element={<OAuthAuthorization />} />
               
               {/* Protected routes with unified layout */}
@@ -0,0 +1,25 @@
+import React from 'react'
+import { LanguageToggle } from '@/components/language-toggle'
+import { ThemeToggle } from '@/components/theme-toggle'
+import { Shield } from 'lucide-react'
+
+// Layout of pages opened from links in emails, which work without signing in
+export const PublicLayout: React.FC<{ children: React.ReactNode }> = ({ children }) => (
+  <div className="min-h-screen flex flex-col bg-background">
+    <header className="flex h-16 items-center justify-between border-b bg-background px-6 shadow-sm flex-shrink-0 w-full">
+      <div className="flex items-center space-x-2">
+        <Shield className="h-6 w-6 text-primary" />
+        <span className="text-lg font-semibold text-foreground">MiniAuth</span>
+      </div>
+
+      <div className="flex items-center space-x-4">
+        <ThemeToggle />
+        <LanguageToggle />
+      </div>
+    </header>
+
+    <div className="flex-1 flex items-center justify-center px-4 py-8">
+      {children}
+    </div>
+  </div>
+)
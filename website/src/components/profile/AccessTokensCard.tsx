@@ -0,0 +1,205 @@
+import React, { useCallback, useEffect, useState } from 'react'
+import { useTranslation } from 'react-i18next'
+import { Button } from '@/components/ui/button'
+import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '@/components/ui/card'
+import { Alert, AlertDescription } from '@/components/ui/alert'
+import { Badge } from '@/components/ui/badge'
+import { Input } from '@/components/ui/input'
+import { Label } from '@/components/ui/label'
+import { AlertCircle, Copy, KeyRound } from 'lucide-react'
+import { useAuth } from '@/hooks/useAuth'
+
+interface AccessToken {
+  id: number
+  name: string
+  prefix: string
+  scopes: string[]
+  expires_at?: string
+  expired: boolean
+  last_used_at?: string
+  last_used_ip?: string
+  created_at: string
+}
+
+const expiryOptions = ['30', '90', '365', '']
+
+export const AccessTokensCard: React.FC = () => {
+  const { user } = useAuth()
+  const [tokens, setTokens] = useState<AccessToken[]>([])
+  const [availableScopes, setAvailableScopes] = useState<string[]>([])
+  const [name, setName] = useState('')
+  const [scopes, setScopes] = useState<string[]>(['read'])
+  const [expiresInDays, setExpiresInDays] = useState('90')
+  const [newToken, setNewToken] = useState('')
+  const [error, setError] = useState('')
+  const { t } = useTranslation()
+
+  const loadTokens = useCallback(async () => {
+    try {
+      const response = await fetch('/api/me/tokens', { credentials: 'include' })
+      if (response.ok) {
+        const data = await response.json()
+        setTokens(data.tokens || [])
+        setAvailableScopes(data.scopes || [])
+      }
+    } catch {
+      setTokens([])
+    }
+  }, [])
+
+  useEffect(() => {
+    loadTokens()
+  }, [loadTokens])
+
+  const toggleScope = (scope: string) => {
+    setScopes((prev) => (prev.includes(scope) ? prev.filter((s) => s !== scope) : [...prev, scope]))
+  }
+
+  const handleCreate = async (e: React.FormEvent) => {
+    e.preventDefault()
+    setError('')
+    setNewToken('')
+    const response = await fetch('/api/me/tokens', {
+      method: 'POST',
+      credentials: 'include',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({
+        name,
+        scopes,
+        expires_in_days: expiresInDays ? Number(expiresInDays) : undefined,
+      }),
+    })
+    const data = await response.json().catch(() => ({}))
+    if (!response.ok) {
+      setError(data.error || data.message || t('profile.createTokenFailed'))
+      return
+    }
+    setNewToken(data.token)
+    setName('')
+    loadTokens()
+  }
+
+  const handleRevoke = async (token: AccessToken) => {
+    setError('')
+    const response = await fetch(`/api/me/tokens/${token.id}`, {
+      method: 'DELETE',
+      credentials: 'include',
+    })
+    if (!response.ok) {
+      const data = await response.json().catch(() => ({}))
+      setError(data.error || t('profile.revokeTokenFailed'))
+      return
+    }
+    loadTokens()
+  }
+
+  const grantableScopes = availableScopes.filter((scope) => scope !== 'admin' || user?.role === 'admin')
+
+  return (
+    <Card>
+      <CardHeader>
+        <CardTitle className="flex items-center">
+          <KeyRound className="w-5 h-5 mr-2" />
+          {t('profile.accessTokens')}
+        </CardTitle>
+        <CardDescription>
+          {t('profile.accessTokensDesc')}
+        </CardDescription>
+      </CardHeader>
+      <CardContent className="space-y-4">
+        {error && (
+          <Alert variant="destructive">
+            <AlertCircle className="h-4 w-4" />
+            <AlertDescription>{error}</AlertDescription>
+          </Alert>
+        )}
+
+        {newToken && (
+          <Alert>
+            <AlertDescription className="space-y-2">
+              <div>{t('profile.tokenCreated')}</div>
+              <div className="flex items-center gap-2">
+                <code className="flex-1 break-all rounded bg-muted px-2 py-1 text-sm">{newToken}</code>
+                <Button type="button" variant="outline" size="sm" onClick={() => navigator.clipboard.writeText(newToken)}>
+                  <Copy className="w-4 h-4" />
+                </Button>
+              </div>
+            </AlertDescription>
+          </Alert>
+        )}
+
+        {tokens.map((token) => (
+          <div key={token.id} className="flex items-center justify-between p-3 border rounded-lg">
+            <div className="space-y-1">
+              <div className="flex items-center gap-2">
+                <span className="font-medium">{token.name}</span>
+                <code className="text-xs text-muted-foreground">{token.prefix}…</code>
+                {token.scopes.map((scope) => (
+                  <Badge key={scope} variant="secondary">{scope}</Badge>
+                ))}
+                {token.expired && <Badge variant="destructive">{t('profile.tokenExpired')}</Badge>}
+              </div>
+              <div className="text-sm text-muted-foreground">
+                {token.expires_at ? t('profile.tokenExpires', { time: token.expires_at }) : t('profile.tokenNoExpiry')}
+                {' • '}
+                {token.last_used_at
+                  ? t('profile.tokenLastUsed', { time: token.last_used_at, ip: token.last_used_ip })
+                  : t('profile.tokenNeverUsed')}
+              </div>
+            </div>
+            <Button variant="outline" size="sm" onClick={() => handleRevoke(token)}>
+              {t('profile.revokeToken')}
+            </Button>
+          </div>
+        ))}
+
+        <form onSubmit={handleCreate} className="space-y-3 border-t pt-4">
+          <div className="space-y-2">
+            <Label htmlFor="token-name">{t('profile.tokenName')}</Label>
+            <Input
+              id="token-name"
+              value={name}
+              onChange={(e) => setName(e.target.value)}
+              placeholder={t('profile.tokenNamePlaceholder')}
+              required
+            />
+          </div>
+          <div className="flex flex-wrap gap-4">
+            {grantableScopes.map((scope) => (
+              <div key={scope} className="flex items-center space-x-2">
+                <input
+                  type="checkbox"
+                  id={`token-scope-${scope}`}
+                  checked={scopes.includes(scope)}
+                  onChange={() => toggleScope(scope)}
+                  className="rounded border-gray-300"
+                />
+                <Label htmlFor={`token-scope-${scope}`} className="text-sm">
+                  {t(`profile.tokenScopes.${scope}`, { defaultValue: scope })}
+                </Label>
+              </div>
+            ))}
+          </div>
+          <div className="space-y-2">
+            <Label htmlFor="token-expiry">{t('profile.tokenExpiry')}</Label>
+            <select
+              id="token-expiry"
+              value={expiresInDays}
+              onChange={(e) => setExpiresInDays(e.target.value)}
+              className="flex h-10 w-full rounded-md border border-input bg-background px-3 py-2 text-sm ring-offset-background focus-visible:outline-none focus-visible:ring-2 focus-visible:ring-ring focus-visible:ring-offset-2"
+            >
+              {expiryOptions.map((days) => (
+                <option key={days} value={days}>
+                  {days ? t('profile.tokenExpiryDays', { count: Number(days) }) : t('profile.tokenNoExpiry')}
+                </option>
+              ))}
+            </select>
+          </div>
+          <Button type="submit" disabled={!name || scopes.length === 0}>
+            {t('profile.createToken')}
+          </Button>
+        </form>
+      </CardContent>
+    </Card>
+  )
+}
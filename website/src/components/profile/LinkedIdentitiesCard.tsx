@@ -0,0 +1,130 @@
+import React, { useCallback, useEffect, useState } from 'react'
+import { useSearchParams } from 'react-router-dom'
+import { useTranslation } from 'react-i18next'
+import { Button } from '@/components/ui/button'
+import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '@/components/ui/card'
+import { Alert, AlertDescription } from '@/components/ui/alert'
+import { AlertCircle, Link2 } from 'lucide-react'
+
+interface LinkedIdentity {
+  id: number
+  provider_slug: string
+  provider_name: string
+  email?: string
+  created_at: string
+  last_login_at?: string
+}
+
+interface LoginProvider {
+  slug: string
+  name: string
+}
+
+export const LinkedIdentitiesCard: React.FC = () => {
+  const [identities, setIdentities] = useState<LinkedIdentity[]>([])
+  const [providers, setProviders] = useState<LoginProvider[]>([])
+  const [error, setError] = useState('')
+  const [searchParams] = useSearchParams()
+  const { t } = useTranslation()
+
+  const loadIdentities = useCallback(async () => {
+    try {
+      const response = await fetch('/api/me/identities', { credentials: 'include' })
+      if (response.ok) {
+        const data = await response.json()
+        setIdentities(data.identities || [])
+      }
+    } catch {
+      setIdentities([])
+    }
+  }, [])
+
+  useEffect(() => {
+    loadIdentities()
+    fetch('/api/auth/providers', { credentials: 'include' })
+      .then((response) => (response.ok ? response.json() : { providers: [] }))
+      .then((data) => setProviders(data.providers || []))
+      .catch(() => setProviders([]))
+  }, [loadIdentities])
+
+  // Show why linking an identity failed
+  useEffect(() => {
+    const federatedError = searchParams.get('federated_error')
+    if (federatedError) {
+      setError(t(`auth.federatedErrors.${federatedError}`, { defaultValue: t('auth.federatedErrors.provider_error') }))
+    }
+  }, [searchParams, t])
+
+  const handleUnlink = async (identity: LinkedIdentity) => {
+    setError('')
+    const response = await fetch(`/api/me/identities/${identity.id}`, {
+      method: 'DELETE',
+      credentials: 'include',
+    })
+    if (!response.ok) {
+      const data = await response.json().catch(() => ({}))
+      setError(data.error || t('profile.unlinkFailed'))
+      return
+    }
+    loadIdentities()
+  }
+
+  const linkedSlugs = new Set(identities.map((identity) => identity.provider_slug))
+  const unlinkedProviders = providers.filter((provider) => !linkedSlugs.has(provider.slug))
+
+  if (identities.length === 0 && providers.length === 0) {
+    return null
+  }
+
+  return (
+    <Card>
+      <CardHeader>
+        <CardTitle className="flex items-center">
+          <Link2 className="w-5 h-5 mr-2" />
+          {t('profile.linkedAccounts')}
+        </CardTitle>
+        <CardDescription>
+          {t('profile.linkedAccountsDesc')}
+        </CardDescription>
+      </CardHeader>
+      <CardContent className="space-y-4">
+        {error && (
+          <Alert variant="destructive">
+            <AlertCircle className="h-4 w-4" />
+            <AlertDescription>{error}</AlertDescription>
+          </Alert>
+        )}
+
+        {identities.map((identity) => (
+          <div key={identity.id} className="flex items-center justify-between p-3 border rounded-lg">
+            <div>
+              <div className="font-medium">{identity.provider_name || identity.provider_slug}</div>
+              {identity.email && (
+                <div className="text-sm text-muted-foreground">{identity.email}</div>
+              )}
+            </div>
+            <Button variant="outline" size="sm" onClick={() => handleUnlink(identity)}>
+              {t('profile.unlink')}
+            </Button>
+          </div>
+        ))}
+
+        {unlinkedProviders.length > 0 && (
+          <div className="flex flex-wrap gap-2">
+            {unlinkedProviders.map((provider) => (
+              <Button
+                key={provider.slug}
+                variant="outline"
+                onClick={() => {
+                  window.location.href = `/api/me/identities/${provider.slug}/link?redirect=${encodeURIComponent('/profile')}`
+                }}
+              >
+                {t('profile.linkProvider', { name: provider.name })}
+              </Button>
+            ))}
+          </div>
+        )}
+      </CardContent>
+    </Card>
+  )
+}
@@ -0,0 +1,156 @@
+import React, { useEffect, useState } from 'react'
+import { Link, useSearchParams } from 'react-router-dom'
+import { useTranslation } from 'react-i18next'
+import { useAuth } from '@/hooks/useAuth'
+import { useDocumentTitle } from '@/hooks/useDocumentTitle'
+import { Button } from '@/components/ui/button'
+import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '@/components/ui/card'
+import { Alert, AlertDescription } from '@/components/ui/alert'
+import { PublicLayout } from '@/components/layout/PublicLayout'
+import { Loader2, AlertCircle, CheckCircle } from 'lucide-react'
+
+interface Invitation {
+  org_name: string
+  org_slug: string
+  email: string
+  role: string
+  expires_at: string
+}
+
+export const InvitationPage: React.FC = () => {
+  const [searchParams] = useSearchParams()
+  const token = searchParams.get('token') || ''
+  const [invitation, setInvitation] = useState<Invitation | null>(null)
+  const [loadingInvitation, setLoadingInvitation] = useState(true)
+  const [error, setError] = useState('')
+  const [result, setResult] = useState<'accepted' | 'declined' | null>(null)
+  const [submitting, setSubmitting] = useState(false)
+  const { user, isAuthenticated } = useAuth()
+  const { t } = useTranslation()
+
+  useDocumentTitle(t('invitation.title'))
+
+  useEffect(() => {
+    if (!token) {
+      setLoadingInvitation(false)
+      return
+    }
+    fetch(`/api/invitations?token=${encodeURIComponent(token)}`)
+      .then(async (response) => {
+        if (!response.ok) {
+          setError(t('invitation.invalid'))
+          return
+        }
+        setInvitation(await response.json())
+      })
+      .catch(() => setError(t('invitation.invalid')))
+      .finally(() => setLoadingInvitation(false))
+  }, [token, t])
+
+  const respond = async (action: 'accept' | 'decline') => {
+    setError('')
+    setSubmitting(true)
+    try {
+      const response = await fetch(`/api/invitations/${action}`, {
+        method: 'POST',
+        credentials: 'include',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token }),
+      })
+      const data = await response.json().catch(() => ({}))
+      if (!response.ok) {
+        setError(data.error || data.message || t('invitation.failed'))
+        return
+      }
+      setResult(action === 'accept' ? 'accepted' : 'declined')
+    } catch {
+      setError(t('invitation.failed'))
+    } finally {
+      setSubmitting(false)
+    }
+  }
+
+  const loginPath = `/login?redirect=${encodeURIComponent(`/invitations?token=${encodeURIComponent(token)}`)}`
+
+  return (
+    <PublicLayout>
+      <Card className="w-full max-w-md">
+        <CardHeader className="space-y-1">
+          <CardTitle className="text-2xl font-bold text-center">{t('invitation.title')}</CardTitle>
+          {invitation && !result && (
+            <CardDescription className="text-center">
+              {t('invitation.description', { org: invitation.org_name, role: t(`roles.${invitation.role}`, { defaultValue: invitation.role }) })}
+            </CardDescription>
+          )}
+        </CardHeader>
+        <CardContent className="space-y-4">
+          {loadingInvitation && (
+            <div className="flex justify-center">
+              <Loader2 className="h-6 w-6 animate-spin" />
+            </div>
+          )}
+
+          {!loadingInvitation && !token && (
+            <Alert variant="destructive">
+              <AlertCircle className="h-4 w-4" />
+              <AlertDescription>{t('invitation.missingToken')}</AlertDescription>
+            </Alert>
+          )}
+
+          {error && (
+            <Alert variant="destructive">
+              <AlertCircle className="h-4 w-4" />
+              <AlertDescription>{error}</AlertDescription>
+            </Alert>
+          )}
+
+          {result && (
+            <>
+              <Alert className="border-green-500 text-green-700 bg-green-50 dark:bg-green-950 dark:text-green-300">
+                <CheckCircle className="h-4 w-4" />
+                <AlertDescription>
+                  {result === 'accepted'
+                    ? t('invitation.accepted', { org: invitation?.org_name })
+                    : t('invitation.declined')}
+                </AlertDescription>
+              </Alert>
+              {result === 'accepted' && (
+                <Button asChild className="w-full">
+                  <Link to="/profile">{t('common.profile')}</Link>
+                </Button>
+              )}
+            </>
+          )}
+
+          {invitation && !result && (
+            <>
+              <div className="text-sm text-muted-foreground text-center">
+                {t('invitation.sentTo', { email: invitation.email, time: invitation.expires_at })}
+              </div>
+              {isAuthenticated && user?.email.toLowerCase() !== invitation.email.toLowerCase() && (
+                <Alert>
+                  <AlertCircle className="h-4 w-4" />
+                  <AlertDescription>{t('invitation.otherAccount', { email: user?.email })}</AlertDescription>
+                </Alert>
+              )}
+              <div className="flex gap-2">
+                {isAuthenticated ? (
+                  <Button className="flex-1" onClick={() => respond('accept')} disabled={submitting}>
+                    {t('invitation.accept')}
+                  </Button>
+                ) : (
+                  <Button asChild className="flex-1">
+                    <Link to={loginPath}>{t('invitation.signInToAccept')}</Link>
+                  </Button>
+                )}
+                <Button variant="outline" className="flex-1" onClick={() => respond('decline')} disabled={submitting}>
+                  {t('invitation.decline')}
+                </Button>
+              </div>
+            </>
+          )}
+        </CardContent>
+      </Card>
+    </PublicLayout>
+  )
+}
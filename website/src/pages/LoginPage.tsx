@@ -1,4 +1,4 @@
-import React, { useState } from 'react'
+import React, { useEffect, useState } from 'react'
 import { useNavigate, useSearchParams } from 'react-router-dom'
 import { useTranslation } from 'react-i18next'
 import { useAuth } from '@/hooks/useAuth'
@@ -11,6 +11,12 @@ import { LanguageToggle } from '@/components/language-toggle'
 import { ThemeToggle } from '@/components/theme-toggle'
 import { Loader2, AlertCircle, Shield } from 'lucide-react'
 
+interface LoginProvider {
+  slug: string
+  name: string
+  login_url: string
+}
+
 export const LoginPage: React.FC = () => {
   const [email, setEmail] = useState('')
   const [password, setPassword] = useState('')
@@ -19,6 +25,7 @@ export const LoginPage: React.FC = () => {
   const [error, setError] = useState('')
   const [success, setSuccess] = useState('')
   const [isSignUp, setIsSignUp] = useState(false)
+  const [providers, setProviders] = useState<LoginProvider[]>([])
   const { login, register, loading } = useAuth()
   const navigate = useNavigate()
   const [searchParams] = useSearchParams()
@@ -27,6 +34,12 @@ export const LoginPage: React.FC = () => {
   // Set document title based on login/signup mode
   useDocumentTitle(isSignUp ? t('auth.signUp') : t('auth.signIn'))
 
+  // Path to return to after signing in, e.g. to resume a SAML sign in; only paths on this site are followed
+  const redirectParam = searchParams.get('redirect')
+  const redirectPath = redirectParam && redirectParam.startsWith('/') && !redirectParam.startsWith('//') && !redirectParam.startsWith('/\\')
+    ? redirectParam
+    : null
+
   // Check if this is an OAuth redirect
   const isOAuthRedirect = searchParams.get('oauth_redirect') === 'true'
   
@@ -39,8 +52,24 @@ export const LoginPage: React.FC = () => {
     response_type: searchParams.get('response_type'),
     code_challenge: searchParams.get('code_challenge'),
     code_challenge_method: searchParams.get('code_challenge_method'),
+    organization: searchParams.get('organization'),
   }
 
+  useEffect(() => {
+    fetch('/api/auth/providers', { credentials: 'include' })
+      .then((response) => (response.ok ? response.json() : { providers: [] }))
+      .then((data) => setProviders(data.providers || []))
+      .catch(() => setProviders([]))
+  }, [])
+
+  // Show why a sign in with an identity provider failed
+  useEffect(() => {
+    const federatedError = searchParams.get('federated_error')
+    if (federatedError) {
+      setError(t(`auth.federatedErrors.${federatedError}`, { defaultValue: t('auth.federatedErrors.provider_error') }))
+    }
+  }, [searchParams, t])
+
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault()
     setError('')
@@ -76,7 +105,10 @@ export const LoginPage: React.FC = () => {
     }
   }
 
-  const handlePostLoginRedirect = () => {
+  const postLoginPath = () => {
+    if (redirectPath) {
+      return redirectPath
+    }
     if (isOAuthRedirect && oauthParams.client_id) {
       // Build OAuth authorization URL with preserved parameters
       const params = new URLSearchParams()
@@ -85,10 +117,23 @@ export const LoginPage: React.FC = () => {
           params.set(key, value)
         }
       })
-      navigate(`/oauth/authorize?${params.toString()}`)
-    } else {
-      navigate('/profile')
+      return `/oauth/authorize?${params.toString()}`
     }
+    return '/profile'
+  }
+
+  const handlePostLoginRedirect = () => {
+    if (redirectPath?.startsWith('/api/')) {
+      // Server endpoints are outside the client-side router
+      window.location.href = redirectPath
+      return
+    }
+    navigate(postLoginPath())
+  }
+
+  const handleProviderLogin = (provider: LoginProvider) => {
+    // The provider redirects back to the server, which returns the user to this path once signed in
+    window.location.href = `${provider.login_url}?redirect=${encodeURIComponent(postLoginPath())}`
   }
 
   const toggleMode = () => {
@@ -254,6 +299,33 @@ export const LoginPage: React.FC = () => {
                 </Button>
               </div>
             </form>
+
+            {!isSignUp && providers.length > 0 && (
+              <div className="mt-6 space-y-4">
+                <div className="relative">
+                  <div className="absolute inset-0 flex items-center">
+                    <span className="w-full border-t" />
+                  </div>
+                  <div className="relative flex justify-center text-xs uppercase">
+                    <span className="bg-card px-2 text-muted-foreground">
+                      {t('auth.orContinueWith')}
+                    </span>
+                  </div>
+                </div>
+                {providers.map((provider) => (
+                  <Button
+                    key={provider.slug}
+                    type="button"
+                    variant="outline"
+                    className="w-full"
+                    onClick={() => handleProviderLogin(provider)}
+                    disabled={loading}
+                  >
+                    {t('auth.signInWith', { name: provider.name })}
+                  </Button>
+                ))}
+              </div>
+            )}
           </CardContent>
         </Card>
       </div>
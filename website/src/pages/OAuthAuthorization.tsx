@@ -16,6 +16,13 @@ interface AuthorizationData {
   response_type: string;
   code_challenge?: string;
   code_challenge_method?: string;
+  organization: string;
+  organizations: {
+    id: number;
+    name: string;
+    slug: string;
+    role: string;
+  }[];
   user: {
     id: number;
     username: string;
@@ -30,6 +37,7 @@ export default function OAuthAuthorization() {
   const [authData, setAuthData] = useState<AuthorizationData | null>(null);
   const [loading, setLoading] = useState(true);
   const [processing, setProcessing] = useState(false);
+  const [organization, setOrganization] = useState('');
 
   const scopeDescriptions = {
     read: t('oauth.authorization.scopeDescriptions.read'),
@@ -58,6 +66,11 @@ export default function OAuthAuthorization() {
         params.append('code_challenge_method', codeChallengeMethod);
       }
 
+      const requestedOrganization = searchParams.get('organization');
+      if (requestedOrganization) {
+        params.append('organization', requestedOrganization);
+      }
+
       try {
         const response = await fetch(`/api/oauth/authorize?${params.toString()}`, {
           credentials: 'include'
@@ -66,6 +79,7 @@ export default function OAuthAuthorization() {
         if (response.ok) {
           const data = await response.json();
           setAuthData(data);
+          setOrganization(data.organization || '');
         } else if (response.status === 302) {
           // Handle redirect (trusted app or login required)
           const location = response.headers.get('Location');
@@ -108,6 +122,7 @@ export default function OAuthAuthorization() {
           state: authData.state,
           code_challenge: authData.code_challenge,
           code_challenge_method: authData.code_challenge_method,
+          organization,
         }),
       });
 
@@ -190,6 +205,32 @@ export default function OAuthAuthorization() {
             </div>
           </div>
 
+          {/* Active Organization */}
+          {authData.organizations.length > 0 && (
+            <div>
+              <Label htmlFor="organization" className="text-sm font-medium">
+                {t('oauth.authorization.organization')}
+              </Label>
+              <select
+                id="organization"
+                value={organization}
+                onChange={(e) => setOrganization(e.target.value)}
+                disabled={processing}
+                className="mt-2 border-input flex h-9 w-full rounded-md border bg-transparent px-3 py-1 text-sm shadow-xs outline-none focus-visible:border-ring focus-visible:ring-ring/50 focus-visible:ring-[3px]"
+              >
+                <option value="">{t('oauth.authorization.noOrganization')}</option>
+                {authData.organizations.map((org) => (
+                  <option key={org.id} value={org.slug}>
+                    {org.name} ({org.role})
+                  </option>
+                ))}
+              </select>
+              <p className="mt-1 text-xs text-muted-foreground">
+                {t('oauth.authorization.organizationHint', { appName: authData.client_name })}
+              </p>
+            </div>
+          )}
+
           {/* Requested Permissions */}
           <div>
             <Label className="text-sm font-medium">{t('oauth.authorization.requestedScopes')}</Label>
@@ -8,6 +8,8 @@ import { Badge } from '@/components/ui/badge'
 import { User, Mail, Building, Shield, RefreshCw, Edit } from 'lucide-react'
 import { ChangePasswordDialog } from '@/components/profile/ChangePasswordDialog'
 import { UpdateProfileDialog } from '@/components/profile/UpdateProfileDialog'
+import { LinkedIdentitiesCard } from '@/components/profile/LinkedIdentitiesCard'
+import { AccessTokensCard } from '@/components/profile/AccessTokensCard'
 
 export const ProfilePage: React.FC = () => {
   const { user, refreshUser } = useAuth()
@@ -177,6 +179,12 @@ export const ProfilePage: React.FC = () => {
         </Card>
       </div>
 
+      {/* Linked Accounts */}
+      <LinkedIdentitiesCard />
+
+      {/* Personal Access Tokens */}
+      <AccessTokensCard />
+
       {/* Account Actions */}
       <Card>
         <CardHeader>
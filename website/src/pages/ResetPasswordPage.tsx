@@ -0,0 +1,143 @@
+import React, { useState } from 'react'
+import { Link, useSearchParams } from 'react-router-dom'
+import { useTranslation } from 'react-i18next'
+import { useDocumentTitle } from '@/hooks/useDocumentTitle'
+import { Button } from '@/components/ui/button'
+import { Input } from '@/components/ui/input'
+import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '@/components/ui/card'
+import { Alert, AlertDescription } from '@/components/ui/alert'
+import { PublicLayout } from '@/components/layout/PublicLayout'
+import { Loader2, AlertCircle, CheckCircle } from 'lucide-react'
+
+interface PasswordViolation {
+  code: string
+  message: string
+}
+
+export const ResetPasswordPage: React.FC = () => {
+  const [searchParams] = useSearchParams()
+  const token = searchParams.get('token') || ''
+  const [password, setPassword] = useState('')
+  const [confirmPassword, setConfirmPassword] = useState('')
+  const [errors, setErrors] = useState<string[]>([])
+  const [done, setDone] = useState(false)
+  const [submitting, setSubmitting] = useState(false)
+  const { t } = useTranslation()
+
+  useDocumentTitle(t('resetPassword.title'))
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault()
+    setErrors([])
+
+    if (password !== confirmPassword) {
+      setErrors([t('auth.passwordMismatch')])
+      return
+    }
+
+    setSubmitting(true)
+    try {
+      const response = await fetch('/api/password/reset', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token, newPassword: password }),
+      })
+      const data = await response.json().catch(() => ({}))
+      if (!response.ok) {
+        const violations: PasswordViolation[] = data.violations || []
+        setErrors(violations.length > 0
+          ? violations.map((violation) => violation.message)
+          : [data.error || data.message || t('resetPassword.failed')])
+        return
+      }
+      setDone(true)
+    } catch {
+      setErrors([t('resetPassword.failed')])
+    } finally {
+      setSubmitting(false)
+    }
+  }
+
+  return (
+    <PublicLayout>
+      <Card className="w-full max-w-md">
+        <CardHeader className="space-y-1">
+          <CardTitle className="text-2xl font-bold text-center">{t('resetPassword.title')}</CardTitle>
+          <CardDescription className="text-center">{t('resetPassword.description')}</CardDescription>
+        </CardHeader>
+        <CardContent className="space-y-4">
+          {!token && (
+            <Alert variant="destructive">
+              <AlertCircle className="h-4 w-4" />
+              <AlertDescription>{t('resetPassword.missingToken')}</AlertDescription>
+            </Alert>
+          )}
+
+          {done ? (
+            <>
+              <Alert className="border-green-500 text-green-700 bg-green-50 dark:bg-green-950 dark:text-green-300">
+                <CheckCircle className="h-4 w-4" />
+                <AlertDescription>{t('resetPassword.success')}</AlertDescription>
+              </Alert>
+              <Button asChild className="w-full">
+                <Link to="/login">{t('auth.signIn')}</Link>
+              </Button>
+            </>
+          ) : token && (
+            <form onSubmit={handleSubmit} className="space-y-4">
+              {errors.length > 0 && (
+                <Alert variant="destructive">
+                  <AlertCircle className="h-4 w-4" />
+                  <AlertDescription>
+                    {errors.map((error) => (
+                      <div key={error}>{error}</div>
+                    ))}
+                  </AlertDescription>
+                </Alert>
+              )}
+
+              <div className="space-y-2">
+                <label htmlFor="password" className="text-sm font-medium">
+                  {t('resetPassword.newPassword')}
+                </label>
+                <Input
+                  id="password"
+                  type="password"
+                  value={password}
+                  onChange={(e) => setPassword(e.target.value)}
+                  disabled={submitting}
+                  required
+                />
+              </div>
+
+              <div className="space-y-2">
+                <label htmlFor="confirmPassword" className="text-sm font-medium">
+                  {t('common.confirmPassword')}
+                </label>
+                <Input
+                  id="confirmPassword"
+                  type="password"
+                  value={confirmPassword}
+                  onChange={(e) => setConfirmPassword(e.target.value)}
+                  disabled={submitting}
+                  required
+                />
+              </div>
+
+              <Button type="submit" className="w-full" disabled={submitting || !password}>
+                {submitting ? (
+                  <>
+                    <Loader2 className="mr-2 h-4 w-4 animate-spin" />
+                    {t('common.loading')}
+                  </>
+                ) : (
+                  t('resetPassword.submit')
+                )}
+              </Button>
+            </form>
+          )}
+        </CardContent>
+      </Card>
+    </PublicLayout>
+  )
+}